@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/config"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+// Exit codes shared by all CLI commands
+const (
+	exitOK               = 0 // Command succeeded
+	exitValidationFailed = 1 // Validation ran and found problems
+	exitToolError        = 2 // Bad arguments, missing inputs or I/O failures
+	exitQmldiffFailed    = 3 // qmldiff crashed, timed out or could not be run
+)
+
+// runCommand runs a CLI subcommand if args names one
+// Returns the process exit code and whether a subcommand was handled
+func runCommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	switch args[0] {
+	case "build-hashtab":
+		return runBuildHashtab(args[1:]), true
+	case "convert-hashlist":
+		return runConvertHashlist(args[1:]), true
+	case "diff-hashtab":
+		return runDiffHashtab(args[1:]), true
+	case "validate-tree":
+		return runValidateTree(args[1:]), true
+	default:
+		return 0, false
+	}
+}
+
+// djb2Seed returns the DJB2 seed set by DJB2_SEED, or the default seed
+func djb2Seed() (uint64, error) {
+	value := config.Get("DJB2_SEED", "")
+	if value == "" {
+		return hashtab.DefaultSeed, nil
+	}
+	seed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DJB2_SEED %q: %w", value, err)
+	}
+	return seed, nil
+}
+
+// runBuildHashtab generates a hashtab from the identifiers and strings in a QML tree
+func runBuildHashtab(args []string) int {
+	fs := flag.NewFlagSet("build-hashtab", flag.ContinueOnError)
+	treePath := fs.String("tree", "", "Path to the QML tree directory")
+	outPath := fs.String("out", "", "Path to write the hashtab to")
+	hashlist := fs.Bool("hashlist", false, "Write a hashlist (hashes only, no strings)")
+	defaultSeed, err := djb2Seed()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitToolError
+	}
+	seed := fs.Uint64("seed", defaultSeed, "DJB2 seed to hash with")
+	reference := fs.String("reference", "", "Existing hashtab to check the seed against")
+	if err := fs.Parse(args); err != nil {
+		return exitToolError
+	}
+
+	if *treePath == "" || *outPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: rm-qmd-verify build-hashtab --tree <dir> --out <file> [--hashlist] [--seed <n>] [--reference <hashtab>]")
+		return exitToolError
+	}
+
+	info, err := os.Stat(*treePath)
+	if err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Tree directory not found: %s\n", *treePath)
+		return exitToolError
+	}
+
+	if *reference != "" {
+		if err := configureVersionHash(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitToolError
+		}
+		ref, err := hashtab.Load(*reference)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load reference hashtab: %v\n", err)
+			return exitToolError
+		}
+		warnSeedMismatch(os.Stderr, ref, *seed)
+	}
+
+	tree, err := qmltree.NewTree(*treePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load tree: %v\n", err)
+		return exitToolError
+	}
+
+	identifiers, err := tree.Identifiers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read tree: %v\n", err)
+		return exitToolError
+	}
+
+	entries := make(map[uint64]string, len(identifiers))
+	for _, identifier := range identifiers {
+		entries[hashtab.DJB2HashWithSeed(identifier, *seed)] = identifier
+	}
+
+	if *hashlist {
+		hashes := make([]uint64, 0, len(entries))
+		for hash := range entries {
+			hashes = append(hashes, hash)
+		}
+		sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+		err = hashtab.WriteHashlist(hashes, *outPath)
+	} else {
+		err = hashtab.WriteHashtab(entries, *outPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write hashtab: %v\n", err)
+		return exitToolError
+	}
+
+	fmt.Printf("Wrote %d hashes from %d QML files to %s\n", len(entries), tree.FileCount, *outPath)
+	return exitOK
+}
+
+// runConvertHashlist strips the strings from a hashtab, writing the hashes
+// alone as a smaller hashlist. The version entry is kept, losing its string.
+func runConvertHashlist(args []string) int {
+	fs := flag.NewFlagSet("convert-hashlist", flag.ContinueOnError)
+	inPath := fs.String("input", "", "Path to the hashtab to convert")
+	outPath := fs.String("output", "", "Path to write the hashlist to")
+	if err := fs.Parse(args); err != nil {
+		return exitToolError
+	}
+
+	if *inPath == "" || *outPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: rm-qmd-verify convert-hashlist --input <hashtab> --output <hashlist>")
+		return exitToolError
+	}
+
+	if err := configureVersionHash(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitToolError
+	}
+	inInfo, err := os.Stat(*inPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Hashtab not found: %s\n", *inPath)
+		return exitToolError
+	}
+	ht, err := hashtab.Load(*inPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load hashtab: %v\n", err)
+		return exitToolError
+	}
+	if ht.IsHashlist() {
+		fmt.Fprintf(os.Stderr, "%s is already a hashlist\n", *inPath)
+		return exitToolError
+	}
+
+	hashes := make([]uint64, 0, len(ht.Entries))
+	for hash := range ht.Entries {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	if err := hashtab.WriteHashlist(hashes, *outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write hashlist: %v\n", err)
+		return exitToolError
+	}
+
+	outInfo, err := os.Stat(*outPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read hashlist: %v\n", err)
+		return exitToolError
+	}
+	fmt.Printf("Converted %s (%d entries, %d bytes) to %s (%d entries, %d bytes)\n",
+		*inPath, len(ht.Entries), inInfo.Size(), *outPath, len(hashes), outInfo.Size())
+	return exitOK
+}
+
+// runDiffHashtab reports the hashes added, removed and changed between two hashtabs
+func runDiffHashtab(args []string) int {
+	fs := flag.NewFlagSet("diff-hashtab", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Write the differences as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitToolError
+	}
+
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: rm-qmd-verify diff-hashtab [--json] <hashtab-a> <hashtab-b>")
+		return exitToolError
+	}
+
+	if err := configureVersionHash(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitToolError
+	}
+	tables := make([]*hashtab.Hashtab, 2)
+	for i, path := range fs.Args() {
+		ht, err := hashtab.Load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", path, err)
+			return exitToolError
+		}
+		tables[i] = ht
+	}
+
+	diff := hashtab.Diff(tables[0], tables[1])
+	if *asJSON {
+		if err := writeHashtabDiffJSON(os.Stdout, tables[0], tables[1], diff); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write diff: %v\n", err)
+			return exitToolError
+		}
+	} else {
+		writeHashtabDiffText(os.Stdout, tables[0], tables[1], diff)
+	}
+	return exitOK
+}
+
+// writeHashtabDiffText writes a human-readable hashtab diff, with each hash's string where known
+func writeHashtabDiffText(w io.Writer, a, b *hashtab.Hashtab, diff hashtab.HashtabDiff) {
+	fmt.Fprintf(w, "%s: %d hashes, %s: %d hashes\n", a.Name, len(a.Entries), b.Name, len(b.Entries))
+	fmt.Fprintf(w, "  only in %s: %d\n", a.Name, len(diff.Removed))
+	fmt.Fprintf(w, "  only in %s: %d\n", b.Name, len(diff.Added))
+	fmt.Fprintf(w, "  in both: %d (%d with changed strings)\n", diff.Common, len(diff.Changed))
+
+	if len(diff.Removed) > 0 {
+		fmt.Fprintf(w, "\nRemoved (only in %s):\n", a.Name)
+		for _, hash := range diff.Removed {
+			fmt.Fprintf(w, "  - %d %s\n", hash, a.Entries[hash])
+		}
+	}
+	if len(diff.Added) > 0 {
+		fmt.Fprintf(w, "\nAdded (only in %s):\n", b.Name)
+		for _, hash := range diff.Added {
+			fmt.Fprintf(w, "  + %d %s\n", hash, b.Entries[hash])
+		}
+	}
+	if len(diff.Changed) > 0 {
+		fmt.Fprintln(w, "\nChanged:")
+		for _, hash := range diff.Changed {
+			fmt.Fprintf(w, "  ~ %d %q -> %q\n", hash, a.Entries[hash], b.Entries[hash])
+		}
+	}
+}
+
+// hashtabDiffEntry is a hash in a JSON hashtab diff
+// Hashes are strings, as JSON numbers lose precision above 2^53.
+type hashtabDiffEntry struct {
+	Hash   string `json:"hash"`
+	String string `json:"string,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// writeHashtabDiffJSON writes a hashtab diff as JSON
+func writeHashtabDiffJSON(w io.Writer, a, b *hashtab.Hashtab, diff hashtab.HashtabDiff) error {
+	entries := func(hashes []uint64, ht *hashtab.Hashtab) []hashtabDiffEntry {
+		list := make([]hashtabDiffEntry, len(hashes))
+		for i, hash := range hashes {
+			list[i] = hashtabDiffEntry{Hash: strconv.FormatUint(hash, 10), String: ht.Entries[hash]}
+		}
+		return list
+	}
+	changed := make([]hashtabDiffEntry, len(diff.Changed))
+	for i, hash := range diff.Changed {
+		changed[i] = hashtabDiffEntry{Hash: strconv.FormatUint(hash, 10), From: a.Entries[hash], To: b.Entries[hash]}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"a":         a.Name,
+		"b":         b.Name,
+		"only_in_a": len(diff.Removed),
+		"only_in_b": len(diff.Added),
+		"in_both":   diff.Common,
+		"removed":   entries(diff.Removed, a),
+		"added":     entries(diff.Added, b),
+		"changed":   changed,
+	})
+}
+
+// warnSeedMismatch warns if seed does not reproduce the hashes in a reference
+// hashtab, naming the known seed that does when there is one
+func warnSeedMismatch(w io.Writer, ref *hashtab.Hashtab, seed uint64) {
+	matched, checked := hashtab.CheckSeed(ref.Entries, seed)
+	if checked == 0 {
+		fmt.Fprintf(w, "Warning: %s has no strings, cannot verify seed %d\n", ref.Name, seed)
+		return
+	}
+	if matched == checked {
+		return
+	}
+
+	fmt.Fprintf(w, "Warning: seed %d reproduces only %d of %d hashes in %s; the generated hashtab will not match\n",
+		seed, matched, checked, ref.Name)
+	if detected, ok := hashtab.DetectSeed(ref.Entries, hashtab.KnownSeeds); ok {
+		fmt.Fprintf(w, "Warning: %s was built with seed %d, rerun with --seed %d\n", ref.Name, detected, detected)
+	}
+}
+
+// runValidateTree validates a QMD file against a QML tree and reports the result
+func runValidateTree(args []string) int {
+	fs := flag.NewFlagSet("validate-tree", flag.ContinueOnError)
+	qmdPath := fs.String("qmd", "", "Path to the QMD file")
+	hashtabPath := fs.String("hashtab", "", "Path to the hashtab file")
+	treePath := fs.String("tree", "", "Path to the QML tree directory")
+	qmldiffBinary := fs.String("qmldiff", config.Get("QMLDIFF_BINARY", "./qmldiff"), "Path to the qmldiff binary")
+	quiet := fs.Bool("quiet", false, "Print nothing on success, only errors on failure")
+	if err := fs.Parse(args); err != nil {
+		return exitToolError
+	}
+
+	if *qmdPath == "" || *hashtabPath == "" || *treePath == "" {
+		fmt.Fprintln(os.Stderr, "usage: rm-qmd-verify validate-tree --qmd <file> --hashtab <file> --tree <dir> [--quiet]")
+		return exitToolError
+	}
+
+	// Service logging would otherwise defeat --quiet
+	if *quiet {
+		log.SetOutput(io.Discard)
+	}
+
+	// Check inputs up front so any later failure is qmldiff's
+	for _, input := range []string{*qmdPath, *hashtabPath, *treePath} {
+		if _, err := os.Stat(input); err != nil {
+			fmt.Fprintf(os.Stderr, "Input not found: %s\n", input)
+			return exitToolError
+		}
+	}
+
+	qmldiff.SetTimeout(config.GetDuration("QMLDIFF_TIMEOUT", qmldiff.DefaultTimeout))
+	service := qmldiff.NewService(*qmldiffBinary, nil, nil)
+	result, err := service.ValidateAgainstTree(context.Background(), *qmdPath, *hashtabPath, *treePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qmldiff failed: %v\n", err)
+		return exitQmldiffFailed
+	}
+
+	success := result.FilesWithErrors == 0 && !result.HasHashErrors
+	if *quiet {
+		if !success {
+			outputResultText(os.Stderr, *qmdPath, result)
+		}
+	} else {
+		outputResultText(os.Stdout, *qmdPath, result)
+	}
+
+	if !success {
+		return exitValidationFailed
+	}
+	return exitOK
+}
+
+// outputResultText writes a human-readable tree validation report
+func outputResultText(w io.Writer, qmdPath string, result *qmldiff.TreeValidationResult) {
+	if result.FilesWithErrors == 0 && !result.HasHashErrors {
+		fmt.Fprintf(w, "OK: %s (%d files processed, %d modified)\n", qmdPath, result.FilesProcessed, result.FilesModified)
+		if result.VersionSkipped > 0 {
+			fmt.Fprintf(w, "  warning: %s\n", qmd.VersionSkippedMessage(result.VersionSkipped))
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "FAILED: %s (%d files processed, %d with errors)\n", qmdPath, result.FilesProcessed, result.FilesWithErrors)
+	for _, e := range result.Errors {
+		if e.Line > 0 {
+			fmt.Fprintf(w, "  %s:%d:%d: %s\n", e.FilePath, e.Line, e.Column, e.Error)
+		} else if e.FilePath != "" {
+			fmt.Fprintf(w, "  %s: %s\n", e.FilePath, e.Error)
+		} else {
+			fmt.Fprintf(w, "  %s\n", e.Error)
+		}
+	}
+	for _, hash := range result.FailedHashes {
+		fmt.Fprintf(w, "  missing hash: %d\n", hash)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+func TestCommandExitCodes(t *testing.T) {
+	tmpDir := t.TempDir()
+	qmdPath := filepath.Join(tmpDir, "mod.qmd")
+	if err := os.WriteFile(qmdPath, []byte("AFFECT [[1]]\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	missing := filepath.Join(tmpDir, "missing")
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"build-hashtab without flags", []string{"build-hashtab"}, exitToolError},
+		{"build-hashtab unknown flag", []string{"build-hashtab", "--bogus"}, exitToolError},
+		{"build-hashtab missing tree", []string{"build-hashtab", "--tree", missing, "--out", filepath.Join(tmpDir, "out")}, exitToolError},
+		{"convert-hashlist without flags", []string{"convert-hashlist"}, exitToolError},
+		{"convert-hashlist missing input", []string{"convert-hashlist", "--input", missing, "--output", filepath.Join(tmpDir, "out")}, exitToolError},
+		{"diff-hashtab one file", []string{"diff-hashtab", qmdPath}, exitToolError},
+		{"diff-hashtab missing file", []string{"diff-hashtab", missing, missing}, exitToolError},
+		{"validate-tree without flags", []string{"validate-tree"}, exitToolError},
+		{"validate-tree missing input", []string{"validate-tree", "--qmd", qmdPath, "--hashtab", missing, "--tree", tmpDir}, exitToolError},
+		{"validate-tree qmldiff not runnable", []string{"validate-tree", "--quiet", "--qmd", qmdPath, "--hashtab", qmdPath, "--tree", tmpDir, "--qmldiff", missing}, exitQmldiffFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, handled := runCommand(tt.args)
+			if !handled {
+				t.Fatalf("runCommand(%v) not handled", tt.args)
+			}
+			if code != tt.want {
+				t.Errorf("runCommand(%v) = %d, want %d", tt.args, code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCommandUnknown(t *testing.T) {
+	if _, handled := runCommand([]string{"serve-nothing"}); handled {
+		t.Error("runCommand() handled an unknown command")
+	}
+	if _, handled := runCommand(nil); handled {
+		t.Error("runCommand() handled empty args")
+	}
+}
+
+func TestDJB2Seed(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    uint64
+		wantErr bool
+	}{
+		{"", hashtab.DefaultSeed, false},
+		{"5381", 5381, false},
+		{"18446744073709551615", 18446744073709551615, false},
+		{"-1", 0, true},
+		{"seed", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("DJB2_SEED", tt.value)
+		got, err := djb2Seed()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("djb2Seed() with DJB2_SEED=%q = %d, %v; want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWarnSeedMismatch(t *testing.T) {
+	ref := &hashtab.Hashtab{
+		Name: "3.22.4.2-rmpp",
+		Entries: map[uint64]string{
+			hashtab.DJB2HashWithSeed("width", 5381): "width",
+			hashtab.DJB2HashWithSeed("Item", 5381):  "Item",
+		},
+	}
+
+	var out bytes.Buffer
+	warnSeedMismatch(&out, ref, 5381)
+	if out.Len() != 0 {
+		t.Errorf("matching seed warned: %s", out.String())
+	}
+
+	out.Reset()
+	warnSeedMismatch(&out, ref, hashtab.DefaultSeed)
+	if !strings.Contains(out.String(), "reproduces only 0 of 2") || !strings.Contains(out.String(), "--seed 5381") {
+		t.Errorf("mismatch warning = %q, want counts and suggested seed", out.String())
+	}
+}
+
+func TestConvertHashlist(t *testing.T) {
+	tmpDir := t.TempDir()
+	input := filepath.Join(tmpDir, "3.22.4.2-rmpp")
+	entries := map[uint64]string{
+		hashtab.DefaultVersionHash: "3.22.4.2",
+		hashtab.DJB2Hash("width"):  "width",
+		hashtab.DJB2Hash("Item"):   "Item",
+	}
+	if err := hashtab.WriteHashtab(entries, input); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+
+	output := filepath.Join(tmpDir, "3.22.4.2-rmpp.hashlist")
+	if code, _ := runCommand([]string{"convert-hashlist", "--input", input, "--output", output}); code != exitOK {
+		t.Fatalf("convert-hashlist = %d, want %d", code, exitOK)
+	}
+
+	ht, err := hashtab.Load(output)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !ht.IsHashlist() || len(ht.Entries) != len(entries) {
+		t.Errorf("hashlist has %d entries (hashlist=%v), want %d hashes only", len(ht.Entries), ht.IsHashlist(), len(entries))
+	}
+	for hash := range entries {
+		if _, ok := ht.Entries[hash]; !ok {
+			t.Errorf("hash %d missing from hashlist", hash)
+		}
+	}
+
+	// Converting again finds nothing to strip
+	if code, _ := runCommand([]string{"convert-hashlist", "--input", output, "--output", filepath.Join(tmpDir, "again")}); code != exitToolError {
+		t.Errorf("converting a hashlist = %d, want %d", code, exitToolError)
+	}
+}
+
+func TestWriteHashtabDiff(t *testing.T) {
+	a := &hashtab.Hashtab{Name: "3.22.4.2-rmpp", Entries: map[uint64]string{1: "width", 2: "height", 3: "radius"}}
+	b := &hashtab.Hashtab{Name: "3.23.0.1-rmpp", Entries: map[uint64]string{1: "width", 2: "implicitHeight", 4: "opacity"}}
+	diff := hashtab.Diff(a, b)
+
+	var out bytes.Buffer
+	writeHashtabDiffText(&out, a, b, diff)
+	for _, want := range []string{
+		"only in 3.22.4.2-rmpp: 1",
+		"only in 3.23.0.1-rmpp: 1",
+		"in both: 2 (1 with changed strings)",
+		"- 3 radius",
+		"+ 4 opacity",
+		`~ 2 "height" -> "implicitHeight"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("text diff missing %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := writeHashtabDiffJSON(&out, a, b, diff); err != nil {
+		t.Fatalf("writeHashtabDiffJSON() failed: %v", err)
+	}
+	var got struct {
+		OnlyInA int                `json:"only_in_a"`
+		OnlyInB int                `json:"only_in_b"`
+		InBoth  int                `json:"in_both"`
+		Changed []hashtabDiffEntry `json:"changed"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	wantChanged := []hashtabDiffEntry{{Hash: "2", From: "height", To: "implicitHeight"}}
+	if got.OnlyInA != 1 || got.OnlyInB != 1 || got.InBoth != 2 || !reflect.DeepEqual(got.Changed, wantChanged) {
+		t.Errorf("JSON diff = %+v, want 1 only in a, 1 only in b, 2 in both and %+v changed", got, wantChanged)
+	}
+}
+
+// captureOutput runs fn with stdout and stderr redirected, returning what it printed
+func captureOutput(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	defer stderr.Close()
+
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	defer func() { os.Stdout, os.Stderr = savedStdout, savedStderr }()
+	// Service logging goes through the log package, which --quiet silences
+	log.SetOutput(stderr)
+	defer log.SetOutput(savedStderr)
+	fn()
+
+	out, _ := os.ReadFile(stdout.Name())
+	errOut, _ := os.ReadFile(stderr.Name())
+	return string(out), string(errOut)
+}
+
+func TestValidateTreeQuiet(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake qmldiff is a shell script")
+	}
+
+	tmpDir := t.TempDir()
+	qmdPath := filepath.Join(tmpDir, "mod.qmd")
+	if err := os.WriteFile(qmdPath, []byte("AFFECT [[1]]\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	hashtabPath := filepath.Join(tmpDir, "3.22.4.2-rmpp")
+	if err := hashtab.WriteHashtab(map[uint64]string{hashtab.DJB2Hash("width"): "width"}, hashtabPath); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	treeDir := filepath.Join(tmpDir, "tree")
+	if err := os.MkdirAll(treeDir, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	fakeQmldiff := func(name, script string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		return path
+	}
+	passing := fakeQmldiff("qmldiff-pass", "#!/bin/sh\nexit 0\n")
+	failing := fakeQmldiff("qmldiff-fail", "#!/bin/sh\n"+
+		"[ \"$1\" = check-compatibility ] || exit 0\n"+
+		"echo '  - 1 required by mod.qmd'\necho 'Total errors: 1'\nexit 1\n")
+
+	tests := []struct {
+		name       string
+		qmldiff    string
+		quiet      bool
+		want       int
+		wantStdout bool
+		wantStderr bool
+	}{
+		{name: "success", qmldiff: passing, want: exitOK, wantStdout: true},
+		{name: "quiet success", qmldiff: passing, quiet: true, want: exitOK},
+		{name: "failure", qmldiff: failing, want: exitValidationFailed, wantStdout: true},
+		{name: "quiet failure", qmldiff: failing, quiet: true, want: exitValidationFailed, wantStderr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"validate-tree", "--qmd", qmdPath, "--hashtab", hashtabPath, "--tree", treeDir, "--qmldiff", tt.qmldiff}
+			if tt.quiet {
+				args = append(args, "--quiet")
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() { code, _ = runCommand(args) })
+			if code != tt.want {
+				t.Errorf("exit code = %d, want %d", code, tt.want)
+			}
+			if (stdout != "") != tt.wantStdout {
+				t.Errorf("stdout = %q, want output: %v", stdout, tt.wantStdout)
+			}
+			if tt.quiet && (stderr != "") != tt.wantStderr {
+				t.Errorf("stderr = %q, want output: %v", stderr, tt.wantStderr)
+			}
+			if tt.wantStderr && !strings.Contains(stderr, "FAILED") {
+				t.Errorf("stderr = %q, want the failure report", stderr)
+			}
+		})
+	}
+}
@@ -48,3 +48,35 @@ func GetDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func GetList(key string, defaultValue []string) []string {
+	val := Get(key, "")
+	if val == "" {
+		return defaultValue
+	}
+	list := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// GetIntsWithPrefix returns the integer variables named prefix+<name>, keyed by
+// name, e.g. CONCURRENCY_rmppm=2 as {"rmppm": 2} for prefix "CONCURRENCY_"
+// Variables that aren't integers are skipped.
+func GetIntsWithPrefix(prefix string) map[string]int {
+	values := make(map[string]int)
+	for _, entry := range os.Environ() {
+		key, val, _ := strings.Cut(entry, "=")
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		if intVal, err := strconv.Atoi(val); err == nil {
+			values[name] = intVal
+		}
+	}
+	return values
+}
@@ -3,12 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
@@ -18,6 +23,7 @@ import (
 	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/uploads"
 	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
 	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
 )
@@ -27,28 +33,54 @@ type APIHandler struct {
 	hashtabService           *hashtab.Service
 	treeService              *qmltree.Service
 	jobStore                 *jobs.Store
+	uploadStore              *uploads.Store
 	maxConcurrentValidations int
+	settings                 Settings      // Options resolved at startup
+	inFlight                 *inFlightJobs // Running compare jobs by upload content, to coalesce duplicates
 }
 
-func NewAPIHandler(qmldiffService *qmldiff.Service, hashtabService *hashtab.Service, treeService *qmltree.Service, jobStore *jobs.Store, maxConcurrentValidations int) *APIHandler {
+func NewAPIHandler(qmldiffService *qmldiff.Service, hashtabService *hashtab.Service, treeService *qmltree.Service, jobStore *jobs.Store, uploadStore *uploads.Store, maxConcurrentValidations int, settings Settings) *APIHandler {
 	return &APIHandler{
 		qmldiffService:           qmldiffService,
 		hashtabService:           hashtabService,
 		treeService:              treeService,
 		jobStore:                 jobStore,
+		uploadStore:              uploadStore,
 		maxConcurrentValidations: maxConcurrentValidations,
+		settings:                 settings,
+		inFlight:                 newInFlightJobs(),
 	}
 }
 
 type CompareResponse struct {
-	Compatible   []qmldiff.TreeComparisonResult `json:"compatible"`
-	Incompatible []qmldiff.TreeComparisonResult `json:"incompatible"`
-	TotalChecked int                            `json:"total_checked"`
-	Mode         string                         `json:"mode"` // "tree" or "hash"
+	Compatible            []qmldiff.TreeComparisonResult `json:"compatible"`
+	Incompatible          []qmldiff.TreeComparisonResult `json:"incompatible"`
+	TotalChecked          int                            `json:"total_checked"`
+	Mode                  string                         `json:"mode"`                             // "tree" or "hash"
+	UnavailableVersions   []string                       `json:"unavailable_versions,omitempty"`   // @supports patterns with no loaded hashtable
+	Info                  string                         `json:"info,omitempty"`                   // Informational note when the file was not validated
+	DependenciesTruncated bool                           `json:"dependencies_truncated,omitempty"` // Some dependency entries were left out of the batch
+	DependenciesOmitted   int                            `json:"dependencies_omitted,omitempty"`   // How many dependency entries were left out
+}
+
+// Result shapes a persistent job store saves: single and batch compare
+// results, flushed partial results, and tree validation responses
+func init() {
+	jobs.RegisterResultType("compare", CompareResponse{})
+	jobs.RegisterResultType("compare_batch", map[string]CompareResponse{})
+	jobs.RegisterResultType("compare_partial", map[string][]qmldiff.TreeComparisonResult{})
+	jobs.RegisterResultType("tree", map[string]interface{}{})
 }
 
 func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(100 << 20); err != nil {
+	err := r.ParseMultipartForm(h.settings.MultipartMemory)
+	// Remove the parser's spooled temp files, including after partial reads
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+	if err != nil {
 		logging.Error(logging.ComponentHandler, "Failed to parse multipart form: %v", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -58,6 +90,20 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tempDir, qmdPaths, filenames, excluded, ok := h.saveUpload(w, r)
+	if !ok {
+		return
+	}
+
+	h.startCompareJob(w, r, tempDir, qmdPaths, filenames, excluded)
+}
+
+// saveUpload writes a compare-style upload, the "files" and "paths" fields,
+// a single "file", or a zip archive in either, to a new temp directory, and
+// returns it with the saved files, their relative names and the names of
+// files left out by EXCLUDE_PATTERNS
+// On failure the error response has been written and nothing is left behind.
+func (h *APIHandler) saveUpload(w http.ResponseWriter, r *http.Request) (string, []string, []string, []string, bool) {
 	var fileHeaders []*multipart.FileHeader
 	var filePaths []string
 
@@ -65,6 +111,15 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 		fileHeaders = files
 		// Get corresponding paths (sent separately to bypass browser path sanitization)
 		filePaths = r.MultipartForm.Value["paths"]
+		if len(filePaths) > 0 && len(filePaths) != len(fileHeaders) {
+			logging.Warn(logging.ComponentHandler, "Mismatched upload: %d files but %d paths", len(fileHeaders), len(filePaths))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("Number of paths (%d) does not match number of files (%d)", len(filePaths), len(fileHeaders)),
+			})
+			return "", nil, nil, nil, false
+		}
 	} else {
 		file, header, err := r.FormFile("file")
 		if err != nil {
@@ -74,7 +129,7 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": "No file uploaded or invalid form data",
 			})
-			return
+			return "", nil, nil, nil, false
 		}
 		file.Close()
 		fileHeaders = []*multipart.FileHeader{header}
@@ -89,72 +144,93 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Failed to create temp directory",
 		})
-		return
+		return "", nil, nil, nil, false
+	}
+
+	var qmdPaths, filenames, excluded []string
+	var ok bool
+	if len(fileHeaders) == 1 && isZipUpload(fileHeaders[0]) {
+		qmdPaths, filenames, excluded, ok = h.extractZipUpload(w, tempDir, fileHeaders[0])
+	} else {
+		qmdPaths, filenames, excluded, ok = saveUploadedFiles(w, tempDir, fileHeaders, filePaths)
+	}
+	if !ok {
+		os.RemoveAll(tempDir)
+		return "", nil, nil, nil, false
 	}
+	return tempDir, qmdPaths, filenames, excluded, true
+}
 
-	qmdPaths := make([]string, 0, len(fileHeaders))
-	filenames := make([]string, 0, len(fileHeaders))
+// saveUploadedFiles writes uploaded files into dir, keeping their relative
+// paths, and returns the files to validate along with their relative names
+// Empty and excluded files are written but not returned; the relative names
+// of excluded ones are listed in excluded. On failure an error response has
+// been written and ok is false; the caller cleans up dir.
+func saveUploadedFiles(w http.ResponseWriter, dir string, fileHeaders []*multipart.FileHeader, filePaths []string) (qmdPaths, filenames, excluded []string, ok bool) {
+	qmdPaths = make([]string, 0, len(fileHeaders))
+	filenames = make([]string, 0, len(fileHeaders))
 
 	for i, fileHeader := range fileHeaders {
 		file, err := fileHeader.Open()
 		if err != nil {
 			logging.Error(logging.ComponentHandler, "Failed to open uploaded file %s: %v", fileHeader.Filename, err)
-			os.RemoveAll(tempDir)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": fmt.Sprintf("Failed to open file %s", fileHeader.Filename),
 			})
-			return
+			return nil, nil, nil, false
+		}
+
+		var pathField string
+		if i < len(filePaths) {
+			pathField = filePaths[i]
 		}
 
 		var relativePath string
-		if i < len(filePaths) && filePaths[i] != "" {
-			relativePath = filepath.Clean(filePaths[i])
+		if pathField != "" {
+			relativePath = filepath.Clean(pathField)
 		} else {
 			relativePath = filepath.Clean(fileHeader.Filename)
 		}
 		logging.Debug(logging.ComponentHandler, "Received file: %s (path field: %s) → cleaned: %s",
-			fileHeader.Filename, filePaths[i], relativePath)
-		tempPath := filepath.Join(tempDir, relativePath)
+			fileHeader.Filename, pathField, relativePath)
+		tempPath := filepath.Join(dir, relativePath)
 
-		cleanTempDir := filepath.Clean(tempDir) + string(os.PathSeparator)
+		cleanTempDir := filepath.Clean(dir) + string(os.PathSeparator)
 		cleanTempPath := filepath.Clean(tempPath)
 		if !strings.HasPrefix(cleanTempPath+string(os.PathSeparator), cleanTempDir) {
 			file.Close()
-			os.RemoveAll(tempDir)
 			logging.Warn(logging.ComponentHandler, "Path traversal attempt detected: %s", relativePath)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": "Invalid file path",
 			})
-			return
+			return nil, nil, nil, false
 		}
 
 		if err := os.MkdirAll(filepath.Dir(tempPath), 0755); err != nil {
 			file.Close()
-			os.RemoveAll(tempDir)
 			logging.Error(logging.ComponentHandler, "Failed to create directory for %s: %v", fileHeader.Filename, err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": fmt.Sprintf("Failed to create directory for file %s", fileHeader.Filename),
 			})
-			return
+			return nil, nil, nil, false
 		}
 
 		tempFile, err := os.Create(tempPath)
 		if err != nil {
 			file.Close()
-			os.RemoveAll(tempDir)
 			logging.Error(logging.ComponentHandler, "Failed to create temp file for %s: %v", fileHeader.Filename, err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": fmt.Sprintf("Failed to save file %s", fileHeader.Filename),
 			})
-			return
+			return nil, nil, nil, false
 		}
 
 		bytesWritten, err := io.Copy(tempFile, file)
@@ -162,14 +238,13 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 		tempFile.Close()
 
 		if err != nil {
-			os.RemoveAll(tempDir)
 			logging.Error(logging.ComponentHandler, "Failed to save file content for %s: %v", fileHeader.Filename, err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": fmt.Sprintf("Failed to save file %s", fileHeader.Filename),
 			})
-			return
+			return nil, nil, nil, false
 		}
 
 		if bytesWritten == 0 {
@@ -177,10 +252,36 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		// Excluded files stay on disk so LOADs still resolve, but aren't validated or counted
+		if qmd.IsExcluded(relativePath) {
+			logging.Info(logging.ComponentHandler, "Excluding file from validation: %s", relativePath)
+			excluded = append(excluded, filepath.ToSlash(relativePath))
+			continue
+		}
+
 		qmdPaths = append(qmdPaths, tempPath)
 		filenames = append(filenames, relativePath)
 	}
 
+	return qmdPaths, filenames, excluded, true
+}
+
+// startCompareJob validates the root-level files among qmdPaths, saved under
+// tempDir, in a background job and responds with the job ID
+// excluded names the uploaded files EXCLUDE_PATTERNS left out, reported when
+// nothing else is left to validate.
+// The job store takes over tempDir, which is removed right away on a bad request
+func (h *APIHandler) startCompareJob(w http.ResponseWriter, r *http.Request, tempDir string, qmdPaths, filenames, excluded []string) {
+	if len(qmdPaths) == 0 && len(excluded) > 0 {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "All uploaded files were excluded by EXCLUDE_PATTERNS",
+			"excluded": excluded,
+		})
+		return
+	}
 	if len(qmdPaths) == 0 {
 		os.RemoveAll(tempDir)
 		w.Header().Set("Content-Type", "application/json")
@@ -191,16 +292,76 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	label, err := jobLabel(r)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	callback, err := h.callbackURL(r)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	selected, unknown, err := h.selectedHashtables(r)
+	if err != nil || len(unknown) > 0 {
+		os.RemoveAll(tempDir)
+		response := map[string]interface{}{}
+		if err != nil {
+			response["error"] = err.Error()
+		} else {
+			response["error"] = fmt.Sprintf("Requested versions are not available: %s", strings.Join(unknown, ", "))
+			response["unknown_versions"] = unknown
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	priority, err := priorityFiles(r)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	selected, available := h.filterVersionDevice(r, selected)
+	if available != nil {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":              "No hashtable matches the requested version and device",
+			"available_versions": available,
+		})
+		return
+	}
+
 	logging.Info(logging.ComponentHandler, "Received %d file upload(s): %v", len(filenames), filenames)
 
 	rootLevelQMDs := qmd.GetRootLevelFiles(tempDir, qmdPaths)
 	if len(rootLevelQMDs) == 0 {
+		subdirCounts := qmd.CountSubdirectoryFiles(tempDir, qmdPaths)
 		os.RemoveAll(tempDir)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "No root-level .qmd files found. Only files at the top level of the upload are validated.",
-		})
+		json.NewEncoder(w).Encode(noRootFilesResponse(subdirCounts))
 		return
 	}
 
@@ -223,31 +384,265 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 			len(qmdPaths), originalQmdCount-len(qmdPaths))
 	}
 
+	modes := h.settings.AllowedModes
 	mode := r.URL.Query().Get("mode")
 	if mode == "" {
 		mode = "tree"
+		// Instances without tree validation default to what they do offer
+		if !slices.Contains(modes, mode) && len(modes) > 0 {
+			mode = modes[0]
+		}
+	}
+	if mode != "tree" && mode != "hash" {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "mode must be 'tree' or 'hash'",
+		})
+		return
+	}
+	if !slices.Contains(modes, mode) {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":         fmt.Sprintf("%s mode is disabled on this server", mode),
+			"allowed_modes": modes,
+		})
+		return
+	}
+
+	binary, status, err := h.qmldiffBinary(r)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	qmldiffService := h.qmldiffService
+	if binary != "" {
+		qmldiffService = qmldiffService.WithBinary(binary)
+	}
+
+	// A wrong paths field leaves LOADs dangling; say so now rather than after the qmldiff pass
+	unresolved := unresolvedLoads(qmdPaths, filenames)
+	for filename, loads := range unresolved {
+		logging.Warn(logging.ComponentHandler, "File %s LOADs files that were not uploaded: %v", filename, loads)
+	}
+
+	// Restrict each root file to the versions declared in its @supports annotation
+	supportedVersions := make(map[string][]string)
+	if r.URL.Query().Get("respect_supports") == "true" {
+		for i, path := range qmdPaths {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				logging.Warn(logging.ComponentHandler, "Failed to read %s for @supports annotation: %v", filenames[i], err)
+				continue
+			}
+			if patterns := qmd.ParseSupportedVersions(string(content)); len(patterns) > 0 {
+				supportedVersions[filenames[i]] = patterns
+				logging.Info(logging.ComponentHandler, "File %s declares support for: %v", filenames[i], patterns)
+			}
+		}
+	}
+
+	// Files without any diffs are reported as informational rather than validated
+	noDiffFiles := make(map[string]bool)
+	validatePaths := make([]string, 0, len(qmdPaths))
+	validateFilenames := make([]string, 0, len(filenames))
+	for i, path := range qmdPaths {
+		content, err := os.ReadFile(path)
+		if err == nil && !qmd.HasDiffs(string(content)) {
+			logging.Info(logging.ComponentHandler, "File %s contains no diffs, skipping validation", filenames[i])
+			noDiffFiles[filenames[i]] = true
+			continue
+		}
+		validatePaths = append(validatePaths, path)
+		validateFilenames = append(validateFilenames, filenames[i])
+	}
+
+	// A lone file gets the original flat response unless the client asks for the batch map
+	batchShape := originalQmdCount > 1 || r.URL.Query().Get("always_batch") == "true"
+	resultShape := "single"
+	if batchShape {
+		resultShape = "batch"
+	}
+
+	// Progress counts one step per hashtable, so clients can show the total up front
+	hashtablesCount := len(h.hashtabService.GetHashtables())
+	if selected != nil {
+		hashtablesCount = len(selected)
+	}
+	created := map[string]interface{}{
+		"files_accepted":   len(filenames),
+		"hashtables_count": hashtablesCount,
+		"result_shape":     resultShape,
+	}
+	if binary != "" {
+		created["qmldiff_binary"] = binary
+	}
+	if len(unresolved) > 0 {
+		created["warning"] = "Some LOAD targets were not uploaded; check that paths keep the folder structure"
+		created["unresolved_loads"] = unresolved
+	}
+
+	// Only priority files that were uploaded split the batches; naming
+	// anything else leaves validation as it would be without priority
+	var prioritySet map[string]bool
+	if len(priority) > 0 {
+		validatePaths, validateFilenames = prioritizeFiles(validatePaths, validateFilenames, priority)
+		wanted := make(map[string]bool, len(priority))
+		for _, filename := range priority {
+			wanted[filename] = true
+		}
+		for _, filename := range validateFilenames {
+			if wanted[filename] {
+				if prioritySet == nil {
+					prioritySet = make(map[string]bool, len(priority))
+				}
+				prioritySet[filename] = true
+			}
+		}
+		if prioritySet != nil {
+			logging.Info(logging.ComponentHandler, "Validating priority files first: %v", priority)
+		}
 	}
 
 	jobID := uuid.New().String()
+
+	// Identical submissions, such as CI retries, share the job already running for them.
+	// Each callback must be delivered, so requests with one always get their own job.
+	coalesceKey := ""
+	if callback == "" {
+		selectedNames := make([]string, 0, len(selected))
+		for name := range selected {
+			selectedNames = append(selectedNames, name)
+		}
+		sort.Strings(selectedNames)
+		key, err := uploadKey(tempDir, mode, strings.Join(selectedNames, ","), binary,
+			r.URL.Query().Get("respect_supports"), label, resultShape)
+		if err != nil {
+			logging.Warn(logging.ComponentHandler, "Failed to key upload, not coalescing: %v", err)
+		} else if existing, ok := h.inFlight.claim(key, jobID); ok {
+			os.RemoveAll(tempDir)
+			logging.Info(logging.ComponentHandler, "Upload matches running job %s, sharing it", existing)
+			created["coalesced"] = true
+			writeJobCreated(w, existing, label, created)
+			return
+		} else {
+			coalesceKey = key
+		}
+	}
+
 	h.jobStore.Create(jobID)
+	h.jobStore.SetLabel(jobID, label)
+	// The upload outlives validation for the hashlist and export endpoints
+	h.jobStore.SetFilesDir(jobID, tempDir)
+
+	logging.InfoKV(logging.ComponentHandler, "Created compare job", map[string]any{
+		"job_id": jobID,
+		"files":  filenames,
+		"mode":   mode,
+	})
+	if binary != "" {
+		logging.Info(logging.ComponentHandler, "Job %s validates with qmldiff binary %s", jobID, binary)
+	}
 
-	logging.Info(logging.ComponentHandler, "Created job %s for %d file(s) (mode: %s)", jobID, len(filenames), mode)
+	ctx, cancel := context.WithTimeout(context.Background(), h.settings.MaxJobDuration)
+	h.jobStore.SetCancel(jobID, cancel)
 
 	go func() {
-		defer os.RemoveAll(tempDir) // Clean up temp files after processing
+		defer cancel()
+		if callback != "" {
+			// Deferred first so it runs last, once the job has its final status
+			defer h.sendCallback(callback, jobID)
+		}
+		if coalesceKey != "" {
+			defer h.inFlight.release(coalesceKey, jobID)
+		}
+		defer func() {
+			if rec := recover(); rec != nil {
+				logging.Error(logging.ComponentHandler, "Validation panicked for job %s: %v\n%s", jobID, rec, debug.Stack())
+				h.jobStore.Update(jobID, "error", fmt.Sprintf("Validation failed: internal error: %v", rec), nil)
+			}
+		}()
+
+		logging.Info(logging.ComponentHandler, "Starting batch %s validation for job %s (%d files)", mode, jobID, len(filenames))
+		resultsMap := make(map[string][]qmldiff.TreeComparisonResult)
+		var err error
+		if len(validatePaths) > 0 {
+			if mode == "hash" {
+				resultsMap, err = h.validateHashesWithWorkers(ctx, validatePaths, validateFilenames, supportedVersions, selected, h.jobStore, jobID)
+			} else {
+				resultsMap, err = h.validateAgainstAllTreesWithWorkers(ctx, qmldiffService, validatePaths, validateFilenames, supportedVersions, selected, prioritySet, h.jobStore, jobID)
+			}
+		}
+		if errors.Is(err, context.Canceled) {
+			logging.Info(logging.ComponentHandler, "Job %s cancelled", jobID)
+			return
+		}
+		timedOut := errors.Is(err, context.DeadlineExceeded)
+		if err != nil && !timedOut {
+			logging.Error(logging.ComponentHandler, "Validation failed for job %s: %v", jobID, err)
+			h.jobStore.Update(jobID, "error", fmt.Sprintf("Validation failed: %v", err), nil)
+			return
+		}
+		if timedOut {
+			logging.Warn(logging.ComponentHandler, "Job %s exceeded max duration of %s, returning partial results", jobID, h.settings.MaxJobDuration)
+		}
 
-		if mode == "tree" {
-			logging.Info(logging.ComponentHandler, "Starting batch tree validation for job %s (%d files)", jobID, len(filenames))
-			ctx := context.Background()
-			resultsMap, err := h.validateAgainstAllTreesWithWorkers(ctx, qmdPaths, filenames, h.jobStore, jobID)
-			if err != nil {
-				logging.Error(logging.ComponentHandler, "Tree validation failed for job %s: %v", jobID, err)
-				h.jobStore.Update(jobID, "error", fmt.Sprintf("Validation failed: %v", err), nil)
-				return
+		for i, filename := range validateFilenames {
+			warnings := h.deviceMismatchWarnings(validatePaths[i])
+			external := externalLoads(validatePaths[i])
+			for j := range resultsMap[filename] {
+				if warning, ok := warnings[resultsMap[filename][j].Device]; ok {
+					resultsMap[filename][j].Warning = warning
+				}
+				resultsMap[filename][j].ExternalLoads = external
+			}
+		}
+
+		if !batchShape {
+			results := resultsMap[filenames[0]]
+			compatible := make([]qmldiff.TreeComparisonResult, 0)
+			incompatible := make([]qmldiff.TreeComparisonResult, 0)
+
+			for _, result := range results {
+				if result.Compatible {
+					compatible = append(compatible, result)
+				} else {
+					incompatible = append(incompatible, result)
+				}
+			}
+
+			logging.Info(logging.ComponentHandler, "Validation complete for job %s: %d compatible, %d incompatible",
+				jobID, len(compatible), len(incompatible))
+
+			response := CompareResponse{
+				Compatible:          compatible,
+				Incompatible:        incompatible,
+				TotalChecked:        len(results),
+				Mode:                mode,
+				UnavailableVersions: h.unavailableVersions(supportedVersions[filenames[0]]),
+			}
+			if noDiffFiles[filenames[0]] {
+				response.Info = qmd.NoDiffsMessage
+			}
+
+			h.jobStore.SetResults(jobID, response)
+			if timedOut {
+				h.jobStore.Update(jobID, "timeout", fmt.Sprintf("Validation timed out after %s, results are partial", h.settings.MaxJobDuration), nil)
+			} else {
+				h.jobStore.Update(jobID, "success", "Validation complete", nil)
 			}
+		} else {
+			batchResponse := make(map[string]CompareResponse)
 
-			if originalQmdCount == 1 {
-				results := resultsMap[filenames[0]]
+			for filename, results := range resultsMap {
 				compatible := make([]qmldiff.TreeComparisonResult, 0)
 				incompatible := make([]qmldiff.TreeComparisonResult, 0)
 
@@ -259,209 +654,267 @@ func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
 					}
 				}
 
-				logging.Info(logging.ComponentHandler, "Tree validation complete for job %s: %d compatible, %d incompatible",
-					jobID, len(compatible), len(incompatible))
+				batchResponse[filename] = CompareResponse{
+					Compatible:          compatible,
+					Incompatible:        incompatible,
+					TotalChecked:        len(results),
+					Mode:                mode,
+					UnavailableVersions: h.unavailableVersions(supportedVersions[filename]),
+				}
+			}
 
-				response := CompareResponse{
-					Compatible:   compatible,
-					Incompatible: incompatible,
-					TotalChecked: len(results),
-					Mode:         "tree",
+			for filename := range noDiffFiles {
+				batchResponse[filename] = CompareResponse{
+					Compatible:   make([]qmldiff.TreeComparisonResult, 0),
+					Incompatible: make([]qmldiff.TreeComparisonResult, 0),
+					Mode:         mode,
+					Info:         qmd.NoDiffsMessage,
 				}
+			}
 
-				h.jobStore.SetResults(jobID, response)
-				h.jobStore.Update(jobID, "success", "Validation complete", nil)
+			filenameToPaths := make(map[string]string)
+			for i, filename := range filenames {
+				filenameToPaths[filename] = qmdPaths[i]
+			}
+
+			flattenDependencies(batchResponse, filenameToPaths)
+			summary := summarizeErrors(batchResponse)
+			logging.Info(logging.ComponentHandler, "Job %s failures: %+v", jobID, summary)
+			if encoded, err := json.Marshal(summary); err == nil {
+				h.jobStore.SetSummary(jobID, encoded)
+			}
+			if omitted := truncateDependencies(batchResponse, filenameToPaths, h.settings.MaxFlattenedDependencies); omitted > 0 {
+				logging.Warn(logging.ComponentHandler, "Job %s has too many dependency results, omitted %d", jobID, omitted)
+			}
+
+			logging.Debug(logging.ComponentHandler, "Final batchResponse contains %d entries:", len(batchResponse))
+			for filename, response := range batchResponse {
+				logging.Debug(logging.ComponentHandler, "  '%s': %d total (%d compatible, %d incompatible)",
+					filename, response.TotalChecked, len(response.Compatible), len(response.Incompatible))
+			}
+
+			logging.Info(logging.ComponentHandler, "Batch validation complete for job %s: %d files processed, %d total results (including dependencies)",
+				jobID, len(filenames), len(batchResponse))
+
+			h.jobStore.SetResults(jobID, batchResponse)
+			if timedOut {
+				h.jobStore.Update(jobID, "timeout", fmt.Sprintf("Batch validation timed out after %s, results are partial", h.settings.MaxJobDuration), nil)
 			} else {
-				batchResponse := make(map[string]CompareResponse)
-
-				for filename, results := range resultsMap {
-					compatible := make([]qmldiff.TreeComparisonResult, 0)
-					incompatible := make([]qmldiff.TreeComparisonResult, 0)
-
-					for _, result := range results {
-						if result.Compatible {
-							compatible = append(compatible, result)
-						} else {
-							incompatible = append(incompatible, result)
-						}
-					}
+				h.jobStore.Update(jobID, "success", "Batch validation complete", nil)
+			}
+		}
+	}()
 
-					batchResponse[filename] = CompareResponse{
-						Compatible:   compatible,
-						Incompatible: incompatible,
-						TotalChecked: len(results),
-						Mode:         "tree",
-					}
-				}
+	writeJobCreated(w, jobID, label, created)
+}
 
-				filenameToPaths := make(map[string]string)
-				for i, filename := range filenames {
-					filenameToPaths[filename] = qmdPaths[i]
-				}
+// noRootFilesResponse explains an upload with no root-level .qmd files,
+// pointing at the subdirectories holding the ones it does have
+func noRootFilesResponse(subdirCounts map[string]int) map[string]interface{} {
+	if len(subdirCounts) == 0 {
+		return map[string]interface{}{
+			"error": "No root-level .qmd files found. Only files at the top level of the upload are validated.",
+		}
+	}
 
-				logging.Debug(logging.ComponentHandler, "Starting dependency flattening for %d root files", len(batchResponse))
-				for rootFilename, response := range batchResponse {
-					allResults := make([]qmldiff.TreeComparisonResult, 0, len(response.Compatible)+len(response.Incompatible))
-					allResults = append(allResults, response.Compatible...)
-					allResults = append(allResults, response.Incompatible...)
-					logging.Debug(logging.ComponentHandler, "Processing root file '%s' with %d total results (%d compatible, %d incompatible)",
-						rootFilename, len(allResults), len(response.Compatible), len(response.Incompatible))
-
-					compatibleNames := make([]string, len(response.Compatible))
-					for i, r := range response.Compatible {
-						compatibleNames[i] = r.Hashtable
-					}
-					incompatibleNames := make([]string, len(response.Incompatible))
-					for i, r := range response.Incompatible {
-						incompatibleNames[i] = r.Hashtable
-					}
-					logging.Debug(logging.ComponentHandler, "  Compatible: %v", compatibleNames)
-					logging.Debug(logging.ComponentHandler, "  Incompatible: %v", incompatibleNames)
+	subdirs := make([]string, 0, len(subdirCounts))
+	total := 0
+	for dir, count := range subdirCounts {
+		subdirs = append(subdirs, dir)
+		total += count
+	}
+	sort.Strings(subdirs)
+
+	return map[string]interface{}{
+		"error": fmt.Sprintf("No root-level .qmd files found, but %d were found in subdirectories (%s). "+
+			"Only files at the top level of the upload are validated; files in subdirectories are treated as LOAD dependencies. "+
+			"Move the files to validate to the top level, or upload the subdirectory itself.",
+			total, strings.Join(subdirs, ", ")),
+		"subdirectory_files": total,
+		"subdirectories":     subdirs,
+	}
+}
 
-					allResultsNames := make([]string, len(allResults))
-					for i, r := range allResults {
-						allResultsNames[i] = r.Hashtable
-					}
-					logging.Debug(logging.ComponentHandler, "  allResults after append: %v", allResultsNames)
-
-					logging.Debug(logging.ComponentHandler, "  About to iterate over %d results in allResults", len(allResults))
-					for i, treeResult := range allResults {
-						logging.Debug(logging.ComponentHandler, "  Loop iteration %d: Hashtable %s", i, treeResult.Hashtable)
-						depCount := 0
-						if treeResult.DependencyResults != nil {
-							depCount = len(treeResult.DependencyResults)
-						}
-						logging.Debug(logging.ComponentHandler, "  Hashtable %s (v%s, %s): %d dependencies",
-							treeResult.Hashtable, treeResult.OSVersion, treeResult.Device, depCount)
-
-						if treeResult.DependencyResults != nil && len(treeResult.DependencyResults) > 0 {
-							for depPath, depResult := range treeResult.DependencyResults {
-								logging.Debug(logging.ComponentHandler, "    Processing dependency '%s': compatible=%v, %d hash errors, %d process errors",
-									depPath, depResult.Compatible, len(depResult.HashErrors), len(depResult.ProcessErrors))
-
-								depTreeResult := qmldiff.TreeComparisonResult{
-									Hashtable:          treeResult.Hashtable,
-									OSVersion:          treeResult.OSVersion,
-									Device:             treeResult.Device,
-									Compatible:         depResult.Compatible,
-									ValidationMode:     "tree",
-									TreeValidationUsed: true,
-								}
-
-								if !depResult.Compatible {
-									if len(depResult.HashErrors) > 0 {
-										logging.Debug(logging.ComponentHandler, "      === Dependency Hash Error Processing ===")
-										logging.Debug(logging.ComponentHandler, "      Dependency: '%s'", depPath)
-										logging.Debug(logging.ComponentHandler, "      Root file: '%s'", rootFilename)
-
-										hashIDs := make([]uint64, len(depResult.HashErrors))
-										for i, hashErr := range depResult.HashErrors {
-											hashIDs[i] = hashErr.HashID
-										}
-										logging.Debug(logging.ComponentHandler, "      Hash IDs to find (%d): %v", len(hashIDs), hashIDs)
-
-										rootPath := filenameToPaths[rootFilename]
-										resolvedDepPath := qmd.ResolveLoadPath(rootPath, depPath)
-										logging.Debug(logging.ComponentHandler, "      Resolving depPath '%s' relative to root '%s' -> '%s'",
-											depPath, rootPath, resolvedDepPath)
-
-										depContents, err := os.ReadFile(resolvedDepPath)
-										if err != nil {
-											logging.Error(logging.ComponentHandler, "      Failed to read dependency file %s: %v", resolvedDepPath, err)
-											depTreeResult.ErrorDetail = fmt.Sprintf("%d hash lookup error(s)", len(depResult.HashErrors))
-										} else {
-											logging.Debug(logging.ComponentHandler, "      Successfully read file, size: %d bytes", len(depContents))
-											depStr := string(depContents)
-											depTreeResult.MissingHashes = qmd.FindHashPositions(depStr, hashIDs)
-											logging.Debug(logging.ComponentHandler, "      FindHashPositions returned %d positions: %v",
-												len(depTreeResult.MissingHashes), depTreeResult.MissingHashes)
-
-											if len(depTreeResult.MissingHashes) > 0 {
-												depTreeResult.ErrorDetail = fmt.Sprintf("missing %d hash(es)", len(depTreeResult.MissingHashes))
-											} else {
-												depTreeResult.ErrorDetail = fmt.Sprintf("%d hash lookup error(s)", len(depResult.HashErrors))
-												depTreeResult.MissingHashes = make([]qmd.HashWithPosition, len(hashIDs))
-												for i, hashID := range hashIDs {
-													depTreeResult.MissingHashes[i] = qmd.HashWithPosition{
-														Hash:   hashID,
-														Line:   0,
-														Column: 0,
-													}
-												}
-											}
-											logging.Debug(logging.ComponentHandler, "      Final ErrorDetail: '%s'", depTreeResult.ErrorDetail)
-										}
-									} else if len(depResult.ProcessErrors) > 0 {
-													depTreeResult.ErrorDetail = "QML failed to apply"
-									} else {
-										if depResult.Status == qmd.StatusNotAttempted {
-											if depResult.BlockedBy != "" {
-												depTreeResult.ErrorDetail = fmt.Sprintf("Not validated due to failure of dependency %s", depResult.BlockedBy)
-											} else {
-												depTreeResult.ErrorDetail = "Not attempted due to prior failure"
-											}
-										} else {
-											depTreeResult.ErrorDetail = fmt.Sprintf("Validation status: %s", depResult.Status)
-										}
-									}
-								}
-
-								if existingResponse, exists := batchResponse[depPath]; exists {
-									logging.Debug(logging.ComponentHandler, "      Appending to existing entry (now %d total)", existingResponse.TotalChecked+1)
-									if depResult.Compatible {
-										existingResponse.Compatible = append(existingResponse.Compatible, depTreeResult)
-									} else {
-										existingResponse.Incompatible = append(existingResponse.Incompatible, depTreeResult)
-									}
-									existingResponse.TotalChecked++
-									batchResponse[depPath] = existingResponse
-								} else {
-									logging.Debug(logging.ComponentHandler, "      Creating new entry for dependency '%s'", depPath)
-									newResponse := CompareResponse{
-										Mode:         "tree",
-										TotalChecked: 1,
-									}
-									if depResult.Compatible {
-										newResponse.Compatible = []qmldiff.TreeComparisonResult{depTreeResult}
-										newResponse.Incompatible = []qmldiff.TreeComparisonResult{}
-									} else {
-										newResponse.Compatible = []qmldiff.TreeComparisonResult{}
-										newResponse.Incompatible = []qmldiff.TreeComparisonResult{depTreeResult}
-									}
-									batchResponse[depPath] = newResponse
-								}
-							}
-						}
-					}
+// unresolvedLoads returns the LOAD targets of each root file, followed
+// recursively, that are missing from the upload or outside it, relative to
+// the root file
+func unresolvedLoads(qmdPaths, filenames []string) map[string][]string {
+	unresolved := make(map[string][]string)
+	for i, path := range qmdPaths {
+		depInfo, err := qmd.BuildDependencyInfo(path)
+		if err != nil {
+			// Reported when the file is validated
+			continue
+		}
+		if missing := append(depInfo.OutsideRoot, depInfo.NotUploaded...); len(missing) > 0 {
+			unresolved[filenames[i]] = missing
+		}
+	}
+	return unresolved
+}
 
-					logging.Debug(logging.ComponentHandler, "Flattened dependency results for %s", rootFilename)
-				}
+// externalLoads returns the LOAD EXTERNAL targets of a root file and the
+// files it LOADs, or nil if there are none
+func externalLoads(qmdPath string) []string {
+	depInfo, err := qmd.BuildDependencyInfo(qmdPath)
+	if err != nil || len(depInfo.ExternalLoads) == 0 {
+		return nil
+	}
+	return depInfo.ExternalLoads
+}
 
-				logging.Debug(logging.ComponentHandler, "Final batchResponse contains %d entries:", len(batchResponse))
-				for filename, response := range batchResponse {
-					logging.Debug(logging.ComponentHandler, "  '%s': %d total (%d compatible, %d incompatible)",
-						filename, response.TotalChecked, len(response.Compatible), len(response.Incompatible))
-				}
+// maxJobLabelLength caps client-supplied job labels
+const maxJobLabelLength = 256
 
-				logging.Info(logging.ComponentHandler, "Batch tree validation complete for job %s: %d files processed, %d total results (including dependencies)",
-					jobID, len(filenames), len(batchResponse))
+// jobLabel returns the optional client label for a new job, taken from the
+// X-Job-Label header or else the "label" form field
+func jobLabel(r *http.Request) (string, error) {
+	label := r.Header.Get("X-Job-Label")
+	if label == "" && r.MultipartForm != nil {
+		if values := r.MultipartForm.Value["label"]; len(values) > 0 {
+			label = values[0]
+		}
+	}
+	label = strings.TrimSpace(label)
+	if len(label) > maxJobLabelLength {
+		return "", fmt.Errorf("label must be at most %d bytes", maxJobLabelLength)
+	}
+	return label, nil
+}
 
-				h.jobStore.SetResults(jobID, batchResponse)
-				h.jobStore.Update(jobID, "success", "Batch validation complete", nil)
-			}
-		} else {
-			// Legacy hash-only mode (temporarily disabled with worker pool migration)
-			logging.Warn(logging.ComponentHandler, "Hash-only mode temporarily disabled during worker pool migration")
-			h.jobStore.Update(jobID, "error", "Hash-only mode temporarily unavailable", nil)
-			return
+// selectedHashtables reads the optional "versions" form field, a JSON array of
+// hashtable names such as "3.22.4.2-rmpp", to validate against instead of all
+// of them. Returns the lowercased names to use, or nil when the field is absent,
+// along with any requested names that have no loaded hashtable and tree.
+func (h *APIHandler) selectedHashtables(r *http.Request) (map[string]bool, []string, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.Value["versions"]) == 0 {
+		return nil, nil, nil
+	}
+
+	var names []string
+	if err := json.Unmarshal([]byte(r.MultipartForm.Value["versions"][0]), &names); err != nil {
+		return nil, nil, fmt.Errorf("versions must be a JSON array of names, e.g. [\"3.22.4.2-rmpp\"]")
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("versions must name at least one hashtable")
+	}
+
+	trees := h.treeService.GetTrees()
+	selected := make(map[string]bool, len(names))
+	var unknown []string
+	for _, name := range names {
+		ht := h.hashtabService.GetHashtable(name)
+		if ht == nil {
+			unknown = append(unknown, name)
+			continue
 		}
-	}()
+		if tree, _ := findTree(ht, trees); tree == nil {
+			unknown = append(unknown, name)
+			continue
+		}
+		selected[strings.ToLower(ht.Name)] = true
+	}
+	return selected, unknown, nil
+}
+
+// priorityFiles reads the optional "priority" form field, a JSON array of
+// uploaded filenames to validate before the rest of the batch
+func priorityFiles(r *http.Request) ([]string, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.Value["priority"]) == 0 {
+		return nil, nil
+	}
+
+	var priority []string
+	if err := json.Unmarshal([]byte(r.MultipartForm.Value["priority"][0]), &priority); err != nil {
+		return nil, fmt.Errorf("priority must be a JSON array of filenames, e.g. [\"mod.qmd\"]")
+	}
+	return priority, nil
+}
+
+// filterVersionDevice narrows selected, or every hashtable when it is nil,
+// to those matching the optional "version" (an OS version such as 3.22.4.2,
+// or a hashtable name) and "device" query parameters. If nothing matches,
+// returns the names that could have been validated against instead.
+func (h *APIHandler) filterVersionDevice(r *http.Request, selected map[string]bool) (map[string]bool, []string) {
+	version := strings.TrimSpace(r.URL.Query().Get("version"))
+	device := strings.TrimSpace(r.URL.Query().Get("device"))
+	if version == "" && device == "" {
+		return selected, nil
+	}
+
+	trees := h.treeService.GetTrees()
+	filtered := make(map[string]bool)
+	available := make([]string, 0)
+	for _, ht := range h.hashtabService.GetHashtables() {
+		name := strings.ToLower(ht.Name)
+		if selected != nil && !selected[name] {
+			continue
+		}
+		if tree, _ := findTree(ht, trees); tree == nil {
+			continue
+		}
+		available = append(available, ht.Name)
+		if version != "" && ht.OSVersion != version && !strings.EqualFold(ht.Name, version) {
+			continue
+		}
+		if device != "" && !strings.EqualFold(ht.Device, device) {
+			continue
+		}
+		filtered[name] = true
+	}
+
+	if len(filtered) == 0 {
+		sort.Strings(available)
+		return nil, available
+	}
+	return filtered, nil
+}
+
+// writeJobCreated responds with the ID of a newly started job, echoing its
+// label and adding any extra fields
+func writeJobCreated(w http.ResponseWriter, jobID, label string, extra map[string]interface{}) {
+	response := map[string]interface{}{
+		"jobId": jobID,
+	}
+	if label != "" {
+		response["label"] = label
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"jobId": jobID,
-	})
+	json.NewEncoder(w).Encode(response)
+}
+
+// unavailableVersions returns the declared version patterns that match no
+// hashtable with a corresponding QML tree
+func (h *APIHandler) unavailableVersions(patterns []string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	hashtables := h.hashtabService.GetHashtables()
+	trees := h.treeService.GetTrees()
+
+	var unavailable []string
+	for _, pattern := range patterns {
+		available := false
+		for _, ht := range hashtables {
+			if !qmd.VersionMatches(pattern, ht.OSVersion) {
+				continue
+			}
+			if tree, _ := findTree(ht, trees); tree != nil {
+				available = true
+				break
+			}
+		}
+		if !available {
+			unavailable = append(unavailable, pattern)
+		}
+	}
+	return unavailable
 }
 
 type HashtableInfo struct {
@@ -472,8 +925,10 @@ type HashtableInfo struct {
 }
 
 func (h *APIHandler) ListHashtables(w http.ResponseWriter, r *http.Request) {
-	if err := h.hashtabService.CheckAndReload(); err != nil {
-		logging.Error(logging.ComponentHandler, "Failed to check/reload hashtables: %v", err)
+	if !h.settings.ReadOnly {
+		if err := h.hashtabService.CheckAndReload(); err != nil {
+			logging.Error(logging.ComponentHandler, "Failed to check/reload hashtables: %v", err)
+		}
 	}
 
 	hashtables := h.hashtabService.GetHashtables()
@@ -507,11 +962,12 @@ type TreeInfo struct {
 
 func (h *APIHandler) ListValidatedVersions(w http.ResponseWriter, r *http.Request) {
 	hashtables := h.hashtabService.GetHashtables()
+	trees := h.treeService.GetTrees()
 	versionSet := make(map[string]bool)
 
+	// Match trees the way validation does, so listed versions are the ones tree mode covers
 	for _, ht := range hashtables {
-		_, treeExists := h.treeService.GetTreeByName(ht.Name)
-		if treeExists {
+		if tree, _ := findTree(ht, trees); tree != nil {
 			versionSet[ht.OSVersion] = true
 		}
 	}
@@ -520,6 +976,7 @@ func (h *APIHandler) ListValidatedVersions(w http.ResponseWriter, r *http.Reques
 	for version := range versionSet {
 		versions = append(versions, version)
 	}
+	sort.Strings(versions)
 
 	response := map[string]interface{}{
 		"versions": versions,
@@ -530,6 +987,10 @@ func (h *APIHandler) ListValidatedVersions(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 
+	if h.settings.ReadOnly {
+		return
+	}
+
 	go func() {
 		if err := h.hashtabService.CheckAndReload(); err != nil {
 			logging.Error(logging.ComponentHandler, "Failed to check/reload hashtables: %v", err)
@@ -541,8 +1002,10 @@ func (h *APIHandler) ListValidatedVersions(w http.ResponseWriter, r *http.Reques
 }
 
 func (h *APIHandler) ListTrees(w http.ResponseWriter, r *http.Request) {
-	if err := h.treeService.CheckAndReload(); err != nil {
-		logging.Error(logging.ComponentHandler, "Failed to check/reload trees: %v", err)
+	if !h.settings.ReadOnly {
+		if err := h.treeService.CheckAndReload(); err != nil {
+			logging.Error(logging.ComponentHandler, "Failed to check/reload trees: %v", err)
+		}
 	}
 
 	trees := h.treeService.GetTrees()
@@ -578,6 +1041,26 @@ func (h *APIHandler) GetResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	only := r.URL.Query().Get("only")
+	if only != "" && only != "compatible" && only != "incompatible" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "only must be 'compatible' or 'incompatible'",
+		})
+		return
+	}
+
+	fields, err := parseResultFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	job, ok := h.jobStore.Get(jobID)
 	if !ok {
 		w.Header().Set("Content-Type", "application/json")
@@ -588,13 +1071,27 @@ func (h *APIHandler) GetResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if job.Status != "success" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
+	if job.Label != "" {
+		w.Header().Set("X-Job-Label", job.Label)
+	}
+
+	// Timed-out jobs still serve the partial results they accumulated
+	if job.Status != "success" && !(job.Status == "timeout" && job.Results != nil) {
+		response := map[string]interface{}{
 			"status":  job.Status,
 			"message": job.Message,
-		})
+		}
+		if job.Label != "" {
+			response["label"] = job.Label
+		}
+		// Long-running jobs expose the per-file results completed so far
+		if job.Partial {
+			response["partial_results"] = job.Results
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
@@ -607,14 +1104,159 @@ func (h *APIHandler) GetResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	results, err := projectResultFields(filterResults(job.Results, only), fields)
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to project results for job %s: %v", jobID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Results not available",
+		})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(job.Results)
+	json.NewEncoder(w).Encode(results)
+}
+
+// filterResults projects compare results down to only the compatible or
+// incompatible entries; other result types are returned unchanged
+func filterResults(results interface{}, only string) interface{} {
+	if only == "" {
+		return results
+	}
+
+	filter := func(response CompareResponse) CompareResponse {
+		if only == "compatible" {
+			response.Incompatible = make([]qmldiff.TreeComparisonResult, 0)
+		} else {
+			response.Compatible = make([]qmldiff.TreeComparisonResult, 0)
+		}
+		return response
+	}
+
+	switch r := results.(type) {
+	case CompareResponse:
+		return filter(r)
+	case map[string]CompareResponse:
+		filtered := make(map[string]CompareResponse, len(r))
+		for filename, response := range r {
+			filtered[filename] = filter(response)
+		}
+		return filtered
+	default:
+		return results
+	}
+}
+
+// GetHashlist returns a hashlist covering every hash referenced by a job's QMD files
+func (h *APIHandler) GetHashlist(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobId")
+	if jobID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Job ID required",
+		})
+		return
+	}
+
+	job, ok := h.jobStore.Get(jobID)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Job not found",
+		})
+		return
+	}
+
+	rootPaths, _, err := uploadRootFiles(job.FilesDir)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Hashlist not available for this job",
+		})
+		return
+	}
+
+	hashes, err := qmd.CollectHashes(rootPaths)
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to collect hashes for job %s: %v", jobID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to collect hashes",
+		})
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "qmd-hashlist-*")
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to create temp directory: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to create temp directory",
+		})
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	hashlistPath := filepath.Join(tempDir, "hashlist.bin")
+	if err := hashtab.WriteHashlist(hashes, hashlistPath); err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to write hashlist for job %s: %v", jobID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to write hashlist",
+		})
+		return
+	}
+
+	logging.Info(logging.ComponentHandler, "Serving hashlist for job %s (%d hashes)", jobID, len(hashes))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="hashlist-%s.bin"`, jobID))
+	http.ServeFile(w, r, hashlistPath)
+}
+
+// uploadRootFiles lists the top-level .qmd files of a job's upload directory,
+// the ones a compare job validates, with their names
+func uploadRootFiles(dir string) (paths, names []string, err error) {
+	if dir == "" {
+		return nil, nil, os.ErrNotExist
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	all := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			all = append(all, filepath.Join(dir, entry.Name()))
+		}
+	}
+	paths = qmd.GetRootLevelFiles(dir, all)
+	names = make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = filepath.Base(path)
+	}
+	return paths, names, nil
 }
 
 // ValidateTree validates a QMD file against a full QML tree
 func (h *APIHandler) ValidateTree(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
+	err := r.ParseMultipartForm(h.settings.MultipartMemory)
+	// Remove the parser's spooled temp files, including after partial reads
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+	if err != nil {
 		logging.Error(logging.ComponentHandler, "Failed to parse multipart form: %v", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -636,11 +1278,22 @@ func (h *APIHandler) ValidateTree(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	hashtabPath := r.FormValue("hashtab_path")
-	treePath := r.FormValue("tree_path")
+	label, err := jobLabel(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Repeated tree_path/hashtab_path fields are paired by position
+	hashtabPaths := r.MultipartForm.Value["hashtab_path"]
+	treePaths := r.MultipartForm.Value["tree_path"]
 	workersStr := r.FormValue("workers")
 
-	if hashtabPath == "" {
+	if len(hashtabPaths) == 0 || hashtabPaths[0] == "" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -649,7 +1302,7 @@ func (h *APIHandler) ValidateTree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if treePath == "" {
+	if len(treePaths) == 0 || treePaths[0] == "" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -658,6 +1311,36 @@ func (h *APIHandler) ValidateTree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(hashtabPaths) != len(treePaths) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("got %d tree_path values but %d hashtab_path values", len(treePaths), len(hashtabPaths)),
+		})
+		return
+	}
+
+	seenTrees := make(map[string]bool, len(treePaths))
+	for i, treePath := range treePaths {
+		if treePath == "" || hashtabPaths[i] == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "tree_path and hashtab_path must not be empty",
+			})
+			return
+		}
+		if seenTrees[treePath] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("duplicate tree_path: %s", treePath),
+			})
+			return
+		}
+		seenTrees[treePath] = true
+	}
+
 	workers := 4
 	if workersStr != "" {
 		if _, err := fmt.Sscanf(workersStr, "%d", &workers); err != nil || workers < 1 {
@@ -670,8 +1353,22 @@ func (h *APIHandler) ValidateTree(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	logging.Info(logging.ComponentHandler, "Received tree validation request: %s, hashtab=%s, tree=%s, workers=%d",
-		header.Filename, hashtabPath, treePath, workers)
+	binary, status, err := h.qmldiffBinary(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	qmldiffService := h.qmldiffService
+	if binary != "" {
+		qmldiffService = qmldiffService.WithBinary(binary)
+	}
+
+	logging.Info(logging.ComponentHandler, "Received tree validation request: %s, hashtabs=%v, trees=%v, workers=%d",
+		header.Filename, hashtabPaths, treePaths, workers)
 
 	qmdPath, err := qmldiff.SaveUploadedFile(file, header.Filename)
 	if err != nil {
@@ -686,43 +1383,151 @@ func (h *APIHandler) ValidateTree(w http.ResponseWriter, r *http.Request) {
 
 	jobID := uuid.New().String()
 	h.jobStore.Create(jobID)
+	h.jobStore.SetLabel(jobID, label)
 	logging.Info(logging.ComponentHandler, "Created tree validation job %s for file %s", jobID, header.Filename)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	h.jobStore.SetCancel(jobID, cancel)
+
 	go func() {
+		defer cancel()
 		defer os.RemoveAll(filepath.Dir(qmdPath))
 
 		logging.Info(logging.ComponentHandler, "Starting tree validation for job %s", jobID)
 		h.jobStore.UpdateWithOperation(jobID, "running", "Validating QMD against QML tree", nil, "validating")
 		h.jobStore.UpdateProgress(jobID, 10)
 
-		result, err := h.qmldiffService.ValidateAgainstTree(qmdPath, hashtabPath, treePath)
-		if err != nil {
-			logging.Error(logging.ComponentHandler, "Tree validation failed for job %s: %v", jobID, err)
-			h.jobStore.Update(jobID, "error", fmt.Sprintf("Validation failed: %v", err), nil)
-			return
-		}
+		// A single pair keeps the flat response; multiple pairs are keyed by tree_path
+		responses := make(map[string]interface{}, len(treePaths))
+		for i, treePath := range treePaths {
+			if len(treePaths) > 1 {
+				h.jobStore.UpdateWithOperation(jobID, "running", fmt.Sprintf("Validating against %s", treePath), nil, "validating")
+			}
 
-		response := map[string]interface{}{
-			"files_processed":   result.FilesProcessed,
-			"files_modified":    result.FilesModified,
-			"files_with_errors": result.FilesWithErrors,
-			"has_hash_errors":   result.HasHashErrors,
-			"errors":            result.Errors,
-			"failed_hashes":     result.FailedHashes,
-			"success":           result.FilesWithErrors == 0 && !result.HasHashErrors,
+			result, err := qmldiffService.ValidateAgainstTree(ctx, qmdPath, hashtabPaths[i], treePath)
+			if ctx.Err() != nil {
+				logging.Info(logging.ComponentHandler, "Tree validation job %s cancelled", jobID)
+				return
+			}
+			if err != nil {
+				logging.Error(logging.ComponentHandler, "Tree validation failed for job %s (tree %s): %v", jobID, treePath, err)
+				if len(treePaths) == 1 {
+					h.jobStore.Update(jobID, "error", fmt.Sprintf("Validation failed: %v", err), nil)
+					return
+				}
+				responses[treePath] = map[string]interface{}{
+					"hashtab_path": hashtabPaths[i],
+					"error":        err.Error(),
+					"success":      false,
+				}
+				h.jobStore.UpdateProgress(jobID, 10+90*(i+1)/len(treePaths))
+				continue
+			}
+
+			logging.Info(logging.ComponentHandler, "Tree validation complete for job %s (tree %s): %d processed, %d modified, %d errors",
+				jobID, treePath, result.FilesProcessed, result.FilesModified, result.FilesWithErrors)
+
+			response := treeValidationResponse(result)
+			if len(treePaths) > 1 {
+				response["hashtab_path"] = hashtabPaths[i]
+			}
+			responses[treePath] = response
+			h.jobStore.UpdateProgress(jobID, 10+90*(i+1)/len(treePaths))
 		}
 
-		logging.Info(logging.ComponentHandler, "Tree validation complete for job %s: %d processed, %d modified, %d errors",
-			jobID, result.FilesProcessed, result.FilesModified, result.FilesWithErrors)
+		var response interface{} = responses
+		if len(treePaths) == 1 {
+			response = responses[treePaths[0]]
+		}
 
 		h.jobStore.SetResults(jobID, response)
 		h.jobStore.Update(jobID, "success", "Validation complete", nil)
 		h.jobStore.UpdateProgress(jobID, 100)
 	}()
 
+	var created map[string]interface{}
+	if binary != "" {
+		created = map[string]interface{}{"qmldiff_binary": binary}
+	}
+	writeJobCreated(w, jobID, label, created)
+}
+
+// CheckSyntax lexes an uploaded QMD file and reports tokenization errors
+// No hashtables or trees are involved, so the result is returned directly
+func (h *APIHandler) CheckSyntax(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(h.settings.MultipartMemory)
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to parse multipart form: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to parse form data",
+		})
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "No file uploaded or invalid form data",
+		})
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to read uploaded file: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to read uploaded file",
+		})
+		return
+	}
+
+	_, syntaxErrors := qmd.Lex(string(content))
+	if syntaxErrors == nil {
+		syntaxErrors = []qmd.SyntaxError{}
+	}
+
+	logging.Info(logging.ComponentHandler, "Syntax check for %s: %d errors", header.Filename, len(syntaxErrors))
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"jobId": jobID,
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"filename": header.Filename,
+		"valid":    len(syntaxErrors) == 0,
+		"errors":   syntaxErrors,
 	})
 }
+
+// treeValidationResponse converts a tree validation result into the results payload
+func treeValidationResponse(result *qmldiff.TreeValidationResult) map[string]interface{} {
+	failedHashes := make([]string, len(result.FailedHashes))
+	for i, hash := range result.FailedHashes {
+		failedHashes[i] = strconv.FormatUint(hash, 10)
+	}
+
+	response := map[string]interface{}{
+		"files_processed":   result.FilesProcessed,
+		"files_modified":    result.FilesModified,
+		"files_with_errors": result.FilesWithErrors,
+		"has_hash_errors":   result.HasHashErrors,
+		"errors":            result.Errors,
+		"failed_hashes":     failedHashes,
+		"version_skipped":   result.VersionSkipped,
+		"success":           result.FilesWithErrors == 0 && !result.HasHashErrors,
+	}
+	if len(result.ModifiedFiles) > 0 {
+		response["modified_files"] = result.ModifiedFiles
+	}
+	return response
+}
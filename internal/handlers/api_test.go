@@ -0,0 +1,1333 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+func newMultipartRequest(t *testing.T, files map[string]string, paths []string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for name, content := range files {
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatalf("CreateFormFile() failed: %v", err)
+		}
+		part.Write([]byte(content))
+	}
+	for _, path := range paths {
+		if err := writer.WriteField("paths", path); err != nil {
+			t.Fatalf("WriteField() failed: %v", err)
+		}
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/compare", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestCompareRejectsMismatchedPaths(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	req := newMultipartRequest(t, map[string]string{
+		"a.qmd": "AFFECT [[1]]\n",
+		"b.qmd": "AFFECT [[2]]\n",
+	}, []string{"a.qmd"})
+	rec := httptest.NewRecorder()
+
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if !strings.Contains(resp["error"], "does not match") {
+		t.Errorf("error = %q, want mismatch message", resp["error"])
+	}
+}
+
+func TestCompareRejectsUploadsWithNothingToValidate(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	tests := []struct {
+		name         string
+		files        map[string]string
+		wantError    string
+		wantExcluded []string
+	}{
+		{"empty", map[string]string{"a.qmd": ""}, "All uploaded files are empty", nil},
+		{"excluded", map[string]string{".DS_Store": "junk", "a.qmd.bak": "AFFECT [[1]]\n", "b.qmd": ""}, "All uploaded files were excluded by EXCLUDE_PATTERNS", []string{".DS_Store", "a.qmd.bak"}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.Compare(rec, newMultipartRequest(t, tt.files, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, http.StatusBadRequest)
+			continue
+		}
+		var resp struct {
+			Error    string   `json:"error"`
+			Excluded []string `json:"excluded"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: Decode() failed: %v", tt.name, err)
+		}
+		sort.Strings(resp.Excluded)
+		if resp.Error != tt.wantError || !reflect.DeepEqual(resp.Excluded, tt.wantExcluded) {
+			t.Errorf("%s: error = %q, excluded = %v, want %q and %v", tt.name, resp.Error, resp.Excluded, tt.wantError, tt.wantExcluded)
+		}
+	}
+}
+
+func TestGetResultsOnlyFilter(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+
+	store.Create("job")
+	store.SetResults("job", CompareResponse{
+		Compatible:   []qmldiff.TreeComparisonResult{{Hashtable: "3.22.0.64-rmpp", Compatible: true}},
+		Incompatible: []qmldiff.TreeComparisonResult{{Hashtable: "3.20.0.52-rm2"}},
+		TotalChecked: 2,
+		Mode:         "tree",
+	})
+	store.Update("job", "success", "Validation complete", nil)
+
+	router := chi.NewRouter()
+	router.Get("/api/results/{jobId}", h.GetResults)
+
+	tests := []struct {
+		query            string
+		wantStatus       int
+		wantCompatible   int
+		wantIncompatible int
+	}{
+		{"", http.StatusOK, 1, 1},
+		{"?only=incompatible", http.StatusOK, 0, 1},
+		{"?only=compatible", http.StatusOK, 1, 0},
+		{"?only=bogus", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/results/job"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp CompareResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if len(resp.Compatible) != tt.wantCompatible || len(resp.Incompatible) != tt.wantIncompatible {
+				t.Errorf("got %d compatible, %d incompatible; want %d, %d",
+					len(resp.Compatible), len(resp.Incompatible), tt.wantCompatible, tt.wantIncompatible)
+			}
+		})
+	}
+}
+
+func TestValidateTreeRejectsUnpairedPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		trees    []string
+		hashtabs []string
+		wantErr  string
+	}{
+		{
+			name:     "more trees than hashtabs",
+			trees:    []string{"/trees/a", "/trees/b"},
+			hashtabs: []string{"/hashtabs/a"},
+			wantErr:  "got 2 tree_path values but 1 hashtab_path values",
+		},
+		{
+			name:     "duplicate tree",
+			trees:    []string{"/trees/a", "/trees/a"},
+			hashtabs: []string{"/hashtabs/a", "/hashtabs/b"},
+			wantErr:  "duplicate tree_path: /trees/a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			part, err := writer.CreateFormFile("file", "a.qmd")
+			if err != nil {
+				t.Fatalf("CreateFormFile() failed: %v", err)
+			}
+			part.Write([]byte("AFFECT [[1]]\n"))
+			for _, tree := range tt.trees {
+				writer.WriteField("tree_path", tree)
+			}
+			for _, hashtab := range tt.hashtabs {
+				writer.WriteField("hashtab_path", hashtab)
+			}
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/validate/tree", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+
+			h.ValidateTree(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			var resp map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if resp["error"] != tt.wantErr {
+				t.Errorf("error = %q, want %q", resp["error"], tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckSyntax(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "broken.qmd")
+	if err != nil {
+		t.Fatalf("CreateFormFile() failed: %v", err)
+	}
+	part.Write([]byte("AFFECT [[1]]\nREPLACE [[2]] WITH \"oops\n"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/check-syntax", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+
+	h.CheckSyntax(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var resp struct {
+		Valid  bool              `json:"valid"`
+		Errors []qmd.SyntaxError `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if resp.Valid {
+		t.Error("valid = true, want false")
+	}
+	want := []qmd.SyntaxError{{Line: 2, Column: 20, Message: "unterminated string"}}
+	if !reflect.DeepEqual(resp.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", resp.Errors, want)
+	}
+}
+
+func TestExtractHashes(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	extract := func(query string) []qmldiff.MissingHashInfo {
+		t.Helper()
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", "repeat.qmd")
+		if err != nil {
+			t.Fatalf("CreateFormFile() failed: %v", err)
+		}
+		part.Write([]byte("AFFECT [[1]]\nREPLACE [[2]] WITH [[1]]\n"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/extract-hashes"+query, &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+
+		h.ExtractHashes(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp struct {
+			Hashes []qmldiff.MissingHashInfo `json:"hashes"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("Decode() failed: %v", err)
+		}
+		return resp.Hashes
+	}
+
+	want := []qmldiff.MissingHashInfo{
+		{Hash: "1", Line: 1, Column: 10, Offset: 9},
+		{Hash: "2", Line: 2, Column: 11, Offset: 23},
+		{Hash: "1", Line: 2, Column: 22, Offset: 34},
+	}
+	if got := extract(""); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("hashes = %+v, want %+v", got, want[:2])
+	}
+	if got := extract("?all=true"); !reflect.DeepEqual(got, want) {
+		t.Errorf("all hashes = %+v, want %+v", got, want)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	jobStore := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, nil, nil, jobStore, nil, 1, DefaultSettings())
+
+	jobStore.Create("running")
+	ctx, cancel := context.WithCancel(context.Background())
+	jobStore.SetCancel("running", cancel)
+	jobStore.Create("done")
+	jobStore.Update("done", "success", "Validation complete", nil)
+
+	cancelJob := func(jobID string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/cancel/"+jobID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("jobId", jobID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		h.CancelJob(rec, req)
+		return rec.Code
+	}
+
+	if code := cancelJob("running"); code != http.StatusOK {
+		t.Errorf("cancel running job: status = %d, want %d", code, http.StatusOK)
+	}
+	if ctx.Err() == nil {
+		t.Error("job context was not cancelled")
+	}
+	if job, _ := jobStore.Get("running"); job.Status != "cancelled" {
+		t.Errorf("job status = %q, want cancelled", job.Status)
+	}
+
+	if code := cancelJob("done"); code != http.StatusConflict {
+		t.Errorf("cancel finished job: status = %d, want %d", code, http.StatusConflict)
+	}
+	if code := cancelJob("missing"); code != http.StatusNotFound {
+		t.Errorf("cancel unknown job: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestCommonFailures(t *testing.T) {
+	missing := func(hashes ...uint64) []qmd.HashWithPosition {
+		positions := make([]qmd.HashWithPosition, len(hashes))
+		for i, hash := range hashes {
+			positions[i] = qmd.HashWithPosition{Hash: hash}
+		}
+		return positions
+	}
+
+	results := map[string]CompareResponse{
+		"a.qmd": {
+			Incompatible: []qmldiff.TreeComparisonResult{
+				{Hashtable: "3.22.0.64-rmpp", MissingHashes: missing(7, 9)},
+				{Hashtable: "3.22.4.2-rmpp", MissingHashes: missing(7, 7)},
+			},
+		},
+		"b.qmd": {
+			Compatible: []qmldiff.TreeComparisonResult{{Hashtable: "3.22.4.2-rmpp", Compatible: true}},
+			Incompatible: []qmldiff.TreeComparisonResult{
+				{Hashtable: "3.22.0.64-rmpp", MissingHashes: missing(9, 10)},
+			},
+		},
+	}
+
+	got := commonFailures(results)
+	want := []CommonFailure{
+		{Hash: "7", Count: 2, Files: []string{"a.qmd"}, Versions: []string{"3.22.0.64-rmpp", "3.22.4.2-rmpp"}},
+		{Hash: "9", Count: 2, Files: []string{"a.qmd", "b.qmd"}, Versions: []string{"3.22.0.64-rmpp"}},
+		{Hash: "10", Count: 1, Files: []string{"b.qmd"}, Versions: []string{"3.22.0.64-rmpp"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commonFailures() = %+v, want %+v", got, want)
+	}
+}
+
+func TestListTreesReadOnlySkipsReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		readOnly  bool
+		wantCount int
+	}{
+		{"reloads by default", false, 1},
+		{"read-only keeps startup catalog", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			treeDir := t.TempDir()
+			treeService := qmltree.NewService(treeDir)
+			settings := DefaultSettings()
+			settings.ReadOnly = tt.readOnly
+			h := NewAPIHandler(nil, nil, treeService, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, settings)
+
+			if err := os.MkdirAll(filepath.Join(treeDir, "3.22.4.2-rmpp"), 0755); err != nil {
+				t.Fatalf("MkdirAll() failed: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ListTrees(rec, httptest.NewRequest(http.MethodGet, "/api/trees", nil))
+
+			var resp struct {
+				Count int `json:"count"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if resp.Count != tt.wantCount {
+				t.Errorf("count = %d, want %d", resp.Count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestListTreeFiles(t *testing.T) {
+	treeDir := t.TempDir()
+	for _, name := range []string{"qml/Main.qml", "qml/components/Button.qml", "Root.qml"} {
+		path := filepath.Join(treeDir, "3.22.4.2-rmpp", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("Item {}\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	h := NewAPIHandler(nil, nil, qmltree.NewService(treeDir), jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+	router := chi.NewRouter()
+	router.Get("/api/trees/{name}/files", h.ListTreeFiles)
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantFiles  []string
+	}{
+		{"all files sorted", "/api/trees/3.22.4.2-RMPP/files", http.StatusOK, []string{"Root.qml", "qml/Main.qml", "qml/components/Button.qml"}},
+		{"prefix", "/api/trees/3.22.4.2-rmpp/files?prefix=qml/components/", http.StatusOK, []string{"qml/components/Button.qml"}},
+		{"absolute prefix", "/api/trees/3.22.4.2-rmpp/files?prefix=/qml/M", http.StatusOK, []string{"qml/Main.qml"}},
+		{"no match", "/api/trees/3.22.4.2-rmpp/files?prefix=nope", http.StatusOK, []string{}},
+		{"unknown tree", "/api/trees/3.20.0.1-rm2/files", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantFiles == nil {
+				return
+			}
+
+			var resp struct {
+				FileCount int      `json:"file_count"`
+				Files     []string `json:"files"`
+				Count     int      `json:"count"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Files, tt.wantFiles) || resp.Count != len(tt.wantFiles) {
+				t.Errorf("files = %v (count %d), want %v", resp.Files, resp.Count, tt.wantFiles)
+			}
+			if resp.FileCount != 3 {
+				t.Errorf("file_count = %d, want 3", resp.FileCount)
+			}
+		})
+	}
+}
+
+func TestFindTreeVersionMismatch(t *testing.T) {
+	trees := []*qmltree.Tree{
+		{Name: "3.22.4.1-rmpp", OSVersion: "3.22.4.1", Device: "rmpp"},
+		{Name: "3.22.4.2-rmpp", OSVersion: "3.22.4.2", Device: "rmpp"},
+	}
+
+	tests := []struct {
+		name         string
+		ht           *hashtab.Hashtab
+		wantTree     string
+		wantMismatch bool
+	}{
+		{
+			name:     "filename version",
+			ht:       &hashtab.Hashtab{Name: "3.22.4.2-rmpp", OSVersion: "3.22.4.2", Device: "rmpp"},
+			wantTree: "3.22.4.2-rmpp",
+		},
+		{
+			name:     "embedded version matches a tree",
+			ht:       &hashtab.Hashtab{Name: "3.22.4.2-rmpp", OSVersion: "3.22.4.1", Device: "rmpp", EmbeddedVersion: "3.22.4.1"},
+			wantTree: "3.22.4.1-rmpp",
+		},
+		{
+			name:         "embedded version matches no tree",
+			ht:           &hashtab.Hashtab{Name: "3.22.4.2-rmpp", OSVersion: "3.22.4.9", Device: "rmpp", EmbeddedVersion: "3.22.4.9"},
+			wantTree:     "3.22.4.2-rmpp",
+			wantMismatch: true,
+		},
+		{
+			name: "no tree",
+			ht:   &hashtab.Hashtab{Name: "3.20.0.1-rm2", OSVersion: "3.20.0.1", Device: "rm2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, mismatch := findTree(tt.ht, trees)
+
+			gotTree := ""
+			if tree != nil {
+				gotTree = tree.Name
+			}
+			if gotTree != tt.wantTree {
+				t.Errorf("findTree() tree = %q, want %q", gotTree, tt.wantTree)
+			}
+			if (mismatch != "") != tt.wantMismatch {
+				t.Errorf("findTree() mismatch = %q, want mismatch %v", mismatch, tt.wantMismatch)
+			}
+		})
+	}
+}
+
+func TestListValidatedVersionsMatchesTreesLikeValidation(t *testing.T) {
+	hashtabDir := t.TempDir()
+	treeDir := t.TempDir()
+	hashtables := map[string]map[uint64]string{
+		// Named after a tree
+		"3.20.0.52-rm2": {1: "width"},
+		// Embeds a version whose tree has a different name than the hashtable
+		"3.22.4.2-rmpp": {1: "width", hashtab.DefaultVersionHash: "3.22.4.1"},
+		// Embeds a version with no tree; validation falls back to the tree named after it
+		"3.23.0.1-rmpp": {1: "width", hashtab.DefaultVersionHash: "3.23.0.2"},
+		// No tree by name or by version and device
+		"3.18.0.1-rm1": {1: "width"},
+	}
+	for name, entries := range hashtables {
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	for _, name := range []string{"3.20.0.52-RM2", "3.22.4.1-rmpp", "3.23.0.1-rmpp", "3.18.0.1-rm2"} {
+		if err := os.MkdirAll(filepath.Join(treeDir, name), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(treeDir, name, "Main.qml"), []byte("Item {}"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	treeService := qmltree.NewService(treeDir)
+	settings := DefaultSettings()
+	settings.ReadOnly = true
+	h := NewAPIHandler(nil, hashtabService, treeService, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, settings)
+
+	rec := httptest.NewRecorder()
+	h.ListValidatedVersions(rec, httptest.NewRequest(http.MethodGet, "/api/validated-versions", nil))
+	var resp struct {
+		Versions []string `json:"versions"`
+		Count    int      `json:"count"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	want := []string{"3.20.0.52", "3.22.4.1", "3.23.0.2"}
+	if !reflect.DeepEqual(resp.Versions, want) || resp.Count != len(want) {
+		t.Errorf("versions = %v (count %d), want %v", resp.Versions, resp.Count, want)
+	}
+
+	// Every listed version is one the worker adapter finds a tree for
+	trees := treeService.GetTrees()
+	for _, ht := range hashtabService.GetHashtables() {
+		tree, _ := findTree(ht, trees)
+		if listed := slices.Contains(resp.Versions, ht.OSVersion); listed != (tree != nil) {
+			t.Errorf("%s (version %s): listed = %v, validation finds tree = %v", ht.Name, ht.OSVersion, listed, tree != nil)
+		}
+	}
+}
+
+func TestJobLabel(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	// An oversized label is rejected before any job is created
+	req := newMultipartRequest(t, map[string]string{"a.qmd": "AFFECT [[1]]\n"}, nil)
+	req.Header.Set("X-Job-Label", strings.Repeat("x", maxJobLabelLength+1))
+	rec := httptest.NewRecorder()
+	h.Compare(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("oversized label status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// The header wins over the form field
+	req = newMultipartRequest(t, map[string]string{"a.qmd": "AFFECT [[1]]\n"}, nil)
+	req.Header.Set("X-Job-Label", " PR #42 ")
+	if err := req.ParseMultipartForm(10 << 20); err != nil {
+		t.Fatalf("ParseMultipartForm() failed: %v", err)
+	}
+	req.MultipartForm.Value["label"] = []string{"form label"}
+	if label, err := jobLabel(req); err != nil || label != "PR #42" {
+		t.Errorf("jobLabel() = %q, %v, want %q", label, err, "PR #42")
+	}
+	req.Header.Del("X-Job-Label")
+	if label, err := jobLabel(req); err != nil || label != "form label" {
+		t.Errorf("jobLabel() = %q, %v, want %q", label, err, "form label")
+	}
+}
+
+func TestGetResultsEchoesLabel(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+	router := chi.NewRouter()
+	router.Get("/api/results/{jobId}", h.GetResults)
+
+	store.Create("job")
+	store.SetLabel("job", "nightly")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/results/job", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if got := rec.Header().Get("X-Job-Label"); got != "nightly" {
+		t.Errorf("X-Job-Label = %q, want %q", got, "nightly")
+	}
+	var resp map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if resp["label"] != "nightly" {
+		t.Errorf("label = %v, want %q", resp["label"], "nightly")
+	}
+}
+
+func TestCompareReportsSubdirectoryOnlyUpload(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	req := newMultipartRequest(t, map[string]string{
+		"a.qmd": "AFFECT [[1]]\n",
+		"b.qmd": "AFFECT [[2]]\n",
+		"c.qmd": "AFFECT [[3]]\n",
+	}, []string{"mods/a.qmd", "mods/lib/b.qmd", "extra/c.qmd"})
+	rec := httptest.NewRecorder()
+
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var resp struct {
+		Error             string   `json:"error"`
+		SubdirectoryFiles int      `json:"subdirectory_files"`
+		Subdirectories    []string `json:"subdirectories"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if resp.SubdirectoryFiles != 3 {
+		t.Errorf("subdirectory_files = %d, want 3", resp.SubdirectoryFiles)
+	}
+	if want := []string{"extra", "mods"}; !reflect.DeepEqual(resp.Subdirectories, want) {
+		t.Errorf("subdirectories = %v, want %v", resp.Subdirectories, want)
+	}
+	if !strings.Contains(resp.Error, "extra, mods") {
+		t.Errorf("error = %q, want it to name the subdirectories", resp.Error)
+	}
+}
+
+func TestCompareAcknowledgesCounts(t *testing.T) {
+	hashtabDir := t.TempDir()
+	for _, name := range []string{"3.22.4.2-rmpp", "3.20.0.52-rm2"} {
+		entries := map[uint64]string{hashtab.DJB2Hash("width"): "width"}
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+
+	h := NewAPIHandler(nil, hashtabService, qmltree.NewService(t.TempDir()), jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	req := newMultipartRequest(t, map[string]string{
+		"a.qmd":   "AFFECT [[1]]\n",
+		"b.qmd":   "AFFECT [[2]]\n",
+		"lib.qmd": "AFFECT [[3]]\n",
+	}, []string{"a.qmd", "b.qmd", "lib/lib.qmd"})
+	rec := httptest.NewRecorder()
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if resp["jobId"] == "" || resp["files_accepted"] != float64(2) || resp["hashtables_count"] != float64(2) {
+		t.Errorf("response = %v, want a jobId with files_accepted 2 and hashtables_count 2", resp)
+	}
+}
+
+func TestCompareAlwaysBatch(t *testing.T) {
+	hashtabDir := t.TempDir()
+	if err := hashtab.WriteHashtab(map[uint64]string{1: "width"}, filepath.Join(hashtabDir, "3.22.4.2-rmpp")); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	jobStore := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, hashtabService, qmltree.NewService(t.TempDir()), jobStore, nil, 1, DefaultSettings())
+
+	compare := func(query string) (string, interface{}, json.RawMessage) {
+		t.Helper()
+		req := newMultipartRequest(t, map[string]string{"mod.qmd": "AFFECT [[1]]\n"}, []string{"mod.qmd"})
+		req.URL.RawQuery = query
+		rec := httptest.NewRecorder()
+		h.Compare(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+
+		jobID := resp["jobId"].(string)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			job, _ := jobStore.Get(jobID)
+			if jobs.IsTerminal(job.Status) {
+				return resp["result_shape"].(string), job.Results, job.Summary
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %s still %s", jobID, job.Status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	shape, results, _ := compare("mode=hash")
+	if _, ok := results.(CompareResponse); shape != "single" || !ok {
+		t.Errorf("default: result_shape = %q, results = %T, want single and CompareResponse", shape, results)
+	}
+
+	shape, results, summary := compare("mode=hash&always_batch=true")
+	batch, ok := results.(map[string]CompareResponse)
+	if shape != "batch" || !ok {
+		t.Fatalf("always_batch: result_shape = %q, results = %T, want batch and a map", shape, results)
+	}
+	if response, ok := batch["mod.qmd"]; !ok || len(response.Compatible) != 1 {
+		t.Errorf("always_batch results = %+v, want mod.qmd compatible with one hashtable", batch)
+	}
+	// The batch's error summary is kept once, on the job
+	var errorSummary ErrorSummary
+	if err := json.Unmarshal(summary, &errorSummary); err != nil || errorSummary != (ErrorSummary{}) {
+		t.Errorf("always_batch error summary = %s (%v), want all zero", summary, err)
+	}
+}
+
+func TestCompareWarnsAboutUnresolvedLoads(t *testing.T) {
+	hashtabService, err := hashtab.NewService(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	h := NewAPIHandler(nil, hashtabService, qmltree.NewService(t.TempDir()), jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	req := newMultipartRequest(t, map[string]string{
+		"mod.qmd": "LOAD lib/helpers.qmd\nAFFECT [[1]]\n",
+	}, []string{"mod.qmd"})
+	rec := httptest.NewRecorder()
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp struct {
+		Warning         string              `json:"warning"`
+		UnresolvedLoads map[string][]string `json:"unresolved_loads"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if want := map[string][]string{"mod.qmd": {"lib/helpers.qmd"}}; !reflect.DeepEqual(resp.UnresolvedLoads, want) {
+		t.Errorf("unresolved_loads = %v, want %v", resp.UnresolvedLoads, want)
+	}
+	if resp.Warning == "" {
+		t.Error("warning is empty")
+	}
+}
+
+func TestUnresolvedLoads(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.qmd":          "LOAD lib/shared.qmd\nLOAD lib/gone.qmd\n",
+		"b.qmd":          "LOAD lib/shared.qmd\n",
+		"lib/shared.qmd": "LOAD nested.qmd\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	got := unresolvedLoads([]string{filepath.Join(dir, "a.qmd"), filepath.Join(dir, "b.qmd")}, []string{"a.qmd", "b.qmd"})
+	want := map[string][]string{
+		"a.qmd": {"lib/gone.qmd", "lib/nested.qmd"},
+		"b.qmd": {"lib/nested.qmd"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unresolvedLoads() = %v, want %v", got, want)
+	}
+}
+
+func TestPrioritizeFiles(t *testing.T) {
+	paths := []string{"/up/a.qmd", "/up/b.qmd", "/up/c.qmd", "/up/d.qmd"}
+	filenames := []string{"a.qmd", "b.qmd", "c.qmd", "d.qmd"}
+
+	// Unknown and repeated names are ignored
+	gotPaths, gotFilenames := prioritizeFiles(paths, filenames, []string{"d.qmd", "missing.qmd", "b.qmd", "d.qmd"})
+	if want := []string{"d.qmd", "b.qmd", "a.qmd", "c.qmd"}; !reflect.DeepEqual(gotFilenames, want) {
+		t.Errorf("filenames = %v, want %v", gotFilenames, want)
+	}
+	if want := []string{"/up/d.qmd", "/up/b.qmd", "/up/a.qmd", "/up/c.qmd"}; !reflect.DeepEqual(gotPaths, want) {
+		t.Errorf("paths = %v, want %v", gotPaths, want)
+	}
+
+	if _, got := prioritizeFiles(paths, filenames, nil); !reflect.DeepEqual(got, filenames) {
+		t.Errorf("without priority filenames = %v, want %v", got, filenames)
+	}
+
+	priority := map[string]bool{"d.qmd": true, "b.qmd": true}
+	if got, want := priorityBatches(gotFilenames, priority), [][2]int{{0, 2}, {2, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("priorityBatches() = %v, want %v", got, want)
+	}
+	// A hashtable whose files are all, or none, of the priority ones validates them together
+	for _, files := range [][]string{{"d.qmd", "b.qmd"}, {"a.qmd", "c.qmd"}} {
+		if got, want := priorityBatches(files, priority), [][2]int{{0, 2}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("priorityBatches(%v) = %v, want %v", files, got, want)
+		}
+	}
+}
+
+func TestCompareSelectedVersions(t *testing.T) {
+	hashtabDir := t.TempDir()
+	treeDir := t.TempDir()
+	for _, name := range []string{"3.22.4.2-rmpp", "3.20.0.52-rm2", "3.18.0.1-rm2"} {
+		entries := map[uint64]string{hashtab.DJB2Hash("width"): "width"}
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	// 3.18.0.1-rm2 has a hashtable but no tree
+	for _, name := range []string{"3.22.4.2-rmpp", "3.20.0.52-rm2"} {
+		if err := os.MkdirAll(filepath.Join(treeDir, name), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(treeDir, name, "Main.qml"), []byte("Item {}"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	treeService := qmltree.NewService(treeDir)
+	// Jobs that start run against a missing qmldiff binary and fail, which is fine here
+	qmldiffService := qmldiff.NewService(filepath.Join(t.TempDir(), "qmldiff"), hashtabService, treeService)
+	h := NewAPIHandler(qmldiffService, hashtabService, treeService, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	tests := []struct {
+		name        string
+		versions    string
+		wantStatus  int
+		wantCount   float64
+		wantUnknown []interface{}
+	}{
+		{name: "subset", versions: `["3.22.4.2-RMPP"]`, wantStatus: http.StatusOK, wantCount: 1},
+		{name: "unknown", versions: `["3.22.4.2-rmpp", "3.18.0.1-rm2", "9.9.9.9-rm2"]`, wantStatus: http.StatusBadRequest,
+			wantUnknown: []interface{}{"3.18.0.1-rm2", "9.9.9.9-rm2"}},
+		{name: "malformed", versions: `3.22.4.2-rmpp`, wantStatus: http.StatusBadRequest},
+		{name: "empty", versions: `[]`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			part, _ := writer.CreateFormFile("file", "mod.qmd")
+			part.Write([]byte("AFFECT [[1]]\n"))
+			writer.WriteField("versions", tt.versions)
+			writer.Close()
+			req := httptest.NewRequest(http.MethodPost, "/api/compare", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rec := httptest.NewRecorder()
+			h.Compare(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			var resp map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if tt.wantStatus == http.StatusOK && resp["hashtables_count"] != tt.wantCount {
+				t.Errorf("hashtables_count = %v, want %v", resp["hashtables_count"], tt.wantCount)
+			}
+			if tt.wantUnknown != nil && !reflect.DeepEqual(resp["unknown_versions"], tt.wantUnknown) {
+				t.Errorf("unknown_versions = %v, want %v", resp["unknown_versions"], tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestCompareVersionDeviceFilter(t *testing.T) {
+	hashtabDir := t.TempDir()
+	treeDir := t.TempDir()
+	for _, name := range []string{"3.22.4.2-rmpp", "3.22.4.2-rm2", "3.20.0.52-rm2"} {
+		entries := map[uint64]string{hashtab.DJB2Hash("width"): "width"}
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Join(treeDir, name), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(treeDir, name, "Main.qml"), []byte("Item {}"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	treeService := qmltree.NewService(treeDir)
+	// Jobs that start run against a missing qmldiff binary and fail, which is fine here
+	qmldiffService := qmldiff.NewService(filepath.Join(t.TempDir(), "qmldiff"), hashtabService, treeService)
+	h := NewAPIHandler(qmldiffService, hashtabService, treeService, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	tests := []struct {
+		name       string
+		query      string
+		versions   string
+		wantStatus int
+		wantCount  float64
+	}{
+		{name: "version", query: "version=3.22.4.2", wantStatus: http.StatusOK, wantCount: 2},
+		{name: "device", query: "device=RM2", wantStatus: http.StatusOK, wantCount: 2},
+		{name: "version and device", query: "version=3.22.4.2&device=rmpp", wantStatus: http.StatusOK, wantCount: 1},
+		{name: "hashtable name", query: "version=3.20.0.52-rm2", wantStatus: http.StatusOK, wantCount: 1},
+		{name: "within versions field", query: "device=rm2", versions: `["3.22.4.2-rmpp", "3.20.0.52-rm2"]`, wantStatus: http.StatusOK, wantCount: 1},
+		{name: "no match", query: "version=3.20.0.52&device=rmpp", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			part, _ := writer.CreateFormFile("file", "mod.qmd")
+			part.Write([]byte("AFFECT [[1]]\n"))
+			if tt.versions != "" {
+				writer.WriteField("versions", tt.versions)
+			}
+			writer.Close()
+			req := httptest.NewRequest(http.MethodPost, "/api/compare?"+tt.query, &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rec := httptest.NewRecorder()
+			h.Compare(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			var resp map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if tt.wantStatus == http.StatusOK && resp["hashtables_count"] != tt.wantCount {
+				t.Errorf("hashtables_count = %v, want %v", resp["hashtables_count"], tt.wantCount)
+			}
+			want := []interface{}{"3.20.0.52-rm2", "3.22.4.2-rm2", "3.22.4.2-rmpp"}
+			if tt.wantStatus == http.StatusBadRequest && !reflect.DeepEqual(resp["available_versions"], want) {
+				t.Errorf("available_versions = %v, want %v", resp["available_versions"], want)
+			}
+		})
+	}
+}
+
+func TestGetResultsFields(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+
+	store.Create("job")
+	store.SetResults("job", map[string]CompareResponse{
+		"a.qmd": {
+			Compatible: []qmldiff.TreeComparisonResult{{Hashtable: "3.22.0.64-rmpp", OSVersion: "3.22.0.64", Device: "rmpp", Compatible: true, ValidationMode: "tree"}},
+			Incompatible: []qmldiff.TreeComparisonResult{{Hashtable: "3.20.0.52-rm2", OSVersion: "3.20.0.52", Device: "rm2", ErrorDetail: "missing 1 hash(es)",
+				MissingHashes: []qmd.HashWithPosition{{Hash: 1, Line: 1, Column: 8}}}},
+			TotalChecked: 2,
+			Mode:         "tree",
+		},
+	})
+	store.Update("job", "success", "Validation complete", nil)
+
+	router := chi.NewRouter()
+	router.Get("/api/results/{jobId}", h.GetResults)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/results/job?fields=compatible,hashtable,missing_hashes", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var resp map[string]map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	file := resp["a.qmd"]
+	if file["total_checked"] != float64(2) || file["mode"] != "tree" {
+		t.Errorf("response fields were projected too: %v", file)
+	}
+	compatible := file["compatible"].([]interface{})[0].(map[string]interface{})
+	if want := map[string]interface{}{"hashtable": "3.22.0.64-rmpp", "compatible": true}; !reflect.DeepEqual(compatible, want) {
+		t.Errorf("compatible entry = %v, want %v", compatible, want)
+	}
+	incompatible := file["incompatible"].([]interface{})[0].(map[string]interface{})
+	if len(incompatible) != 3 || incompatible["missing_hashes"] == nil {
+		t.Errorf("incompatible entry = %v, want hashtable, compatible and missing_hashes", incompatible)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/results/job?fields=hashtable,bogus", nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "bogus") {
+		t.Errorf("unknown field: status = %d, body = %s; want 400 naming it", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPersistentStoreRoundTripsCompareResults(t *testing.T) {
+	dir := t.TempDir()
+	store, err := jobs.NewPersistentStore(dir, jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() failed: %v", err)
+	}
+
+	incompatible := qmldiff.TreeComparisonResult{
+		Hashtable:     "3.22.4.2-rmpp",
+		OSVersion:     "3.22.4.2",
+		Device:        "rmpp",
+		ErrorDetail:   "missing 1 hash(es)",
+		MissingHashes: []qmd.HashWithPosition{{Hash: 17607111715072197239, Line: 3, Column: 8, Offset: 41}},
+		DependencyResults: map[string]*qmd.ValidationResult{
+			"lib/dep.qmd": {Path: "lib/dep.qmd", Status: qmd.StatusFailed, HashErrors: []qmd.HashError{{HashID: 17607111715072197239, Error: "Cannot resolve hash"}}},
+		},
+	}
+	single := CompareResponse{
+		Compatible:   []qmldiff.TreeComparisonResult{{Hashtable: "3.20.0.52-rm2", Compatible: true}},
+		Incompatible: []qmldiff.TreeComparisonResult{incompatible},
+		TotalChecked: 2,
+		Mode:         "tree",
+	}
+	batch := map[string]CompareResponse{"a.qmd": single, "b.qmd": {Mode: "hash"}}
+	tree := map[string]interface{}{"compatible": true, "files_processed": float64(3)}
+
+	for id, results := range map[string]interface{}{"single": single, "batch": batch, "tree": tree} {
+		store.Create(id)
+		store.SetResults(id, results)
+		store.Update(id, "success", "done", nil)
+	}
+
+	reloaded, err := jobs.NewPersistentStore(dir, jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() reload failed: %v", err)
+	}
+	for id, want := range map[string]interface{}{"single": single, "batch": batch, "tree": tree} {
+		job, ok := reloaded.Get(id)
+		if !ok {
+			t.Fatalf("job %s was not reloaded", id)
+		}
+		if !reflect.DeepEqual(job.Results, want) {
+			t.Errorf("job %s results = %#v, want %#v", id, job.Results, want)
+		}
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("running")
+	store.UpdateWithOperation("running", "running", "Validating", nil, "validating")
+	store.Create("done")
+	store.SetLabel("done", "PR #42")
+	store.SetResults("done", CompareResponse{Mode: "tree"})
+	store.Update("done", "success", "Validation complete", nil)
+	settings := DefaultSettings()
+	settings.AdminToken = "secret"
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, settings)
+
+	list := func(query, token string) (int, []JobSummary, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ListJobs(rec, req)
+		var resp struct {
+			Jobs []JobSummary `json:"jobs"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Jobs, rec.Body.String()
+	}
+
+	if code, _, _ := list("", ""); code != http.StatusForbidden {
+		t.Errorf("without a token: status = %d, want 403", code)
+	}
+
+	code, summaries, body := list("", "secret")
+	if code != http.StatusOK || len(summaries) != 2 {
+		t.Fatalf("status = %d, %d job(s), want 200 and 2: %s", code, len(summaries), body)
+	}
+	if summaries[0].JobID != "running" || summaries[0].Operation != "validating" || summaries[0].CompletedAt != nil {
+		t.Errorf("first job = %+v, want the running job", summaries[0])
+	}
+	if summaries[1].JobID != "done" || summaries[1].Label != "PR #42" || summaries[1].CompletedAt == nil {
+		t.Errorf("second job = %+v, want the completed job", summaries[1])
+	}
+	if strings.Contains(body, "compatible") {
+		t.Errorf("job list includes results: %s", body)
+	}
+
+	if _, summaries, _ := list("?status=success", "secret"); len(summaries) != 1 || summaries[0].JobID != "done" {
+		t.Errorf("status=success listed %+v, want only done", summaries)
+	}
+}
+
+func TestReady(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "qmldiff")
+	hashtabDir := t.TempDir()
+	ready := func() (int, map[string]string) {
+		hashtabService, err := hashtab.NewService(hashtabDir)
+		if err != nil {
+			t.Fatalf("NewService() failed: %v", err)
+		}
+		qmldiffService := qmldiff.NewService(binary, hashtabService, nil)
+		settings := DefaultSettings()
+		settings.ReadOnly = true
+		h := NewAPIHandler(qmldiffService, hashtabService, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, settings)
+
+		rec := httptest.NewRecorder()
+		h.Ready(rec, httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+		var resp struct {
+			Checks map[string]string `json:"checks"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp.Checks
+	}
+
+	if code, checks := ready(); code != http.StatusServiceUnavailable || checks["hashtables"] == "ok" || checks["qmldiff"] == "ok" {
+		t.Errorf("with no data: status = %d, checks = %v, want 503 with both checks failing", code, checks)
+	}
+
+	if err := hashtab.WriteHashtab(map[uint64]string{1: "width"}, filepath.Join(hashtabDir, "3.22.4.2-rmpp")); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	if code, checks := ready(); code != http.StatusServiceUnavailable || checks["hashtables"] != "ok" {
+		t.Errorf("without qmldiff: status = %d, checks = %v, want 503 with hashtables ok", code, checks)
+	}
+
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if code, checks := ready(); code != http.StatusOK {
+		t.Errorf("with data: status = %d, checks = %v, want 200", code, checks)
+	}
+}
+
+func TestDeviceConcurrencyLimit(t *testing.T) {
+	settings := DefaultSettings()
+	settings.DeviceConcurrency = map[string]int{"rmppm": 2, "rm2": 0}
+	h := NewAPIHandler(nil, nil, nil, nil, nil, 8, settings)
+
+	for device, want := range map[string]int{"rmppm": 2, "rm2": 8, "rmpp": 8} {
+		if got := h.deviceConcurrencyLimit(device); got != want {
+			t.Errorf("deviceConcurrencyLimit(%s) = %d, want %d", device, got, want)
+		}
+	}
+}
+
+func TestGetHashlistReadsJobFiles(t *testing.T) {
+	filesDir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.qmd":          "LOAD lib/common.qmd\nAFFECT [[1]] {}\n",
+		"lib/common.qmd": "AFFECT [[2]] {}\n",
+		"lib/unused.qmd": "AFFECT [[3]] {}\n",
+	} {
+		path := filepath.Join(filesDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("job")
+	store.Create("no-files")
+	store.SetFilesDir("job", filesDir)
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+	router := chi.NewRouter()
+	router.Get("/api/hashlist/{jobId}", h.GetHashlist)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/hashlist/no-files", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("job without files: status = %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/hashlist/job", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	path := filepath.Join(t.TempDir(), "hashlist.bin")
+	if err := os.WriteFile(path, rec.Body.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ht, err := hashtab.Load(path)
+	if err != nil {
+		t.Fatalf("hashtab.Load() failed: %v", err)
+	}
+
+	// Root files and what they LOAD, not every uploaded file
+	if len(ht.Entries) != 2 {
+		t.Errorf("hashlist has %d entries, want 2", len(ht.Entries))
+	}
+	for _, hash := range []uint64{1, 2} {
+		if _, ok := ht.Entries[hash]; !ok {
+			t.Errorf("hashlist missing hash %d", hash)
+		}
+	}
+}
+
+func TestCompareRecoversFromValidationPanic(t *testing.T) {
+	hashtabDir := t.TempDir()
+	if err := hashtab.WriteHashtab(map[uint64]string{hashtab.DJB2Hash("width"): "width"}, filepath.Join(hashtabDir, "3.22.4.2-rmpp")); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	// Without a tree service, looking up trees panics inside the job
+	jobStore := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, hashtabService, nil, jobStore, nil, 1, DefaultSettings())
+
+	rec := httptest.NewRecorder()
+	h.Compare(rec, newMultipartRequest(t, map[string]string{"a.qmd": "AFFECT [[1]]\n"}, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	jobID, _ := resp["jobId"].(string)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := jobStore.Get(jobID)
+		if !ok {
+			t.Fatalf("job %s not found", jobID)
+		}
+		if jobs.IsTerminal(job.Status) {
+			if job.Status != "error" || !strings.Contains(job.Message, "internal error") {
+				t.Errorf("job status = %q, message = %q, want an internal error", job.Status, job.Message)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never finished, status %q", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUploadHandlersRemoveSpooledFiles(t *testing.T) {
+	// The multipart parser spools files larger than the memory limit to TMPDIR
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	settings := DefaultSettings()
+	settings.MultipartMemory = 1
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, settings)
+	content := strings.Repeat("AFFECT [[1]]\n", 100)
+
+	// Both requests are rejected after the form has been parsed
+	compare := newMultipartRequest(t, map[string]string{"a.qmd": content, "b.qmd": content}, []string{"a.qmd"})
+	h.Compare(httptest.NewRecorder(), compare)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "a.qmd")
+	part.Write([]byte(content))
+	writer.WriteField("tree_path", "/trees/a")
+	writer.Close()
+	validateTree := httptest.NewRequest(http.MethodPost, "/api/validate/tree", &body)
+	validateTree.Header.Set("Content-Type", writer.FormDataContentType())
+	h.ValidateTree(httptest.NewRecorder(), validateTree)
+
+	for _, req := range []*http.Request{compare, validateTree} {
+		if req.MultipartForm == nil || len(req.MultipartForm.File) == 0 {
+			t.Fatalf("%s: form was not parsed", req.URL.Path)
+		}
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadDir() failed: %v", err)
+	}
+	for _, entry := range entries {
+		t.Errorf("temp file left behind: %s", entry.Name())
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+)
+
+// CancelJob stops a running job, killing its qmldiff processes, and marks it
+// "cancelled". Jobs that already finished are left as they are.
+func (h *APIHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobId")
+
+	err := h.jobStore.Cancel(jobID)
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Job not found",
+		})
+		return
+	case errors.Is(err, jobs.ErrJobFinished):
+		job, _ := h.jobStore.Get(jobID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":  "Job already finished",
+			"status": job.Status,
+		})
+		return
+	}
+
+	logging.Info(logging.ComponentHandler, "Cancelled job %s", jobID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"jobId":  jobID,
+		"status": "cancelled",
+	})
+}
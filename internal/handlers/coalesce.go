@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+)
+
+// inFlightJobs maps the content key of a running compare job's upload to its
+// job ID, so identical concurrent submissions can share the one job
+type inFlightJobs struct {
+	mu   sync.Mutex
+	jobs map[string]string
+}
+
+func newInFlightJobs() *inFlightJobs {
+	return &inFlightJobs{jobs: make(map[string]string)}
+}
+
+// claim registers jobID as running for key, unless another job already is
+// Returns that job's ID and true when there is one.
+func (f *inFlightJobs) claim(key, jobID string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if existing, ok := f.jobs[key]; ok {
+		return existing, true
+	}
+	f.jobs[key] = jobID
+	return "", false
+}
+
+// release forgets key once jobID has finished
+func (f *inFlightJobs) release(key, jobID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.jobs[key] == jobID {
+		delete(f.jobs, key)
+	}
+}
+
+// reset forgets every running job, so later submissions start their own
+func (f *inFlightJobs) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jobs = make(map[string]string)
+}
+
+// CatalogReloaded stops new compare jobs from joining ones already running,
+// which validate against the hashtables and trees from before the reload.
+// Register it with the hashtab and tree services' OnReload.
+func (h *APIHandler) CatalogReloaded(changed []string) {
+	logging.Info(logging.ComponentHandler, "Catalog reloaded (%s), new jobs won't join running ones", strings.Join(changed, ", "))
+	h.inFlight.reset()
+}
+
+// uploadKey hashes the paths and contents of every file saved under dir,
+// along with the request parameters that change a job's results, into a key
+// that only identical submissions share
+func uploadKey(dir string, params ...string) (string, error) {
+	hash := sha256.New()
+	writeField := func(field []byte) {
+		binary.Write(hash, binary.BigEndian, uint64(len(field)))
+		hash.Write(field)
+	}
+	for _, param := range params {
+		writeField([]byte(param))
+	}
+
+	// WalkDir visits files in lexical order, so the key doesn't depend on upload order
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		writeField([]byte(filepath.ToSlash(relPath)))
+		binary.Write(hash, binary.BigEndian, uint64(info.Size()))
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
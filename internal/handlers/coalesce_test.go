@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+func TestUploadKey(t *testing.T) {
+	writeUpload := func(files map[string]string) string {
+		t.Helper()
+		dir := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("MkdirAll() failed: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("WriteFile() failed: %v", err)
+			}
+		}
+		return dir
+	}
+	key := func(dir string, params ...string) string {
+		t.Helper()
+		key, err := uploadKey(dir, params...)
+		if err != nil {
+			t.Fatalf("uploadKey() failed: %v", err)
+		}
+		return key
+	}
+
+	files := map[string]string{"mod.qmd": "LOAD lib/a.qmd\n", "lib/a.qmd": "AFFECT [[1]]\n"}
+	base := key(writeUpload(files), "tree")
+	if again := key(writeUpload(files), "tree"); again != base {
+		t.Errorf("identical uploads keyed %s and %s", base, again)
+	}
+
+	different := map[string]string{
+		"other params":  key(writeUpload(files), "hash"),
+		"moved file":    key(writeUpload(map[string]string{"mod.qmd": "LOAD lib/a.qmd\n", "a.qmd": "AFFECT [[1]]\n"}), "tree"),
+		"changed file":  key(writeUpload(map[string]string{"mod.qmd": "LOAD lib/a.qmd\n", "lib/a.qmd": "AFFECT [[2]]\n"}), "tree"),
+		"content split": key(writeUpload(map[string]string{"mod.qmd": "LOAD lib/a.qmd\nAFFECT [[1]]\n", "lib/a.qmd": ""}), "tree"),
+	}
+	for name, other := range different {
+		if other == base {
+			t.Errorf("%s: key unchanged", name)
+		}
+	}
+}
+
+func TestInFlightJobs(t *testing.T) {
+	inFlight := newInFlightJobs()
+
+	if _, ok := inFlight.claim("upload", "job-1"); ok {
+		t.Fatal("first claim found a running job")
+	}
+	if existing, ok := inFlight.claim("upload", "job-2"); !ok || existing != "job-1" {
+		t.Errorf("second claim = %q, %v, want job-1", existing, ok)
+	}
+
+	// Only the job holding the key can release it
+	inFlight.release("upload", "job-2")
+	if existing, ok := inFlight.claim("upload", "job-3"); !ok || existing != "job-1" {
+		t.Errorf("claim after foreign release = %q, %v, want job-1", existing, ok)
+	}
+
+	inFlight.release("upload", "job-1")
+	if _, ok := inFlight.claim("upload", "job-4"); ok {
+		t.Error("claim after release found a running job")
+	}
+}
+
+func TestCatalogReloadedStopsCoalescing(t *testing.T) {
+	hashtabDir := t.TempDir()
+	path := filepath.Join(hashtabDir, "3.22.4.2-rmpp")
+	if err := hashtab.WriteHashtab(map[uint64]string{hashtab.DJB2Hash("width"): "width"}, path); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	h := NewAPIHandler(nil, hashtabService, nil, nil, nil, 1, DefaultSettings())
+	hashtabService.OnReload(h.CatalogReloaded)
+
+	h.inFlight.claim("upload", "job-1")
+
+	// A fixed hashtable must not hand resubmissions the running job's stale results
+	if err := hashtab.WriteHashtab(map[uint64]string{hashtab.DJB2Hash("height"): "height"}, path); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Chtimes() failed: %v", err)
+	}
+	if err := hashtabService.CheckAndReload(); err != nil {
+		t.Fatalf("CheckAndReload() failed: %v", err)
+	}
+
+	if existing, ok := h.inFlight.claim("upload", "job-2"); ok {
+		t.Errorf("claim after reload joined %s, want a new job", existing)
+	}
+	// The old job finishing leaves the new one's claim alone
+	h.inFlight.release("upload", "job-1")
+	if existing, ok := h.inFlight.claim("upload", "job-3"); !ok || existing != "job-2" {
+		t.Errorf("claim = %q, %v, want job-2", existing, ok)
+	}
+}
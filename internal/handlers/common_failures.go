@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CommonFailure is a hash that is missing in one or more results of a job
+type CommonFailure struct {
+	Hash     string   `json:"hash"`
+	String   string   `json:"string,omitempty"` // Resolved through a hashtable, if any has it
+	Count    int      `json:"count"`            // Number of file/version results missing the hash
+	Files    []string `json:"files"`
+	Versions []string `json:"versions"`
+}
+
+// commonFailures counts missing hashes across every incompatible result of a
+// job, most frequent first. A hash counts once per file and hashtable.
+func commonFailures(results interface{}) []CommonFailure {
+	var responses map[string]CompareResponse
+	switch r := results.(type) {
+	case CompareResponse:
+		responses = map[string]CompareResponse{"": r}
+	case map[string]CompareResponse:
+		responses = r
+	default:
+		return []CommonFailure{}
+	}
+
+	type tally struct {
+		count    int
+		files    map[string]bool
+		versions map[string]bool
+	}
+	tallies := make(map[uint64]*tally)
+
+	for filename, response := range responses {
+		for _, result := range response.Incompatible {
+			seen := make(map[uint64]bool)
+			for _, missing := range result.MissingHashes {
+				if seen[missing.Hash] {
+					continue
+				}
+				seen[missing.Hash] = true
+
+				t, ok := tallies[missing.Hash]
+				if !ok {
+					t = &tally{files: make(map[string]bool), versions: make(map[string]bool)}
+					tallies[missing.Hash] = t
+				}
+				t.count++
+				if filename != "" {
+					t.files[filename] = true
+				}
+				t.versions[result.Hashtable] = true
+			}
+		}
+	}
+
+	hashes := make([]uint64, 0, len(tallies))
+	for hash := range tallies {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		if tallies[hashes[i]].count != tallies[hashes[j]].count {
+			return tallies[hashes[i]].count > tallies[hashes[j]].count
+		}
+		return hashes[i] < hashes[j]
+	})
+
+	failures := make([]CommonFailure, 0, len(hashes))
+	for _, hash := range hashes {
+		t := tallies[hash]
+		failures = append(failures, CommonFailure{
+			Hash:     strconv.FormatUint(hash, 10),
+			Count:    t.count,
+			Files:    sortedKeys(t.files),
+			Versions: sortedKeys(t.versions),
+		})
+	}
+
+	return failures
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetCommonFailures reports the hashes most frequently missing across a job's results
+func (h *APIHandler) GetCommonFailures(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobId")
+
+	job, ok := h.jobStore.Get(jobID)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Job not found",
+		})
+		return
+	}
+
+	if job.Results == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": job.Status,
+		})
+		return
+	}
+
+	limit := 10
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "limit must be a positive integer",
+			})
+			return
+		}
+		limit = n
+	}
+
+	failures := commonFailures(job.Results)
+	if len(failures) > limit {
+		failures = failures[:limit]
+	}
+	h.resolveFailureStrings(failures)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"common_failures": failures,
+	})
+}
+
+// resolveFailureStrings fills in the string for each hash from the first
+// loaded hashtable that has one
+func (h *APIHandler) resolveFailureStrings(failures []CommonFailure) {
+	if h.hashtabService == nil {
+		return
+	}
+	for i := range failures {
+		hash, err := strconv.ParseUint(failures[i].Hash, 10, 64)
+		if err != nil {
+			continue
+		}
+		if str, ok := h.hashtabService.LookupString(hash); ok {
+			failures[i].String = str
+		}
+	}
+}
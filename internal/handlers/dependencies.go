@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+)
+
+// DependencyGraph is a root file's LOAD dependency tree, with paths relative
+// to the upload root
+type DependencyGraph struct {
+	ExpectedLoads []string            `json:"expected_loads"`           // Every LOADed file, recursively, in the order qmldiff reaches them
+	LoadGraph     map[string][]string `json:"load_graph"`               // File -> the files it LOADs
+	NotUploaded   []string            `json:"not_uploaded"`             // LOAD targets missing from the upload
+	OutsideRoot   []string            `json:"outside_root"`             // LOAD targets outside the uploaded folder
+	SelfLoads     []string            `json:"self_loads,omitempty"`     // Files that LOAD themselves
+	ExternalLoads []string            `json:"external_loads,omitempty"` // LOAD EXTERNAL targets, which are never validated
+	Error         string              `json:"error,omitempty"`          // Why the graph could not be built
+}
+
+// Dependencies previews the LOAD dependency tree of each top-level file in an
+// upload, accepted in the same forms as /api/compare, without running
+// qmldiff. LOAD targets that were not uploaded or lie outside the upload are
+// listed per file, and together in unresolved_loads.
+func (h *APIHandler) Dependencies(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(h.settings.MultipartMemory)
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to parse multipart form: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to parse form data",
+		})
+		return
+	}
+
+	tempDir, qmdPaths, _, _, ok := h.saveUpload(w, r)
+	if !ok {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	rootPaths := qmd.GetRootLevelFiles(tempDir, qmdPaths)
+	if len(rootPaths) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(noRootFilesResponse(qmd.CountSubdirectoryFiles(tempDir, qmdPaths)))
+		return
+	}
+
+	graphs := make(map[string]DependencyGraph, len(rootPaths))
+	unresolved := make(map[string][]string)
+	for _, rootPath := range rootPaths {
+		filename := filepath.Base(rootPath)
+		graph := dependencyGraph(tempDir, rootPath)
+		if missing := append(append([]string{}, graph.OutsideRoot...), graph.NotUploaded...); len(missing) > 0 {
+			unresolved[filename] = missing
+		}
+		graphs[filename] = graph
+	}
+
+	logging.Info(logging.ComponentHandler, "Built dependency graphs for %d file(s), %d with unresolved LOADs", len(graphs), len(unresolved))
+
+	response := map[string]interface{}{
+		"files": graphs,
+	}
+	if len(unresolved) > 0 {
+		response["warning"] = "Some LOAD targets were not uploaded; check that paths keep the folder structure"
+		response["unresolved_loads"] = unresolved
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// dependencyGraph builds the dependency graph of a root file in dir
+func dependencyGraph(dir, rootPath string) DependencyGraph {
+	graph := DependencyGraph{
+		ExpectedLoads: []string{},
+		LoadGraph:     map[string][]string{},
+		NotUploaded:   []string{},
+		OutsideRoot:   []string{},
+	}
+
+	depInfo, err := qmd.BuildDependencyInfo(rootPath)
+	if err != nil {
+		graph.Error = err.Error()
+		return graph
+	}
+
+	for _, load := range depInfo.ExpectedLoads {
+		graph.ExpectedLoads = append(graph.ExpectedLoads, filepath.ToSlash(load))
+	}
+	for parent, children := range depInfo.LoadGraph {
+		relParent, err := filepath.Rel(dir, parent)
+		if err != nil {
+			relParent = filepath.Base(parent)
+		}
+		slashed := make([]string, len(children))
+		for i, child := range children {
+			slashed[i] = filepath.ToSlash(child)
+		}
+		graph.LoadGraph[filepath.ToSlash(relParent)] = slashed
+	}
+	for _, load := range depInfo.NotUploaded {
+		graph.NotUploaded = append(graph.NotUploaded, filepath.ToSlash(load))
+	}
+	for _, load := range depInfo.OutsideRoot {
+		graph.OutsideRoot = append(graph.OutsideRoot, filepath.ToSlash(load))
+	}
+	for _, load := range depInfo.SelfLoads {
+		graph.SelfLoads = append(graph.SelfLoads, filepath.ToSlash(load))
+	}
+	graph.ExternalLoads = depInfo.ExternalLoads
+	return graph
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+)
+
+func TestDependencies(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	// Files and paths are matched by position, so add them in order
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, file := range []struct{ path, content string }{
+		{"a.qmd", "LOAD lib/common.qmd\nLOAD ../shared/base.qmd\nAFFECT [[1]]\n"},
+		{"b.qmd", "AFFECT [[2]]\n"},
+		{"lib/common.qmd", "LOAD missing.qmd\nAFFECT [[3]]\n"},
+	} {
+		part, err := writer.CreateFormFile("files", path.Base(file.path))
+		if err != nil {
+			t.Fatalf("CreateFormFile() failed: %v", err)
+		}
+		part.Write([]byte(file.content))
+		writer.WriteField("paths", file.path)
+	}
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/dependencies", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.Dependencies(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp struct {
+		Files           map[string]DependencyGraph `json:"files"`
+		UnresolvedLoads map[string][]string        `json:"unresolved_loads"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	// Only top-level files get a graph; lib/common.qmd is a dependency
+	if len(resp.Files) != 2 {
+		t.Fatalf("files = %v, want a.qmd and b.qmd", resp.Files)
+	}
+	a := resp.Files["a.qmd"]
+	if want := []string{"lib/common.qmd", "../shared/base.qmd", "lib/missing.qmd"}; !reflect.DeepEqual(a.ExpectedLoads, want) {
+		t.Errorf("expected_loads = %v, want %v", a.ExpectedLoads, want)
+	}
+	wantGraph := map[string][]string{
+		"a.qmd":          {"lib/common.qmd", "../shared/base.qmd"},
+		"lib/common.qmd": {"lib/missing.qmd"},
+	}
+	if !reflect.DeepEqual(a.LoadGraph, wantGraph) {
+		t.Errorf("load_graph = %v, want %v", a.LoadGraph, wantGraph)
+	}
+	if !reflect.DeepEqual(a.NotUploaded, []string{"lib/missing.qmd"}) || !reflect.DeepEqual(a.OutsideRoot, []string{"../shared/base.qmd"}) {
+		t.Errorf("not_uploaded = %v, outside_root = %v", a.NotUploaded, a.OutsideRoot)
+	}
+
+	if want := map[string][]string{"a.qmd": {"../shared/base.qmd", "lib/missing.qmd"}}; !reflect.DeepEqual(resp.UnresolvedLoads, want) {
+		t.Errorf("unresolved_loads = %v, want %v", resp.UnresolvedLoads, want)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+)
+
+// deviceMismatchWarnings flags devices a QMD file likely wasn't written for.
+// A device is flagged when none of the file's AFFECT targets exist in any of
+// that device's trees while at least one other device has them.
+// Returns a map of device -> warning message.
+func (h *APIHandler) deviceMismatchWarnings(qmdPath string) map[string]string {
+	content, err := os.ReadFile(qmdPath)
+	if err != nil {
+		return nil
+	}
+
+	// Resolve hashed targets through any hashtable that carries strings
+	var targets []string
+	for _, target := range qmd.ResolveAffectTargets(string(content), h.hashtabService.LookupString) {
+		if !target.Resolved() {
+			logging.Debug(logging.ComponentHandler, "Unresolved hashed AFFECT target %s in %s", target, qmdPath)
+			continue
+		}
+		targets = append(targets, target.Path)
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	devicesWithTargets := make(map[string]bool)
+	allDevices := make(map[string]bool)
+	for _, tree := range h.treeService.GetTrees() {
+		allDevices[tree.Device] = true
+		if devicesWithTargets[tree.Device] {
+			continue
+		}
+		for _, target := range targets {
+			if tree.ContainsFile(target) {
+				devicesWithTargets[tree.Device] = true
+				break
+			}
+		}
+	}
+
+	if len(devicesWithTargets) == 0 {
+		// Targets match no tree at all, so there's nothing to say about devices
+		return nil
+	}
+
+	likelyDevices := make([]string, 0, len(devicesWithTargets))
+	for device := range devicesWithTargets {
+		likelyDevices = append(likelyDevices, device)
+	}
+	sort.Strings(likelyDevices)
+
+	warnings := make(map[string]string)
+	for device := range allDevices {
+		if devicesWithTargets[device] {
+			continue
+		}
+		warnings[device] = fmt.Sprintf("possible device mismatch: no AFFECT targets exist in %s trees (found in %s)",
+			device, strings.Join(likelyDevices, ", "))
+		logging.Debug(logging.ComponentHandler, "Device mismatch for %s on %s", qmdPath, device)
+	}
+
+	return warnings
+}
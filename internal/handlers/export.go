@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+// ExportVersion describes one hashtable's directory in an export bundle
+type ExportVersion struct {
+	Hashtable string   `json:"hashtable"`
+	OSVersion string   `json:"os_version"`
+	Device    string   `json:"device"`
+	Files     []string `json:"files"`
+	Hashes    int      `json:"hashes"`
+}
+
+// collectSources reads each validated file and the files it LOADs from the
+// upload, keyed by path relative to the upload root, along with each root
+// file's LOADed files. LOAD targets outside the upload or missing from it
+// are left out.
+func collectSources(qmdPaths, filenames []string) (map[string][]byte, map[string][]string) {
+	sources := make(map[string][]byte)
+	loads := make(map[string][]string)
+	for i, qmdPath := range qmdPaths {
+		content, err := os.ReadFile(qmdPath)
+		if err != nil {
+			continue
+		}
+		sources[filenames[i]] = content
+
+		depInfo, err := qmd.BuildDependencyInfo(qmdPath)
+		if err != nil {
+			continue
+		}
+		files := []string{}
+		for _, load := range depInfo.ExpectedLoads {
+			relPath := path.Join(path.Dir(filenames[i]), filepath.ToSlash(load))
+			if relPath == ".." || strings.HasPrefix(relPath, "../") {
+				continue
+			}
+			if _, ok := sources[relPath]; !ok {
+				content, err := os.ReadFile(filepath.Join(filepath.Dir(qmdPath), load))
+				if err != nil {
+					continue
+				}
+				sources[relPath] = content
+			}
+			files = append(files, relPath)
+		}
+		loads[filenames[i]] = files
+	}
+	return sources, loads
+}
+
+// ExportBundle serves a finished job as a zip archive ready to copy to a
+// device: for every hashtable a root file is compatible with, a directory
+// holding those files, the files they LOAD, and a hashlist of just the
+// hashes they reference. A manifest.json lists each directory's contents.
+func (h *APIHandler) ExportBundle(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobId")
+	job, ok := h.jobStore.Get(jobID)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Job not found",
+		})
+		return
+	}
+
+	if job.Status != "success" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":  "Job has not completed successfully",
+			"status": job.Status,
+		})
+		return
+	}
+
+	// Sources are read from the job's upload directory on request rather
+	// than kept with the job
+	rootPaths, rootNames, err := uploadRootFiles(job.FilesDir)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Export not available for this job",
+		})
+		return
+	}
+	sources, loads := collectSources(rootPaths, rootNames)
+
+	versions := exportVersions(job.Results, loads)
+	if len(versions) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "No compatible versions to export",
+		})
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "qmd-export-*")
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to create temp directory: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to create temp directory",
+		})
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	bundlePath := filepath.Join(tempDir, "bundle.zip")
+	if err := writeExportBundle(bundlePath, jobID, versions, sources); err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to write export bundle for job %s: %v", jobID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to write export bundle",
+		})
+		return
+	}
+
+	logging.Info(logging.ComponentHandler, "Serving export bundle for job %s (%d version(s))", jobID, len(versions))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="qmd-export-%s.zip"`, jobID))
+	http.ServeFile(w, r, bundlePath)
+}
+
+// exportVersions lists the hashtables any root file is compatible with,
+// sorted by name, with the files to bundle for each
+func exportVersions(results interface{}, loads map[string][]string) []ExportVersion {
+	var responses map[string]CompareResponse
+	switch r := results.(type) {
+	case CompareResponse:
+		// The single result shape is only used for a lone root file
+		responses = map[string]CompareResponse{}
+		for root := range loads {
+			responses[root] = r
+		}
+	case map[string]CompareResponse:
+		responses = r
+	default:
+		return nil
+	}
+
+	byHashtable := make(map[string]*ExportVersion)
+	included := make(map[string]map[string]bool)
+	for root, files := range loads {
+		for _, result := range responses[root].Compatible {
+			version, ok := byHashtable[result.Hashtable]
+			if !ok {
+				version = &ExportVersion{Hashtable: result.Hashtable, OSVersion: result.OSVersion, Device: result.Device}
+				byHashtable[result.Hashtable] = version
+				included[result.Hashtable] = make(map[string]bool)
+			}
+			for _, file := range append([]string{root}, files...) {
+				if !included[result.Hashtable][file] {
+					included[result.Hashtable][file] = true
+					version.Files = append(version.Files, file)
+				}
+			}
+		}
+	}
+
+	versions := make([]ExportVersion, 0, len(byHashtable))
+	for _, version := range byHashtable {
+		sort.Strings(version.Files)
+		versions = append(versions, *version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Hashtable < versions[j].Hashtable })
+	return versions
+}
+
+// writeExportBundle writes the zip archive described by versions to bundlePath
+// Each version's hashlist is built with hashtab.WriteHashlist, so it matches
+// the hash-only hashtable format.
+func writeExportBundle(bundlePath, jobID string, versions []ExportVersion, sources map[string][]byte) error {
+	file, err := os.Create(bundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+
+	for i, version := range versions {
+		seen := make(map[uint64]bool)
+		for _, name := range version.Files {
+			entry, err := archive.Create(path.Join(version.Hashtable, name))
+			if err != nil {
+				return err
+			}
+			if _, err := entry.Write(sources[name]); err != nil {
+				return err
+			}
+			for _, hash := range qmd.ExtractHashes(string(sources[name])) {
+				seen[hash] = true
+			}
+		}
+
+		hashes := make([]uint64, 0, len(seen))
+		for hash := range seen {
+			hashes = append(hashes, hash)
+		}
+		sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+		versions[i].Hashes = len(hashes)
+
+		hashlistPath := filepath.Join(filepath.Dir(bundlePath), "hashlist.bin")
+		if err := hashtab.WriteHashlist(hashes, hashlistPath); err != nil {
+			return err
+		}
+		hashlist, err := os.ReadFile(hashlistPath)
+		if err != nil {
+			return err
+		}
+		entry, err := archive.Create(path.Join(version.Hashtable, "hashlist.bin"))
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(hashlist); err != nil {
+			return err
+		}
+	}
+
+	entry, err := archive.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(map[string]interface{}{
+		"job_id":   jobID,
+		"versions": versions,
+	}); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+func TestExportBundle(t *testing.T) {
+	uploadDir := t.TempDir()
+	files := map[string]string{
+		"a.qmd":          "LOAD lib/common.qmd\nAFFECT [[1]] {}\n",
+		"b.qmd":          "AFFECT [[3]] {}\n",
+		"lib/common.qmd": "AFFECT [[2]] {}\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(uploadDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(uploadDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, loads := collectSources(
+		[]string{filepath.Join(uploadDir, "a.qmd"), filepath.Join(uploadDir, "b.qmd")},
+		[]string{"a.qmd", "b.qmd"})
+	if want := map[string][]string{"a.qmd": {"lib/common.qmd"}, "b.qmd": {}}; !reflect.DeepEqual(loads, want) {
+		t.Fatalf("loads = %v, want %v", loads, want)
+	}
+
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("job")
+	store.SetFilesDir("job", uploadDir)
+	compatible := func(names ...string) []qmldiff.TreeComparisonResult {
+		results := []qmldiff.TreeComparisonResult{}
+		for _, name := range names {
+			results = append(results, qmldiff.TreeComparisonResult{Hashtable: name, Compatible: true})
+		}
+		return results
+	}
+	store.SetResults("job", map[string]CompareResponse{
+		"a.qmd":          {Compatible: compatible("3.20.0.52-rm2", "3.22.4.2-rmpp")},
+		"b.qmd":          {Compatible: compatible("3.22.4.2-rmpp")},
+		"lib/common.qmd": {Compatible: compatible("3.20.0.52-rm2", "3.22.4.2-rmpp")},
+	})
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+	router := chi.NewRouter()
+	router.Get("/api/export/{jobId}", h.ExportBundle)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/export/job", nil))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("running job: status = %d, want 409", rec.Code)
+	}
+
+	store.Update("job", "success", "done", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/export/job", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() failed: %v", err)
+	}
+	entries := make(map[string][]byte)
+	var names []string
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name], _ = io.ReadAll(r)
+		r.Close()
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{
+		"3.20.0.52-rm2/a.qmd", "3.20.0.52-rm2/hashlist.bin", "3.20.0.52-rm2/lib/common.qmd",
+		"3.22.4.2-rmpp/a.qmd", "3.22.4.2-rmpp/b.qmd", "3.22.4.2-rmpp/hashlist.bin", "3.22.4.2-rmpp/lib/common.qmd",
+		"manifest.json",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("entries = %v, want %v", names, want)
+	}
+	if string(entries["3.22.4.2-rmpp/lib/common.qmd"]) != files["lib/common.qmd"] {
+		t.Errorf("lib/common.qmd = %q, want the uploaded content", entries["3.22.4.2-rmpp/lib/common.qmd"])
+	}
+
+	// Each hashlist holds only the hashes its version's files reference
+	for dir, wantHashes := range map[string][]uint64{"3.20.0.52-rm2": {1, 2}, "3.22.4.2-rmpp": {1, 2, 3}} {
+		path := filepath.Join(t.TempDir(), "hashlist")
+		if err := os.WriteFile(path, entries[dir+"/hashlist.bin"], 0644); err != nil {
+			t.Fatal(err)
+		}
+		ht, err := hashtab.Load(path)
+		if err != nil {
+			t.Fatalf("hashtab.Load(%s) failed: %v", dir, err)
+		}
+		if !ht.IsHashlist() || len(ht.Entries) != len(wantHashes) {
+			t.Errorf("%s hashlist has %d entries (hashlist = %v), want %v", dir, len(ht.Entries), ht.IsHashlist(), wantHashes)
+		}
+		for _, hash := range wantHashes {
+			if _, ok := ht.Entries[hash]; !ok {
+				t.Errorf("%s hashlist missing hash %d", dir, hash)
+			}
+		}
+	}
+
+	var manifest struct {
+		JobID    string          `json:"job_id"`
+		Versions []ExportVersion `json:"versions"`
+	}
+	if err := json.Unmarshal(entries["manifest.json"], &manifest); err != nil {
+		t.Fatalf("manifest.json: %v", err)
+	}
+	if manifest.JobID != "job" || len(manifest.Versions) != 2 || manifest.Versions[1].Hashes != 3 {
+		t.Errorf("manifest = %+v, want 2 versions with 3 hashes for 3.22.4.2-rmpp", manifest)
+	}
+}
+
+func TestExportBundleNothingCompatible(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("job")
+	uploadDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(uploadDir, "a.qmd"), []byte("AFFECT [[1]] {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store.SetFilesDir("job", uploadDir)
+	store.SetResults("job", CompareResponse{
+		Compatible:   []qmldiff.TreeComparisonResult{},
+		Incompatible: []qmldiff.TreeComparisonResult{{Hashtable: "3.22.4.2-rmpp"}},
+	})
+	store.Update("job", "success", "done", nil)
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+	router := chi.NewRouter()
+	router.Get("/api/export/{jobId}", h.ExportBundle)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/export/job", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", rec.Code)
+	}
+}
+
+func TestExportBundleWithoutUpload(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("job")
+	store.SetResults("job", CompareResponse{Compatible: []qmldiff.TreeComparisonResult{{Hashtable: "3.22.4.2-rmpp", Compatible: true}}})
+	store.Update("job", "success", "done", nil)
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+	router := chi.NewRouter()
+	router.Get("/api/export/{jobId}", h.ExportBundle)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/export/job", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
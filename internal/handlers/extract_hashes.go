@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+)
+
+// ExtractHashes lists the [[hash]] references in an uploaded QMD file with
+// their positions. Each hash is reported once, at its first occurrence,
+// unless ?all=true asks for every occurrence.
+func (h *APIHandler) ExtractHashes(w http.ResponseWriter, r *http.Request) {
+	all := r.URL.Query().Get("all") == "true"
+
+	err := r.ParseMultipartForm(h.settings.MultipartMemory)
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to parse multipart form: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to parse form data",
+		})
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "No file uploaded or invalid form data",
+		})
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to read uploaded file: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to read uploaded file",
+		})
+		return
+	}
+
+	var positions []qmd.HashWithPosition
+	if all {
+		positions = qmd.ExtractAllHashPositions(string(content))
+	} else {
+		positions = qmd.ExtractHashPositions(string(content))
+	}
+
+	hashes := make([]qmldiff.MissingHashInfo, len(positions))
+	for i, position := range positions {
+		hashes[i] = qmldiff.MissingHashInfo{
+			Hash:        strconv.FormatUint(position.Hash, 10),
+			Line:        position.Line,
+			Column:      position.Column,
+			Offset:      position.Offset,
+			Approximate: position.Approximate,
+		}
+	}
+
+	logging.Info(logging.ComponentHandler, "Extracted %d hash reference(s) from %s (all: %v)", len(hashes), header.Filename, all)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"filename": header.Filename,
+		"hashes":   hashes,
+	})
+}
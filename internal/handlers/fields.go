@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+)
+
+// resultFieldNames lists the JSON fields of a tree comparison result that
+// ?fields= may select, read from the struct tags
+var resultFieldNames = func() map[string]bool {
+	names := map[string]bool{"missing_hashes": true} // Added by MarshalJSON
+	resultType := reflect.TypeOf(qmldiff.TreeComparisonResult{})
+	for i := 0; i < resultType.NumField(); i++ {
+		name, _, _ := strings.Cut(resultType.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// parseResultFields parses a comma-separated ?fields= value
+// Returns nil when no fields were requested.
+func parseResultFields(raw string) (map[string]bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	fields := make(map[string]bool)
+	var unknown []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !resultFieldNames[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		fields[name] = true
+	}
+
+	if len(unknown) > 0 {
+		valid := make([]string, 0, len(resultFieldNames))
+		for name := range resultFieldNames {
+			valid = append(valid, name)
+		}
+		sort.Strings(valid)
+		return nil, fmt.Errorf("unknown fields: %s (valid fields: %s)", strings.Join(unknown, ", "), strings.Join(valid, ", "))
+	}
+	return fields, nil
+}
+
+// projectResultFields trims every entry of the compatible and incompatible
+// lists in results, at any depth, down to the given fields. Works on the
+// marshaled JSON, so single and batch results are handled alike.
+func projectResultFields(results interface{}, fields map[string]bool) (interface{}, error) {
+	if fields == nil {
+		return results, nil
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+
+	projectValue(generic, fields)
+	return generic, nil
+}
+
+func projectValue(value interface{}, fields map[string]bool) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for key, child := range object {
+		entries, isList := child.([]interface{})
+		if isList && (key == "compatible" || key == "incompatible") {
+			for _, entry := range entries {
+				if result, ok := entry.(map[string]interface{}); ok {
+					for name := range result {
+						if !fields[name] {
+							delete(result, name)
+						}
+					}
+				}
+			}
+			continue
+		}
+		projectValue(child, fields)
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+)
+
+// Error details of tree results that summarizeErrors recognizes
+const (
+	detailPanicked      = "QML application failed"
+	detailFailedToApply = "QML failed to apply"
+	detailNotUploaded   = "Referenced by LOAD but not uploaded"
+)
+
+// ErrorSummary counts the incompatible results across a batch by cause
+type ErrorSummary struct {
+	Total               int `json:"total"`
+	MissingHashes       int `json:"missing_hashes"`
+	ProcessErrors       int `json:"process_errors"`
+	Panics              int `json:"panics"`
+	MissingDependencies int `json:"missing_dependencies"`
+	Timeouts            int `json:"timeouts"`
+	Other               int `json:"other"` // e.g. blocked by another file's failure, tree unavailable
+}
+
+// flattenDependencies adds an entry to a batch response for every file the
+// root files LOAD, holding one result per hashtable the root was checked
+// against. filenameToPaths maps root filenames to their paths on disk.
+func flattenDependencies(batchResponse map[string]CompareResponse, filenameToPaths map[string]string) {
+	logging.Debug(logging.ComponentHandler, "Starting dependency flattening for %d root files", len(batchResponse))
+	for rootFilename, response := range batchResponse {
+		allResults := make([]qmldiff.TreeComparisonResult, 0, len(response.Compatible)+len(response.Incompatible))
+		allResults = append(allResults, response.Compatible...)
+		allResults = append(allResults, response.Incompatible...)
+		logging.Debug(logging.ComponentHandler, "Processing root file '%s' with %d total results (%d compatible, %d incompatible)",
+			rootFilename, len(allResults), len(response.Compatible), len(response.Incompatible))
+
+		compatibleNames := make([]string, len(response.Compatible))
+		for i, r := range response.Compatible {
+			compatibleNames[i] = r.Hashtable
+		}
+		incompatibleNames := make([]string, len(response.Incompatible))
+		for i, r := range response.Incompatible {
+			incompatibleNames[i] = r.Hashtable
+		}
+		logging.Debug(logging.ComponentHandler, "  Compatible: %v", compatibleNames)
+		logging.Debug(logging.ComponentHandler, "  Incompatible: %v", incompatibleNames)
+
+		allResultsNames := make([]string, len(allResults))
+		for i, r := range allResults {
+			allResultsNames[i] = r.Hashtable
+		}
+		logging.Debug(logging.ComponentHandler, "  allResults after append: %v", allResultsNames)
+
+		logging.Debug(logging.ComponentHandler, "  About to iterate over %d results in allResults", len(allResults))
+		for i, treeResult := range allResults {
+			logging.Debug(logging.ComponentHandler, "  Loop iteration %d: Hashtable %s", i, treeResult.Hashtable)
+			depCount := 0
+			if treeResult.DependencyResults != nil {
+				depCount = len(treeResult.DependencyResults)
+			}
+			logging.Debug(logging.ComponentHandler, "  Hashtable %s (v%s, %s): %d dependencies",
+				treeResult.Hashtable, treeResult.OSVersion, treeResult.Device, depCount)
+
+			if treeResult.DependencyResults != nil && len(treeResult.DependencyResults) > 0 {
+				for depPath, depResult := range treeResult.DependencyResults {
+					logging.Debug(logging.ComponentHandler, "    Processing dependency '%s': compatible=%v, %d hash errors, %d process errors",
+						depPath, depResult.Compatible, len(depResult.HashErrors), len(depResult.ProcessErrors))
+
+					depTreeResult := qmldiff.TreeComparisonResult{
+						Hashtable:          treeResult.Hashtable,
+						OSVersion:          treeResult.OSVersion,
+						Device:             treeResult.Device,
+						Compatible:         depResult.Compatible,
+						ValidationMode:     "tree",
+						TreeValidationUsed: true,
+						TreeName:           treeResult.TreeName,
+						TreeFileCount:      treeResult.TreeFileCount,
+						Status:             depResult.Status,
+						BlockedBy:          depResult.BlockedBy,
+						BlockedChain:       qmd.BlockedChain(treeResult.DependencyResults, depPath),
+					}
+
+					if !depResult.Compatible {
+						if len(depResult.HashErrors) > 0 {
+							logging.Debug(logging.ComponentHandler, "      === Dependency Hash Error Processing ===")
+							logging.Debug(logging.ComponentHandler, "      Dependency: '%s'", depPath)
+							logging.Debug(logging.ComponentHandler, "      Root file: '%s'", rootFilename)
+
+							hashIDs := make([]uint64, len(depResult.HashErrors))
+							for i, hashErr := range depResult.HashErrors {
+								hashIDs[i] = hashErr.HashID
+							}
+							logging.Debug(logging.ComponentHandler, "      Hash IDs to find (%d): %v", len(hashIDs), hashIDs)
+
+							rootPath := filenameToPaths[rootFilename]
+							resolvedDepPath := qmd.ResolveLoadPath(rootPath, depPath)
+							logging.Debug(logging.ComponentHandler, "      Resolving depPath '%s' relative to root '%s' -> '%s'",
+								depPath, rootPath, resolvedDepPath)
+
+							positions, err := qmd.FindHashPositionsInFile(resolvedDepPath, hashIDs)
+							if err != nil {
+								logging.Error(logging.ComponentHandler, "      Failed to read dependency file %s: %v", resolvedDepPath, err)
+								depTreeResult.ErrorDetail = fmt.Sprintf("%d hash lookup error(s)", len(depResult.HashErrors))
+							} else {
+								depTreeResult.MissingHashes = positions
+								logging.Debug(logging.ComponentHandler, "      FindHashPositions returned %d positions: %v",
+									len(depTreeResult.MissingHashes), depTreeResult.MissingHashes)
+
+								if len(depTreeResult.MissingHashes) > 0 {
+									depTreeResult.ErrorDetail = fmt.Sprintf("missing %d hash(es)", len(depTreeResult.MissingHashes))
+								} else {
+									depTreeResult.ErrorDetail = fmt.Sprintf("%d hash lookup error(s)", len(depResult.HashErrors))
+									depTreeResult.MissingHashes = make([]qmd.HashWithPosition, len(hashIDs))
+									for i, hashID := range hashIDs {
+										depTreeResult.MissingHashes[i] = qmd.HashWithPosition{
+											Hash:   hashID,
+											Line:   0,
+											Column: 0,
+										}
+									}
+								}
+								logging.Debug(logging.ComponentHandler, "      Final ErrorDetail: '%s'", depTreeResult.ErrorDetail)
+							}
+						} else if depResult.Status == qmd.StatusNotUploaded {
+							depTreeResult.ErrorDetail = detailNotUploaded
+							if slices.Contains(depResult.ProcessErrors, qmd.ErrLoadOutsideRoot.Error()) {
+								depTreeResult.ErrorDetail = qmd.ErrLoadOutsideRoot.Error()
+							}
+						} else if len(depResult.ProcessErrors) > 0 {
+							depTreeResult.ErrorDetail = detailFailedToApply
+						} else {
+							if depResult.Status == qmd.StatusNotAttempted {
+								if depResult.BlockedBy != "" {
+									depTreeResult.ErrorDetail = fmt.Sprintf("Not validated due to failure of dependency %s", depResult.BlockedBy)
+								} else {
+									depTreeResult.ErrorDetail = "Not attempted due to prior failure"
+								}
+							} else {
+								depTreeResult.ErrorDetail = fmt.Sprintf("Validation status: %s", depResult.Status)
+							}
+						}
+					}
+
+					if existingResponse, exists := batchResponse[depPath]; exists {
+						logging.Debug(logging.ComponentHandler, "      Appending to existing entry (now %d total)", existingResponse.TotalChecked+1)
+						if depResult.Compatible {
+							existingResponse.Compatible = append(existingResponse.Compatible, depTreeResult)
+						} else {
+							existingResponse.Incompatible = append(existingResponse.Incompatible, depTreeResult)
+						}
+						existingResponse.TotalChecked++
+						batchResponse[depPath] = existingResponse
+					} else {
+						logging.Debug(logging.ComponentHandler, "      Creating new entry for dependency '%s'", depPath)
+						newResponse := CompareResponse{
+							Mode:         "tree",
+							TotalChecked: 1,
+						}
+						if depResult.Compatible {
+							newResponse.Compatible = []qmldiff.TreeComparisonResult{depTreeResult}
+							newResponse.Incompatible = []qmldiff.TreeComparisonResult{}
+						} else {
+							newResponse.Compatible = []qmldiff.TreeComparisonResult{}
+							newResponse.Incompatible = []qmldiff.TreeComparisonResult{depTreeResult}
+						}
+						batchResponse[depPath] = newResponse
+					}
+				}
+			}
+		}
+
+		logging.Debug(logging.ComponentHandler, "Flattened dependency results for %s", rootFilename)
+	}
+}
+
+// truncateDependencies bounds the number of dependency entries in a batch
+// response to limit, so a QMD with a huge LOAD tree doesn't produce a
+// response the UI can't render. Dependencies with failures are kept over
+// ones that passed. Root files are never dropped, and each is marked when
+// entries were omitted. Returns the number omitted; a limit below 1 disables
+// truncation.
+func truncateDependencies(batchResponse map[string]CompareResponse, filenameToPaths map[string]string, limit int) int {
+	if limit < 1 {
+		return 0
+	}
+
+	dependencies := make([]string, 0, len(batchResponse))
+	for filename := range batchResponse {
+		if _, isRoot := filenameToPaths[filename]; !isRoot {
+			dependencies = append(dependencies, filename)
+		}
+	}
+	if len(dependencies) <= limit {
+		return 0
+	}
+
+	sort.Slice(dependencies, func(i, j int) bool {
+		iFailed := len(batchResponse[dependencies[i]].Incompatible) > 0
+		jFailed := len(batchResponse[dependencies[j]].Incompatible) > 0
+		if iFailed != jFailed {
+			return iFailed
+		}
+		return dependencies[i] < dependencies[j]
+	})
+
+	omitted := dependencies[limit:]
+	for _, filename := range omitted {
+		delete(batchResponse, filename)
+	}
+	for filename := range filenameToPaths {
+		if response, ok := batchResponse[filename]; ok {
+			response.DependenciesTruncated = true
+			response.DependenciesOmitted = len(omitted)
+			batchResponse[filename] = response
+		}
+	}
+
+	return len(omitted)
+}
+
+// summarizeErrors tallies the incompatible results of a flattened batch
+// response, dependencies included
+func summarizeErrors(batchResponse map[string]CompareResponse) ErrorSummary {
+	summary := ErrorSummary{}
+	for _, response := range batchResponse {
+		for _, result := range response.Incompatible {
+			summary.Total++
+			switch {
+			case len(result.MissingHashes) > 0 || strings.HasPrefix(result.ErrorDetail, "missing "):
+				summary.MissingHashes++
+			case result.Status == qmd.StatusNotUploaded || result.ErrorDetail == detailNotUploaded || result.ErrorDetail == qmd.ErrLoadOutsideRoot.Error():
+				summary.MissingDependencies++
+			case strings.Contains(result.ErrorDetail, " timed out after "):
+				summary.Timeouts++
+			case result.ErrorDetail == detailPanicked:
+				summary.Panics++
+			case result.ErrorDetail == detailFailedToApply:
+				summary.ProcessErrors++
+			default:
+				summary.Other++
+			}
+		}
+	}
+	return summary
+}
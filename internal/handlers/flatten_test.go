@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+)
+
+func TestTruncateDependenciesLargeFanOut(t *testing.T) {
+	// root.qmd LOADs 2000 files; every 400th fails
+	depResults := map[string]*qmd.ValidationResult{
+		"root.qmd": {Path: "root.qmd", Status: qmd.StatusValidated, Compatible: true, Position: -1},
+	}
+	var failing []string
+	for i := 0; i < 2000; i++ {
+		path := fmt.Sprintf("lib/dep%04d.qmd", i)
+		result := &qmd.ValidationResult{Path: path, Status: qmd.StatusValidated, Compatible: true, Position: i}
+		if i%400 == 399 {
+			result.Status = qmd.StatusFailed
+			result.Compatible = false
+			result.ProcessErrors = []string{"Cannot locate node"}
+			failing = append(failing, path)
+		}
+		depResults[path] = result
+	}
+
+	batchResponse := map[string]CompareResponse{
+		"root.qmd": {
+			Compatible: []qmldiff.TreeComparisonResult{},
+			Incompatible: []qmldiff.TreeComparisonResult{{
+				Hashtable:         "3.22.4.2-rmpp",
+				TreeName:          "3.22.4.2-rmpp",
+				TreeFileCount:     1200,
+				DependencyResults: depResults,
+			}},
+			TotalChecked: 1,
+			Mode:         "tree",
+		},
+		"other.qmd": {Compatible: []qmldiff.TreeComparisonResult{}, Incompatible: []qmldiff.TreeComparisonResult{}, Mode: "tree"},
+	}
+	filenameToPaths := map[string]string{"root.qmd": "/upload/root.qmd", "other.qmd": "/upload/other.qmd"}
+
+	flattenDependencies(batchResponse, filenameToPaths)
+	if len(batchResponse) != 2002 {
+		t.Fatalf("flattened %d entries, want 2002", len(batchResponse))
+	}
+	if dep := batchResponse[failing[0]].Incompatible; len(dep) != 1 || dep[0].TreeName != "3.22.4.2-rmpp" || dep[0].TreeFileCount != 1200 {
+		t.Errorf("dependency %s results = %+v, want tree 3.22.4.2-rmpp with 1200 files", failing[0], dep)
+	}
+
+	omitted := truncateDependencies(batchResponse, filenameToPaths, 100)
+	if omitted != 1900 {
+		t.Errorf("omitted = %d, want 1900", omitted)
+	}
+	if len(batchResponse) != 102 {
+		t.Errorf("%d entries after truncation, want 100 dependencies and 2 roots", len(batchResponse))
+	}
+
+	// Failures survive truncation, however late they sort
+	for _, path := range failing {
+		if _, ok := batchResponse[path]; !ok {
+			t.Errorf("failing dependency %s was dropped", path)
+		}
+	}
+	for _, root := range []string{"root.qmd", "other.qmd"} {
+		response, ok := batchResponse[root]
+		if !ok {
+			t.Fatalf("root file %s was dropped", root)
+		}
+		if !response.DependenciesTruncated || response.DependenciesOmitted != 1900 {
+			t.Errorf("%s: DependenciesTruncated = %v, DependenciesOmitted = %d, want true and 1900",
+				root, response.DependenciesTruncated, response.DependenciesOmitted)
+		}
+	}
+}
+
+func TestTruncateDependenciesUnderLimit(t *testing.T) {
+	batchResponse := map[string]CompareResponse{
+		"root.qmd":    {Mode: "tree"},
+		"lib/dep.qmd": {Mode: "tree"},
+	}
+	filenameToPaths := map[string]string{"root.qmd": "/upload/root.qmd"}
+
+	for _, limit := range []int{0, 1, 10} {
+		if omitted := truncateDependencies(batchResponse, filenameToPaths, limit); omitted != 0 {
+			t.Errorf("limit %d: omitted = %d, want 0", limit, omitted)
+		}
+	}
+	if batchResponse["root.qmd"].DependenciesTruncated {
+		t.Error("root.qmd marked truncated with nothing omitted")
+	}
+}
+
+func TestSummarizeErrors(t *testing.T) {
+	batchResponse := map[string]CompareResponse{
+		"root.qmd": {Incompatible: []qmldiff.TreeComparisonResult{
+			{Hashtable: "a", MissingHashes: []qmd.HashWithPosition{{Hash: 1}}, ErrorDetail: "missing 1 hash(es)"},
+			{Hashtable: "b", ErrorDetail: detailPanicked},
+			{Hashtable: "c", ErrorDetail: "validation timed out after 5m0s"},
+		}},
+		"lib/dep.qmd": {Incompatible: []qmldiff.TreeComparisonResult{
+			{Hashtable: "a", ErrorDetail: detailFailedToApply},
+			{Hashtable: "b", Status: qmd.StatusNotUploaded, ErrorDetail: detailNotUploaded},
+			{Hashtable: "c", ErrorDetail: "Blocked by failure in root.qmd"},
+		}},
+		"other.qmd": {Compatible: []qmldiff.TreeComparisonResult{{Hashtable: "a", Compatible: true}}},
+	}
+
+	want := ErrorSummary{Total: 6, MissingHashes: 1, ProcessErrors: 1, Panics: 1, MissingDependencies: 1, Timeouts: 1, Other: 1}
+	if got := summarizeErrors(batchResponse); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HashString is a string a hash maps to, with the hashtables that define it
+type HashString struct {
+	String   string   `json:"string"`
+	Versions []string `json:"versions"`
+}
+
+// LookupHash reports the strings a hash maps to across the loaded hashtables,
+// to help explain why a diff resolves on one firmware version but not another
+// Hashlists only record that a hash exists, so they are listed separately.
+func (h *APIHandler) LookupHash(w http.ResponseWriter, r *http.Request) {
+	hash, err := strconv.ParseUint(chi.URLParam(r, "hashID"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "hashID must be a decimal hash",
+		})
+		return
+	}
+
+	hashStrings := make([]HashString, 0)
+	index := make(map[string]int)
+	for _, match := range h.hashtabService.LookupHashAll(hash) {
+		i, ok := index[match.String]
+		if !ok {
+			i = len(hashStrings)
+			index[match.String] = i
+			hashStrings = append(hashStrings, HashString{String: match.String})
+		}
+		hashStrings[i].Versions = append(hashStrings[i].Versions, match.Hashtable)
+	}
+
+	hashlists := make([]string, 0)
+	for _, ht := range h.hashtabService.GetHashtables() {
+		if _, ok := ht.Entries[hash]; ok && ht.IsHashlist() {
+			hashlists = append(hashlists, ht.Name)
+		}
+	}
+
+	status := http.StatusOK
+	if len(hashStrings) == 0 && len(hashlists) == 0 {
+		status = http.StatusNotFound
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hash":      strconv.FormatUint(hash, 10),
+		"strings":   hashStrings,
+		"hashlists": hashlists,
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+func TestLookupHash(t *testing.T) {
+	hashtabDir := t.TempDir()
+	width := hashtab.DJB2Hash("width")
+	tables := map[string]map[uint64]string{
+		"3.20.0.52-rm2": {width: "width"},
+		"3.22.0.64-rm2": {width: "width"},
+		"3.22.4.2-rmpp": {width: "implicitWidth"},
+	}
+	for name, entries := range tables {
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	if err := hashtab.WriteHashlist([]uint64{width}, filepath.Join(hashtabDir, "3.23.0.1-rmpp")); err != nil {
+		t.Fatalf("WriteHashlist() failed: %v", err)
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	h := NewAPIHandler(nil, hashtabService, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	lookup := func(hashID string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, "/api/hash/"+hashID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("hashID", hashID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		h.LookupHash(rec, req)
+
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec, body
+	}
+
+	rec, body := lookup(strconv.FormatUint(width, 10))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	wantStrings := []interface{}{
+		map[string]interface{}{"string": "width", "versions": []interface{}{"3.20.0.52-rm2", "3.22.0.64-rm2"}},
+		map[string]interface{}{"string": "implicitWidth", "versions": []interface{}{"3.22.4.2-rmpp"}},
+	}
+	if !reflect.DeepEqual(body["strings"], wantStrings) {
+		t.Errorf("strings = %v, want %v", body["strings"], wantStrings)
+	}
+	if !reflect.DeepEqual(body["hashlists"], []interface{}{"3.23.0.1-rmpp"}) {
+		t.Errorf("hashlists = %v, want [3.23.0.1-rmpp]", body["hashlists"])
+	}
+
+	if rec, _ := lookup("42"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown hash: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec, _ := lookup("width"); rec.Code != http.StatusBadRequest {
+		t.Errorf("non-numeric hash: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+// hashlistNote explains results from a hashtable without strings
+const hashlistNote = "hashtable is a hashlist: only checked that hashes exist, diffs were not applied to the QML tree"
+
+// validateHashesWithWorkers checks that every hash the QMD files and the
+// files they LOAD reference exists in each hashtable. No QML trees are
+// needed, so this is a fast pre-screen before full tree validation.
+// supportedVersions and selected filter hashtables as in
+// validateAgainstAllTreesWithWorkers.
+func (h *APIHandler) validateHashesWithWorkers(
+	ctx context.Context,
+	qmdPaths []string,
+	filenames []string,
+	supportedVersions map[string][]string,
+	selected map[string]bool,
+	jobStore *jobs.Store,
+	jobID string,
+) (map[string][]qmldiff.TreeComparisonResult, error) {
+
+	hashtables := h.hashtabService.GetHashtables()
+	if selected != nil {
+		filtered := make([]*hashtab.Hashtab, 0, len(selected))
+		for _, ht := range hashtables {
+			if selected[strings.ToLower(ht.Name)] {
+				filtered = append(filtered, ht)
+			}
+		}
+		hashtables = filtered
+	}
+	if len(hashtables) == 0 {
+		return nil, fmt.Errorf("no hashtables available")
+	}
+
+	// Every hashtable checks the same hashes, so find them once
+	fileHashes := make([][]qmd.HashWithPosition, len(qmdPaths))
+	fileErrors := make([]error, len(qmdPaths))
+	for i, qmdPath := range qmdPaths {
+		fileHashes[i], fileErrors[i] = collectHashPositions(qmdPath)
+	}
+
+	resultsMap := make(map[string][]qmldiff.TreeComparisonResult)
+	for _, filename := range filenames {
+		resultsMap[filename] = make([]qmldiff.TreeComparisonResult, 0)
+	}
+
+	totalComparisons := len(hashtables)
+	completedComparisons := 0
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, h.maxConcurrentValidations)
+
+	logging.Info(logging.ComponentHandler, "Starting parallel hash-only validation with max concurrency: %d", h.maxConcurrentValidations)
+
+	for _, ht := range hashtables {
+		wg.Add(1)
+		go func(ht *hashtab.Hashtab) {
+			defer wg.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				return
+			}
+
+			results := make(map[string]qmldiff.TreeComparisonResult, len(filenames))
+			for i, filename := range filenames {
+				if !qmd.SupportsVersion(supportedVersions[filename], ht.OSVersion) {
+					continue
+				}
+				results[filename] = hashOnlyResult(ht, ht.Device, "", fileHashes[i], fileErrors[i])
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for filename, result := range results {
+				resultsMap[filename] = append(resultsMap[filename], result)
+			}
+			completedComparisons++
+			if jobStore != nil {
+				progress := int((float64(completedComparisons) / float64(totalComparisons)) * 100)
+				jobStore.UpdateProgress(jobID, progress)
+			}
+		}(ht)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+
+		logging.Warn(logging.ComponentHandler, "Hash-only validation stopped early: %d/%d hashtables processed (%v)",
+			completedComparisons, totalComparisons, ctx.Err())
+
+		return copyResults(resultsMap), ctx.Err()
+	}
+
+	logging.Info(logging.ComponentHandler, "Hash-only validation complete: %d hashtables processed", completedComparisons)
+
+	return resultsMap, nil
+}
+
+// validateAgainstHashlist checks each QMD file against a hashtable whose
+// entries have no strings. qmldiff needs the strings to apply diffs to a
+// tree, so a hashlist can only tell whether the referenced hashes exist.
+// Returns one result per path.
+func validateAgainstHashlist(ht *hashtab.Hashtab, device, versionMismatch string, qmdPaths []string) []qmldiff.TreeComparisonResult {
+	results := make([]qmldiff.TreeComparisonResult, len(qmdPaths))
+	for i, qmdPath := range qmdPaths {
+		hashes, err := collectHashPositions(qmdPath)
+		results[i] = hashOnlyResult(ht, device, versionMismatch, hashes, err)
+		results[i].Warning = hashlistNote
+	}
+
+	logging.Info(logging.ComponentHandler, "Checked %d file(s) against hashlist %s by hash presence only", len(qmdPaths), ht.Name)
+
+	return results
+}
+
+// hashOnlyResult reports which of a file's hashes are missing from a hashtable
+// err is a failure to collect the file's hashes
+func hashOnlyResult(ht *hashtab.Hashtab, device, versionMismatch string, hashes []qmd.HashWithPosition, err error) qmldiff.TreeComparisonResult {
+	result := qmldiff.TreeComparisonResult{
+		Hashtable:          ht.Name,
+		VersionMismatch:    versionMismatch,
+		OSVersion:          ht.OSVersion,
+		Device:             device,
+		Compatible:         true,
+		ValidationMode:     "hash",
+		TreeValidationUsed: false,
+	}
+
+	if err != nil {
+		result.Compatible = false
+		result.ErrorDetail = fmt.Sprintf("validation error: %v", err)
+		return result
+	}
+
+	verifyResult := qmd.VerifyWithHashes(hashes, ht)
+	if !verifyResult.Compatible {
+		result.Compatible = false
+		result.MissingHashes = verifyResult.MissingHashes
+		result.ErrorDetail = fmt.Sprintf("missing %d hash(es)", len(verifyResult.MissingHashes))
+	}
+	return result
+}
+
+// collectHashPositions returns every hash a QMD file and the files it LOADs
+// reference, positioned within the QMD file. Hashes that only appear in
+// LOADed files have no position.
+func collectHashPositions(qmdPath string) ([]qmd.HashWithPosition, error) {
+	hashes, err := qmd.CollectHashes([]string{qmdPath})
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(qmdPath)
+	if err != nil {
+		return nil, err
+	}
+	positions := qmd.FindHashPositions(string(content), qmd.ExtractHashes(string(content)))
+
+	found := make(map[uint64]bool, len(positions))
+	for _, position := range positions {
+		found[position.Hash] = true
+	}
+	for _, hash := range hashes {
+		if !found[hash] {
+			positions = append(positions, qmd.HashWithPosition{Hash: hash})
+		}
+	}
+	return positions, nil
+}
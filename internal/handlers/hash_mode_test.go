@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+func TestValidateHashesWithWorkers(t *testing.T) {
+	hashtabDir := t.TempDir()
+	width := hashtab.DJB2Hash("width")
+	height := hashtab.DJB2Hash("height")
+	tables := map[string]map[uint64]string{
+		"3.22.4.2-rmpp": {width: "width", height: "height"},
+		"3.20.0.52-rm2": {width: "width"},
+	}
+	for name, entries := range tables {
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+
+	uploadDir := t.TempDir()
+	qmdPath := filepath.Join(uploadDir, "mod.qmd")
+	content := "AFFECT [[" + strconv.FormatUint(width, 10) + "]]\n  REPLACE [[" + strconv.FormatUint(height, 10) + "]]\n"
+	if err := os.WriteFile(qmdPath, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	// Hash-only mode needs neither trees nor qmldiff
+	h := NewAPIHandler(nil, hashtabService, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+	resultsMap, err := h.validateHashesWithWorkers(context.Background(), []string{qmdPath}, []string{"mod.qmd"}, nil, nil, nil, "")
+	if err != nil {
+		t.Fatalf("validateHashesWithWorkers() failed: %v", err)
+	}
+
+	results := resultsMap["mod.qmd"]
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want one per hashtable", len(results))
+	}
+	for _, result := range results {
+		if result.ValidationMode != "hash" || result.TreeValidationUsed {
+			t.Errorf("%s: ValidationMode = %q, TreeValidationUsed = %v, want hash mode", result.Hashtable, result.ValidationMode, result.TreeValidationUsed)
+		}
+		switch result.Hashtable {
+		case "3.22.4.2-rmpp":
+			if !result.Compatible {
+				t.Errorf("3.22.4.2-rmpp: incompatible (%s), want compatible", result.ErrorDetail)
+			}
+		case "3.20.0.52-rm2":
+			if result.Compatible || len(result.MissingHashes) != 1 {
+				t.Fatalf("3.20.0.52-rm2: Compatible = %v, MissingHashes = %+v, want height missing", result.Compatible, result.MissingHashes)
+			}
+			if missing := result.MissingHashes[0]; missing.Hash != height || missing.Line != 2 || missing.Column != 13 {
+				t.Errorf("3.20.0.52-rm2: missing %+v, want height at 2:13", missing)
+			}
+		}
+	}
+}
+
+func TestValidateHashesFindsBlockHashes(t *testing.T) {
+	hashtabDir := t.TempDir()
+	width := hashtab.DJB2Hash("width")
+	height := hashtab.DJB2Hash("height")
+	if err := hashtab.WriteHashtab(map[uint64]string{width: "width"}, filepath.Join(hashtabDir, "3.20.0.52-rm2")); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+
+	// height is only referenced from inside the inserted QML block
+	qmdPath := filepath.Join(t.TempDir(), "mod.qmd")
+	content := "AFFECT [[" + strconv.FormatUint(width, 10) + "]]\n  INSERT {\n    id: ~&" + strconv.FormatUint(height, 10) + "&~\n  }\n"
+	if err := os.WriteFile(qmdPath, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	h := NewAPIHandler(nil, hashtabService, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+	resultsMap, err := h.validateHashesWithWorkers(context.Background(), []string{qmdPath}, []string{"mod.qmd"}, nil, nil, nil, "")
+	if err != nil {
+		t.Fatalf("validateHashesWithWorkers() failed: %v", err)
+	}
+
+	results := resultsMap["mod.qmd"]
+	if len(results) != 1 || results[0].Compatible || len(results[0].MissingHashes) != 1 {
+		t.Fatalf("results = %+v, want height missing", results)
+	}
+	if missing := results[0].MissingHashes[0]; missing.Hash != height || missing.Line != 3 || missing.Column != 11 {
+		t.Errorf("missing %+v, want height at 3:11", missing)
+	}
+}
+
+func TestCompareRejectsUnknownMode(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	req := newMultipartRequest(t, map[string]string{"mod.qmd": "AFFECT [[1]]\n"}, nil)
+	req.URL.RawQuery = "mode=fast"
+	rec := httptest.NewRecorder()
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
+
+func TestCompareRejectsDisabledMode(t *testing.T) {
+	settings := DefaultSettings()
+	settings.AllowedModes = []string{"hash"}
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, settings)
+
+	req := newMultipartRequest(t, map[string]string{"mod.qmd": "AFFECT [[1]]\n"}, nil)
+	req.URL.RawQuery = "mode=tree"
+	rec := httptest.NewRecorder()
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	var resp struct {
+		Error        string   `json:"error"`
+		AllowedModes []string `json:"allowed_modes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if resp.Error != "tree mode is disabled on this server" || !reflect.DeepEqual(resp.AllowedModes, []string{"hash"}) {
+		t.Errorf("response = %+v, want tree disabled with allowed_modes [hash]", resp)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+func TestValidateAgainstHashlist(t *testing.T) {
+	hashtabDir := t.TempDir()
+	treeDir := t.TempDir()
+	width := hashtab.DJB2Hash("width")
+	if err := hashtab.WriteHashlist([]uint64{width}, filepath.Join(hashtabDir, "3.22.4.2-rmpp")); err != nil {
+		t.Fatalf("WriteHashlist() failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(treeDir, "3.22.4.2-rmpp"), 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(treeDir, "3.22.4.2-rmpp", "Main.qml"), []byte("Item {}"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	uploadDir := t.TempDir()
+	files := map[string]string{
+		"ok.qmd":      "AFFECT [[" + strconv.FormatUint(width, 10) + "]]\n",
+		"broken.qmd":  "AFFECT [[" + strconv.FormatUint(width, 10) + "]]\nLOAD lib/dep.qmd\n",
+		"lib/dep.qmd": "AFFECT [[42]]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(uploadDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	// No qmldiff service: a hashlist must never reach the tree validation path
+	jobStore := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, hashtabService, qmltree.NewService(treeDir), jobStore, nil, 1, DefaultSettings())
+	jobStore.Create("job")
+
+	qmdPaths := []string{filepath.Join(uploadDir, "ok.qmd"), filepath.Join(uploadDir, "broken.qmd")}
+	resultsMap, err := h.validateAgainstAllTreesWithWorkers(context.Background(), h.qmldiffService, qmdPaths, []string{"ok.qmd", "broken.qmd"}, nil, nil, nil, jobStore, "job")
+	if err != nil {
+		t.Fatalf("validateAgainstAllTreesWithWorkers() failed: %v", err)
+	}
+	// The hashlist is checked off the dispatch loop but still counted
+	if job, _ := jobStore.Get("job"); job.Hashtables == nil || job.Hashtables.Completed != 1 || len(job.Hashtables.InFlight) != 0 {
+		t.Errorf("hashtable progress = %+v, want 1 completed and none in flight", job.Hashtables)
+	}
+
+	ok := resultsMap["ok.qmd"]
+	if len(ok) != 1 || !ok[0].Compatible || ok[0].ValidationMode != "hash" || ok[0].TreeValidationUsed {
+		t.Fatalf("ok.qmd results = %+v, want one compatible hash-mode result", ok)
+	}
+	if ok[0].Warning != hashlistNote {
+		t.Errorf("ok.qmd Warning = %q, want the hashlist note", ok[0].Warning)
+	}
+
+	// The missing hash is only in a LOADed file, so it has no position
+	broken := resultsMap["broken.qmd"]
+	if len(broken) != 1 || broken[0].Compatible || broken[0].ErrorDetail != "missing 1 hash(es)" {
+		t.Fatalf("broken.qmd results = %+v, want one result missing 1 hash", broken)
+	}
+	if missing := broken[0].MissingHashes; len(missing) != 1 || missing[0].Hash != 42 || missing[0].Line != 0 {
+		t.Errorf("broken.qmd MissingHashes = %+v, want hash 42 without a position", missing)
+	}
+}
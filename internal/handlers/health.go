@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Health reports that the server is up, for liveness probes
+func (h *APIHandler) Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
+// Ready reports whether the server can validate, for readiness probes: it
+// responds 503 until at least one hashtable is loaded and the qmldiff binary
+// is available
+func (h *APIHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if count := len(h.hashtabService.GetHashtables()); count == 0 {
+		checks["hashtables"] = "no hashtables loaded"
+		ready = false
+	} else {
+		checks["hashtables"] = "ok"
+	}
+
+	if err := h.qmldiffService.TestBinary(); err != nil {
+		checks["qmldiff"] = err.Error()
+		ready = false
+	} else {
+		checks["qmldiff"] = "ok"
+	}
+
+	status := http.StatusOK
+	response := map[string]interface{}{"status": "ready", "checks": checks}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		response["status"] = "not ready"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JobSummary is a job as listed by ListJobs, without its results
+type JobSummary struct {
+	JobID       string     `json:"jobId"`
+	Status      string     `json:"status"`
+	Message     string     `json:"message"`
+	Progress    int        `json:"progress"`
+	Operation   string     `json:"operation,omitempty"`
+	Label       string     `json:"label,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ListJobs lists the jobs the store holds, unfinished ones first, then the
+// most recently completed. ?status= keeps only jobs with one of the given
+// comma-separated statuses. Job IDs are what keep one user's results from
+// another, so listing them requires the admin token.
+func (h *APIHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Listing jobs requires an admin token",
+		})
+		return
+	}
+
+	statuses := make(map[string]bool)
+	for _, status := range strings.Split(r.URL.Query().Get("status"), ",") {
+		if status = strings.ToLower(strings.TrimSpace(status)); status != "" {
+			statuses[status] = true
+		}
+	}
+
+	summaries := make([]JobSummary, 0)
+	for id, job := range h.jobStore.List() {
+		if len(statuses) > 0 && !statuses[job.Status] {
+			continue
+		}
+		summaries = append(summaries, JobSummary{
+			JobID:       id,
+			Status:      job.Status,
+			Message:     job.Message,
+			Progress:    job.Progress,
+			Operation:   job.Operation,
+			Label:       job.Label,
+			CompletedAt: job.CompletedAt,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i].CompletedAt, summaries[j].CompletedAt
+		switch {
+		case a == nil && b == nil:
+			return summaries[i].JobID < summaries[j].JobID
+		case a == nil || b == nil:
+			return a == nil
+		case !a.Equal(*b):
+			return a.After(*b)
+		}
+		return summaries[i].JobID < summaries[j].JobID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs":  summaries,
+		"count": len(summaries),
+	})
+}
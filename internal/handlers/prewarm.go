@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+// prewarmQMD is validated against every tree at startup. It makes no changes,
+// so it passes on any tree where the pipeline itself works.
+const prewarmQMD = "; rm-qmd-verify startup prewarm\n"
+
+// Prewarm validates a built-in no-op QMD once per hashtable and tree pair,
+// loading the trees into the OS cache (and downloading remote ones) before
+// the first real request. Doubles as a self-test: returns how many pairs
+// validated cleanly and how many failed.
+func (h *APIHandler) Prewarm(ctx context.Context) (passed, failed int) {
+	tempDir, err := os.MkdirTemp("", "qmd-prewarm-*")
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Prewarm failed to create temp dir: %v", err)
+		return 0, 0
+	}
+	defer os.RemoveAll(tempDir)
+
+	qmdPath := filepath.Join(tempDir, "prewarm.qmd")
+	if err := os.WriteFile(qmdPath, []byte(prewarmQMD), 0644); err != nil {
+		logging.Error(logging.ComponentHandler, "Prewarm failed to write QMD: %v", err)
+		return 0, 0
+	}
+
+	trees := h.treeService.GetTrees()
+	for _, ht := range h.hashtabService.GetHashtables() {
+		if ctx.Err() != nil {
+			break
+		}
+		tree, _ := findTree(ht, trees)
+		if tree == nil {
+			continue
+		}
+
+		start := time.Now()
+		if err := h.prewarmPair(ctx, qmdPath, ht.Path, tree); err != nil {
+			failed++
+			logging.Warn(logging.ComponentHandler, "Prewarm %s failed after %s: %v", ht.Name, time.Since(start).Round(time.Millisecond), err)
+			continue
+		}
+		passed++
+		logging.Info(logging.ComponentHandler, "Prewarm %s passed in %s", ht.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	return passed, failed
+}
+
+// prewarmPair runs the prewarm QMD against one hashtable and tree
+func (h *APIHandler) prewarmPair(ctx context.Context, qmdPath, hashtabPath string, tree *qmltree.Tree) error {
+	treePath, err := h.treeService.Materialize(ctx, tree)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errTreeUnavailable, err)
+	}
+
+	batchResult, err := h.qmldiffService.ValidateMultipleAgainstTreeSequential(ctx, []string{qmdPath}, hashtabPath, treePath)
+	if err != nil {
+		return err
+	}
+	if err := batchResult.Errors[qmdPath]; err != nil {
+		return err
+	}
+	if result := batchResult.Results[qmdPath]; result != nil && result.FilesWithErrors > 0 {
+		return fmt.Errorf("%d file(s) with errors", result.FilesWithErrors)
+	}
+	return nil
+}
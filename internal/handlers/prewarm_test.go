@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+func TestPrewarmReportsFailures(t *testing.T) {
+	hashtabDir := t.TempDir()
+	treeDir := t.TempDir()
+	for _, name := range []string{"3.22.4.2-rmpp", "3.20.0.52-rm2"} {
+		entries := map[uint64]string{hashtab.DJB2Hash("width"): "width"}
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	// Only one hashtable has a tree; the other is skipped
+	if err := os.MkdirAll(filepath.Join(treeDir, "3.22.4.2-rmpp"), 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(treeDir, "3.22.4.2-rmpp", "Main.qml"), []byte("Item {}"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	treeService := qmltree.NewService(treeDir)
+	qmldiffService := qmldiff.NewService(filepath.Join(t.TempDir(), "qmldiff"), hashtabService, treeService)
+	h := NewAPIHandler(qmldiffService, hashtabService, treeService, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	passed, failed := h.Prewarm(context.Background())
+	if passed != 0 || failed != 1 {
+		t.Errorf("Prewarm() = %d passed, %d failed, want 0 and 1 with a missing qmldiff binary", passed, failed)
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// isAdmin reports whether the request carries Settings.AdminToken as a
+// bearer token. With no token configured, no request is an admin request.
+func (h *APIHandler) isAdmin(r *http.Request) bool {
+	token := h.settings.AdminToken
+	if token == "" {
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// qmldiffBinary returns the alternate qmldiff binary a request asks for with
+// ?qmldiff_binary=, or "" for the default one. Only admins may choose, and
+// only from Settings.QMLDiffBinaries, so a candidate qmldiff build can be qualified
+// side by side with the default without a restart. On error, status is the
+// HTTP status to respond with.
+func (h *APIHandler) qmldiffBinary(r *http.Request) (binary string, status int, err error) {
+	binary = strings.TrimSpace(r.URL.Query().Get("qmldiff_binary"))
+	if binary == "" {
+		return "", 0, nil
+	}
+	if !h.isAdmin(r) {
+		return "", http.StatusForbidden, fmt.Errorf("qmldiff_binary requires an admin token")
+	}
+	if !slices.Contains(h.settings.QMLDiffBinaries, binary) {
+		return "", http.StatusBadRequest, fmt.Errorf("qmldiff_binary %s is not one of QMLDIFF_BINARIES", binary)
+	}
+	return binary, 0, nil
+}
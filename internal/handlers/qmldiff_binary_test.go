@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+)
+
+func TestQMLDiffBinary(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		adminToken string
+		auth       string
+		query      string
+		want       string
+		wantStatus int
+	}{
+		{name: "default binary", query: ""},
+		{name: "no admin token configured", auth: "Bearer secret", query: "/opt/qmldiff-next", wantStatus: http.StatusForbidden},
+		{name: "missing token", adminToken: "secret", query: "/opt/qmldiff-next", wantStatus: http.StatusForbidden},
+		{name: "wrong token", adminToken: "secret", auth: "Bearer guess", query: "/opt/qmldiff-next", wantStatus: http.StatusForbidden},
+		{name: "not allowlisted", adminToken: "secret", auth: "Bearer secret", query: "/usr/bin/evil", wantStatus: http.StatusBadRequest},
+		{name: "allowlisted", adminToken: "secret", auth: "Bearer secret", query: "/opt/qmldiff-old", want: "/opt/qmldiff-old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := DefaultSettings()
+			settings.QMLDiffBinaries = []string{"/opt/qmldiff-next", "/opt/qmldiff-old"}
+			settings.AdminToken = tt.adminToken
+			h := NewAPIHandler(nil, nil, nil, nil, nil, 1, settings)
+			req := httptest.NewRequest(http.MethodPost, "/api/compare?qmldiff_binary="+tt.query, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+
+			binary, status, err := h.qmldiffBinary(req)
+			if binary != tt.want || status != tt.wantStatus || (err != nil) != (tt.wantStatus != 0) {
+				t.Errorf("qmldiffBinary() = %q, %d, %v, want %q, %d", binary, status, err, tt.want, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCompareRefusesQMLDiffBinaryWithoutAdmin(t *testing.T) {
+	settings := DefaultSettings()
+	settings.QMLDiffBinaries = []string{"/opt/qmldiff-next"}
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, settings)
+
+	req := newMultipartRequest(t, map[string]string{"a.qmd": "AFFECT [[1]]\n"}, nil)
+	req.URL.RawQuery = "qmldiff_binary=/opt/qmldiff-next"
+	rec := httptest.NewRecorder()
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body.String())
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+)
+
+// Statuses a file can have against a hashtable in a results diff
+const (
+	diffCompatible   = "compatible"
+	diffIncompatible = "incompatible"
+	diffAbsent       = "absent" // Not checked against that hashtable in the job
+)
+
+// ResultChange is one file whose status against a hashtable differs between two jobs
+type ResultChange struct {
+	File      string `json:"file"`
+	Hashtable string `json:"hashtable"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// ResultsDiff lists the status transitions between two compare jobs
+type ResultsDiff struct {
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Changes   []ResultChange `json:"changes"`
+	Fixed     int            `json:"fixed"`     // incompatible -> compatible
+	Regressed int            `json:"regressed"` // compatible -> incompatible
+}
+
+// GetResultsDiff compares the results of two finished compare jobs, such as a
+// batch before and after fixing a QMD, and returns what changed per file and
+// hashtable
+func (h *APIHandler) GetResultsDiff(w http.ResponseWriter, r *http.Request) {
+	fromID := r.URL.Query().Get("from")
+	toID := r.URL.Query().Get("to")
+	if fromID == "" || toID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "from and to job IDs required",
+		})
+		return
+	}
+
+	fromStatuses, status, err := h.jobStatuses(fromID)
+	var toStatuses map[string]map[string]string
+	if err == nil {
+		toStatuses, status, err = h.jobStatuses(toID)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(diffResults(fromID, toID, fromStatuses, toStatuses))
+}
+
+// jobStatuses returns a finished compare job's statuses by file, then
+// hashtable, or an error with the HTTP status to report it with
+func (h *APIHandler) jobStatuses(jobID string) (map[string]map[string]string, int, error) {
+	job, ok := h.jobStore.Get(jobID)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("job %s not found", jobID)
+	}
+	if !jobHasResults(job) {
+		return nil, http.StatusConflict, fmt.Errorf("job %s has no results yet (status: %s)", jobID, job.Status)
+	}
+
+	var responses map[string]CompareResponse
+	switch results := job.Results.(type) {
+	case CompareResponse:
+		// Single-file jobs don't record the filename
+		responses = map[string]CompareResponse{"": results}
+	case map[string]CompareResponse:
+		responses = results
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("job %s is not a compare job", jobID)
+	}
+
+	statuses := make(map[string]map[string]string, len(responses))
+	for file, response := range responses {
+		byHashtable := make(map[string]string, len(response.Compatible)+len(response.Incompatible))
+		for _, result := range response.Compatible {
+			byHashtable[result.Hashtable] = diffCompatible
+		}
+		for _, result := range response.Incompatible {
+			byHashtable[result.Hashtable] = diffIncompatible
+		}
+		statuses[file] = byHashtable
+	}
+	return statuses, http.StatusOK, nil
+}
+
+// jobHasResults reports whether a job finished with results, including the
+// partial results of a timed-out job
+func jobHasResults(job *jobs.Job) bool {
+	return job.Results != nil && (job.Status == "success" || job.Status == "timeout")
+}
+
+// diffResults lists every file and hashtable whose status differs between two jobs
+func diffResults(fromID, toID string, from, to map[string]map[string]string) ResultsDiff {
+	diff := ResultsDiff{From: fromID, To: toID, Changes: make([]ResultChange, 0)}
+
+	files := make(map[string]bool)
+	for file := range from {
+		files[file] = true
+	}
+	for file := range to {
+		files[file] = true
+	}
+
+	for file := range files {
+		hashtables := make(map[string]bool)
+		for hashtable := range from[file] {
+			hashtables[hashtable] = true
+		}
+		for hashtable := range to[file] {
+			hashtables[hashtable] = true
+		}
+
+		for hashtable := range hashtables {
+			before, after := from[file][hashtable], to[file][hashtable]
+			if before == "" {
+				before = diffAbsent
+			}
+			if after == "" {
+				after = diffAbsent
+			}
+			if before == after {
+				continue
+			}
+
+			diff.Changes = append(diff.Changes, ResultChange{File: file, Hashtable: hashtable, From: before, To: after})
+			if before == diffIncompatible && after == diffCompatible {
+				diff.Fixed++
+			} else if before == diffCompatible && after == diffIncompatible {
+				diff.Regressed++
+			}
+		}
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].File != diff.Changes[j].File {
+			return diff.Changes[i].File < diff.Changes[j].File
+		}
+		return diff.Changes[i].Hashtable < diff.Changes[j].Hashtable
+	})
+	return diff
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+)
+
+func TestGetResultsDiff(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, nil, nil, store, nil, 1, DefaultSettings())
+
+	result := func(hashtable string) qmldiff.TreeComparisonResult {
+		return qmldiff.TreeComparisonResult{Hashtable: hashtable}
+	}
+	store.Create("before")
+	store.SetResults("before", map[string]CompareResponse{
+		"a.qmd": {
+			Compatible:   []qmldiff.TreeComparisonResult{result("3.22-rmpp")},
+			Incompatible: []qmldiff.TreeComparisonResult{result("3.20-rm2")},
+		},
+		"b.qmd": {Compatible: []qmldiff.TreeComparisonResult{result("3.22-rmpp")}},
+	})
+	store.Update("before", "success", "Batch validation complete", nil)
+
+	store.Create("after")
+	store.SetResults("after", map[string]CompareResponse{
+		"a.qmd": {Compatible: []qmldiff.TreeComparisonResult{result("3.22-rmpp"), result("3.20-rm2")}},
+		"b.qmd": {Incompatible: []qmldiff.TreeComparisonResult{result("3.22-rmpp")}},
+		"c.qmd": {Compatible: []qmldiff.TreeComparisonResult{result("3.22-rmpp")}},
+	})
+	store.Update("after", "success", "Batch validation complete", nil)
+
+	store.Create("running")
+
+	tests := []struct {
+		query      string
+		wantStatus int
+	}{
+		{"?from=before&to=after", http.StatusOK},
+		{"?from=before", http.StatusBadRequest},
+		{"?from=before&to=missing", http.StatusNotFound},
+		{"?from=running&to=after", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.GetResultsDiff(rec, httptest.NewRequest(http.MethodGet, "/api/results/diff"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var diff ResultsDiff
+			if err := json.NewDecoder(rec.Body).Decode(&diff); err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			want := []ResultChange{
+				{File: "a.qmd", Hashtable: "3.20-rm2", From: "incompatible", To: "compatible"},
+				{File: "b.qmd", Hashtable: "3.22-rmpp", From: "compatible", To: "incompatible"},
+				{File: "c.qmd", Hashtable: "3.22-rmpp", From: "absent", To: "compatible"},
+			}
+			if !reflect.DeepEqual(diff.Changes, want) {
+				t.Errorf("changes = %+v, want %+v", diff.Changes, want)
+			}
+			if diff.Fixed != 1 || diff.Regressed != 1 {
+				t.Errorf("fixed = %d, regressed = %d, want 1 and 1", diff.Fixed, diff.Regressed)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import "time"
+
+// Settings are handler options resolved once at startup, so every request
+// and job sees the same values
+type Settings struct {
+	DeviceConcurrency        map[string]int // Per-device validation limits from CONCURRENCY_<device>; other devices use the global limit
+	ResultFlushInterval      time.Duration  // How often running jobs save partial results, 0 to never
+	MaxFlattenedDependencies int            // Max LOADed-file entries in a batch response, 0 for no limit
+	CallbackAllowedHosts     []string       // Hosts, globs allowed, that job callbacks may be sent to; none refuses callbacks
+	CallbackTimeout          time.Duration  // Timeout for each callback attempt
+	CallbackRetries          int            // Retries after a failed callback
+	AllowedModes             []string       // Compare modes offered, "tree" and/or "hash"
+	AdminToken               string         // Bearer token for admin-only options; none refuses them
+	QMLDiffBinaries          []string       // Alternate qmldiff binaries admins may pick per request
+	MaxZipExtractedMB        int            // Max total size of the files in an uploaded zip archive
+	MaxZipEntries            int            // Max entries in an uploaded zip archive, 0 for no limit
+	MaxJobDuration           time.Duration  // How long a compare job may run before it stops with partial results
+	MultipartMemory          int64          // Bytes of a multipart upload held in memory before spooling to disk
+	ReadOnly                 bool           // Catalogs are fixed at startup; on-request reloads are skipped
+}
+
+// DefaultSettings returns the settings used when nothing is configured
+func DefaultSettings() Settings {
+	return Settings{
+		ResultFlushInterval:      30 * time.Second,
+		MaxFlattenedDependencies: 1000,
+		CallbackTimeout:          10 * time.Second,
+		CallbackRetries:          3,
+		AllowedModes:             []string{"tree", "hash"},
+		MaxZipExtractedMB:        100,
+		MaxZipEntries:            1000,
+		MaxJobDuration:           10 * time.Minute,
+		MultipartMemory:          10 << 20,
+	}
+}
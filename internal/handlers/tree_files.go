@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListTreeFiles returns the sorted relative paths of the .qml files in a
+// tree, optionally only those under ?prefix=
+func (h *APIHandler) ListTreeFiles(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	tree, ok := h.treeService.GetTreeByName(name)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Tree not found: " + name,
+		})
+		return
+	}
+
+	prefix := strings.TrimPrefix(r.URL.Query().Get("prefix"), "/")
+	files := make([]string, 0)
+	for _, file := range tree.Files() {
+		if strings.HasPrefix(file, prefix) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":       tree.Name,
+		"file_count": tree.FileCount,
+		"files":      files,
+		"count":      len(files),
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+)
+
+// CreateUpload starts an upload session so a large batch can be sent across
+// several requests and validated once complete
+func (h *APIHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	session, err := h.uploadStore.Create()
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to create upload session: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to create upload session",
+		})
+		return
+	}
+
+	logging.Info(logging.ComponentHandler, "Created upload session %s", session.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upload_id":       session.ID,
+		"expires_in_secs": int(h.uploadStore.TTL().Seconds()),
+	})
+}
+
+// AddUploadFiles saves a chunk of files into an upload session
+// Takes the same "files" and "paths" fields as /api/compare. Resending a path
+// replaces the earlier copy, so a failed chunk can simply be retried.
+func (h *APIHandler) AddUploadFiles(w http.ResponseWriter, r *http.Request) {
+	uploadID := chi.URLParam(r, "uploadId")
+
+	err := r.ParseMultipartForm(h.settings.MultipartMemory)
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to parse multipart form: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to parse form data",
+		})
+		return
+	}
+
+	fileHeaders := r.MultipartForm.File["files"]
+	filePaths := r.MultipartForm.Value["paths"]
+	if len(fileHeaders) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "No files uploaded",
+		})
+		return
+	}
+	if len(filePaths) > 0 && len(filePaths) != len(fileHeaders) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("Number of paths (%d) does not match number of files (%d)", len(filePaths), len(fileHeaders)),
+		})
+		return
+	}
+
+	// Held until the files are recorded, so validation can't start on a
+	// half-written chunk
+	session, ok := h.uploadStore.Acquire(uploadID)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Upload session not found or expired",
+		})
+		return
+	}
+	defer h.uploadStore.Release(session)
+
+	qmdPaths, filenames, excluded, ok := saveUploadedFiles(w, session.Dir, fileHeaders, filePaths)
+	if !ok {
+		return
+	}
+	total := session.AddFiles(qmdPaths, filenames, excluded)
+
+	logging.Debug(logging.ComponentHandler, "Upload session %s: received %d files, %d total", uploadID, len(fileHeaders), total)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upload_id": uploadID,
+		"received":  len(fileHeaders),
+		"total":     total,
+	})
+}
+
+// ValidateUpload closes an upload session and validates its files as one
+// batch, exactly as if they had been sent to /api/compare together
+func (h *APIHandler) ValidateUpload(w http.ResponseWriter, r *http.Request) {
+	uploadID := chi.URLParam(r, "uploadId")
+
+	session, ok := h.uploadStore.Take(uploadID)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Upload session not found or expired",
+		})
+		return
+	}
+
+	filenames := session.Filenames()
+	qmdPaths := make([]string, len(filenames))
+	for i, name := range filenames {
+		qmdPaths[i] = session.Files[name]
+	}
+
+	logging.Info(logging.ComponentHandler, "Validating upload session %s with %d files", uploadID, len(filenames))
+	h.startCompareJob(w, r, session.Dir, qmdPaths, filenames, session.Excluded)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/uploads"
+)
+
+func withUploadID(req *http.Request, id string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("uploadId", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestUploadSessionChunks(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), uploads.NewStore(time.Hour), 1, DefaultSettings())
+
+	rec := httptest.NewRecorder()
+	h.CreateUpload(rec, httptest.NewRequest(http.MethodPost, "/api/uploads", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var created map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	uploadID, _ := created["upload_id"].(string)
+	if uploadID == "" {
+		t.Fatalf("upload_id missing from %v", created)
+	}
+
+	chunks := []struct {
+		files map[string]string
+		paths []string
+		total int
+	}{
+		{map[string]string{"a.qmd": "AFFECT [[1]]\n"}, []string{"mods/a.qmd"}, 1},
+		{map[string]string{"b.qmd": "AFFECT [[2]]\n"}, []string{"mods/b.qmd"}, 2},
+		// Retrying a chunk replaces the file rather than adding it twice
+		{map[string]string{"b.qmd": "AFFECT [[2]]\n"}, []string{"mods/b.qmd"}, 2},
+	}
+	for i, chunk := range chunks {
+		rec := httptest.NewRecorder()
+		h.AddUploadFiles(rec, withUploadID(newMultipartRequest(t, chunk.files, chunk.paths), uploadID))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk %d status = %d, want %d: %s", i, rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("Decode() failed: %v", err)
+		}
+		if got := int(resp["total"].(float64)); got != chunk.total {
+			t.Errorf("chunk %d total = %d, want %d", i, got, chunk.total)
+		}
+	}
+
+	session, ok := h.uploadStore.Take(uploadID)
+	if !ok {
+		t.Fatal("session missing after uploads")
+	}
+	defer os.RemoveAll(session.Dir)
+	if got := session.Filenames(); len(got) != 2 || got[0] != "mods/a.qmd" || got[1] != "mods/b.qmd" {
+		t.Errorf("Filenames() = %v, want [mods/a.qmd mods/b.qmd]", got)
+	}
+}
+
+func TestUploadSessionNotFound(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), uploads.NewStore(time.Hour), 1, DefaultSettings())
+
+	rec := httptest.NewRecorder()
+	req := newMultipartRequest(t, map[string]string{"a.qmd": "AFFECT [[1]]\n"}, nil)
+	h.AddUploadFiles(rec, withUploadID(req, "missing"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("add files status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ValidateUpload(rec, withUploadID(httptest.NewRequest(http.MethodPost, "/", nil), "missing"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("validate status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestValidateUploadConsumesSession(t *testing.T) {
+	store := uploads.NewStore(time.Hour)
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), store, 1, DefaultSettings())
+
+	session, err := store.Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	// An empty session is rejected, and the session cannot be validated again
+	rec := httptest.NewRecorder()
+	h.ValidateUpload(rec, withUploadID(httptest.NewRequest(http.MethodPost, "/", nil), session.ID))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("validate status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ValidateUpload(rec, withUploadID(httptest.NewRequest(http.MethodPost, "/", nil), session.ID))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second validate status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
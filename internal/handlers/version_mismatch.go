@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+// findTree finds the QML tree to validate a hashtable against.
+// Trees are matched on the hashtable's version and device. When the version
+// embedded in the hashtable disagrees with its filename, no tree may match;
+// the tree named like the hashtable is used instead and the returned message
+// explains the mismatch. Returns nil if no tree fits either way.
+func findTree(ht *hashtab.Hashtab, trees []*qmltree.Tree) (*qmltree.Tree, string) {
+	for _, tree := range trees {
+		if tree.OSVersion == ht.OSVersion && tree.Device == ht.Device {
+			return tree, ""
+		}
+	}
+
+	if ht.EmbeddedVersion == "" {
+		return nil, ""
+	}
+	for _, tree := range trees {
+		if strings.EqualFold(tree.Name, ht.Name) {
+			return tree, fmt.Sprintf("version mismatch: hashtable %s contains version %s but tree %s is version %s",
+				ht.Name, ht.EmbeddedVersion, tree.Name, tree.OSVersion)
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+// VersionSetHash is a hash reference missing from some of a set of hashtables
+type VersionSetHash struct {
+	qmldiff.MissingHashInfo
+	MissingIn []string `json:"missing_in"`
+}
+
+// CheckVersionSet checks a QMD file's hashes against a chosen set of
+// hashtables at once. Hashes missing from the union exist in none of them;
+// hashes missing from the intersection are absent from at least one.
+// Only hash presence is checked, so no trees are needed.
+func (h *APIHandler) CheckVersionSet(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(h.settings.MultipartMemory)
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to parse multipart form: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to parse form data",
+		})
+		return
+	}
+
+	var names []string
+	if values := r.MultipartForm.Value["versions"]; len(values) == 0 || json.Unmarshal([]byte(values[0]), &names) != nil || len(names) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "versions must be a JSON array of hashtable names, e.g. [\"3.21.0.79-rm2\", \"3.22.0.64-rm2\"]",
+		})
+		return
+	}
+
+	hashtables := make([]*hashtab.Hashtab, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	var unknown []string
+	for _, name := range names {
+		ht := h.hashtabService.GetHashtable(name)
+		if ht == nil {
+			unknown = append(unknown, name)
+			continue
+		}
+		if !seen[strings.ToLower(ht.Name)] {
+			seen[strings.ToLower(ht.Name)] = true
+			hashtables = append(hashtables, ht)
+		}
+	}
+	if len(unknown) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":            "Unknown hashtables: " + strings.Join(unknown, ", "),
+			"unknown_versions": unknown,
+		})
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "No file uploaded or invalid form data",
+		})
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to read uploaded file: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to read uploaded file",
+		})
+		return
+	}
+
+	union, intersection := versionSetGaps(qmd.ExtractHashPositions(string(content)), hashtables)
+	checked := make([]string, len(hashtables))
+	for i, ht := range hashtables {
+		checked[i] = ht.Name
+	}
+
+	logging.Info(logging.ComponentHandler, "Checked %s against %d hashtable(s): %d hash(es) missing from all, %d from some",
+		header.Filename, len(hashtables), len(union), len(intersection))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"filename":                  header.Filename,
+		"versions":                  checked,
+		"missing_from_union":        union,
+		"missing_from_intersection": intersection,
+		"compatible_with_any":       len(union) == 0,
+		"compatible_with_all":       len(intersection) == 0,
+	})
+}
+
+// versionSetGaps returns the hashes no hashtable has, missing from their
+// union, and the hashes at least one lacks, missing from their intersection
+func versionSetGaps(positions []qmd.HashWithPosition, hashtables []*hashtab.Hashtab) (union, intersection []VersionSetHash) {
+	union = make([]VersionSetHash, 0)
+	intersection = make([]VersionSetHash, 0)
+	for _, position := range positions {
+		var missingIn []string
+		for _, ht := range hashtables {
+			if _, ok := ht.Entries[position.Hash]; !ok {
+				missingIn = append(missingIn, ht.Name)
+			}
+		}
+		if len(missingIn) == 0 {
+			continue
+		}
+
+		missing := VersionSetHash{
+			MissingHashInfo: qmldiff.MissingHashInfo{
+				Hash:        strconv.FormatUint(position.Hash, 10),
+				Line:        position.Line,
+				Column:      position.Column,
+				Offset:      position.Offset,
+				Approximate: position.Approximate,
+			},
+			MissingIn: missingIn,
+		}
+		intersection = append(intersection, missing)
+		if len(missingIn) == len(hashtables) {
+			union = append(union, missing)
+		}
+	}
+	return union, intersection
+}
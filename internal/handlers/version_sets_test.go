@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+)
+
+func TestCheckVersionSet(t *testing.T) {
+	hashtabDir := t.TempDir()
+	width, height, radius := hashtab.DJB2Hash("width"), hashtab.DJB2Hash("height"), hashtab.DJB2Hash("radius")
+	tables := map[string]map[uint64]string{
+		"3.21.0.79-rm2": {width: "width"},
+		"3.22.0.64-rm2": {width: "width", height: "height"},
+	}
+	for name, entries := range tables {
+		if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	h := NewAPIHandler(nil, hashtabService, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	check := func(versions string) (int, map[string]interface{}) {
+		t.Helper()
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", "mod.qmd")
+		if err != nil {
+			t.Fatalf("CreateFormFile() failed: %v", err)
+		}
+		part.Write([]byte("AFFECT [[" + strconv.FormatUint(width, 10) + "]]\n" +
+			"AFFECT [[" + strconv.FormatUint(height, 10) + "]]\n" +
+			"AFFECT [[" + strconv.FormatUint(radius, 10) + "]]\n"))
+		writer.WriteField("versions", versions)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/check-version-set", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		h.CheckVersionSet(rec, req)
+
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	code, resp := check(`["3.21.0.79-rm2", "3.22.0.64-rm2"]`)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", code, http.StatusOK, resp)
+	}
+	missing := func(key string) map[string]interface{} {
+		got := make(map[string]interface{})
+		for _, entry := range resp[key].([]interface{}) {
+			entry := entry.(map[string]interface{})
+			got[entry["hash"].(string)] = entry["missing_in"]
+		}
+		return got
+	}
+	both := []interface{}{"3.21.0.79-rm2", "3.22.0.64-rm2"}
+	if got, want := missing("missing_from_union"), map[string]interface{}{strconv.FormatUint(radius, 10): both}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing_from_union = %v, want %v", got, want)
+	}
+	wantIntersection := map[string]interface{}{
+		strconv.FormatUint(height, 10): []interface{}{"3.21.0.79-rm2"},
+		strconv.FormatUint(radius, 10): both,
+	}
+	if got := missing("missing_from_intersection"); !reflect.DeepEqual(got, wantIntersection) {
+		t.Errorf("missing_from_intersection = %v, want %v", got, wantIntersection)
+	}
+	if resp["compatible_with_any"] != false || resp["compatible_with_all"] != false {
+		t.Errorf("compatible_with_any = %v, compatible_with_all = %v, want false and false", resp["compatible_with_any"], resp["compatible_with_all"])
+	}
+
+	for _, versions := range []string{``, `[]`, `"3.21.0.79-rm2"`, `["3.21.0.79-rm2", "3.99.0.0-rm2"]`} {
+		if code, resp := check(versions); code != http.StatusBadRequest {
+			t.Errorf("versions %q: status = %d, want %d: %v", versions, code, http.StatusBadRequest, resp)
+		}
+	}
+}
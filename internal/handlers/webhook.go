@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+)
+
+// callbackBackoff is the wait before the first callback retry; it doubles after each attempt
+var callbackBackoff = time.Second
+
+// errCallbackRedirect stops a callback that is redirected off the allowlist
+var errCallbackRedirect = errors.New("callback redirected to a host that is not allowed")
+
+// callbackURL returns the optional URL to POST a job's results to once it
+// finishes, taken from the X-Callback-URL header or else the "callback_url"
+// form field. The host must match Settings.CallbackAllowedHosts, so callbacks
+// are refused until hosts are configured.
+func (h *APIHandler) callbackURL(r *http.Request) (string, error) {
+	raw := r.Header.Get("X-Callback-URL")
+	if raw == "" && r.MultipartForm != nil {
+		if values := r.MultipartForm.Value["callback_url"]; len(values) > 0 {
+			raw = values[0]
+		}
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+
+	if !h.callbackHostAllowed(u) {
+		return "", fmt.Errorf("callback_url host %s is not allowed", strings.ToLower(u.Hostname()))
+	}
+	return u.String(), nil
+}
+
+// callbackHostAllowed reports whether u's host matches an allowed callback host
+func (h *APIHandler) callbackHostAllowed(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range h.settings.CallbackAllowedHosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sendCallback POSTs a finished job's status and results to callbackURL,
+// retrying network errors and 5xx responses with exponential backoff.
+// Redirects are only followed to allowed hosts, since 307 and 308 resend the
+// results.
+func (h *APIHandler) sendCallback(callbackURL, jobID string) {
+	job, ok := h.jobStore.Get(jobID)
+	if !ok {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"jobId":   jobID,
+		"status":  job.Status,
+		"message": job.Message,
+	}
+	if job.Label != "" {
+		payload["label"] = job.Label
+	}
+	if job.Results != nil {
+		payload["results"] = job.Results
+	}
+	if job.Summary != nil {
+		payload["error_summary"] = job.Summary
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to encode callback for job %s: %v", jobID, err)
+		return
+	}
+
+	client := &http.Client{
+		Timeout: h.settings.CallbackTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if (req.URL.Scheme != "http" && req.URL.Scheme != "https") || !h.callbackHostAllowed(req.URL) {
+				return fmt.Errorf("%w: %s", errCallbackRedirect, req.URL.Redacted())
+			}
+			return nil
+		},
+	}
+	attempts := h.settings.CallbackRetries + 1
+	backoff := callbackBackoff
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		retry, err := postCallback(client, callbackURL, body)
+		if err == nil {
+			logging.Info(logging.ComponentHandler, "Delivered callback for job %s to %s", jobID, callbackURL)
+			return
+		}
+		if !retry || attempt == attempts {
+			logging.Warn(logging.ComponentHandler, "Giving up on callback for job %s after %d attempt(s): %v", jobID, attempt, err)
+			return
+		}
+		logging.Warn(logging.ComponentHandler, "Callback for job %s failed (attempt %d/%d), retrying in %s: %v",
+			jobID, attempt, attempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postCallback makes one callback attempt, reporting whether a failure is worth retrying
+func postCallback(client *http.Client, callbackURL string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return !errors.Is(err, errCallbackRedirect), err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return true, fmt.Errorf("callback returned %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return false, nil
+}
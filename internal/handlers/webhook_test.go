@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+)
+
+func TestCallbackURL(t *testing.T) {
+	settings := DefaultSettings()
+	settings.CallbackAllowedHosts = []string{"ci.example.com", "*.builds.example.com"}
+	h := NewAPIHandler(nil, nil, nil, nil, nil, 1, settings)
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"", false},
+		{"https://ci.example.com/hooks/qmd", false},
+		{"http://runner-1.builds.example.com:8080/done", false},
+		{"https://evil.example.net/hooks", true},
+		{"ftp://ci.example.com/hooks", true},
+		{"/relative/path", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/compare", nil)
+		req.Header.Set("X-Callback-URL", tt.url)
+		_, err := h.callbackURL(req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("callbackURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+
+	// Without an allowlist every callback is refused
+	h = NewAPIHandler(nil, nil, nil, nil, nil, 1, DefaultSettings())
+	req := httptest.NewRequest(http.MethodPost, "/api/compare", nil)
+	req.Header.Set("X-Callback-URL", "https://ci.example.com/hooks/qmd")
+	if _, err := h.callbackURL(req); err == nil {
+		t.Error("callbackURL() accepted a URL with no allowed hosts configured")
+	}
+}
+
+func TestSendCallbackRetries(t *testing.T) {
+	defer func(backoff time.Duration) { callbackBackoff = backoff }(callbackBackoff)
+	callbackBackoff = time.Millisecond
+
+	var attempts atomic.Int32
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	jobStore := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, nil, nil, jobStore, nil, 1, DefaultSettings())
+	jobStore.Create("job-1")
+	jobStore.SetLabel("job-1", "ci-run-42")
+	jobStore.SetResults("job-1", CompareResponse{TotalChecked: 3, Mode: "tree"})
+	jobStore.Update("job-1", "success", "Validation complete", nil)
+
+	h.sendCallback(server.URL, "job-1")
+
+	if got := attempts.Load(); got != 2 {
+		t.Fatalf("callback attempts = %d, want 2", got)
+	}
+	if payload["jobId"] != "job-1" || payload["label"] != "ci-run-42" || payload["status"] != "success" {
+		t.Errorf("payload = %v, want jobId job-1, label ci-run-42, status success", payload)
+	}
+	if results, ok := payload["results"].(map[string]interface{}); !ok || results["total_checked"] != float64(3) {
+		t.Errorf("payload results = %v, want the job's results", payload["results"])
+	}
+}
+
+func TestSendCallbackRedirects(t *testing.T) {
+	defer func(backoff time.Duration) { callbackBackoff = backoff }(callbackBackoff)
+	callbackBackoff = time.Millisecond
+
+	var received atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	// Only "localhost" is allowed, so the target is reachable by that name but
+	// not as 127.0.0.1
+	var attempts atomic.Int32
+	redirectTo := ""
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Redirect(w, r, redirectTo, http.StatusTemporaryRedirect)
+	}))
+	defer redirector.Close()
+	redirectorURL, _ := url.Parse(redirector.URL)
+
+	settings := DefaultSettings()
+	settings.CallbackAllowedHosts = []string{"localhost"}
+	jobStore := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	h := NewAPIHandler(nil, nil, nil, jobStore, nil, 1, settings)
+	jobStore.Create("job-1")
+	jobStore.Update("job-1", "success", "Validation complete", nil)
+	callback := "http://localhost:" + redirectorURL.Port() + "/hook"
+
+	redirectTo = "http://127.0.0.1:" + targetURL.Port() + "/stolen"
+	h.sendCallback(callback, "job-1")
+	if got := received.Load(); got != 0 {
+		t.Errorf("redirect off the allowlist delivered %d callback(s), want 0", got)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("callback attempts = %d, want 1 with no retries", got)
+	}
+
+	redirectTo = "http://localhost:" + targetURL.Port() + "/moved"
+	h.sendCallback(callback, "job-1")
+	if got := received.Load(); got != 1 {
+		t.Errorf("redirect to an allowed host delivered %d callback(s), want 1", got)
+	}
+}
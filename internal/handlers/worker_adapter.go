@@ -2,28 +2,51 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
 	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
 )
 
+// errTreeUnavailable marks validations that failed because the QML tree
+// could not be fetched from remote storage
+var errTreeUnavailable = errors.New("QML tree unavailable")
+
 // validateAgainstAllTreesWithWorkers uses the qmldiff CLI binary to validate QMD files in parallel
+// supportedVersions optionally restricts each filename to the OS versions matching its patterns
+// selected optionally restricts validation to the hashtables with these lowercased names
+// priority optionally names files, placed first by prioritizeFiles, whose results are recorded first
 func (h *APIHandler) validateAgainstAllTreesWithWorkers(
 	ctx context.Context,
+	qmldiffService *qmldiff.Service,
 	qmdPaths []string,
 	filenames []string,
+	supportedVersions map[string][]string,
+	selected map[string]bool,
+	priority map[string]bool,
 	jobStore *jobs.Store,
 	jobID string,
 ) (map[string][]qmldiff.TreeComparisonResult, error) {
 
 	hashtables := h.hashtabService.GetHashtables()
+	if selected != nil {
+		filtered := make([]*hashtab.Hashtab, 0, len(selected))
+		for _, ht := range hashtables {
+			if selected[strings.ToLower(ht.Name)] {
+				filtered = append(filtered, ht)
+			}
+		}
+		hashtables = filtered
+	}
 	trees := h.treeService.GetTrees()
 
 	if len(hashtables) == 0 {
@@ -48,116 +71,218 @@ func (h *APIHandler) validateAgainstAllTreesWithWorkers(
 	// Semaphore to limit concurrent validations
 	semaphore := make(chan struct{}, h.maxConcurrentValidations)
 
+	// Per-device semaphores, for devices whose trees are expensive to validate
+	deviceSemaphores := make(map[string]chan struct{})
+
+	// Hashtables being validated, for the job's per-hashtable progress
+	inFlight := make(map[string]bool)
+	lastCompleted := ""
+
+	// reportProgress publishes the per-hashtable progress; the caller holds mu
+	reportProgress := func() {
+		if jobStore == nil {
+			return
+		}
+		names := make([]string, 0, len(inFlight))
+		for name := range inFlight {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		jobStore.SetHashtableProgress(jobID, jobs.HashtableProgress{
+			Completed:     completedComparisons,
+			Total:         totalComparisons,
+			InFlight:      names,
+			LastCompleted: lastCompleted,
+		})
+	}
+
+	// completeHashtable counts a hashtable as done, validated or skipped;
+	// the caller holds mu
+	completeHashtable := func(name string) {
+		completedComparisons++
+		delete(inFlight, name)
+		lastCompleted = name
+		reportProgress()
+	}
+
 	logging.Info(logging.ComponentHandler, "Starting parallel validation with max concurrency: %d", h.maxConcurrentValidations)
 
 	// Process each hashtable in parallel
 	for _, ht := range hashtables {
-		// Find matching tree
-		var matchingTree *qmltree.Tree
-		for i := range trees {
-			if trees[i].OSVersion == ht.OSVersion && trees[i].Device == ht.Device {
-				matchingTree = trees[i]
-				break
-			}
+		matchingTree, versionMismatch := findTree(ht, trees)
+		if versionMismatch != "" {
+			logging.Warn(logging.ComponentHandler, "%s", versionMismatch)
 		}
 
 		if matchingTree == nil {
 			logging.Warn(logging.ComponentHandler, "No tree found for hashtable %s (version %s, device %s), skipping", ht.Name, ht.OSVersion, ht.Device)
 			mu.Lock()
-			completedComparisons++
-			if jobStore != nil {
-				progress := int((float64(completedComparisons) / float64(totalComparisons)) * 100)
-				jobStore.UpdateProgress(jobID, progress)
+			completeHashtable(ht.Name)
+			mu.Unlock()
+			continue
+		}
+
+		htQmdPaths := make([]string, 0, len(qmdPaths))
+		htFilenames := make([]string, 0, len(filenames))
+		for i, filename := range filenames {
+			if qmd.SupportsVersion(supportedVersions[filename], ht.OSVersion) {
+				htQmdPaths = append(htQmdPaths, qmdPaths[i])
+				htFilenames = append(htFilenames, filename)
 			}
+		}
+
+		if len(htQmdPaths) == 0 {
+			logging.Debug(logging.ComponentHandler, "No files declare support for %s (version %s), skipping", ht.Name, ht.OSVersion)
+			mu.Lock()
+			completeHashtable(ht.Name)
 			mu.Unlock()
 			continue
 		}
 
+		// Without strings qmldiff can't apply diffs, so fall back to checking hashes
+		// Checked in the pool like any other hashtable, so large uploads don't
+		// hold up dispatching the rest
+		if ht.IsHashlist() {
+			logging.Warn(logging.ComponentHandler, "Hashtable %s is a hashlist, validating by hash presence only", ht.Name)
+			wg.Add(1)
+			go func(ht *hashtab.Hashtab, device string, versionMismatch string, qmdPaths []string, filenames []string) {
+				defer wg.Done()
+
+				select {
+				case semaphore <- struct{}{}:
+					defer func() { <-semaphore }()
+				case <-ctx.Done():
+					return
+				}
+
+				mu.Lock()
+				inFlight[ht.Name] = true
+				reportProgress()
+				mu.Unlock()
+
+				hashlistResults := validateAgainstHashlist(ht, device, versionMismatch, qmdPaths)
+
+				mu.Lock()
+				var delta ResultDelta
+				if jobStore != nil {
+					delta = ResultDelta{Hashtable: ht.Name, Results: make(map[string]qmldiff.TreeComparisonResult, len(filenames))}
+				}
+				for i, filename := range filenames {
+					resultsMap[filename] = append(resultsMap[filename], hashlistResults[i])
+					if jobStore != nil {
+						delta.Results[filename] = hashlistResults[i]
+					}
+				}
+				completeHashtable(ht.Name)
+				mu.Unlock()
+
+				if jobStore != nil {
+					jobStore.PublishResult(jobID, delta)
+				}
+			}(ht, matchingTree.Device, versionMismatch, htQmdPaths, htFilenames)
+			continue
+		}
+
+		deviceSemaphore, ok := deviceSemaphores[matchingTree.Device]
+		if !ok {
+			limit := h.deviceConcurrencyLimit(matchingTree.Device)
+			deviceSemaphore = make(chan struct{}, limit)
+			deviceSemaphores[matchingTree.Device] = deviceSemaphore
+			if limit != h.maxConcurrentValidations {
+				logging.Info(logging.ComponentHandler, "Using device concurrency limit for %s: %d", matchingTree.Device, limit)
+			}
+		}
+
 		wg.Add(1)
-		go func(htName string, htPath string, htOSVersion string, htDevice string, tree *qmltree.Tree) {
+		go func(htName string, htPath string, htOSVersion string, htDevice string, tree *qmltree.Tree, versionMismatch string, deviceSemaphore chan struct{}, qmdPaths []string, filenames []string) {
 			defer wg.Done()
 
+			// Acquire device slot before the global slot so waiting on a
+			// busy device doesn't hold up other devices
+			select {
+			case deviceSemaphore <- struct{}{}:
+				defer func() { <-deviceSemaphore }()
+			case <-ctx.Done():
+				return
+			}
+
 			// Acquire semaphore slot
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				return
+			}
+
+			if ctx.Err() != nil {
+				logging.Warn(logging.ComponentHandler, "Skipping %s: %v", htName, ctx.Err())
+				return
+			}
 
 			logging.Info(logging.ComponentHandler, "Validating %d file(s) against hashtable %s and tree %s",
 				len(qmdPaths), htName, tree.Name)
 
-			// Call qmldiff service directly with CLI binary
-			batchResult, err := h.qmldiffService.ValidateMultipleAgainstTreeSequential(
-				qmdPaths,
-				htPath,
-				tree.Path,
-			)
-
 			mu.Lock()
-			defer mu.Unlock()
+			inFlight[htName] = true
+			reportProgress()
+			mu.Unlock()
 
-			logging.Debug(logging.ComponentHandler, "Validation returned for %s: err=%v, hasResults=%v, resultCount=%d",
-				htName, err != nil, batchResult != nil && len(batchResult.Results) > 0,
-				func() int { if batchResult != nil { return len(batchResult.Results) }; return 0 }())
+			// Trees from remote storage are downloaded on first use
+			treePath, materializeErr := h.treeService.Materialize(ctx, tree)
+			if materializeErr != nil {
+				materializeErr = fmt.Errorf("%w: %v", errTreeUnavailable, materializeErr)
+			}
 
-			if err != nil {
-				logging.Error(logging.ComponentHandler, "Validation failed for %s/%s: %v", htName, tree.Name, err)
+			// Priority files lead the list; validate and record them first so
+			// partial results have them before the rest of the batch is done
+			allPaths, allFilenames := qmdPaths, filenames
+			for _, batch := range priorityBatches(allFilenames, priority) {
+				qmdPaths, filenames := allPaths[batch[0]:batch[1]], allFilenames[batch[0]:batch[1]]
+
+				var batchResult *qmldiff.BatchTreeValidationResult
+				err := materializeErr
+				if err == nil {
+					// Call qmldiff service directly with CLI binary
+					batchResult, err = qmldiffService.ValidateMultipleAgainstTreeSequential(
+						ctx,
+						qmdPaths,
+						htPath,
+						treePath,
+					)
+				}
 
-				// Add error results for all files
-				logging.Debug(logging.ComponentHandler, "Taking error path for %s, adding %d file results", htName, len(filenames))
-				for i, filename := range filenames {
-					errorDetail := "QML application failed"
-					if !strings.Contains(err.Error(), "panicked") {
-					errorDetail = "QML failed to apply"
-					}
+				mu.Lock()
 
-					var depResults map[string]*qmd.ValidationResult
-					if batchResult != nil && len(qmdPaths) > i {
-						qmdPath := qmdPaths[i]
-						if treeResult, hasResult := batchResult.Results[qmdPath]; hasResult {
-							depResults = treeResult.DependencyResults
-							logging.Debug(logging.ComponentHandler, "  File %s: Found %d dependencies in error results", filename, len(depResults))
-						}
-					}
+				logging.Debug(logging.ComponentHandler, "Validation returned for %s: err=%v, hasResults=%v, resultCount=%d",
+					htName, err != nil, batchResult != nil && len(batchResult.Results) > 0,
+					func() int { if batchResult != nil { return len(batchResult.Results) }; return 0 }())
 
-					resultsMap[filename] = append(resultsMap[filename], qmldiff.TreeComparisonResult{
-						Hashtable:          htName,
-						OSVersion:          htOSVersion,
-						Device:             tree.Device,
-						Compatible:         false,
-						ErrorDetail:        errorDetail,
-						DependencyResults:  depResults,
-						ValidationMode:     "tree",
-						TreeValidationUsed: true,
-					})
-				}
-			} else {
-				// Process results for each file
-				logging.Debug(logging.ComponentHandler, "Taking success path for %s, processing %d files", htName, len(qmdPaths))
+				if err != nil {
+					logging.Error(logging.ComponentHandler, "Validation failed for %s/%s: %v", htName, tree.Name, err)
 
-				resultKeys := make([]string, 0, len(batchResult.Results))
-				for key := range batchResult.Results {
-					resultKeys = append(resultKeys, key)
-				}
-				logging.Debug(logging.ComponentHandler, "  batchResult.Results keys: %v", resultKeys)
-
-				for i, qmdPath := range qmdPaths {
-					filename := filenames[i]
-					logging.Debug(logging.ComponentHandler, "  Looking for qmdPath='%s' in results", qmdPath)
-
-					// Check if this file had an error
-					if fileErr, hasError := batchResult.Errors[qmdPath]; hasError {
-						logging.Debug(logging.ComponentHandler, "  File %s: Has file-level error", filename)
-						errorDetail := "QML application failed"
-						if !strings.Contains(fileErr.Error(), "panicked") {
-						errorDetail = "QML failed to apply"
+					// Add error results for all files
+					logging.Debug(logging.ComponentHandler, "Taking error path for %s, adding %d file results", htName, len(filenames))
+					for i, filename := range filenames {
+						errorDetail := detailPanicked
+						if !strings.Contains(err.Error(), "panicked") {
+						errorDetail = detailFailedToApply
+						}
+						if errors.Is(err, errTreeUnavailable) {
+							errorDetail = "QML tree unavailable"
 						}
 
 						var depResults map[string]*qmd.ValidationResult
-						if treeResult, hasResult := batchResult.Results[qmdPath]; hasResult {
-							depResults = treeResult.DependencyResults
+						if batchResult != nil && len(qmdPaths) > i {
+							qmdPath := qmdPaths[i]
+							if treeResult, hasResult := batchResult.Results[qmdPath]; hasResult {
+								depResults = treeResult.DependencyResults
+								logging.Debug(logging.ComponentHandler, "  File %s: Found %d dependencies in error results", filename, len(depResults))
+							}
 						}
 
 						resultsMap[filename] = append(resultsMap[filename], qmldiff.TreeComparisonResult{
 							Hashtable:          htName,
+							VersionMismatch:    versionMismatch,
 							OSVersion:          htOSVersion,
 							Device:             tree.Device,
 							Compatible:         false,
@@ -165,93 +290,283 @@ func (h *APIHandler) validateAgainstAllTreesWithWorkers(
 							DependencyResults:  depResults,
 							ValidationMode:     "tree",
 							TreeValidationUsed: true,
+							TreeName:           tree.Name,
+							TreeFileCount:      tree.FileCount,
 						})
-					} else if treeResult, hasResult := batchResult.Results[qmdPath]; hasResult {
-						compatible := treeResult.FilesWithErrors == 0 && !treeResult.HasHashErrors
-						logging.Debug(logging.ComponentHandler, "  File %s: Has result, compatible=%v, depCount=%d",
-							filename, compatible, len(treeResult.DependencyResults))
-						errorDetail := ""
-						var missingHashes []qmd.HashWithPosition
-
-						// Map failed hashes to positions in the QMD file
-						if len(treeResult.FailedHashes) > 0 {
-							qmdContents, err := os.ReadFile(qmdPath)
-							if err != nil {
-								logging.Error(logging.ComponentHandler, "Failed to read QMD file %s: %v", qmdPath, err)
-							} else {
-								qmdStr := string(qmdContents)
-								missingHashes = qmd.FindHashPositions(qmdStr, treeResult.FailedHashes)
-								errorDetail = fmt.Sprintf("missing %d hash(es)", len(missingHashes))
-								logging.Warn(logging.ComponentHandler, "Validation failed for %s on %s: %d missing hashes",
-									filename, htName, len(missingHashes))
+					}
+				} else {
+					// Process results for each file
+					logging.Debug(logging.ComponentHandler, "Taking success path for %s, processing %d files", htName, len(qmdPaths))
+
+					resultKeys := make([]string, 0, len(batchResult.Results))
+					for key := range batchResult.Results {
+						resultKeys = append(resultKeys, key)
+					}
+					logging.Debug(logging.ComponentHandler, "  batchResult.Results keys: %v", resultKeys)
+
+					for i, qmdPath := range qmdPaths {
+						filename := filenames[i]
+						logging.DebugSampled(logging.ComponentHandler, "handler.lookup_result", "  Looking for qmdPath='%s' in results", qmdPath)
+
+						// Check if this file had an error
+						if fileErr, hasError := batchResult.Errors[qmdPath]; hasError {
+							logging.Debug(logging.ComponentHandler, "  File %s: Has file-level error", filename)
+							errorDetail := detailPanicked
+							var timeoutErr *qmldiff.TimeoutError
+							if errors.As(fileErr, &timeoutErr) {
+								errorDetail = timeoutErr.Error()
+							} else if !strings.Contains(fileErr.Error(), "panicked") {
+							errorDetail = detailFailedToApply
 							}
-						} else if !compatible {
-						// Check if there are actual dependencies (files with Position != -1)
-						hasDependencies := false
-						for _, depResult := range treeResult.DependencyResults {
-							if depResult.Position != -1 {
-								hasDependencies = true
-								break
+
+							var depResults map[string]*qmd.ValidationResult
+							if treeResult, hasResult := batchResult.Results[qmdPath]; hasResult {
+								depResults = treeResult.DependencyResults
 							}
-						}
 
-						if hasDependencies {
-							// Errors are in dependency files
-							if treeResult.FilesWithErrors == 1 {
-								errorDetail = "1 dependency file has errors"
+							resultsMap[filename] = append(resultsMap[filename], qmldiff.TreeComparisonResult{
+								Hashtable:          htName,
+								VersionMismatch:    versionMismatch,
+								OSVersion:          htOSVersion,
+								Device:             tree.Device,
+								Compatible:         false,
+								ErrorDetail:        errorDetail,
+								DependencyResults:  depResults,
+								ValidationMode:     "tree",
+								TreeValidationUsed: true,
+								TreeName:           tree.Name,
+								TreeFileCount:      tree.FileCount,
+							})
+						} else if treeResult, hasResult := batchResult.Results[qmdPath]; hasResult {
+							compatible := treeResult.FilesWithErrors == 0 && !treeResult.HasHashErrors
+							logging.DebugSampled(logging.ComponentHandler, "handler.has_result", "  File %s: Has result, compatible=%v, depCount=%d",
+								filename, compatible, len(treeResult.DependencyResults))
+							errorDetail := ""
+							var missingHashes []qmd.HashWithPosition
+
+							// Map failed hashes to positions in the QMD file
+							if len(treeResult.FailedHashes) > 0 {
+								positions, err := qmd.FindHashPositionsInFile(qmdPath, treeResult.FailedHashes)
+								if err != nil {
+									logging.Error(logging.ComponentHandler, "Failed to read QMD file %s: %v", qmdPath, err)
+								} else {
+									missingHashes = positions
+									errorDetail = fmt.Sprintf("missing %d hash(es)", len(missingHashes))
+									logging.Warn(logging.ComponentHandler, "Validation failed for %s on %s: %d missing hashes",
+										filename, htName, len(missingHashes))
+								}
+							} else if !compatible {
+							// Check if there are actual dependencies (files with Position != -1)
+							hasDependencies := false
+							for _, depResult := range treeResult.DependencyResults {
+								if depResult.Position != -1 {
+									hasDependencies = true
+									break
+								}
+							}
+
+							if hasDependencies {
+								// Errors are in dependency files
+								if treeResult.FilesWithErrors == 1 {
+									errorDetail = "1 dependency file has errors"
+								} else {
+									errorDetail = fmt.Sprintf("%d dependency files have errors", treeResult.FilesWithErrors)
+								}
 							} else {
-								errorDetail = fmt.Sprintf("%d dependency files have errors", treeResult.FilesWithErrors)
+								// Single file with non-hash errors
+								errorDetail = detailFailedToApply
+							}
 							}
+
+							// Nothing failing can still mean nothing applied
+							if compatible && treeResult.VersionSkipped > 0 {
+								errorDetail = qmd.VersionSkippedMessage(treeResult.VersionSkipped)
+							}
+
+							resultsMap[filename] = append(resultsMap[filename], qmldiff.TreeComparisonResult{
+								Hashtable:          htName,
+								VersionMismatch:    versionMismatch,
+								OSVersion:          htOSVersion,
+								Device:             tree.Device,
+								Compatible:         compatible,
+								ErrorDetail:        errorDetail,
+								MissingHashes:      missingHashes,
+								DependencyResults:  treeResult.DependencyResults,
+								ValidationMode:     "tree",
+								TreeValidationUsed: true,
+								TreeName:           tree.Name,
+								TreeFileCount:      tree.FileCount,
+								FilesProcessed:     treeResult.FilesProcessed,
+								FilesModified:      treeResult.FilesModified,
+								FilesWithErrors:    treeResult.FilesWithErrors,
+								VersionSkipped:     treeResult.VersionSkipped,
+							})
+							logging.DebugSampled(logging.ComponentHandler, "handler.added_result", "  Added result to resultsMap[%s]: %s (compatible=%v, depCount=%d)",
+								filename, htName, compatible, len(treeResult.DependencyResults))
 						} else {
-							// Single file with non-hash errors
-							errorDetail = "QML failed to apply"
-						}
+							// No result or error - this shouldn't happen
+							logging.Warn(logging.ComponentHandler, "  File %s: No result or error received from validation!", filename)
+							resultsMap[filename] = append(resultsMap[filename], qmldiff.TreeComparisonResult{
+								Hashtable:          htName,
+								VersionMismatch:    versionMismatch,
+								OSVersion:          htOSVersion,
+								Device:             tree.Device,
+								Compatible:         false,
+								ErrorDetail:        "no validation result received",
+								ValidationMode:     "tree",
+								TreeValidationUsed: true,
+								TreeName:           tree.Name,
+								TreeFileCount:      tree.FileCount,
+							})
 						}
+					}
+				}
 
-						resultsMap[filename] = append(resultsMap[filename], qmldiff.TreeComparisonResult{
-							Hashtable:          htName,
-							OSVersion:          htOSVersion,
-							Device:             tree.Device,
-							Compatible:         compatible,
-							ErrorDetail:        errorDetail,
-							MissingHashes:      missingHashes,
-							DependencyResults:  treeResult.DependencyResults,
-							ValidationMode:     "tree",
-							TreeValidationUsed: true,
-							FilesProcessed:     treeResult.FilesProcessed,
-							FilesModified:      treeResult.FilesModified,
-							FilesWithErrors:    treeResult.FilesWithErrors,
-						})
-						logging.Debug(logging.ComponentHandler, "  Added result to resultsMap[%s]: %s (compatible=%v, depCount=%d)",
-							filename, htName, compatible, len(treeResult.DependencyResults))
-					} else {
-						// No result or error - this shouldn't happen
-						logging.Warn(logging.ComponentHandler, "  File %s: No result or error received from validation!", filename)
-						resultsMap[filename] = append(resultsMap[filename], qmldiff.TreeComparisonResult{
-							Hashtable:          htName,
-							OSVersion:          htOSVersion,
-							Device:             tree.Device,
-							Compatible:         false,
-							ErrorDetail:        "no validation result received",
-							ValidationMode:     "tree",
-							TreeValidationUsed: true,
-						})
+				// Every file in the batch just got this hashtable's result
+				var delta ResultDelta
+				if jobStore != nil {
+					delta = ResultDelta{Hashtable: htName, Results: make(map[string]qmldiff.TreeComparisonResult, len(filenames))}
+					for _, filename := range filenames {
+						if results := resultsMap[filename]; len(results) > 0 {
+							delta.Results[filename] = results[len(results)-1]
+						}
 					}
 				}
-			}
 
-			completedComparisons++
-			if jobStore != nil {
-				progress := int((float64(completedComparisons) / float64(totalComparisons)) * 100)
-				jobStore.UpdateProgress(jobID, progress)
+				mu.Unlock()
+
+				if jobStore != nil {
+					jobStore.PublishResult(jobID, delta)
+				}
 			}
-		}(ht.Name, ht.Path, ht.OSVersion, ht.Device, matchingTree)
+
+			mu.Lock()
+			defer mu.Unlock()
+			completeHashtable(htName)
+		}(ht.Name, ht.Path, ht.OSVersion, ht.Device, matchingTree, versionMismatch, deviceSemaphore, htQmdPaths, htFilenames)
 	}
 
-	// Wait for all validations to complete
-	wg.Wait()
+	// Wait for all validations to complete, or return what has finished so far
+	// if the context ends first
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Periodically flush completed results so long batches can serve partial results
+	flushInterval := h.settings.ResultFlushInterval
+	var flushTick <-chan time.Time
+	if jobStore != nil && flushInterval > 0 {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		flushTick = ticker.C
+	}
+
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case <-flushTick:
+			mu.Lock()
+			partial := copyResults(resultsMap)
+			logging.Debug(logging.ComponentHandler, "Flushing partial results for job %s: %d/%d hashtables processed",
+				jobID, completedComparisons, totalComparisons)
+			mu.Unlock()
+			jobStore.SetPartialResults(jobID, partial)
+		case <-ctx.Done():
+			mu.Lock()
+			defer mu.Unlock()
+
+			logging.Warn(logging.ComponentHandler, "Parallel validation stopped early: %d/%d hashtables processed (%v)",
+				completedComparisons, totalComparisons, ctx.Err())
+
+			return copyResults(resultsMap), ctx.Err()
+		}
+	}
 
 	logging.Info(logging.ComponentHandler, "Parallel validation complete: %d hashtables processed", completedComparisons)
 
 	return resultsMap, nil
 }
+
+// ResultDelta is one hashtable's results for a batch of files, pushed to
+// status WebSocket watchers as soon as that hashtable finishes them
+type ResultDelta struct {
+	Hashtable string                                  `json:"hashtable"`
+	Results   map[string]qmldiff.TreeComparisonResult `json:"results"` // By filename
+}
+
+// prioritizeFiles reorders qmdPaths and filenames so the files named in
+// priority come first, in that order, followed by the rest as they were.
+// Workers validate each hashtable's files in this order, so a job that
+// times out or is read early has the priority files' results.
+func prioritizeFiles(qmdPaths, filenames, priority []string) ([]string, []string) {
+	if len(priority) == 0 {
+		return qmdPaths, filenames
+	}
+
+	index := make(map[string]int, len(filenames))
+	for i, filename := range filenames {
+		index[filename] = i
+	}
+	order := make([]int, 0, len(filenames))
+	taken := make(map[int]bool, len(priority))
+	for _, filename := range priority {
+		if i, ok := index[filename]; ok && !taken[i] {
+			order = append(order, i)
+			taken[i] = true
+		}
+	}
+	for i := range filenames {
+		if !taken[i] {
+			order = append(order, i)
+		}
+	}
+
+	orderedPaths := make([]string, len(order))
+	orderedFilenames := make([]string, len(order))
+	for j, i := range order {
+		orderedPaths[j] = qmdPaths[i]
+		orderedFilenames[j] = filenames[i]
+	}
+	return orderedPaths, orderedFilenames
+}
+
+// priorityBatches splits filenames, ordered by prioritizeFiles, into the
+// [start, end) ranges to validate one after the other: the leading priority
+// files, then the rest. Each file is still validated once per hashtable.
+func priorityBatches(filenames []string, priority map[string]bool) [][2]int {
+	if len(priority) == 0 {
+		return [][2]int{{0, len(filenames)}}
+	}
+	split := 0
+	for split < len(filenames) && priority[filenames[split]] {
+		split++
+	}
+	if split == 0 || split == len(filenames) {
+		return [][2]int{{0, len(filenames)}}
+	}
+	return [][2]int{{0, split}, {split, len(filenames)}}
+}
+
+// deviceConcurrencyLimit returns the max concurrent validations for a device,
+// from Settings.DeviceConcurrency and falling back to the global limit
+func (h *APIHandler) deviceConcurrencyLimit(device string) int {
+	limit, ok := h.settings.DeviceConcurrency[device]
+	if !ok || limit < 1 {
+		return h.maxConcurrentValidations
+	}
+	return limit
+}
+
+// copyResults returns a copy of a results map that is safe to hand off while
+// workers keep appending to the original
+func copyResults(resultsMap map[string][]qmldiff.TreeComparisonResult) map[string][]qmldiff.TreeComparisonResult {
+	partial := make(map[string][]qmldiff.TreeComparisonResult, len(resultsMap))
+	for filename, results := range resultsMap {
+		partial[filename] = append([]qmldiff.TreeComparisonResult(nil), results...)
+	}
+	return partial
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+// newWorkerTestHandler returns a handler with a hashtable and tree for each
+// name, running script in place of the qmldiff binary
+func newWorkerTestHandler(t *testing.T, script string, jobStore *jobs.Store, settings Settings, names ...string) *APIHandler {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake qmldiff is a shell script")
+	}
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "qmldiff")
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	hashtabDir := filepath.Join(dir, "hashtables")
+	treeDir := filepath.Join(dir, "trees")
+	if err := os.MkdirAll(hashtabDir, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(treeDir, name), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(treeDir, name, "Main.qml"), []byte("Item {}\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		if err := hashtab.WriteHashtab(map[uint64]string{hashtab.DJB2Hash("width"): "width"}, filepath.Join(hashtabDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	treeService := qmltree.NewService(treeDir)
+	qmldiffService := qmldiff.NewService(binary, hashtabService, treeService)
+	return NewAPIHandler(qmldiffService, hashtabService, treeService, jobStore, nil, len(names), settings)
+}
+
+// writeQMDFiles writes files to a temp dir, returning their paths in order
+func writeQMDFiles(t *testing.T, filenames ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, len(filenames))
+	for i, filename := range filenames {
+		paths[i] = filepath.Join(dir, filename)
+		if err := os.WriteFile(paths[i], []byte("AFFECT [[1]]\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	return paths
+}
+
+func TestValidationFlushesPartialResultsToStore(t *testing.T) {
+	jobsDir := t.TempDir()
+	store, err := jobs.NewPersistentStore(jobsDir, jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() failed: %v", err)
+	}
+	settings := DefaultSettings()
+	settings.ResultFlushInterval = 10 * time.Millisecond
+	// The rm2 hashtable never finishes, so only the rmpp results can be flushed
+	script := "#!/bin/sh\ncase \"$*\" in *-rm2*) sleep 30 ;; esac\nexit 0\n"
+	h := newWorkerTestHandler(t, script, store, settings, "3.22.4.2-rmpp", "3.20.0.52-rm2")
+
+	store.Create("job")
+	paths := writeQMDFiles(t, "a.qmd")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.validateAgainstAllTreesWithWorkers(ctx, h.qmldiffService, paths, []string{"a.qmd"}, nil, nil, nil, store, "job")
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if job, ok := store.Get("job"); ok && job.Partial {
+			if results := job.Results.(map[string][]qmldiff.TreeComparisonResult); len(results["a.qmd"]) == 1 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("partial results were never flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stop as a restart would, then reload what was saved
+	cancel()
+	<-done
+	reloaded, err := jobs.NewPersistentStore(jobsDir, jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() reload failed: %v", err)
+	}
+	job, ok := reloaded.Get("job")
+	if !ok {
+		t.Fatal("job was not reloaded")
+	}
+	if job.Status != "error" || !job.Partial {
+		t.Fatalf("reloaded job status = %q, partial = %v, want interrupted with partial results", job.Status, job.Partial)
+	}
+	results, ok := job.Results.(map[string][]qmldiff.TreeComparisonResult)
+	if !ok || len(results["a.qmd"]) != 1 || results["a.qmd"][0].Hashtable != "3.22.4.2-rmpp" {
+		t.Errorf("reloaded partial results = %#v, want the rmpp result for a.qmd", job.Results)
+	}
+}
+
+func TestPriorityDoesNotRerunQMLDiff(t *testing.T) {
+	countFile := filepath.Join(t.TempDir(), "count")
+	script := "#!/bin/sh\necho \"$1\" >> " + countFile + "\nexit 0\n"
+	filenames := []string{"a.qmd", "b.qmd", "c.qmd"}
+
+	invocations := func(priority map[string]bool) int {
+		t.Helper()
+		os.Remove(countFile)
+		store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+		h := newWorkerTestHandler(t, script, store, DefaultSettings(), "3.22.4.2-rmpp")
+		store.Create("job")
+		paths := writeQMDFiles(t, filenames...)
+		if _, err := h.validateAgainstAllTreesWithWorkers(context.Background(), h.qmldiffService, paths, filenames, nil, nil, priority, store, "job"); err != nil {
+			t.Fatalf("validateAgainstAllTreesWithWorkers() failed: %v", err)
+		}
+		data, err := os.ReadFile(countFile)
+		if err != nil {
+			t.Fatalf("ReadFile() failed: %v", err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+
+	want := invocations(nil)
+	if want == 0 {
+		t.Fatal("qmldiff was never run")
+	}
+	for _, priority := range []map[string]bool{
+		{"a.qmd": true},
+		{"a.qmd": true, "b.qmd": true, "c.qmd": true},
+	} {
+		if got := invocations(priority); got != want {
+			t.Errorf("priority %v ran qmldiff %d times, want %d as without priority", priority, got, want)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"nhooyr.io/websocket"
@@ -11,7 +16,19 @@ import (
 	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
 )
 
-func StatusWSHandler(jobStore *jobs.Store) http.HandlerFunc {
+// StatusWSHandler streams job status updates over a WebSocket
+// Upgrades are accepted from the server's own origin and from hosts matching
+// allowedOrigins; "*" among them disables the check.
+// The client is pinged every pingInterval (0 disables), so proxies with idle
+// timeouts don't drop the connection while a job runs without updates.
+// Mount it outside any request timeout middleware, which would cancel the
+// connection's context however often it pings.
+func StatusWSHandler(jobStore *jobs.Store, allowedOrigins []string, pingInterval time.Duration) http.HandlerFunc {
+	acceptOptions := &websocket.AcceptOptions{
+		OriginPatterns:     originPatterns(allowedOrigins),
+		InsecureSkipVerify: slices.Contains(allowedOrigins, "*"),
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		jobID := chi.URLParam(r, "jobId")
 		if jobID == "" {
@@ -24,27 +41,72 @@ func StatusWSHandler(jobStore *jobs.Store) http.HandlerFunc {
 			return
 		}
 
-		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-			InsecureSkipVerify: true,
-		})
+		conn, err := websocket.Accept(w, r, acceptOptions)
 		if err != nil {
-			logging.Error(logging.ComponentHandler, "Failed to accept WebSocket: %v", err)
+			logging.Error(logging.ComponentHandler, "Failed to accept WebSocket from origin %q: %v", r.Header.Get("Origin"), err)
 			return
 		}
 
 		ch, unsubscribe := jobStore.Subscribe(jobID)
 		defer unsubscribe()
 
-		ctx := r.Context()
-		for job := range ch {
-			if err := wsjson.Write(ctx, conn, job); err != nil {
-				logging.Error(logging.ComponentHandler, "Failed to write WebSocket message: %v", err)
+		// Clients only listen, but reading is what handles their pongs and close
+		// frames; ctx is cancelled once the client goes away
+		ctx := conn.CloseRead(r.Context())
+
+		var pings <-chan time.Time
+		if pingInterval > 0 {
+			ticker := time.NewTicker(pingInterval)
+			defer ticker.Stop()
+			pings = ticker.C
+		}
+
+		for {
+			select {
+			case job, ok := <-ch:
+				if !ok {
+					// The job was removed from the store
+					conn.Close(websocket.StatusGoingAway, "job removed")
+					return
+				}
+				if err := wsjson.Write(ctx, conn, job); err != nil {
+					logging.Error(logging.ComponentHandler, "Failed to write WebSocket message: %v", err)
+					return
+				}
+
+				if jobs.IsTerminal(job.Status) {
+					conn.Close(websocket.StatusNormalClosure, "job "+job.Status)
+					return
+				}
+
+			case <-pings:
+				pingCtx, cancel := context.WithTimeout(ctx, pingInterval)
+				err := conn.Ping(pingCtx)
+				cancel()
+				if err != nil {
+					logging.Warn(logging.ComponentHandler, "WebSocket for job %s stopped answering pings: %v", jobID, err)
+					conn.Close(websocket.StatusGoingAway, "ping timeout")
+					return
+				}
+
+			case <-ctx.Done():
 				return
 			}
+		}
+	}
+}
 
-			if job.Status == "success" || job.Status == "error" {
-				return
+// originPatterns converts configured origins to the host patterns the
+// WebSocket library matches against, accepting full URLs like https://example.com
+func originPatterns(origins []string) []string {
+	patterns := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		if strings.Contains(origin, "://") {
+			if u, err := url.Parse(origin); err == nil && u.Host != "" {
+				origin = u.Host
 			}
 		}
+		patterns = append(patterns, origin)
 	}
+	return patterns
 }
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+)
+
+func TestStatusWSHandlerOriginCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		wantAccepted   bool
+	}{
+		{"same origin", nil, "", true},
+		{"foreign origin rejected", nil, "https://evil.example", false},
+		{"allowed host", []string{"app.example.com"}, "https://app.example.com", true},
+		{"allowed URL", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"allowed glob", []string{"*.example.com"}, "https://app.example.com", true},
+		{"other host rejected", []string{"app.example.com"}, "https://other.example.com", false},
+		{"wildcard allows any origin", []string{"*"}, "https://evil.example", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+			store.Create("job")
+			store.Update("job", "success", "Validation complete", nil)
+
+			r := chi.NewRouter()
+			r.Get("/api/status/ws/{jobId}", StatusWSHandler(store, tt.allowedOrigins, 0))
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			origin := tt.origin
+			if origin == "" {
+				origin = server.URL
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/status/ws/job"
+			conn, resp, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+				HTTPHeader: http.Header{"Origin": []string{origin}},
+			})
+			if tt.wantAccepted {
+				if err != nil {
+					t.Fatalf("Dial() failed: %v", err)
+				}
+				conn.CloseNow()
+				return
+			}
+
+			if err == nil {
+				conn.CloseNow()
+				t.Fatal("Dial() succeeded, want origin rejection")
+			}
+			if resp == nil || resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Dial() response = %v, want status %d", resp, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestStatusWSHandlerClosesAfterTerminalJob(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("job")
+	store.Update("job", "success", "Validation complete", nil)
+
+	r := chi.NewRouter()
+	r.Get("/api/status/ws/{jobId}", StatusWSHandler(store, nil, 0))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/status/ws/job"
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": []string{server.URL}},
+	})
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var job jobs.Job
+	if err := wsjson.Read(ctx, conn, &job); err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if job.Status != "success" {
+		t.Errorf("status = %q, want %q", job.Status, "success")
+	}
+
+	_, _, err = conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusNormalClosure {
+		t.Errorf("close status = %v (err %v), want %v", status, err, websocket.StatusNormalClosure)
+	}
+}
+
+func TestStatusWSHandlerPingsIdleConnection(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("job")
+	store.UpdateWithOperation("job", "running", "Validating", nil, "validating")
+
+	r := chi.NewRouter()
+	r.Get("/api/status/ws/{jobId}", StatusWSHandler(store, nil, 10*time.Millisecond))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/status/ws/job"
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": []string{server.URL}},
+	})
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var job jobs.Job
+	if err := wsjson.Read(ctx, conn, &job); err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if job.Status != "running" {
+		t.Fatalf("status = %q, want %q", job.Status, "running")
+	}
+
+	// The job goes quiet for many ping intervals; pings are answered while the client reads
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		store.Update("job", "success", "Validation complete", nil)
+	}()
+
+	if err := wsjson.Read(ctx, conn, &job); err != nil {
+		t.Fatalf("Read() after idle period failed: %v", err)
+	}
+	if job.Status != "success" {
+		t.Errorf("status = %q, want %q", job.Status, "success")
+	}
+
+	_, _, err = conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusNormalClosure {
+		t.Errorf("close status = %v (err %v), want %v", status, err, websocket.StatusNormalClosure)
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+)
+
+// zipMagic starts every zip archive's first local file header
+var zipMagic = []byte("PK\x03\x04")
+
+// isZipUpload reports whether an uploaded file is a zip archive, by its
+// extension or, failing that, its magic bytes
+func isZipUpload(fileHeader *multipart.FileHeader) bool {
+	if strings.EqualFold(filepath.Ext(fileHeader.Filename), ".zip") {
+		return true
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, zipMagic)
+}
+
+// extractZipUpload unpacks an uploaded zip archive into dir, keeping its
+// directory structure, with the same results as saveUploadedFiles.
+// An archive holding a single top-level folder is unpacked from inside it, so
+// zipping the folder of a project behaves like uploading its contents.
+// Entries that would land outside dir are rejected, and the entry count and
+// unpacked size are capped by Settings.MaxZipEntries and MaxZipExtractedMB.
+func (h *APIHandler) extractZipUpload(w http.ResponseWriter, dir string, fileHeader *multipart.FileHeader) (qmdPaths, filenames, excluded []string, ok bool) {
+	fail := func(status int, message string) ([]string, []string, []string, bool) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": message,
+		})
+		return nil, nil, nil, false
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logging.Error(logging.ComponentHandler, "Failed to open uploaded file %s: %v", fileHeader.Filename, err)
+		return fail(http.StatusInternalServerError, fmt.Sprintf("Failed to open file %s", fileHeader.Filename))
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, fileHeader.Size)
+	if err != nil {
+		logging.Warn(logging.ComponentHandler, "Invalid zip upload %s: %v", fileHeader.Filename, err)
+		return fail(http.StatusBadRequest, fmt.Sprintf("%s is not a valid zip archive", fileHeader.Filename))
+	}
+	if maxEntries := h.settings.MaxZipEntries; maxEntries > 0 && len(archive.File) > maxEntries {
+		logging.Warn(logging.ComponentHandler, "Zip upload %s has %d entries, limit is %d", fileHeader.Filename, len(archive.File), maxEntries)
+		return fail(http.StatusBadRequest, fmt.Sprintf("Zip archive has more than %d entries", maxEntries))
+	}
+
+	entries := make([]*zip.File, 0, len(archive.File))
+	for _, entry := range archive.File {
+		// Resource forks added by macOS's Archive Utility
+		if entry.Name == "__MACOSX/" || strings.HasPrefix(entry.Name, "__MACOSX/") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	prefix := commonZipFolder(entries)
+
+	maxMB := h.settings.MaxZipExtractedMB
+	remaining := int64(maxMB) << 20
+	cleanDir := filepath.Clean(dir) + string(os.PathSeparator)
+
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			// Directories are created for the files in them; links are never followed
+			continue
+		}
+
+		relativePath := filepath.FromSlash(path.Clean(strings.TrimPrefix(entry.Name, prefix)))
+		tempPath := filepath.Join(dir, relativePath)
+		if filepath.IsAbs(relativePath) || !strings.HasPrefix(filepath.Clean(tempPath)+string(os.PathSeparator), cleanDir) || filepath.Clean(tempPath) == filepath.Clean(dir) {
+			logging.Warn(logging.ComponentHandler, "Path traversal attempt detected in zip %s: %s", fileHeader.Filename, entry.Name)
+			return fail(http.StatusBadRequest, "Invalid file path")
+		}
+
+		if err := os.MkdirAll(filepath.Dir(tempPath), 0755); err != nil {
+			logging.Error(logging.ComponentHandler, "Failed to create directory for %s: %v", relativePath, err)
+			return fail(http.StatusInternalServerError, fmt.Sprintf("Failed to save file %s", relativePath))
+		}
+
+		written, err := writeZipEntry(entry, tempPath, remaining)
+		if err != nil {
+			if err == errZipTooLarge {
+				return fail(http.StatusBadRequest, fmt.Sprintf("Zip archive expands to more than %d MB", maxMB))
+			}
+			logging.Error(logging.ComponentHandler, "Failed to extract %s from %s: %v", entry.Name, fileHeader.Filename, err)
+			return fail(http.StatusBadRequest, fmt.Sprintf("Failed to extract %s from zip archive", entry.Name))
+		}
+		remaining -= written
+
+		if written == 0 {
+			logging.Warn(logging.ComponentHandler, "Skipping empty file: %s", relativePath)
+			continue
+		}
+
+		// Excluded files stay on disk so LOADs still resolve, but aren't validated or counted
+		relativePath = filepath.ToSlash(relativePath)
+		if qmd.IsExcluded(relativePath) {
+			logging.Info(logging.ComponentHandler, "Excluding file from validation: %s", relativePath)
+			excluded = append(excluded, relativePath)
+			continue
+		}
+
+		qmdPaths = append(qmdPaths, tempPath)
+		filenames = append(filenames, relativePath)
+	}
+
+	logging.Info(logging.ComponentHandler, "Extracted %d file(s) from zip %s", len(filenames), fileHeader.Filename)
+	return qmdPaths, filenames, excluded, true
+}
+
+// errZipTooLarge stops extraction once the size limit is passed
+var errZipTooLarge = fmt.Errorf("zip archive too large")
+
+// writeZipEntry writes one entry to tempPath, returning its size
+// More than limit bytes returns errZipTooLarge, whatever the entry claims.
+func writeZipEntry(entry *zip.File, tempPath string, limit int64) (int64, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	out, err := os.Create(tempPath)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(out, io.LimitReader(reader, limit+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+	if written > limit {
+		return written, errZipTooLarge
+	}
+	return written, nil
+}
+
+// commonZipFolder returns "folder/" when every entry is inside one
+// top-level folder, or "" otherwise
+func commonZipFolder(entries []*zip.File) string {
+	folder := ""
+	for _, entry := range entries {
+		first, _, nested := strings.Cut(entry.Name, "/")
+		if !nested || (folder != "" && first != folder) {
+			return ""
+		}
+		folder = first
+	}
+	if folder == "" {
+		return ""
+	}
+	return folder + "/"
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+func newZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		entry, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%s) failed: %v", name, err)
+		}
+		entry.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestCompareExtractsZipUpload(t *testing.T) {
+	hashtabDir := t.TempDir()
+	entries := map[uint64]string{hashtab.DJB2Hash("width"): "width"}
+	if err := hashtab.WriteHashtab(entries, filepath.Join(hashtabDir, "3.22.4.2-rmpp")); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	h := NewAPIHandler(nil, hashtabService, qmltree.NewService(t.TempDir()), jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	// Zipping the project folder wraps everything in it; the folder is stripped
+	// and the dependency in lib/ is extracted but not validated on its own
+	for _, name := range []string{"mods.zip", "upload"} {
+		req := newMultipartRequest(t, map[string]string{
+			name: newZip(t, map[string]string{
+				"mods/a.qmd":            "LOAD lib/common.qmd\nAFFECT [[1]]\n",
+				"mods/b.qmd":            "AFFECT [[2]]\n",
+				"mods/lib/common.qmd":   "AFFECT [[3]]\n",
+				"__MACOSX/mods/._a.qmd": "resource fork",
+			}),
+		}, nil)
+		rec := httptest.NewRecorder()
+		h.Compare(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d: %s", name, rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("Decode() failed: %v", err)
+		}
+		if resp["files_accepted"] != float64(2) {
+			t.Errorf("%s: files_accepted = %v, want 2", name, resp["files_accepted"])
+		}
+	}
+}
+
+func TestCompareRejectsZipSlip(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	for _, entry := range []string{"../escape.qmd", "mods/../../escape.qmd", "/etc/escape.qmd"} {
+		req := newMultipartRequest(t, map[string]string{
+			"mods.zip": newZip(t, map[string]string{
+				"a.qmd": "AFFECT [[1]]\n",
+				entry:   "AFFECT [[2]]\n",
+			}),
+		}, nil)
+		rec := httptest.NewRecorder()
+		h.Compare(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", entry, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCompareRejectsInvalidZip(t *testing.T) {
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, DefaultSettings())
+
+	req := newMultipartRequest(t, map[string]string{"mods.zip": "not a zip"}, nil)
+	rec := httptest.NewRecorder()
+	h.Compare(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCompareRejectsOversizedZip(t *testing.T) {
+	settings := DefaultSettings()
+	settings.MaxZipEntries = 2
+	settings.MaxZipExtractedMB = 1
+	h := NewAPIHandler(nil, nil, nil, jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval), nil, 1, settings)
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"too many entries", map[string]string{"a.qmd": "AFFECT [[1]]\n", "b.qmd": "AFFECT [[2]]\n", "c.qmd": "AFFECT [[3]]\n"}, "Zip archive has more than 2 entries"},
+		{"too large", map[string]string{"a.qmd": strings.Repeat("x", 1<<20+1)}, "Zip archive expands to more than 1 MB"},
+	}
+	for _, tt := range tests {
+		req := newMultipartRequest(t, map[string]string{"mods.zip": newZip(t, tt.files)}, nil)
+		rec := httptest.NewRecorder()
+		h.Compare(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, http.StatusBadRequest)
+			continue
+		}
+		var resp map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: Decode() failed: %v", tt.name, err)
+		}
+		if resp["error"] != tt.want {
+			t.Errorf("%s: error = %q, want %q", tt.name, resp["error"], tt.want)
+		}
+	}
+}
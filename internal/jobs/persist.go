@@ -0,0 +1,216 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+)
+
+var (
+	resultTypesMu sync.RWMutex
+	resultTypes   = make(map[string]reflect.Type) // Tag -> concrete Results type
+	resultTags    = make(map[reflect.Type]string)
+)
+
+// RegisterResultType lets a persistent store save and reload job results of
+// sample's type, recorded under tag. Results of unregistered types are kept
+// in memory only, so they don't survive a restart.
+func RegisterResultType(tag string, sample interface{}) {
+	resultTypesMu.Lock()
+	defer resultTypesMu.Unlock()
+	t := reflect.TypeOf(sample)
+	resultTypes[tag] = t
+	resultTags[t] = tag
+}
+
+// jobRecord is a job as saved to disk
+// A job still running when the server stops is reloaded as failed, keeping
+// the last partial results flushed before the restart.
+type jobRecord struct {
+	Status      string            `json:"status"`
+	Message     string            `json:"message"`
+	Data        map[string]string `json:"data,omitempty"`
+	Progress    int               `json:"progress"`
+	Operation   string            `json:"operation,omitempty"`
+	Label       string            `json:"label,omitempty"`
+	ResultsType string            `json:"results_type,omitempty"` // Tag given to RegisterResultType
+	Results     json.RawMessage   `json:"results,omitempty"`
+	Partial     bool              `json:"partial,omitempty"` // Results are an in-progress snapshot
+	Summary     json.RawMessage   `json:"error_summary,omitempty"`
+	FilesDir    string            `json:"files_dir,omitempty"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
+
+// NewPersistentStore returns a store like NewStore that also saves each job
+// as <job ID>.json in dir, so results survive a restart. Jobs already in dir
+// are loaded; ones that were still running are marked as failed, and keep
+// any partial results they had flushed.
+func NewPersistentStore(dir string, ttl, cleanupInterval time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+
+	s := &Store{
+		jobs:            make(map[string]*Job),
+		watchers:        make(map[string][]chan *Job),
+		dir:             dir,
+		ttl:             ttl,
+		cleanupInterval: cleanupInterval,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.startCleanup()
+	return s, nil
+}
+
+// load reads every saved job in the store's directory
+func (s *Store) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		job, err := readJob(path)
+		if err != nil {
+			logging.Warn(logging.ComponentServer, "Skipping saved job %s: %v", id, err)
+			continue
+		}
+		s.jobs[id] = job
+		s.watchers[id] = []chan *Job{}
+
+		if !IsTerminal(job.Status) {
+			job.Status = "error"
+			job.Message = "Job was interrupted by a server restart"
+			if job.Partial {
+				job.Message += ", results are partial"
+			}
+			now := time.Now()
+			job.CompletedAt = &now
+			s.persistLocked(id)
+		}
+	}
+	logging.Info(logging.ComponentServer, "Loaded %d saved job(s) from %s", len(s.jobs), s.dir)
+	return nil
+}
+
+func readJob(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var record jobRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Status:      record.Status,
+		Message:     record.Message,
+		Data:        record.Data,
+		Progress:    record.Progress,
+		Operation:   record.Operation,
+		Label:       record.Label,
+		FilesDir:    record.FilesDir,
+		Summary:     record.Summary,
+		CompletedAt: record.CompletedAt,
+	}
+	if record.ResultsType != "" {
+		resultTypesMu.RLock()
+		t, ok := resultTypes[record.ResultsType]
+		resultTypesMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown results type %q", record.ResultsType)
+		}
+		results := reflect.New(t)
+		if err := json.Unmarshal(record.Results, results.Interface()); err != nil {
+			return nil, fmt.Errorf("failed to decode results: %w", err)
+		}
+		job.Results = results.Elem().Interface()
+		job.Partial = record.Partial
+	}
+	return job, nil
+}
+
+// persistLocked saves a job, replacing the file atomically
+// It does nothing for an in-memory store. The caller holds s.mu.
+func (s *Store) persistLocked(id string) {
+	j, ok := s.jobs[id]
+	if s.dir == "" || !ok {
+		return
+	}
+
+	record := jobRecord{
+		Status:      j.Status,
+		Message:     j.Message,
+		Data:        j.Data,
+		Progress:    j.Progress,
+		Operation:   j.Operation,
+		Label:       j.Label,
+		FilesDir:    j.FilesDir,
+		Summary:     j.Summary,
+		CompletedAt: j.CompletedAt,
+	}
+	if j.Results != nil {
+		resultTypesMu.RLock()
+		tag, ok := resultTags[reflect.TypeOf(j.Results)]
+		resultTypesMu.RUnlock()
+		if ok {
+			results, err := json.Marshal(j.Results)
+			if err != nil {
+				logging.Warn(logging.ComponentServer, "Not saving results of job %s: %v", id, err)
+			} else {
+				record.ResultsType = tag
+				record.Results = results
+				record.Partial = j.Partial
+			}
+		} else {
+			logging.Warn(logging.ComponentServer, "Not saving results of job %s: unregistered type %T", id, j.Results)
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(s.dir, id+".json"), data)
+	}
+	if err != nil {
+		logging.Warn(logging.ComponentServer, "Failed to save job %s: %v", id, err)
+	}
+}
+
+// removeLocked deletes a job's saved file, if the store has one
+func (s *Store) removeLocked(id string) {
+	if s.dir == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		logging.Warn(logging.ComponentServer, "Failed to remove saved job %s: %v", id, err)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,125 @@
+package jobs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testResult struct {
+	Files []string `json:"files"`
+	Count int      `json:"count"`
+}
+
+func init() {
+	RegisterResultType("test", map[string]testResult{})
+}
+
+func TestPersistentStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewPersistentStore(dir, DefaultTTL, DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() failed: %v", err)
+	}
+
+	results := map[string]testResult{"a.qmd": {Files: []string{"lib/b.qmd"}, Count: 2}}
+	store.Create("done")
+	store.SetLabel("done", "ci-42")
+	filesDir := t.TempDir()
+	store.SetFilesDir("done", filesDir)
+	store.SetSummary("done", json.RawMessage(`{"total":1}`))
+	store.SetResults("done", results)
+	store.Update("done", "success", "Batch validation complete", nil)
+
+	store.Create("running")
+	store.UpdateWithOperation("running", "running", "Validating", nil, "validating")
+
+	reloaded, err := NewPersistentStore(dir, DefaultTTL, DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() reload failed: %v", err)
+	}
+
+	job, ok := reloaded.Get("done")
+	if !ok {
+		t.Fatal("finished job was not reloaded")
+	}
+	if job.Status != "success" || job.Label != "ci-42" || job.CompletedAt == nil {
+		t.Errorf("reloaded job = %+v, want success with label and completion time", job)
+	}
+	if !reflect.DeepEqual(job.Results, results) {
+		t.Errorf("reloaded results = %#v, want %#v", job.Results, results)
+	}
+	if job.FilesDir != filesDir {
+		t.Errorf("reloaded files dir = %q, want %q", job.FilesDir, filesDir)
+	}
+	if string(job.Summary) != `{"total":1}` {
+		t.Errorf("reloaded summary = %s, want {\"total\":1}", job.Summary)
+	}
+
+	// Nothing is left to finish a job that was running when the server stopped
+	job, ok = reloaded.Get("running")
+	if !ok {
+		t.Fatal("running job was not reloaded")
+	}
+	if job.Status != "error" || job.CompletedAt == nil {
+		t.Errorf("interrupted job status = %q (completed %v), want error", job.Status, job.CompletedAt)
+	}
+
+	reloaded.Cleanup("done")
+	if _, err := os.Stat(filepath.Join(dir, "done.json")); !os.IsNotExist(err) {
+		t.Errorf("saved job file still exists after Cleanup: %v", err)
+	}
+	if _, err := os.Stat(filesDir); !os.IsNotExist(err) {
+		t.Errorf("job files still exist after Cleanup: %v", err)
+	}
+}
+
+func TestPersistentStoreSkipsUnregisteredResults(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewPersistentStore(dir, DefaultTTL, DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() failed: %v", err)
+	}
+	store.Create("job")
+	store.SetResults("job", []int{1, 2})
+	store.Update("job", "success", "done", nil)
+
+	reloaded, err := NewPersistentStore(dir, DefaultTTL, DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() reload failed: %v", err)
+	}
+	job, ok := reloaded.Get("job")
+	if !ok || job.Status != "success" || job.Results != nil {
+		t.Errorf("reloaded job = %+v, want success without results", job)
+	}
+}
+
+func TestPersistentStoreKeepsPartialResults(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewPersistentStore(dir, DefaultTTL, DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() failed: %v", err)
+	}
+
+	partial := map[string]testResult{"a.qmd": {Count: 1}}
+	store.Create("running")
+	store.UpdateWithOperation("running", "running", "Validating", nil, "validating")
+	store.SetPartialResults("running", partial)
+
+	reloaded, err := NewPersistentStore(dir, DefaultTTL, DefaultCleanupInterval)
+	if err != nil {
+		t.Fatalf("NewPersistentStore() reload failed: %v", err)
+	}
+	job, ok := reloaded.Get("running")
+	if !ok {
+		t.Fatal("running job was not reloaded")
+	}
+	if job.Status != "error" || !job.Partial {
+		t.Errorf("interrupted job status = %q, partial = %v, want error with partial results", job.Status, job.Partial)
+	}
+	if !reflect.DeepEqual(job.Results, partial) {
+		t.Errorf("reloaded partial results = %#v, want %#v", job.Results, partial)
+	}
+}
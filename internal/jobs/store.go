@@ -1,6 +1,10 @@
 package jobs
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
 	"sync"
 	"time"
 )
@@ -11,22 +15,66 @@ type Job struct {
 	Data        map[string]string      `json:"data,omitempty"`
 	Progress    int                    `json:"progress"`
 	Operation   string                 `json:"operation,omitempty"`
+	Label       string                 `json:"label,omitempty"` // Client-supplied tag for correlating jobs
+	Delta       interface{}            `json:"delta,omitempty"` // Newly finished results, only on updates sent by PublishResult
+	Hashtables  *HashtableProgress     `json:"hashtables,omitempty"` // Per-hashtable breakdown of Progress, see SetHashtableProgress
+	Summary     json.RawMessage        `json:"error_summary,omitempty"` // Failure counts across the job's results, see SetSummary
 	Results     interface{}            `json:"-"`
+	Partial     bool                   `json:"-"` // Results hold an in-progress snapshot
+	FilesDir    string                 `json:"-"` // The job's uploaded files, removed with the job, see SetFilesDir
 	CompletedAt *time.Time             `json:"-"`
+
+	cancel context.CancelFunc // Stops the job's work, see SetCancel
+}
+
+// HashtableProgress breaks a validation job's progress down by hashtable
+// Hashtables are validated concurrently, so there is no single current one:
+// InFlight lists every hashtable being validated, sorted by name.
+type HashtableProgress struct {
+	Completed     int      `json:"completed"`
+	Total         int      `json:"total"`
+	InFlight      []string `json:"in_flight"`
+	LastCompleted string   `json:"last_completed,omitempty"`
+}
+
+// IsTerminal reports whether a job status is final
+// "timeout" jobs are final but may still carry partial results
+func IsTerminal(status string) bool {
+	return status == "success" || status == "error" || status == "timeout" || status == "cancelled"
 }
 
+// Errors returned by Cancel
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// Defaults for how long finished jobs are kept and how often they are reaped
+const (
+	DefaultTTL             = 5 * time.Minute
+	DefaultCleanupInterval = time.Minute
+)
+
 type Store struct {
-	mu       sync.RWMutex
-	jobs     map[string]*Job
-	watchers map[string][]chan *Job
+	mu              sync.RWMutex
+	jobs            map[string]*Job
+	watchers        map[string][]chan *Job
+	dir             string // Where jobs are saved, see NewPersistentStore; empty keeps them in memory only
+	ttl             time.Duration
+	cleanupInterval time.Duration
 }
 
-func NewStore() *Store {
+// NewStore returns a store that removes jobs ttl after they finish, checking
+// every cleanupInterval. Jobs with a subscribed watcher are kept until it
+// leaves. A ttl or cleanupInterval of 0 keeps finished jobs forever.
+func NewStore(ttl, cleanupInterval time.Duration) *Store {
 	s := &Store{
-		jobs:     make(map[string]*Job),
-		watchers: make(map[string][]chan *Job),
+		jobs:            make(map[string]*Job),
+		watchers:        make(map[string][]chan *Job),
+		ttl:             ttl,
+		cleanupInterval: cleanupInterval,
 	}
-	go s.startCleanup()
+	s.startCleanup()
 	return s
 }
 
@@ -41,6 +89,7 @@ func (s *Store) Create(id string) *Job {
 	}
 	s.jobs[id] = j
 	s.watchers[id] = []chan *Job{}
+	s.persistLocked(id)
 	return j
 }
 
@@ -51,23 +100,91 @@ func (s *Store) Get(id string) (*Job, bool) {
 	return j, ok
 }
 
+// List returns shallow copies of every job, keyed by job ID
+// Changing a copy doesn't affect the stored job.
+func (s *Store) List() map[string]Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := make(map[string]Job, len(s.jobs))
+	for id, j := range s.jobs {
+		jobs[id] = *j
+	}
+	return jobs
+}
+
 func (s *Store) Update(id, status, message string, data map[string]string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if j, ok := s.jobs[id]; ok {
+	if j, ok := s.jobs[id]; ok && j.Status != "cancelled" {
 		j.Status = status
 		j.Message = message
 		if data != nil {
 			j.Data = data
 		}
-		if (status == "success" || status == "error") && j.CompletedAt == nil {
+		if IsTerminal(status) && j.CompletedAt == nil {
 			now := time.Now()
 			j.CompletedAt = &now
 		}
 		s.broadcastLocked(id)
+		s.persistLocked(id)
+	}
+}
+
+// SetLabel attaches a client-supplied label to a job
+func (s *Store) SetLabel(id, label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		j.Label = label
+		s.persistLocked(id)
+	}
+}
+
+// SetSummary attaches a JSON summary of a job's failures, sent with its
+// status alongside the results
+func (s *Store) SetSummary(id string, summary json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		j.Summary = summary
+		s.persistLocked(id)
+	}
+}
+
+// SetCancel registers the function that stops a running job's work
+func (s *Store) SetCancel(id string, cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		j.cancel = cancel
 	}
 }
 
+// Cancel stops a running job and marks it "cancelled"
+// The status sticks: updates the job's own goroutine makes afterwards are ignored.
+func (s *Store) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if IsTerminal(j.Status) {
+		return ErrJobFinished
+	}
+
+	if j.cancel != nil {
+		j.cancel()
+	}
+	j.Status = "cancelled"
+	j.Message = "Job cancelled"
+	now := time.Now()
+	j.CompletedAt = &now
+	s.broadcastLocked(id)
+	s.persistLocked(id)
+	return nil
+}
+
 func (s *Store) UpdateProgress(id string, p int) {
 	if p < 0 {
 		p = 0
@@ -76,27 +193,42 @@ func (s *Store) UpdateProgress(id string, p int) {
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if j, ok := s.jobs[id]; ok {
+	if j, ok := s.jobs[id]; ok && j.Status != "cancelled" {
 		j.Progress = p
 		s.broadcastLocked(id)
 	}
 }
 
+// SetHashtableProgress records which hashtables a job has finished and is
+// working on, and sets Progress to the share completed
+func (s *Store) SetHashtableProgress(id string, progress HashtableProgress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok && j.Status != "cancelled" {
+		j.Hashtables = &progress
+		if progress.Total > 0 {
+			j.Progress = progress.Completed * 100 / progress.Total
+		}
+		s.broadcastLocked(id)
+	}
+}
+
 func (s *Store) UpdateWithOperation(id, status, message string, data map[string]string, operation string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if j, ok := s.jobs[id]; ok {
+	if j, ok := s.jobs[id]; ok && j.Status != "cancelled" {
 		j.Status = status
 		j.Message = message
 		if data != nil {
 			j.Data = data
 		}
 		j.Operation = operation
-		if (status == "success" || status == "error") && j.CompletedAt == nil {
+		if IsTerminal(status) && j.CompletedAt == nil {
 			now := time.Now()
 			j.CompletedAt = &now
 		}
 		s.broadcastLocked(id)
+		s.persistLocked(id)
 	}
 }
 
@@ -105,30 +237,73 @@ func (s *Store) SetResults(id string, results interface{}) {
 	defer s.mu.Unlock()
 	if j, ok := s.jobs[id]; ok {
 		j.Results = results
+		j.Partial = false
+		s.persistLocked(id)
 	}
 }
 
-func (s *Store) Subscribe(id string) (<-chan *Job, func()) {
-	ch := make(chan *Job, 10)
+// SetPartialResults stores an in-progress snapshot of a running job's results
+// It is ignored once final results have been set. A persistent store saves
+// the snapshot, so it outlives a restart.
+func (s *Store) SetPartialResults(id string, results interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok && (j.Results == nil || j.Partial) {
+		j.Results = results
+		j.Partial = true
+		s.persistLocked(id)
+	}
+}
 
+// SetFilesDir hands a job's upload directory to the store, so endpoints can
+// read the uploaded files after validation. The directory is removed along
+// with the job, or right away if the job no longer exists.
+func (s *Store) SetFilesDir(id, dir string) {
 	s.mu.Lock()
-	s.watchers[id] = append(s.watchers[id], ch)
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		os.RemoveAll(dir)
+		return
+	}
+	j.FilesDir = dir
+	s.persistLocked(id)
+}
+
+// PublishResult sends watchers of a running job a piece of its results as
+// soon as it is known, as the update's Delta. The job's accumulated Results
+// are left alone. Like progress updates, deltas are dropped for watchers that
+// fall behind.
+func (s *Store) PublishResult(id string, partial interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	job := s.jobs[id]
-	s.mu.Unlock()
+	if job == nil || IsTerminal(job.Status) {
+		return
+	}
 
-	if job != nil {
-		jobCopy := &Job{
-			Status:    job.Status,
-			Message:   job.Message,
-			Data:      make(map[string]string),
-			Progress:  job.Progress,
-			Operation: job.Operation,
-		}
-		for k, v := range job.Data {
-			jobCopy.Data[k] = v
+	jobCopy := snapshot(job)
+	jobCopy.Delta = partial
+	for _, ch := range s.watchers[id] {
+		select {
+		case ch <- jobCopy:
+		default:
 		}
-		ch <- jobCopy
 	}
+}
+
+// Subscribe returns a channel of job updates, starting with the current state
+// A job that is already terminal yields its final state immediately
+func (s *Store) Subscribe(id string) (<-chan *Job, func()) {
+	ch := make(chan *Job, 10)
+
+	// Queue the snapshot under the lock so no update can overtake it
+	s.mu.Lock()
+	s.watchers[id] = append(s.watchers[id], ch)
+	if job := s.jobs[id]; job != nil {
+		ch <- snapshot(job)
+	}
+	s.mu.Unlock()
 
 	unsubscribe := func() {
 		s.mu.Lock()
@@ -152,28 +327,56 @@ func (s *Store) broadcastLocked(id string) {
 		return
 	}
 
+	jobCopy := snapshot(job)
+	terminal := IsTerminal(job.Status)
+
+	for _, ch := range s.watchers[id] {
+		select {
+		case ch <- jobCopy:
+		default:
+			if !terminal {
+				continue
+			}
+			// Never drop the final state: make room by discarding the oldest update
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- jobCopy:
+			default:
+			}
+		}
+	}
+}
+
+// snapshot copies the fields of a job that are sent to watchers
+func snapshot(job *Job) *Job {
 	jobCopy := &Job{
 		Status:    job.Status,
 		Message:   job.Message,
 		Data:      make(map[string]string),
 		Progress:  job.Progress,
 		Operation: job.Operation,
+		Label:     job.Label,
 	}
 	for k, v := range job.Data {
 		jobCopy.Data[k] = v
 	}
-
-	for _, ch := range s.watchers[id] {
-		select {
-		case ch <- jobCopy:
-		default:
-		}
+	if job.Hashtables != nil {
+		hashtables := *job.Hashtables
+		hashtables.InFlight = append([]string{}, job.Hashtables.InFlight...)
+		jobCopy.Hashtables = &hashtables
 	}
+	return jobCopy
 }
 
 func (s *Store) Cleanup(id string) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	var filesDir string
+	if j, ok := s.jobs[id]; ok {
+		filesDir = j.FilesDir
+	}
 
 	for _, ch := range s.watchers[id] {
 		close(ch)
@@ -181,31 +384,53 @@ func (s *Store) Cleanup(id string) {
 
 	delete(s.watchers, id)
 	delete(s.jobs, id)
+	s.removeLocked(id)
+	s.mu.Unlock()
+
+	// Uploads can be large, so they are deleted without holding the lock
+	if filesDir != "" {
+		os.RemoveAll(filesDir)
+	}
 }
 
+// startCleanup starts reaping finished jobs in the background, unless the
+// store keeps them forever
 func (s *Store) startCleanup() {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		s.cleanupOldJobs()
+	if s.ttl <= 0 || s.cleanupInterval <= 0 {
+		return
 	}
+
+	go func() {
+		ticker := time.NewTicker(s.cleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.cleanupOldJobs()
+		}
+	}()
 }
 
 func (s *Store) cleanupOldJobs() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	now := time.Now()
-	ttl := 5 * time.Minute
+	var filesDirs []string
 
 	for id, job := range s.jobs {
-		if job.CompletedAt != nil && now.Sub(*job.CompletedAt) > ttl {
+		if job.CompletedAt != nil && now.Sub(*job.CompletedAt) > s.ttl {
 			// Only cleanup if there are no active watchers
 			if len(s.watchers[id]) == 0 {
+				if job.FilesDir != "" {
+					filesDirs = append(filesDirs, job.FilesDir)
+				}
 				delete(s.jobs, id)
 				delete(s.watchers, id)
+				s.removeLocked(id)
 			}
 		}
 	}
+	s.mu.Unlock()
+
+	for _, dir := range filesDirs {
+		os.RemoveAll(dir)
+	}
 }
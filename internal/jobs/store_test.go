@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscribeToTerminalJob(t *testing.T) {
+	store := NewStore(DefaultTTL, DefaultCleanupInterval)
+	store.Create("job")
+	store.Update("job", "success", "Validation complete", nil)
+
+	ch, unsubscribe := store.Subscribe("job")
+	defer unsubscribe()
+
+	select {
+	case job := <-ch:
+		if job.Status != "success" {
+			t.Errorf("first update status = %q, want %q", job.Status, "success")
+		}
+	default:
+		t.Fatal("Subscribe() did not send the current state")
+	}
+}
+
+func TestBroadcastKeepsTerminalUpdate(t *testing.T) {
+	store := NewStore(DefaultTTL, DefaultCleanupInterval)
+	store.Create("job")
+
+	ch, unsubscribe := store.Subscribe("job")
+	defer unsubscribe()
+
+	// Fill the watcher's buffer without draining it
+	for i := 0; i < 20; i++ {
+		store.UpdateProgress("job", i)
+	}
+	store.Update("job", "error", "Validation failed", nil)
+
+	var last *Job
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	if last == nil || last.Status != "error" {
+		t.Errorf("last update = %+v, want status %q", last, "error")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	store := NewStore(DefaultTTL, DefaultCleanupInterval)
+	store.Create("job")
+	ctx, cancel := context.WithCancel(context.Background())
+	store.SetCancel("job", cancel)
+	store.UpdateWithOperation("job", "running", "Validating", nil, "validating")
+
+	if err := store.Cancel("job"); err != nil {
+		t.Fatalf("Cancel() failed: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("Cancel() did not cancel the job's context")
+	}
+
+	// The job's goroutine finishing late must not overwrite the cancellation
+	store.Update("job", "success", "Validation complete", nil)
+	job, _ := store.Get("job")
+	if job.Status != "cancelled" || job.CompletedAt == nil {
+		t.Errorf("status = %q, CompletedAt = %v, want cancelled and set", job.Status, job.CompletedAt)
+	}
+
+	if err := store.Cancel("job"); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Cancel() of a cancelled job = %v, want ErrJobFinished", err)
+	}
+	if err := store.Cancel("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Cancel() of an unknown job = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	store := NewStore(DefaultTTL, DefaultCleanupInterval)
+	store.Create("a")
+	store.Create("b")
+	store.Update("b", "success", "done", nil)
+
+	jobs := store.List()
+	if len(jobs) != 2 || jobs["a"].Status != "pending" || jobs["b"].Status != "success" || jobs["b"].CompletedAt == nil {
+		t.Fatalf("List() = %+v, want pending a and completed b", jobs)
+	}
+
+	listed := jobs["a"]
+	listed.Status = "error"
+	if job, _ := store.Get("a"); job.Status != "pending" {
+		t.Errorf("changing a listed copy changed the stored job to %q", job.Status)
+	}
+}
+
+func TestCleanupOldJobsUsesTTL(t *testing.T) {
+	store := NewStore(time.Hour, 0)
+	store.Create("recent")
+	store.Update("recent", "success", "done", nil)
+	store.Create("old")
+	store.Update("old", "success", "done", nil)
+	store.Create("watched")
+	store.Update("watched", "success", "done", nil)
+	store.Create("running")
+
+	// Backdate two jobs past the TTL
+	expired := time.Now().Add(-2 * time.Hour)
+	for _, id := range []string{"old", "watched"} {
+		job, _ := store.Get(id)
+		job.CompletedAt = &expired
+	}
+	_, unsubscribe := store.Subscribe("watched")
+
+	store.cleanupOldJobs()
+	for id, want := range map[string]bool{"recent": true, "old": false, "watched": true, "running": true} {
+		if _, ok := store.Get(id); ok != want {
+			t.Errorf("job %s kept = %v, want %v", id, ok, want)
+		}
+	}
+
+	// Once its watcher leaves, the expired job goes too
+	unsubscribe()
+	store.cleanupOldJobs()
+	if _, ok := store.Get("watched"); ok {
+		t.Error("expired job kept after its watcher unsubscribed")
+	}
+}
+
+func TestPublishResult(t *testing.T) {
+	store := NewStore(DefaultTTL, DefaultCleanupInterval)
+	store.Create("job")
+	store.Update("job", "running", "Validating", nil)
+	store.SetPartialResults("job", "accumulated")
+
+	ch, unsubscribe := store.Subscribe("job")
+	defer unsubscribe()
+	<-ch // Current state
+
+	store.PublishResult("job", "3.22.4.2-rmpp done")
+	select {
+	case update := <-ch:
+		if update.Delta != "3.22.4.2-rmpp done" || update.Status != "running" {
+			t.Errorf("update = %+v, want the delta on a running update", update)
+		}
+	default:
+		t.Fatal("PublishResult() sent nothing")
+	}
+
+	job, _ := store.Get("job")
+	if job.Results != "accumulated" || job.Delta != nil {
+		t.Errorf("stored job Results = %v, Delta = %v, want accumulated results and no delta", job.Results, job.Delta)
+	}
+
+	// Later updates don't repeat the delta, and finished jobs get no more
+	store.UpdateProgress("job", 50)
+	if update := <-ch; update.Delta != nil {
+		t.Errorf("progress update carries delta %v", update.Delta)
+	}
+	store.Update("job", "success", "done", nil)
+	<-ch
+	store.PublishResult("job", "late")
+	select {
+	case update := <-ch:
+		t.Errorf("finished job sent delta update %+v", update)
+	default:
+	}
+}
+
+func TestSetHashtableProgress(t *testing.T) {
+	store := NewStore(DefaultTTL, DefaultCleanupInterval)
+	store.Create("job")
+
+	ch, unsubscribe := store.Subscribe("job")
+	defer unsubscribe()
+	<-ch // Current state
+
+	inFlight := []string{"3.20.0.52-rm2", "3.22.4.2-rmpp"}
+	store.SetHashtableProgress("job", HashtableProgress{Completed: 5, Total: 12, InFlight: inFlight, LastCompleted: "3.18.1.1-rm2"})
+	update := <-ch
+	if update.Progress != 41 || update.Hashtables == nil || update.Hashtables.Completed != 5 || update.Hashtables.Total != 12 {
+		t.Fatalf("update = %+v (hashtables %+v), want progress 41 with 5/12 hashtables", update, update.Hashtables)
+	}
+
+	// Watchers get their own copy of the in-flight list
+	inFlight[0] = "changed"
+	if update.Hashtables.InFlight[0] != "3.20.0.52-rm2" {
+		t.Errorf("in_flight = %v, want it unaffected by later changes", update.Hashtables.InFlight)
+	}
+}
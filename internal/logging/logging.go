@@ -1,8 +1,13 @@
 package logging
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,26 +22,218 @@ const (
 	ComponentHandler  Component = "HANDLER"
 )
 
-func Info(component Component, message string, args ...interface{}) {
+// Level is the minimum severity a log entry needs to be written
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+var (
+	minLevel   atomic.Int32 // Level; the zero value would be debug, so init sets info
+	jsonFormat atomic.Bool
+	jsonMu     sync.Mutex // Keeps JSON lines whole when written concurrently
+)
+
+func init() {
+	minLevel.Store(int32(LevelInfo))
+}
+
+// SetLevel sets the minimum level written from "debug", "info", "warn" or
+// "error". An unknown name leaves the level unchanged and returns an error.
+func SetLevel(name string) error {
+	level, ok := levelNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return fmt.Errorf("unknown log level %q, want debug, info, warn or error", name)
+	}
+	minLevel.Store(int32(level))
+	return nil
+}
+
+func enabled(level Level) bool {
+	return Level(minLevel.Load()) <= level
+}
+
+// SetFormat selects the log output format: "json" writes one JSON object per
+// line for log aggregators, anything else the default human-readable text
+func SetFormat(format string) {
+	jsonFormat.Store(strings.EqualFold(strings.TrimSpace(format), "json"))
+}
+
+// emit writes one log entry in the selected format
+// In text mode fields are appended to the message as key=value pairs.
+func emit(component Component, level, message string, fields map[string]any) {
+	if jsonFormat.Load() {
+		entry := make(map[string]any, len(fields)+4)
+		for key, value := range fields {
+			entry[key] = value
+		}
+		entry["timestamp"] = time.Now().Format(time.RFC3339)
+		entry["level"] = strings.ToLower(level)
+		entry["component"] = component
+		entry["message"] = message
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			line, _ = json.Marshal(map[string]any{
+				"timestamp": entry["timestamp"],
+				"level":     entry["level"],
+				"component": component,
+				"message":   fmt.Sprintf("%s (fields not encodable: %v)", message, err),
+			})
+		}
+		jsonMu.Lock()
+		defer jsonMu.Unlock()
+		log.Writer().Write(append(line, '\n'))
+		return
+	}
+
+	if len(fields) > 0 {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			message += fmt.Sprintf(" %s=%v", key, fields[key])
+		}
+	}
+	prefix := ""
+	if level != "INFO" {
+		prefix = level + ": "
+	}
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(message, args...)
-	log.Printf("[%s] [%s] %s", timestamp, component, msg)
+	log.Printf("[%s] [%s] %s%s", timestamp, component, prefix, message)
+}
+
+func Info(component Component, message string, args ...interface{}) {
+	if !enabled(LevelInfo) {
+		return
+	}
+	emit(component, "INFO", fmt.Sprintf(message, args...), nil)
 }
 
 func Error(component Component, message string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(message, args...)
-	log.Printf("[%s] [%s] ERROR: %s", timestamp, component, msg)
+	if !enabled(LevelError) {
+		return
+	}
+	emit(component, "ERROR", fmt.Sprintf(message, args...), nil)
 }
 
 func Warn(component Component, message string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(message, args...)
-	log.Printf("[%s] [%s] WARN: %s", timestamp, component, msg)
+	if !enabled(LevelWarn) {
+		return
+	}
+	emit(component, "WARN", fmt.Sprintf(message, args...), nil)
+}
+
+// InfoKV logs message with key/value context, such as a job ID or filename,
+// as separate JSON fields or trailing key=value pairs in text mode
+func InfoKV(component Component, message string, fields map[string]any) {
+	if !enabled(LevelInfo) {
+		return
+	}
+	emit(component, "INFO", message, fields)
+}
+
+// WarnKV logs a warning with key/value context, see InfoKV
+func WarnKV(component Component, message string, fields map[string]any) {
+	if !enabled(LevelWarn) {
+		return
+	}
+	emit(component, "WARN", message, fields)
+}
+
+// ErrorKV logs an error with key/value context, see InfoKV
+func ErrorKV(component Component, message string, fields map[string]any) {
+	if !enabled(LevelError) {
+		return
+	}
+	emit(component, "ERROR", message, fields)
+}
+
+var (
+	debugMu         sync.RWMutex
+	debugComponents map[Component]bool // nil enables debug for every component
+
+	debugSampleEvery atomic.Int64
+	debugCounters    sync.Map // key -> *atomic.Int64
+)
+
+// SetDebugComponents limits debug output to the named components
+// An empty list or "all" enables every component; "none" disables debug output
+func SetDebugComponents(names []string) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	debugComponents = nil
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		switch name {
+		case "", "ALL", "*":
+			debugComponents = nil
+			return
+		case "NONE":
+			debugComponents = map[Component]bool{}
+		default:
+			if debugComponents == nil {
+				debugComponents = map[Component]bool{}
+			}
+			debugComponents[Component(name)] = true
+		}
+	}
+}
+
+// SetDebugSampleEvery makes DebugSampled log only the first and every nth
+// call for each key; n <= 1 logs every call
+func SetDebugSampleEvery(n int) {
+	debugSampleEvery.Store(int64(n))
+}
+
+// DebugEnabled reports whether debug output is enabled for a component,
+// which needs LOG_LEVEL=debug as well as the component being selected
+func DebugEnabled(component Component) bool {
+	if !enabled(LevelDebug) {
+		return false
+	}
+	debugMu.RLock()
+	defer debugMu.RUnlock()
+	return debugComponents == nil || debugComponents[component]
+}
+
+// DebugSampled logs like Debug, but is for per-item statements in hot loops
+// Calls sharing a key are sampled according to SetDebugSampleEvery
+func DebugSampled(component Component, key string, message string, args ...interface{}) {
+	if !DebugEnabled(component) {
+		return
+	}
+
+	every := debugSampleEvery.Load()
+	if every > 1 {
+		counter, _ := debugCounters.LoadOrStore(key, new(atomic.Int64))
+		n := counter.(*atomic.Int64).Add(1)
+		if (n-1)%every != 0 {
+			return
+		}
+		message = fmt.Sprintf("%s (sampled 1/%d)", message, every)
+	}
+
+	Debug(component, message, args...)
 }
 
 func Debug(component Component, message string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(message, args...)
-	log.Printf("[%s] [%s] DEBUG: %s", timestamp, component, msg)
+	if !DebugEnabled(component) {
+		return
+	}
+	emit(component, "DEBUG", fmt.Sprintf(message, args...), nil)
 }
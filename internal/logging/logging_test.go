@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func captureLog(t *testing.T, fn func()) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	SetLevel("debug")
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		SetLevel("info")
+		SetDebugComponents(nil)
+		SetDebugSampleEvery(1)
+		SetFormat("text")
+	})
+
+	fn()
+	return buf.String()
+}
+
+func TestSetDebugComponents(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []string
+		wantQMD    bool
+		wantServer bool
+	}{
+		{"default enables all", nil, true, true},
+		{"all keyword", []string{"all"}, true, true},
+		{"single component", []string{"qmd"}, true, false},
+		{"none", []string{"none"}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureLog(t, func() {
+				SetDebugComponents(tt.components)
+				Debug(ComponentQMD, "qmd message")
+				Debug(ComponentServer, "server message")
+			})
+
+			if got := strings.Contains(out, "qmd message"); got != tt.wantQMD {
+				t.Errorf("QMD debug logged = %v, want %v", got, tt.wantQMD)
+			}
+			if got := strings.Contains(out, "server message"); got != tt.wantServer {
+				t.Errorf("SERVER debug logged = %v, want %v", got, tt.wantServer)
+			}
+		})
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	tests := []struct {
+		level string
+		want  []string
+	}{
+		{"debug", []string{"debug message", "info message", "warn message", "error message"}},
+		{"info", []string{"info message", "warn message", "error message"}},
+		{"WARN", []string{"warn message", "error message"}},
+		{"error", []string{"error message"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			out := captureLog(t, func() {
+				if err := SetLevel(tt.level); err != nil {
+					t.Fatalf("SetLevel(%q) failed: %v", tt.level, err)
+				}
+				Debug(ComponentQMD, "debug message")
+				InfoKV(ComponentQMD, "info message", nil)
+				Warn(ComponentQMD, "warn message")
+				Error(ComponentQMD, "error message")
+			})
+
+			if got := strings.Count(out, "\n"); got != len(tt.want) {
+				t.Errorf("logged %d line(s), want %d:\n%s", got, len(tt.want), out)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output missing %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+
+	captureLog(t, func() {
+		if err := SetLevel("verbose"); err == nil {
+			t.Error("SetLevel(\"verbose\") succeeded, want an error")
+		}
+		if !DebugEnabled(ComponentQMD) {
+			t.Error("an unknown level changed the current level")
+		}
+	})
+}
+
+func TestDebugSampled(t *testing.T) {
+	out := captureLog(t, func() {
+		SetDebugSampleEvery(3)
+		for i := 0; i < 7; i++ {
+			DebugSampled(ComponentQMD, "test.sampled", "item %d", i)
+		}
+	})
+
+	for _, want := range []string{"item 0", "item 3", "item 6"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "item 1") {
+		t.Errorf("output contains unsampled item 1:\n%s", out)
+	}
+	if got := strings.Count(out, "(sampled 1/3)"); got != 3 {
+		t.Errorf("sampled marker count = %d, want 3", got)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	out := captureLog(t, func() {
+		SetFormat("JSON")
+		Warn(ComponentHandler, "job %s slow", "abc")
+		InfoKV(ComponentHandler, "Created compare job", map[string]any{"job_id": "abc", "level": "ignored"})
+	})
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), out)
+	}
+	var warn, info map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &warn); err != nil {
+		t.Fatalf("line 1 is not JSON: %v\n%s", err, lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &info); err != nil {
+		t.Fatalf("line 2 is not JSON: %v\n%s", err, lines[1])
+	}
+
+	if warn["level"] != "warn" || warn["component"] != "HANDLER" || warn["message"] != "job abc slow" || warn["timestamp"] == nil {
+		t.Errorf("warn entry = %v", warn)
+	}
+	// Context can't override the standard fields
+	if info["level"] != "info" || info["job_id"] != "abc" || info["message"] != "Created compare job" {
+		t.Errorf("info entry = %v", info)
+	}
+}
+
+func TestTextFormatWithContext(t *testing.T) {
+	out := captureLog(t, func() {
+		ErrorKV(ComponentQMD, "Validation failed", map[string]any{"job_id": "abc", "file": "mod.qmd"})
+	})
+
+	if !strings.Contains(out, "[QMD] ERROR: Validation failed file=mod.qmd job_id=abc") {
+		t.Errorf("text output = %q, want the message with sorted key=value context", out)
+	}
+}
@@ -1,10 +1,12 @@
 package qmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
@@ -16,47 +18,45 @@ type DependencyInfo struct {
 	ExpectedLoads []string            // All files expected to be LOADed (in discovered order)
 	LoadOrder     map[string]int      // Map of file path to first occurrence position
 	LoadGraph     map[string][]string // Parent file -> child files loaded by it
+	SelfLoads     []string            // Files that LOAD themselves (relative to the root file directory)
+	NotUploaded   []string            // LOAD targets that do not exist (relative to the root file directory)
+	OutsideRoot   []string            // LOAD targets outside the root file directory, e.g. "../shared/base.qmd"; never read
+	ExternalLoads []string            // LOAD EXTERNAL targets as written, in discovered order; not validated
 }
 
+// loadRegex matches LOAD statements, which may be indented and in any case,
+// capturing the first argument and, for LOAD EXTERNAL, the second
+// Arguments stop at whitespace, so the CR of a CRLF line ending is left out;
+// LOADER and other words merely starting with LOAD need whitespace after LOAD.
+var loadRegex = regexp.MustCompile(`(?m)^[ \t]*(?i:LOAD)[ \t]+(\S+)(?:[ \t]+(\S+))?`)
+
 // ExtractLoadStatements parses a QMD file and extracts LOAD statements
 // Returns the list of file paths in the order they appear
 func ExtractLoadStatements(qmdPath string) ([]string, error) {
+	loads, _, err := ExtractLoads(qmdPath)
+	return loads, err
+}
+
+// ExtractLoads parses a QMD file's LOAD statements, returning the paths it
+// LOADs and, separately, its LOAD EXTERNAL targets, each in file order
+// External targets are not part of the upload, so they are not validated.
+func ExtractLoads(qmdPath string) (loads, external []string, err error) {
 	content, err := os.ReadFile(qmdPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Regex to match LOAD statements (but not LOAD EXTERNAL)
-	// Matches: "LOAD <path>" at the start of a line
-	loadRegex := regexp.MustCompile(`(?m)^LOAD\s+([^\s]+)`)
-
-	// Exclude LOAD EXTERNAL
-	loadExternalRegex := regexp.MustCompile(`(?m)^LOAD\s+EXTERNAL`)
-
-	contentStr := string(content)
-	loads := []string{}
-
-	// Find all LOAD statements
-	matches := loadRegex.FindAllStringSubmatch(contentStr, -1)
-	positions := loadRegex.FindAllStringSubmatchIndex(contentStr, -1)
-
-	for i, match := range matches {
-		if len(match) > 1 {
-			// Check if this is LOAD EXTERNAL by examining the full match
-			pos := positions[i]
-			lineStart := pos[0]
-			lineEnd := pos[1]
-			fullLine := contentStr[lineStart:lineEnd]
-
-			if !loadExternalRegex.MatchString(fullLine) {
-				loadPath := strings.TrimSpace(match[1])
-				loads = append(loads, loadPath)
-			}
+	loads = []string{}
+	for _, match := range loadRegex.FindAllStringSubmatch(string(content), -1) {
+		if !strings.EqualFold(match[1], "EXTERNAL") {
+			loads = append(loads, match[1])
+		} else if match[2] != "" {
+			external = append(external, match[2])
 		}
 	}
 
-	logging.Debug(logging.ComponentQMD, "Extracted %d LOAD statements from %s", len(loads), qmdPath)
-	return loads, nil
+	logging.Debug(logging.ComponentQMD, "Extracted %d LOAD and %d LOAD EXTERNAL statements from %s", len(loads), len(external), qmdPath)
+	return loads, external, nil
 }
 
 // BuildDependencyInfo creates a complete dependency map for a QMD file by recursively
@@ -67,6 +67,10 @@ func BuildDependencyInfo(qmdPath string) (*DependencyInfo, error) {
 	loadOrder := make(map[string]int)
 	loadGraph := make(map[string][]string)
 	visited := make(map[string]bool)
+	selfLoads := []string{}
+	notUploaded := []string{}
+	outsideRoot := []string{}
+	externalLoads := []string{}
 
 	// Get root file directory for path normalization
 	rootDir := filepath.Dir(qmdPath)
@@ -93,20 +97,31 @@ func BuildDependencyInfo(qmdPath string) (*DependencyInfo, error) {
 		}
 
 		// Extract LOAD statements from current file
-		loads, err := ExtractLoadStatements(current.filePath)
+		loads, external, err := ExtractLoads(current.filePath)
 		if err != nil {
 			// File not found or read error - log warning but continue
 			logging.Warn(logging.ComponentQMD, "Cannot read file %s: %v", current.filePath, err)
+			if current.depth > 0 && os.IsNotExist(err) {
+				if rel, relErr := filepath.Rel(rootDir, current.filePath); relErr == nil {
+					notUploaded = append(notUploaded, rel)
+				}
+			}
 			continue
 		}
 
+		for _, target := range external {
+			if !slices.Contains(externalLoads, target) {
+				externalLoads = append(externalLoads, target)
+			}
+		}
+
 		// Track the children of this file
 		children := []string{}
 
 		// Process each LOAD statement
 		for _, loadPath := range loads {
-			// Resolve relative to current file
-			resolvedPath := ResolveLoadPath(current.filePath, loadPath)
+			// Resolve relative to current file, which must not leave the upload
+			resolvedPath, resolveErr := ResolveLoadPathWithin(rootDir, current.filePath, loadPath)
 
 			// Normalize path to be relative to root file directory
 			normalizedPath, err := filepath.Rel(rootDir, resolvedPath)
@@ -116,6 +131,25 @@ func BuildDependencyInfo(qmdPath string) (*DependencyInfo, error) {
 				normalizedPath = filepath.Base(resolvedPath)
 			}
 
+			// A file loading itself is an authoring error rather than a real cycle
+			if resolvedPath == filepath.Clean(current.filePath) {
+				logging.Warn(logging.ComponentQMD, "File %s LOADs itself", normalizedPath)
+				selfLoads = append(selfLoads, normalizedPath)
+				continue
+			}
+
+			if IsExcluded(normalizedPath) {
+				logging.Debug(logging.ComponentQMD, "Skipping excluded LOAD target %s", normalizedPath)
+				continue
+			}
+
+			// qmldiff still tries the LOAD, so it keeps its place in the order,
+			// but whatever is there wasn't uploaded and is not read
+			if errors.Is(resolveErr, ErrLoadOutsideRoot) && !slices.Contains(outsideRoot, normalizedPath) {
+				logging.Warn(logging.ComponentQMD, "File %s LOADs %s, which is outside the uploaded folder", current.filePath, loadPath)
+				outsideRoot = append(outsideRoot, normalizedPath)
+			}
+
 			// Track this child using normalized path
 			children = append(children, normalizedPath)
 
@@ -131,6 +165,10 @@ func BuildDependencyInfo(qmdPath string) (*DependencyInfo, error) {
 			loadOrder[normalizedPath] = len(allLoads) - 1
 			visited[resolvedPath] = true
 
+			if resolveErr != nil {
+				continue
+			}
+
 			// Add to queue for processing
 			queue = append(queue, queueItem{
 				filePath:   resolvedPath,
@@ -150,6 +188,10 @@ func BuildDependencyInfo(qmdPath string) (*DependencyInfo, error) {
 		ExpectedLoads: allLoads,
 		LoadOrder:     loadOrder,
 		LoadGraph:     loadGraph,
+		SelfLoads:     selfLoads,
+		NotUploaded:   notUploaded,
+		OutsideRoot:   outsideRoot,
+		ExternalLoads: externalLoads,
 	}
 
 	logging.Info(logging.ComponentQMD, "Built dependency info for %s: %d expected loads (recursive)",
@@ -160,6 +202,7 @@ func BuildDependencyInfo(qmdPath string) (*DependencyInfo, error) {
 
 // GetRootLevelFiles returns only the .qmd files at the root of the given directory
 // (mimics qmldiff's behavior of not recursing into subdirectories)
+// Files matching the exclude patterns are skipped
 func GetRootLevelFiles(baseDir string, allUploadedPaths []string) []string {
 	rootFiles := []string{}
 
@@ -170,6 +213,11 @@ func GetRootLevelFiles(baseDir string, allUploadedPaths []string) []string {
 			continue
 		}
 
+		if IsExcluded(relPath) {
+			logging.Debug(logging.ComponentQMD, "Excluding %s from validation", relPath)
+			continue
+		}
+
 		// Check if file is at root level (no directory separators in relative path)
 		if !strings.Contains(relPath, string(filepath.Separator)) &&
 		   strings.HasSuffix(strings.ToLower(relPath), ".qmd") {
@@ -183,6 +231,27 @@ func GetRootLevelFiles(baseDir string, allUploadedPaths []string) []string {
 	return rootFiles
 }
 
+// CountSubdirectoryFiles counts the .qmd files below the root of baseDir,
+// grouped by the top-level subdirectory they are in
+// Used to explain uploads that GetRootLevelFiles finds nothing in.
+func CountSubdirectoryFiles(baseDir string, allUploadedPaths []string) map[string]int {
+	counts := make(map[string]int)
+
+	for _, path := range allUploadedPaths {
+		relPath, err := filepath.Rel(baseDir, path)
+		if err != nil || IsExcluded(relPath) {
+			continue
+		}
+
+		dir, _, nested := strings.Cut(filepath.ToSlash(relPath), "/")
+		if nested && strings.HasSuffix(strings.ToLower(relPath), ".qmd") {
+			counts[dir]++
+		}
+	}
+
+	return counts
+}
+
 // ResolveLoadPath resolves a LOAD path relative to the loading file
 // Matches qmldiff's path resolution logic
 func ResolveLoadPath(loadingFile string, loadPath string) string {
@@ -194,3 +263,31 @@ func ResolveLoadPath(loadingFile string, loadPath string) string {
 
 	return filepath.Clean(resolved)
 }
+
+// ErrLoadOutsideRoot is returned by ResolveLoadPathWithin for a LOAD target
+// that is not inside the uploaded folder
+var ErrLoadOutsideRoot = errors.New("LOAD target is outside the uploaded folder")
+
+// ResolveLoadPathWithin resolves a LOAD path like ResolveLoadPath, and checks
+// the result is inside root
+// A path escaping root, such as "../shared/base.qmd" from a root-level file,
+// is still returned, with ErrLoadOutsideRoot.
+func ResolveLoadPathWithin(root, loadingFile, loadPath string) (string, error) {
+	resolved := ResolveLoadPath(loadingFile, loadPath)
+
+	rel, err := filepath.Rel(filepath.Clean(root), resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return resolved, ErrLoadOutsideRoot
+	}
+	return resolved, nil
+}
+
+// AttachDependencyWarnings adds authoring warnings found while building the
+// dependency info (such as self-LOADs) to the matching validation results
+func AttachDependencyWarnings(depInfo *DependencyInfo, results map[string]*ValidationResult) {
+	for _, path := range depInfo.SelfLoads {
+		if result, ok := results[path]; ok {
+			result.Warnings = append(result.Warnings, "file LOADs itself")
+		}
+	}
+}
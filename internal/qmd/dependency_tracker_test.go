@@ -0,0 +1,229 @@
+package qmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildDependencyInfoDetectsSelfLoad(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"self.qmd":    "LOAD ./self.qmd\nLOAD lib/dep.qmd\n",
+		"lib/dep.qmd": "LOAD ../self.qmd\nAFFECT [[1]]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	info, err := BuildDependencyInfo(filepath.Join(tmpDir, "self.qmd"))
+	if err != nil {
+		t.Fatalf("BuildDependencyInfo() failed: %v", err)
+	}
+
+	// lib/dep.qmd loading self.qmd back is a cycle, not a self-LOAD
+	if want := []string{"self.qmd"}; !reflect.DeepEqual(info.SelfLoads, want) {
+		t.Errorf("SelfLoads = %v, want %v", info.SelfLoads, want)
+	}
+	if want := []string{"lib/dep.qmd"}; !reflect.DeepEqual(info.ExpectedLoads, want) {
+		t.Errorf("ExpectedLoads = %v, want %v", info.ExpectedLoads, want)
+	}
+
+	results := map[string]*ValidationResult{
+		"self.qmd":    {Path: "self.qmd", Status: StatusValidated, Compatible: true, Position: -1},
+		"lib/dep.qmd": {Path: "lib/dep.qmd", Status: StatusValidated, Compatible: true},
+	}
+	AttachDependencyWarnings(info, results)
+
+	if len(results["self.qmd"].Warnings) != 1 {
+		t.Errorf("self.qmd warnings = %v, want one self-LOAD warning", results["self.qmd"].Warnings)
+	}
+	if len(results["lib/dep.qmd"].Warnings) != 0 {
+		t.Errorf("lib/dep.qmd warnings = %v, want none", results["lib/dep.qmd"].Warnings)
+	}
+}
+
+func TestBuildDependencyInfoRecordsNotUploaded(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"main.qmd":    "LOAD lib/dep.qmd\nLOAD lib/missing.qmd\n",
+		"lib/dep.qmd": "LOAD other.qmd\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	info, err := BuildDependencyInfo(filepath.Join(tmpDir, "main.qmd"))
+	if err != nil {
+		t.Fatalf("BuildDependencyInfo() failed: %v", err)
+	}
+
+	if want := []string{"lib/missing.qmd", "lib/other.qmd"}; !reflect.DeepEqual(info.NotUploaded, want) {
+		t.Errorf("NotUploaded = %v, want %v", info.NotUploaded, want)
+	}
+}
+
+func TestBuildDependencyInfoRecordsOutsideRoot(t *testing.T) {
+	parent := t.TempDir()
+	uploadDir := filepath.Join(parent, "upload")
+
+	files := map[string]string{
+		"upload/main.qmd":    "LOAD ../shared/base.qmd\nLOAD lib/dep.qmd\n",
+		"upload/lib/dep.qmd": "LOAD ../../shared/base.qmd\n",
+		"shared/base.qmd":    "LOAD secret.qmd\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(parent, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	info, err := BuildDependencyInfo(filepath.Join(uploadDir, "main.qmd"))
+	if err != nil {
+		t.Fatalf("BuildDependencyInfo() failed: %v", err)
+	}
+
+	// The target keeps its place in the LOAD order but is never read
+	if want := []string{"../shared/base.qmd", "lib/dep.qmd"}; !reflect.DeepEqual(info.ExpectedLoads, want) {
+		t.Errorf("ExpectedLoads = %v, want %v", info.ExpectedLoads, want)
+	}
+	if want := []string{"../shared/base.qmd"}; !reflect.DeepEqual(info.OutsideRoot, want) {
+		t.Errorf("OutsideRoot = %v, want %v", info.OutsideRoot, want)
+	}
+	if len(info.NotUploaded) != 0 {
+		t.Errorf("NotUploaded = %v, want none", info.NotUploaded)
+	}
+}
+
+func TestResolveLoadPathWithin(t *testing.T) {
+	root := filepath.Join("/tmp", "upload")
+	tests := []struct {
+		loadingFile string
+		loadPath    string
+		want        string
+		outside     bool
+	}{
+		{filepath.Join(root, "main.qmd"), "lib/dep.qmd", filepath.Join(root, "lib", "dep.qmd"), false},
+		{filepath.Join(root, "lib", "dep.qmd"), "../other.qmd", filepath.Join(root, "other.qmd"), false},
+		{filepath.Join(root, "main.qmd"), "../shared/base.qmd", filepath.Join("/tmp", "shared", "base.qmd"), true},
+		{filepath.Join(root, "lib", "dep.qmd"), "../../upload2/x.qmd", filepath.Join("/tmp", "upload2", "x.qmd"), true},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveLoadPathWithin(root, tt.loadingFile, tt.loadPath)
+		if got != tt.want {
+			t.Errorf("ResolveLoadPathWithin(%s, %s) = %s, want %s", tt.loadingFile, tt.loadPath, got, tt.want)
+		}
+		if outside := errors.Is(err, ErrLoadOutsideRoot); outside != tt.outside || (err != nil && !outside) {
+			t.Errorf("ResolveLoadPathWithin(%s, %s) error = %v, want outside %v", tt.loadingFile, tt.loadPath, err, tt.outside)
+		}
+	}
+}
+
+func TestBuildDependencyInfoRecordsExternalLoads(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"main.qmd": "LOAD lib/dep.qmd\n" +
+			"LOAD EXTERNAL /home/root/xovi/shared.qmd\n" +
+			"; LOAD EXTERNAL commented.qmd\n" +
+			"AFFECT [[1]]\n" +
+			"    LOAD lib/indented.qmd\n" +
+			"\tLOAD EXTERNAL /opt/theme.qmd\n",
+		"lib/dep.qmd":      "LOAD EXTERNAL /home/root/xovi/shared.qmd\nLOAD EXTERNAL /opt/fonts.qmd\n",
+		"lib/indented.qmd": "AFFECT [[2]]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	loads, external, err := ExtractLoads(filepath.Join(tmpDir, "main.qmd"))
+	if err != nil {
+		t.Fatalf("ExtractLoads() failed: %v", err)
+	}
+	if want := []string{"lib/dep.qmd", "lib/indented.qmd"}; !reflect.DeepEqual(loads, want) {
+		t.Errorf("ExtractLoads() loads = %v, want %v", loads, want)
+	}
+	if want := []string{"/home/root/xovi/shared.qmd", "/opt/theme.qmd"}; !reflect.DeepEqual(external, want) {
+		t.Errorf("ExtractLoads() external = %v, want %v", external, want)
+	}
+
+	info, err := BuildDependencyInfo(filepath.Join(tmpDir, "main.qmd"))
+	if err != nil {
+		t.Fatalf("BuildDependencyInfo() failed: %v", err)
+	}
+
+	// External loads are listed once each and leave the LOAD order alone
+	if want := []string{"lib/dep.qmd", "lib/indented.qmd"}; !reflect.DeepEqual(info.ExpectedLoads, want) {
+		t.Errorf("ExpectedLoads = %v, want %v", info.ExpectedLoads, want)
+	}
+	if want := []string{"/home/root/xovi/shared.qmd", "/opt/theme.qmd", "/opt/fonts.qmd"}; !reflect.DeepEqual(info.ExternalLoads, want) {
+		t.Errorf("ExternalLoads = %v, want %v", info.ExternalLoads, want)
+	}
+	if len(info.NotUploaded) != 0 {
+		t.Errorf("NotUploaded = %v, want none", info.NotUploaded)
+	}
+}
+
+func TestExtractLoadsTolerantSyntax(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantLoads    []string
+		wantExternal []string
+	}{
+		{"indented", "  LOAD file.qmd\n", []string{"file.qmd"}, nil},
+		{"tab separated", "LOAD\tfile.qmd\n", []string{"file.qmd"}, nil},
+		{"CRLF line endings", "LOAD a.qmd\r\nLOAD b.qmd\r\nAFFECT [[1]]\r\n", []string{"a.qmd", "b.qmd"}, nil},
+		{"trailing whitespace", "LOAD file.qmd \t\n", []string{"file.qmd"}, nil},
+		{"lowercase", "load file.qmd\nLoad other.qmd\n", []string{"file.qmd", "other.qmd"}, nil},
+		{"lowercase external", "load external /usr/share/base.qmd\r\n", []string{}, []string{"/usr/share/base.qmd"}},
+		{"not LOAD", "LOADER file.qmd\nLOADING\nRELOAD file.qmd\n", []string{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "main.qmd")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("WriteFile() failed: %v", err)
+			}
+
+			loads, external, err := ExtractLoads(path)
+			if err != nil {
+				t.Fatalf("ExtractLoads() failed: %v", err)
+			}
+			if !reflect.DeepEqual(loads, tt.wantLoads) {
+				t.Errorf("loads = %q, want %q", loads, tt.wantLoads)
+			}
+			if !reflect.DeepEqual(external, tt.wantExternal) {
+				t.Errorf("external = %q, want %q", external, tt.wantExternal)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package qmd
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultExcludePatterns matches common junk found in uploaded folders
+var DefaultExcludePatterns = []string{".*", "*~", "*.bak"}
+
+var (
+	excludeMu       sync.RWMutex
+	excludePatterns = DefaultExcludePatterns
+)
+
+// SetExcludePatterns replaces the glob patterns used to exclude files from validation
+func SetExcludePatterns(patterns []string) {
+	excludeMu.Lock()
+	defer excludeMu.Unlock()
+	excludePatterns = patterns
+}
+
+// ParseExcludePatterns splits a comma-separated pattern list, dropping empty entries
+func ParseExcludePatterns(value string) []string {
+	patterns := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// IsExcluded reports whether any component of a relative path matches an exclude pattern
+func IsExcluded(relPath string) bool {
+	excludeMu.RLock()
+	defer excludeMu.RUnlock()
+
+	for _, component := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if component == "" || component == "." || component == ".." {
+			continue
+		}
+		for _, pattern := range excludePatterns {
+			if matched, err := path.Match(pattern, component); err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
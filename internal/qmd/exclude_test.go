@@ -0,0 +1,84 @@
+package qmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsExcluded(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"patch.qmd", false},
+		{"lib/patch.qmd", false},
+		{".DS_Store", true},
+		{".hidden.qmd", true},
+		{"backup.qmd~", true},
+		{"old.qmd.bak", true},
+		{".git/config.qmd", true},
+		{"../patch.qmd", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := IsExcluded(tt.path); got != tt.want {
+				t.Errorf("IsExcluded(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRootLevelFilesSkipsJunk(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	names := []string{"a.qmd", "b.qmd", ".hidden.qmd", ".DS_Store", "backup.qmd~", "old.qmd.bak", "README", "lib/dep.qmd"}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(paths[i]), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(paths[i], []byte("AFFECT [[1]]\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	got := GetRootLevelFiles(tmpDir, paths)
+	want := []string{filepath.Join(tmpDir, "a.qmd"), filepath.Join(tmpDir, "b.qmd")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRootLevelFiles() = %v, want %v", got, want)
+	}
+
+	// An upload of nothing but junk leaves no root files
+	if got := GetRootLevelFiles(tmpDir, paths[2:6]); len(got) != 0 {
+		t.Errorf("GetRootLevelFiles() of junk only = %v, want none", got)
+	}
+}
+
+func TestBuildDependencyInfoSkipsExcludedLoads(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"root.qmd":    "LOAD dep.qmd\nLOAD dep.qmd.bak\n",
+		"dep.qmd":     "AFFECT [[1]]\n",
+		"dep.qmd.bak": "AFFECT [[2]]\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	info, err := BuildDependencyInfo(filepath.Join(tmpDir, "root.qmd"))
+	if err != nil {
+		t.Fatalf("BuildDependencyInfo() failed: %v", err)
+	}
+
+	want := []string{"dep.qmd"}
+	if !reflect.DeepEqual(info.ExpectedLoads, want) {
+		t.Errorf("ExpectedLoads = %v, want %v", info.ExpectedLoads, want)
+	}
+}
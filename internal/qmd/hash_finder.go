@@ -2,68 +2,310 @@ package qmd
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
 )
 
+// hashReferenceRegex matches hashed identifiers in QMD diffs, e.g. [[214620122227]]
+var hashReferenceRegex = regexp.MustCompile(`\[\[(\d+)\]\]`)
+
+// anyHashReferenceRegex also matches the ~&hash&~ form used inside QML blocks
+var anyHashReferenceRegex = regexp.MustCompile(`\[\[(\d+)\]\]|~&(\d+)&~`)
+
+// directiveRegex matches the directives that make a QMD file do something
+var directiveRegex = regexp.MustCompile(`(?m)^\s*(AFFECT|(?i:LOAD))\b`)
+
+// affectRegex matches AFFECT directives and captures their target
+var affectRegex = regexp.MustCompile(`(?m)^\s*AFFECT\s+(\S+)`)
+
+// NoDiffsMessage is reported for QMD files without any diffs or hashes
+const NoDiffsMessage = "file contains no diffs"
+
+// hashScanChunkSize is how much of a QMD file FindHashPositionsReader reads at a time
+var hashScanChunkSize = 64 * 1024
+
+// hashLexMaxSize is the largest QMD file FindHashPositionsInFile reads whole
+// to lex; larger files are streamed through the text scan instead
+var hashLexMaxSize int64 = 4 << 20
+
 // FindHashPositions searches a QMD file for specific hash IDs and returns their positions
-// Just searches for the hash ID as a decimal string anywhere in the file
+// Only hash references count, [[hash]] in the diff and ~&hash&~ in QML
+// blocks, so an ID mentioned in a comment or string is not reported. Files
+// that fail to lex fall back to a plain text scan. Reports the first
+// occurrence of each hash, positioned at its digits.
 func FindHashPositions(qmdContent string, failedHashes []uint64) []HashWithPosition {
 	if len(failedHashes) == 0 {
 		return nil
 	}
 
-	// Build a map of hash strings to search for
+	refs, ok := hashReferences(qmdContent)
+	if !ok {
+		return findHashPositionsText(qmdContent, failedHashes)
+	}
+
+	hashStrings := make(map[string]uint64, len(failedHashes))
+	for _, hash := range failedHashes {
+		hashStrings[strconv.FormatUint(hash, 10)] = hash
+	}
+	results := make([]HashWithPosition, 0, len(hashStrings))
+
+	// References come in file order, so the line and column only move forward
+	line, col, scanned := 1, 1, 0
+	for _, ref := range refs {
+		if len(hashStrings) == 0 {
+			break
+		}
+		hashID, ok := hashStrings[ref.digits]
+		if !ok {
+			continue
+		}
+		delete(hashStrings, ref.digits)
+
+		for ; scanned < ref.offset; scanned++ {
+			if qmdContent[scanned] == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+		reportedLine, reportedCol, approximate := clampPosition(line, col)
+		results = append(results, HashWithPosition{
+			Hash:        hashID,
+			Line:        reportedLine,
+			Column:      reportedCol,
+			Offset:      ref.offset,
+			Approximate: approximate,
+		})
+	}
+
+	return results
+}
+
+// hashReference is a hash reference in a QMD file: the ID's decimal digits
+// and their byte offset
+type hashReference struct {
+	digits string
+	offset int
+}
+
+// hashReferences lexes a QMD file and returns its hash references in file
+// order, [[hash]] in the diff and ~&hash&~ in QML blocks. ok is false when
+// the file fails to lex.
+func hashReferences(qmdContent string) (refs []hashReference, ok bool) {
+	tokens, errs := Lex(qmdContent)
+	if len(errs) > 0 {
+		return nil, false
+	}
+
+	for _, token := range tokens {
+		switch token.Kind {
+		case TokenHash:
+			refs = append(refs, hashReference{token.Value, token.Offset + len("[[")})
+		case TokenBlock:
+			blockStart := token.Offset + len("{")
+			for _, qmlToken := range qmltree.Tokenize(token.Value) {
+				if qmlToken.Kind == qmltree.TokenHash {
+					refs = append(refs, hashReference{qmlToken.Value, blockStart + qmlToken.Offset + len("~&")})
+				}
+			}
+		}
+	}
+	return refs, true
+}
+
+// hashReferencesText is hashReferences for files that fail to lex, matching
+// both reference forms as plain text
+func hashReferencesText(qmdContent string) []hashReference {
+	var refs []hashReference
+	for _, match := range anyHashReferenceRegex.FindAllStringSubmatchIndex(qmdContent, -1) {
+		start, end := match[2], match[3]
+		if start < 0 {
+			start, end = match[4], match[5]
+		}
+		refs = append(refs, hashReference{qmdContent[start:end], start})
+	}
+	return refs
+}
+
+// allHashReferences returns a QMD file's hash references, from the lexer
+// when it can, so every consumer sees the same ones
+func allHashReferences(qmdContent string) []hashReference {
+	if refs, ok := hashReferences(qmdContent); ok {
+		return refs
+	}
+	return hashReferencesText(qmdContent)
+}
+
+// findHashPositionsText is FindHashPositions without the lexer: it matches
+// each hash ID as a whole decimal number anywhere in the file, comments and
+// strings included
+func findHashPositionsText(qmdContent string, failedHashes []uint64) []HashWithPosition {
+	scan := newHashScan(failedHashes)
+	if scan == nil {
+		return nil
+	}
+	scanHashes(scan, qmdContent, 0, len(qmdContent))
+	return scan.results
+}
+
+// FindHashPositionsInFile is FindHashPositions for a file on disk
+// Files larger than hashLexMaxSize are streamed through the text scan
+// instead, so memory stays bounded however large the file is.
+func FindHashPositionsInFile(qmdPath string, failedHashes []uint64) ([]HashWithPosition, error) {
+	if len(failedHashes) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(qmdPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > hashLexMaxSize {
+		return FindHashPositionsReader(f, failedHashes)
+	}
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return FindHashPositions(string(content), failedHashes), nil
+}
+
+// FindHashPositionsReader is the text scan of FindHashPositions over a
+// stream, read in chunks, so comments and strings are not skipped
+// Line and column are carried across chunks, and the tail of each chunk that
+// could start a hash is kept until the next one arrives.
+func FindHashPositionsReader(r io.Reader, failedHashes []uint64) ([]HashWithPosition, error) {
+	scan := newHashScan(failedHashes)
+	if scan == nil {
+		return nil, nil
+	}
+
+	buf := make([]byte, 0, hashScanChunkSize+scan.maxLength)
+	base := 0 // Offset in the stream of buf[0]
+	eof := false
+
+	for !eof && len(scan.hashStrings) > 0 {
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			eof = true
+		} else if err != nil {
+			return scan.results, err
+		}
+
+		// Until the end, leave the bytes a hash could still extend past,
+		// plus the one after it that shows whether the number ends there
+		limit := len(buf)
+		if !eof {
+			limit -= scan.maxLength
+		}
+
+		if scanned := scanHashes(scan, buf, base, limit); scanned > 0 {
+			base += scanned
+			buf = buf[:copy(buf, buf[scanned:])]
+		}
+	}
+
+	return scan.results, nil
+}
+
+// hashScan is the state of a search for hash IDs, which may span several chunks
+type hashScan struct {
+	hashStrings map[string]uint64 // Hashes not found yet, by decimal string
+	maxLength   int               // Length of the longest hash string
+	prevDigit   bool              // Whether the last byte scanned was a digit
+	line        int
+	col         int
+	results     []HashWithPosition
+}
+
+// newHashScan prepares a search for failedHashes, or returns nil if there are none
+func newHashScan(failedHashes []uint64) *hashScan {
+	if len(failedHashes) == 0 {
+		return nil
+	}
+
 	hashStrings := make(map[string]uint64)
+	maxLength := 0
 	for _, hash := range failedHashes {
 		hashStr := strconv.FormatUint(hash, 10)
 		hashStrings[hashStr] = hash
+		maxLength = max(maxLength, len(hashStr))
 	}
 
-	results := make([]HashWithPosition, 0, len(failedHashes))
-	found := make(map[uint64]bool)
-
-	line := 1
-	col := 1
+	return &hashScan{
+		hashStrings: hashStrings,
+		maxLength:   maxLength,
+		line:        1,
+		col:         1,
+		results:     make([]HashWithPosition, 0, len(hashStrings)),
+	}
+}
 
-	// Scan through the content character by character
-	for i := 0; i < len(qmdContent); i++ {
-		ch := qmdContent[i]
+// scanHashes checks each number starting before limit in data for a hash
+// still being searched for; data starts at offset base in the file. Returns
+// how far it got, which is short of limit only when every hash has been found.
+// data must extend maxLength bytes past limit, unless it ends at end of file.
+func scanHashes[T string | []byte](scan *hashScan, data T, base, limit int) int {
+	i := 0
+	// Scan through the content once, stopping early when every hash is found
+	for ; i < limit && len(scan.hashStrings) > 0; i++ {
+		ch := data[i]
 
 		// Track line and column
 		if ch == '\n' {
-			line++
-			col = 1
+			scan.line++
+			scan.col = 1
+			scan.prevDigit = false
 			continue
 		}
 
-		// Check if any hash string starts at this position
-		for hashStr, hashID := range hashStrings {
-			// Skip if already found
-			if found[hashID] {
-				continue
-			}
-
-			// Check if we have enough characters left
-			if i+len(hashStr) > len(qmdContent) {
-				continue
+		digit := isDigit(ch)
+		if digit && !scan.prevDigit {
+			// Only a whole number can be a hash, so find where this one ends
+			end := i + 1
+			for end < len(data) && end-i <= scan.maxLength && isDigit(data[end]) {
+				end++
 			}
-
-			// Check if the hash string matches at this position
-			if qmdContent[i:i+len(hashStr)] == hashStr {
-				found[hashID] = true
-				results = append(results, HashWithPosition{
-					Hash:   hashID,
-					Line:   line,
-					Column: col,
-				})
-				// Don't break - continue checking other hashes
+			if end-i <= scan.maxLength {
+				hashStr := string(data[i:end])
+				if hashID, ok := scan.hashStrings[hashStr]; ok {
+					delete(scan.hashStrings, hashStr)
+					reportedLine, reportedCol, approximate := clampPosition(scan.line, scan.col)
+					scan.results = append(scan.results, HashWithPosition{
+						Hash:        hashID,
+						Line:        reportedLine,
+						Column:      reportedCol,
+						Offset:      base + i,
+						Approximate: approximate,
+					})
+				}
 			}
 		}
+		scan.prevDigit = digit
 
-		col++
+		scan.col++
 	}
+	return i
+}
 
-	return results
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
 }
 
 // FindHashPosition is a convenience function to find a single hash position
@@ -79,3 +321,172 @@ func FindHashPosition(qmdContent string, hash uint64) *HashWithPosition {
 func FormatHashError(hash uint64, line, column int) string {
 	return fmt.Sprintf("Cannot resolve hash %d at line %d, column %d", hash, line, column)
 }
+
+// ExtractHashes returns the unique hash IDs referenced in a QMD file's
+// contents, [[hash]] in the diff and ~&hash&~ in QML blocks
+func ExtractHashes(qmdContent string) []uint64 {
+	seen := make(map[uint64]bool)
+	hashes := make([]uint64, 0)
+
+	for _, ref := range allHashReferences(qmdContent) {
+		hash, err := strconv.ParseUint(ref.digits, 10, 64)
+		if err != nil || seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		hashes = append(hashes, hash)
+	}
+
+	return hashes
+}
+
+// ExtractHashPositions returns the first occurrence of each hash reference
+// in a QMD file, positioned at the hash digits
+func ExtractHashPositions(qmdContent string) []HashWithPosition {
+	return extractHashPositions(qmdContent, false)
+}
+
+// ExtractAllHashPositions returns every hash reference in a QMD file,
+// including repeats of the same hash, in file order
+func ExtractAllHashPositions(qmdContent string) []HashWithPosition {
+	return extractHashPositions(qmdContent, true)
+}
+
+func extractHashPositions(qmdContent string, all bool) []HashWithPosition {
+	seen := make(map[uint64]bool)
+	results := make([]HashWithPosition, 0)
+
+	line := 1
+	col := 1
+	scanned := 0
+
+	for _, ref := range allHashReferences(qmdContent) {
+		hash, err := strconv.ParseUint(ref.digits, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// Advance the line and column from the previous reference
+		for ; scanned < ref.offset; scanned++ {
+			if qmdContent[scanned] == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+
+		if seen[hash] && !all {
+			continue
+		}
+		seen[hash] = true
+
+		reportedLine, reportedCol, approximate := clampPosition(line, col)
+		results = append(results, HashWithPosition{
+			Hash:        hash,
+			Line:        reportedLine,
+			Column:      reportedCol,
+			Offset:      ref.offset,
+			Approximate: approximate,
+		})
+	}
+
+	return results
+}
+
+// CollectHashes returns the sorted union of hash IDs referenced by the given QMD files
+// and every file they LOAD, recursively
+func CollectHashes(qmdPaths []string) ([]uint64, error) {
+	seen := make(map[uint64]bool)
+	readFiles := make(map[string]bool)
+
+	for _, qmdPath := range qmdPaths {
+		depInfo, err := BuildDependencyInfo(qmdPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build dependency info for %s: %w", qmdPath, err)
+		}
+
+		files := []string{qmdPath}
+		for _, load := range depInfo.ExpectedLoads {
+			files = append(files, filepath.Join(filepath.Dir(qmdPath), load))
+		}
+
+		for _, file := range files {
+			if readFiles[file] {
+				continue
+			}
+			readFiles[file] = true
+
+			content, err := os.ReadFile(file)
+			if err != nil {
+				// Missing LOAD targets are reported by validation, not here
+				continue
+			}
+			for _, hash := range ExtractHashes(string(content)) {
+				seen[hash] = true
+			}
+		}
+	}
+
+	hashes := make([]uint64, 0, len(seen))
+	for hash := range seen {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	return hashes, nil
+}
+
+// HasDiffs reports whether a QMD file contains any AFFECT or LOAD directives or hash
+// references once comments are stripped. Empty and comment-only files have none
+func HasDiffs(qmdContent string) bool {
+	var code strings.Builder
+	for _, line := range strings.Split(qmdContent, "\n") {
+		if idx := strings.Index(line, ";"); idx >= 0 {
+			line = line[:idx]
+		}
+		code.WriteString(line)
+		code.WriteString("\n")
+	}
+
+	stripped := code.String()
+	return directiveRegex.MatchString(stripped) || hashReferenceRegex.MatchString(stripped)
+}
+
+// ExtractAffectTargets returns the files targeted by AFFECT directives
+// Literal targets are returned as paths; hashed targets ([[123]]) as hash IDs
+func ExtractAffectTargets(qmdContent string) (paths []string, hashes []uint64) {
+	for _, match := range affectRegex.FindAllStringSubmatch(qmdContent, -1) {
+		target := match[1]
+		if hashMatch := hashReferenceRegex.FindStringSubmatch(target); hashMatch != nil {
+			if hash, err := strconv.ParseUint(hashMatch[1], 10, 64); err == nil {
+				hashes = append(hashes, hash)
+			}
+			continue
+		}
+		paths = append(paths, target)
+	}
+	return paths, hashes
+}
+
+// ResolveAffectTargets returns the targets of a QMD file's AFFECT directives,
+// resolving hashed targets to paths with lookup where it knows them
+// Hashed targets lookup cannot resolve are kept, with an empty Path.
+func ResolveAffectTargets(qmdContent string, lookup func(hash uint64) (string, bool)) []AffectTarget {
+	paths, hashes := ExtractAffectTargets(qmdContent)
+
+	targets := make([]AffectTarget, 0, len(paths)+len(hashes))
+	for _, path := range paths {
+		targets = append(targets, AffectTarget{Path: path})
+	}
+	for _, hash := range hashes {
+		target := AffectTarget{Hash: hash}
+		if lookup != nil {
+			if path, ok := lookup(hash); ok {
+				target.Path = path
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
@@ -0,0 +1,428 @@
+package qmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestExtractHashes(t *testing.T) {
+	content := "AFFECT [[123]]\n  REPLACE [[456]] WITH [[123]]\n; 789 is not a hash reference\n"
+
+	got := ExtractHashes(content)
+	want := []uint64{123, 456}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractHashes() = %v, want %v", got, want)
+	}
+
+	// Hashes in QML blocks count too, but not ones in comments or strings
+	content = "AFFECT [[123]]\n  INSERT {\n    // ~&999&~\n    text: \"~&998&~\"\n    id: ~&789&~\n  }\n"
+	if got, want := ExtractHashes(content), []uint64{123, 789}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractHashes() with a block = %v, want %v", got, want)
+	}
+}
+
+func TestExtractAllHashPositions(t *testing.T) {
+	content := "AFFECT [[123]]\n  REPLACE [[456]] WITH [[123]]\n  REMOVE [[123]]\n; 789 is not a hash reference\n"
+
+	got := ExtractAllHashPositions(content)
+	want := []HashWithPosition{
+		{Hash: 123, Line: 1, Column: 10, Offset: 9},
+		{Hash: 456, Line: 2, Column: 13, Offset: 27},
+		{Hash: 123, Line: 2, Column: 26, Offset: 40},
+		{Hash: 123, Line: 3, Column: 12, Offset: 57},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractAllHashPositions() = %+v, want %+v", got, want)
+	}
+	for _, position := range got {
+		if hashStr := strconv.FormatUint(position.Hash, 10); content[position.Offset:position.Offset+len(hashStr)] != hashStr {
+			t.Errorf("Offset %d does not point at hash %d", position.Offset, position.Hash)
+		}
+	}
+
+	// The deduplicated variant keeps the first occurrence of each hash
+	first := ExtractHashPositions(content)
+	if !reflect.DeepEqual(first, want[:2]) {
+		t.Errorf("ExtractHashPositions() = %+v, want %+v", first, want[:2])
+	}
+}
+
+func TestCollectHashesFollowsLoads(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"root.qmd":      "LOAD lib/dep.qmd\nAFFECT [[300]]\n",
+		"lib/dep.qmd":   "LOAD other.qmd\nAFFECT [[100]]\n",
+		"lib/other.qmd": "AFFECT [[200]] [[300]]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	got, err := CollectHashes([]string{filepath.Join(tmpDir, "root.qmd")})
+	if err != nil {
+		t.Fatalf("CollectHashes() failed: %v", err)
+	}
+
+	want := []uint64{100, 200, 300}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectHashes() = %v, want %v", got, want)
+	}
+}
+
+func TestHasDiffs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"empty file", "", false},
+		{"comment only", "; This diff is not finished yet\n; AFFECT [[123]]\n\n", false},
+		{"affect directive", "AFFECT [[123]]\n  REPLACE [[456]] WITH x\n", true},
+		{"load only", "LOAD other.qmd\n", true},
+		{"trailing comment", "AFFECT [[123]] ; patch the main view\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasDiffs(tt.content); got != tt.want {
+				t.Errorf("HasDiffs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractAffectTargets(t *testing.T) {
+	content := "AFFECT /qml/Main.qml\n  REPLACE [[1]] WITH x\nEND AFFECT\nAFFECT [[42]]\nEND AFFECT\n"
+
+	paths, hashes := ExtractAffectTargets(content)
+	if !reflect.DeepEqual(paths, []string{"/qml/Main.qml"}) {
+		t.Errorf("paths = %v, want [/qml/Main.qml]", paths)
+	}
+	if !reflect.DeepEqual(hashes, []uint64{42}) {
+		t.Errorf("hashes = %v, want [42]", hashes)
+	}
+}
+
+func TestResolveAffectTargets(t *testing.T) {
+	content := "AFFECT /qml/Main.qml\nEND AFFECT\nAFFECT [[42]]\nEND AFFECT\nAFFECT [[43]]\nEND AFFECT\n"
+	lookup := func(hash uint64) (string, bool) {
+		if hash == 42 {
+			return "/qml/Settings.qml", true
+		}
+		return "", false
+	}
+
+	targets := ResolveAffectTargets(content, lookup)
+	want := []AffectTarget{
+		{Path: "/qml/Main.qml"},
+		{Path: "/qml/Settings.qml", Hash: 42},
+		{Hash: 43},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Fatalf("ResolveAffectTargets() = %+v, want %+v", targets, want)
+	}
+
+	var got []string
+	for _, target := range targets {
+		got = append(got, fmt.Sprintf("%s hashed=%v resolved=%v", target, target.Hashed(), target.Resolved()))
+	}
+	wantStrings := []string{
+		"/qml/Main.qml hashed=false resolved=true",
+		"/qml/Settings.qml hashed=true resolved=true",
+		"[[43]] hashed=true resolved=false",
+	}
+	if !reflect.DeepEqual(got, wantStrings) {
+		t.Errorf("targets = %q, want %q", got, wantStrings)
+	}
+}
+
+func TestFindHashPositionsClampsMinifiedFiles(t *testing.T) {
+	padding := strings.Repeat(" ", MaxReportedColumn+500)
+	content := "AFFECT [[111]]\n" + padding + "[[222]]"
+
+	positions := FindHashPositions(content, []uint64{111, 222})
+	if len(positions) != 2 {
+		t.Fatalf("FindHashPositions() returned %d positions, want 2", len(positions))
+	}
+
+	short := positions[0]
+	if short.Line != 1 || short.Column != 10 || short.Offset != 9 || short.Approximate {
+		t.Errorf("short line position = %+v, want line 1, column 10, offset 9, exact", short)
+	}
+
+	long := positions[1]
+	if long.Line != 2 || long.Column != MaxReportedColumn || !long.Approximate {
+		t.Errorf("long line position = %+v, want line 2, column %d, approximate", long, MaxReportedColumn)
+	}
+	if want := strings.Index(content, "222"); long.Offset != want {
+		t.Errorf("long line Offset = %d, want %d", long.Offset, want)
+	}
+}
+
+func TestFindHashPositionsSkipsCommentsAndStrings(t *testing.T) {
+	content := "; 12345 is missing on 3.22\n" +
+		"AFFECT /qml/Main.qml\n" +
+		"  LOCATE AFTER \"12345\"\n" +
+		"  INSERT {\n" +
+		"    // ~&12345&~ and [[67890]]\n" +
+		"    text: \"~&12345&~\"\n" +
+		"    id: ~&12345&~\n" +
+		"  }\n" +
+		"  REPLACE [[67890]] WITH [[11111]]\n"
+
+	positions := FindHashPositions(content, []uint64{12345, 67890, 22222})
+	want := []HashWithPosition{
+		{Hash: 12345, Line: 7, Column: 11, Offset: strings.Index(content, "id: ~&") + len("id: ~&")},
+		{Hash: 67890, Line: 9, Column: 13, Offset: strings.LastIndex(content, "[[67890]]") + len("[[")},
+	}
+	if !reflect.DeepEqual(positions, want) {
+		t.Errorf("FindHashPositions() = %+v, want %+v", positions, want)
+	}
+
+	// A file the lexer rejects is still searched as text
+	broken := "; 12345\nAFFECT [[12345]]\n  INSERT {\n"
+	if positions := FindHashPositions(broken, []uint64{12345}); len(positions) != 1 || positions[0].Offset != 2 {
+		t.Errorf("FindHashPositions() on unlexable file = %+v, want the text match at offset 2", positions)
+	}
+}
+
+func TestFindHashPositionsWholeNumbers(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []int // Offset of each hash found
+	}{
+		{"qmd hash reference", "AFFECT [[12345]]", []int{9}},
+		{"hashed string", "text: ~&12345&~", []int{8}},
+		{"between letters", "x12345y", []int{1}},
+		{"end of file", "REPLACE 12345", []int{8}},
+		{"whole file", "12345", []int{0}},
+		{"inside a longer number", "[[123456789]]", nil},
+		{"digit before", "[[012345]]", nil},
+		{"digit after", "[[123450]]", nil},
+		{"longer number first", "[[9912345]] [[12345]]", []int{14}},
+		{"after a newline", "1\n12345", []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positions := findHashPositionsText(tt.content, []uint64{12345})
+			streamed, err := FindHashPositionsReader(iotest.OneByteReader(strings.NewReader(tt.content)), []uint64{12345})
+			if err != nil {
+				t.Fatalf("FindHashPositionsReader() failed: %v", err)
+			}
+
+			for name, got := range map[string][]HashWithPosition{"findHashPositionsText": positions, "FindHashPositionsReader": streamed} {
+				var offsets []int
+				for _, position := range got {
+					offsets = append(offsets, position.Offset)
+				}
+				if !reflect.DeepEqual(offsets, tt.want) {
+					t.Errorf("%s(%q) offsets = %v, want %v", name, tt.content, offsets, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFindHashPositionsAcrossChunks(t *testing.T) {
+	defer func(size int) { hashScanChunkSize = size }(hashScanChunkSize)
+
+	content, hashes := largeQMD(50)
+	hashes = append(hashes[:10], 404, 7)
+	want := findHashPositionsNaive(content, hashes)
+	sortPositions(want)
+
+	// Tiny chunks put hashes across every possible boundary
+	for _, size := range []int{1, 2, 5, 7, 64} {
+		hashScanChunkSize = size
+		got, err := FindHashPositionsReader(strings.NewReader(content), hashes)
+		if err != nil {
+			t.Fatalf("chunk size %d: FindHashPositionsReader() failed: %v", size, err)
+		}
+		sortPositions(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("chunk size %d: FindHashPositionsReader() = %+v, want %+v", size, got, want)
+		}
+	}
+}
+
+func TestFindHashPositionsInFileLargeSingleLine(t *testing.T) {
+	// A minified 8 MB QMD on one line, with a hash straddling a chunk boundary
+	const size = 8 << 20
+	var b strings.Builder
+	b.Grow(size + 64)
+	b.WriteString("AFFECT [[111]] ")
+	for b.Len() < hashScanChunkSize*3-4 {
+		b.WriteString("x ")
+	}
+	straddling := b.Len() + 2
+	b.WriteString("[[222222]] ")
+	for b.Len() < size {
+		b.WriteString("REPLACE y WITH z ")
+	}
+	last := b.Len() + 2
+	b.WriteString("[[333]]")
+	content := b.String()
+
+	qmdPath := filepath.Join(t.TempDir(), "minified.qmd")
+	if err := os.WriteFile(qmdPath, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	positions, err := FindHashPositionsInFile(qmdPath, []uint64{111, 222222, 333})
+	if err != nil {
+		t.Fatalf("FindHashPositionsInFile() failed: %v", err)
+	}
+	want := []HashWithPosition{
+		{Hash: 111, Line: 1, Column: 10, Offset: 9},
+		{Hash: 222222, Line: 1, Column: MaxReportedColumn, Offset: straddling, Approximate: true},
+		{Hash: 333, Line: 1, Column: MaxReportedColumn, Offset: last, Approximate: true},
+	}
+	if !reflect.DeepEqual(positions, want) {
+		t.Errorf("FindHashPositionsInFile() = %+v, want %+v", positions, want)
+	}
+}
+
+// findHashPositionsNaive is the original text scan of FindHashPositions,
+// kept as a reference: it compares every hash string at every position not
+// preceded by a digit, and accepts a match not followed by one
+func findHashPositionsNaive(qmdContent string, failedHashes []uint64) []HashWithPosition {
+	if len(failedHashes) == 0 {
+		return nil
+	}
+
+	hashStrings := make(map[string]uint64)
+	for _, hash := range failedHashes {
+		hashStrings[strconv.FormatUint(hash, 10)] = hash
+	}
+
+	results := make([]HashWithPosition, 0, len(failedHashes))
+	found := make(map[uint64]bool)
+	line, col := 1, 1
+	for i := 0; i < len(qmdContent); i++ {
+		if qmdContent[i] == '\n' {
+			line++
+			col = 1
+			continue
+		}
+		for hashStr, hashID := range hashStrings {
+			if found[hashID] || i+len(hashStr) > len(qmdContent) {
+				continue
+			}
+			end := i + len(hashStr)
+			if i > 0 && isDigit(qmdContent[i-1]) || end < len(qmdContent) && isDigit(qmdContent[end]) {
+				continue
+			}
+			if qmdContent[i:end] == hashStr {
+				found[hashID] = true
+				reportedLine, reportedCol, approximate := clampPosition(line, col)
+				results = append(results, HashWithPosition{
+					Hash:        hashID,
+					Line:        reportedLine,
+					Column:      reportedCol,
+					Offset:      i,
+					Approximate: approximate,
+				})
+			}
+		}
+		col++
+	}
+	return results
+}
+
+// sortPositions orders positions by offset, then hash, since hashes found at
+// the same offset may be reported in any order
+func sortPositions(positions []HashWithPosition) {
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Offset != positions[j].Offset {
+			return positions[i].Offset < positions[j].Offset
+		}
+		return positions[i].Hash < positions[j].Hash
+	})
+}
+
+func FuzzFindHashPositions(f *testing.F) {
+	f.Add("AFFECT [[123]]\n  REPLACE [[456]] WITH [[123]]\n", uint64(123), uint64(456), uint64(789))
+	f.Add("[[12345]] [[1234]]\n[[12]]", uint64(12), uint64(1234), uint64(12345))
+	f.Add("1\n2\n3", uint64(1), uint64(1), uint64(3))
+	f.Add("[[123456789]] ~&12345&~ 1234", uint64(12345), uint64(123), uint64(1234))
+	f.Add("", uint64(0), uint64(18446744073709551615), uint64(5))
+
+	f.Fuzz(func(t *testing.T, content string, h1, h2, h3 uint64) {
+		hashes := []uint64{h1, h2, h3}
+		got := findHashPositionsText(content, hashes)
+		want := findHashPositionsNaive(content, hashes)
+		sortPositions(got)
+		sortPositions(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("findHashPositionsText(%q, %v) = %+v, want %+v", content, hashes, got, want)
+		}
+
+		// Lexed positions must point at the hash's digits
+		for _, position := range FindHashPositions(content, hashes) {
+			hashStr := strconv.FormatUint(position.Hash, 10)
+			if !strings.HasPrefix(content[position.Offset:], hashStr) {
+				t.Errorf("FindHashPositions(%q, %v) reported %d at offset %d", content, hashes, position.Hash, position.Offset)
+			}
+		}
+
+		streamed, err := FindHashPositionsReader(iotest.OneByteReader(strings.NewReader(content)), hashes)
+		if err != nil {
+			t.Fatalf("FindHashPositionsReader() failed: %v", err)
+		}
+		sortPositions(streamed)
+		if !reflect.DeepEqual(streamed, want) {
+			t.Errorf("FindHashPositionsReader(%q, %v) = %+v, want %+v", content, hashes, streamed, want)
+		}
+	})
+}
+
+// largeQMD builds a QMD diff with n AFFECT blocks, each referencing three
+// distinct hashes, and returns it with every hash it references
+func largeQMD(n int) (string, []uint64) {
+	var b strings.Builder
+	hashes := make([]uint64, 0, n*3)
+	for i := 0; i < n; i++ {
+		h1, h2, h3 := uint64(1000000000+i*3), uint64(1000000001+i*3), uint64(1000000002+i*3)
+		hashes = append(hashes, h1, h2, h3)
+		fmt.Fprintf(&b, "; block %d\nAFFECT [[%d]]\n  TRAVERSE [[%d]]\n    REPLACE [[%d]] WITH {\n      text: \"item %d\"\n    }\n  END TRAVERSE\nEND AFFECT\n", i, h1, h2, h3, i)
+	}
+	return b.String(), hashes
+}
+
+func BenchmarkExtractHashes(b *testing.B) {
+	content, _ := largeQMD(2000)
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ExtractHashes(content)
+	}
+}
+
+func BenchmarkFindHashPositions(b *testing.B) {
+	content, hashes := largeQMD(2000)
+	// Scanning cost grows with both file size and the number of failed hashes
+	for _, failed := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("hashes=%d", failed), func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				FindHashPositions(content, hashes[:failed])
+			}
+		})
+	}
+}
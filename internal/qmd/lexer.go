@@ -0,0 +1,247 @@
+package qmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind identifies the kind of a QMD diff token
+type TokenKind int
+
+const (
+	TokenWord   TokenKind = iota // Directives and bare arguments, e.g. AFFECT, /qml/Main.qml
+	TokenHash                    // Hash reference [[123]], Value holds the digits
+	TokenString                  // Quoted string, Value holds the contents without quotes
+	TokenBlock                   // Braced QML code block, Value holds the contents without braces
+	TokenSymbol                  // Any other single character
+)
+
+// Token is a single lexical token from a QMD diff
+type Token struct {
+	Kind   TokenKind
+	Value  string
+	Line   int
+	Column int
+	Offset int // Byte offset of the token in the diff
+}
+
+// SyntaxError is a tokenization error with its 1-based position
+type SyntaxError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// Limits that stop lexing of pathological input, such as deeply nested blocks
+// or files made of nothing but stray braces
+const (
+	maxBlockDepth = 256
+	maxTokens     = 1000000 // Applies to tokens and errors separately
+)
+
+// lexer tracks the position while scanning a QMD diff
+type lexer struct {
+	content string
+	pos     int
+	line    int
+	column  int
+	tokens  []Token
+	errors  []SyntaxError
+	stopped bool
+}
+
+// Lex splits a QMD diff into tokens, collecting errors for unterminated
+// strings and blocks, unbalanced braces and malformed hash references
+// Lexing continues past errors so every problem in a file is reported
+func Lex(content string) ([]Token, []SyntaxError) {
+	l := &lexer{content: content, line: 1, column: 1}
+
+	for l.pos < len(l.content) && !l.stopped {
+		ch := l.content[l.pos]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			l.advance()
+
+		case ch == ';':
+			for l.pos < len(l.content) && l.content[l.pos] != '\n' {
+				l.advance()
+			}
+
+		case ch == '"' || ch == '\'' || ch == '`':
+			line, column, start := l.line, l.column, l.pos
+			if value, ok := l.readString(); ok {
+				l.emit(TokenString, value, line, column, start)
+			}
+
+		case ch == '{':
+			l.lexBlock()
+
+		case ch == '}':
+			l.errorf(l.line, l.column, "unexpected '}' without matching '{'")
+			l.advance()
+
+		case strings.HasPrefix(l.content[l.pos:], "[["):
+			l.lexHash()
+
+		case isWordChar(ch):
+			line, column, start := l.line, l.column, l.pos
+			for l.pos < len(l.content) && isWordChar(l.content[l.pos]) {
+				l.advance()
+			}
+			l.emit(TokenWord, l.content[start:l.pos], line, column, start)
+
+		default:
+			l.emit(TokenSymbol, l.content[l.pos:l.pos+1], l.line, l.column, l.pos)
+			l.advance()
+		}
+	}
+
+	return l.tokens, l.errors
+}
+
+// readString reads a quoted string, honoring backslash escapes
+// Reports an error and returns false if the string is unterminated
+func (l *lexer) readString() (string, bool) {
+	line, column := l.line, l.column
+	quote := l.content[l.pos]
+	l.advance()
+	start := l.pos
+
+	for l.pos < len(l.content) {
+		switch l.content[l.pos] {
+		case '\\':
+			l.advance()
+			if l.pos < len(l.content) {
+				l.advance()
+			}
+			continue
+		case quote:
+			value := l.content[start:l.pos]
+			l.advance()
+			return value, true
+		}
+		l.advance()
+	}
+
+	l.errorf(line, column, "unterminated string")
+	return "", false
+}
+
+// lexBlock reads a braced QML code block, skipping nested braces, strings
+// and comments
+func (l *lexer) lexBlock() {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance()
+	start := l.pos
+	depth := 1
+
+	for l.pos < len(l.content) {
+		rest := l.content[l.pos:]
+		switch {
+		case rest[0] == '{':
+			depth++
+			if depth > maxBlockDepth {
+				l.stop(l.line, l.column, "blocks nested more than %d levels deep", maxBlockDepth)
+				return
+			}
+		case rest[0] == '}':
+			depth--
+			if depth == 0 {
+				l.emit(TokenBlock, l.content[start:l.pos], line, column, offset)
+				l.advance()
+				return
+			}
+		case rest[0] == '"' || rest[0] == '\'' || rest[0] == '`':
+			// Strings may contain braces; errors inside are reported on their own
+			l.readString()
+			continue
+		case strings.HasPrefix(rest, "//"):
+			for l.pos < len(l.content) && l.content[l.pos] != '\n' {
+				l.advance()
+			}
+			continue
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest) - 2
+			} else {
+				end += 2
+			}
+			for n := 0; n < end+2 && l.pos < len(l.content); n++ {
+				l.advance()
+			}
+			continue
+		}
+		l.advance()
+	}
+
+	l.errorf(line, column, "unterminated block, missing '}'")
+}
+
+// lexHash reads a [[digits]] hash reference
+func (l *lexer) lexHash() {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance()
+	l.advance()
+	start := l.pos
+
+	for l.pos < len(l.content) && l.content[l.pos] >= '0' && l.content[l.pos] <= '9' {
+		l.advance()
+	}
+	digits := l.content[start:l.pos]
+
+	if digits == "" || !strings.HasPrefix(l.content[l.pos:], "]]") {
+		l.errorf(line, column, "invalid hash reference, expected [[digits]]")
+		return
+	}
+
+	l.advance()
+	l.advance()
+	l.emit(TokenHash, digits, line, column, offset)
+}
+
+func (l *lexer) advance() {
+	if l.content[l.pos] == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	l.pos++
+}
+
+func (l *lexer) emit(kind TokenKind, value string, line, column, offset int) {
+	if len(l.tokens) >= maxTokens {
+		l.stop(line, column, "more than %d tokens", maxTokens)
+		return
+	}
+	l.tokens = append(l.tokens, Token{Kind: kind, Value: value, Line: line, Column: column, Offset: offset})
+}
+
+func (l *lexer) errorf(line, column int, format string, args ...interface{}) {
+	if len(l.errors) >= maxTokens {
+		l.stop(line, column, "more than %d errors", maxTokens)
+		return
+	}
+	l.errors = append(l.errors, SyntaxError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)})
+}
+
+// stop reports why lexing gave up and ends it; the rest of the file is not checked
+func (l *lexer) stop(line, column int, format string, args ...interface{}) {
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	message := "lexing stopped: " + fmt.Sprintf(format, args...)
+	l.errors = append(l.errors, SyntaxError{Line: line, Column: column, Message: message})
+}
+
+func isWordChar(ch byte) bool {
+	return ch == '_' || ch == '.' || ch == '/' || ch == '-' || ch == '$' ||
+		(ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch >= 0x80
+}
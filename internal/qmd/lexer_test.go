@@ -0,0 +1,132 @@
+package qmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLexTokens(t *testing.T) {
+	content := "; comment\nAFFECT [[123]]\n  INSERT {\n    text: \"}\" // don't\n  }\n"
+
+	tokens, errs := Lex(content)
+	if len(errs) != 0 {
+		t.Fatalf("Lex() errors = %v, want none", errs)
+	}
+
+	want := []Token{
+		{Kind: TokenWord, Value: "AFFECT", Line: 2, Column: 1, Offset: 10},
+		{Kind: TokenHash, Value: "123", Line: 2, Column: 8, Offset: 17},
+		{Kind: TokenWord, Value: "INSERT", Line: 3, Column: 3, Offset: 27},
+		{Kind: TokenBlock, Value: "\n    text: \"}\" // don't\n  ", Line: 3, Column: 10, Offset: 34},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("Lex() tokens = %+v, want %+v", tokens, want)
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []SyntaxError
+	}{
+		{
+			name:    "clean",
+			content: "AFFECT [[1]]\nREPLACE [[2]] WITH 'x'\n",
+			want:    nil,
+		},
+		{
+			name:    "unterminated string",
+			content: "AFFECT [[1]]\nREPLACE [[2]] WITH \"oops\n",
+			want:    []SyntaxError{{Line: 2, Column: 20, Message: "unterminated string"}},
+		},
+		{
+			name:    "unterminated block",
+			content: "INSERT {\n  Item {}\n",
+			want:    []SyntaxError{{Line: 1, Column: 8, Message: "unterminated block, missing '}'"}},
+		},
+		{
+			name:    "stray closing brace",
+			content: "AFFECT [[1]]\n}\n",
+			want:    []SyntaxError{{Line: 2, Column: 1, Message: "unexpected '}' without matching '{'"}},
+		},
+		{
+			name:    "invalid hashes",
+			content: "AFFECT [[12a]]\nLOCATE [[]]\n",
+			want: []SyntaxError{
+				{Line: 1, Column: 8, Message: "invalid hash reference, expected [[digits]]"},
+				{Line: 2, Column: 8, Message: "invalid hash reference, expected [[digits]]"},
+			},
+		},
+		{
+			name:    "escaped quote",
+			content: "REPLACE [[1]] WITH \"say \\\"hi\\\"\"\n",
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Lex(tt.content)
+			if !reflect.DeepEqual(errs, tt.want) {
+				t.Errorf("Lex() errors = %+v, want %+v", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestLexLimits(t *testing.T) {
+	nested := func(depth int) string {
+		return "INSERT " + strings.Repeat("{", depth) + strings.Repeat("}", depth) + "\n"
+	}
+
+	if _, errs := Lex(nested(maxBlockDepth)); len(errs) != 0 {
+		t.Errorf("Lex() at the depth limit errors = %+v, want none", errs)
+	}
+
+	_, errs := Lex(nested(maxBlockDepth + 1))
+	want := []SyntaxError{{Line: 1, Column: 8 + maxBlockDepth, Message: "lexing stopped: blocks nested more than 256 levels deep"}}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("Lex() too deep errors = %+v, want %+v", errs, want)
+	}
+
+	tokens, errs := Lex(strings.Repeat("a ", maxTokens+10))
+	if len(tokens) != maxTokens {
+		t.Errorf("Lex() returned %d tokens, want %d", len(tokens), maxTokens)
+	}
+	if len(errs) != 1 || errs[0].Message != "lexing stopped: more than 1000000 tokens" {
+		t.Errorf("Lex() too many tokens errors = %+v, want the token limit", errs)
+	}
+}
+
+func BenchmarkLex(b *testing.B) {
+	content, _ := largeQMD(2000)
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Lex(content)
+	}
+}
+
+func FuzzLex(f *testing.F) {
+	f.Add("; comment\nAFFECT [[123]]\n  INSERT {\n    text: \"}\" // don't\n  }\n")
+	f.Add("AFFECT [[12")
+	f.Add("INSERT { /* unterminated")
+	f.Add("\"escape at end\\")
+	f.Add("}}{{[[]]")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		tokens, errs := Lex(content)
+		for _, token := range tokens {
+			if token.Line < 1 || token.Column < 1 || token.Offset < 0 || token.Offset >= len(content) {
+				t.Fatalf("token %+v has an invalid position", token)
+			}
+		}
+		for _, err := range errs {
+			if err.Line < 1 || err.Column < 1 {
+				t.Fatalf("error %+v has an invalid position", err)
+			}
+		}
+	})
+}
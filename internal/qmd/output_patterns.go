@@ -0,0 +1,126 @@
+package qmd
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// OutputPatterns are the regular expressions that pick results out of
+// qmldiff apply-diffs output. Each must use named capture groups, so a new
+// qmldiff wording can be matched without a rebuild; a group name may appear
+// in several alternatives.
+type OutputPatterns struct {
+	HashError      string // Groups "file" and "hash"
+	ProcessError   string // Groups "file" and "message"
+	WrittenFile    string // Group "qml"
+	VersionSkipped string // Group "file", optionally "count"; diffs left out for targeting another version
+}
+
+// DefaultOutputPatterns match the output of current qmldiff releases
+var DefaultOutputPatterns = OutputPatterns{
+	HashError:      `(?:(?P<file>.+\.qmd) - Cannot resolve hash (?P<hash>\d+)|Cannot resolve hash (?P<hash>\d+) required by (?P<file>.+\.qmd))`,
+	ProcessError:   `\(On behalf of '(?P<file>.+\.qmd)'\): (?P<message>.+)`,
+	WrittenFile:    `Written file (?P<qml>.+\.qml) - (?P<count>\d+) diff\(s\) applied`,
+	VersionSkipped: `(?:(?P<file>.+\.qmd) - (?P<count>\d+) diff\(s\) skipped \(version mismatch\)|Skipping diff in (?P<file>.+\.qmd): version mismatch)`,
+}
+
+// outputRegexes are compiled OutputPatterns
+type outputRegexes struct {
+	hashError    *regexp.Regexp
+	processError *regexp.Regexp
+	writtenFile  *regexp.Regexp
+	versionSkip  *regexp.Regexp
+}
+
+var (
+	outputMu      sync.RWMutex
+	outputPattern = mustCompileOutputPatterns(DefaultOutputPatterns)
+)
+
+// SetOutputPatterns replaces the patterns used to parse apply-diffs output
+// Empty fields keep their default. Returns an error, leaving the patterns
+// unchanged, if one doesn't compile or lacks a required group.
+func SetOutputPatterns(patterns OutputPatterns) error {
+	if patterns.HashError == "" {
+		patterns.HashError = DefaultOutputPatterns.HashError
+	}
+	if patterns.ProcessError == "" {
+		patterns.ProcessError = DefaultOutputPatterns.ProcessError
+	}
+	if patterns.WrittenFile == "" {
+		patterns.WrittenFile = DefaultOutputPatterns.WrittenFile
+	}
+	if patterns.VersionSkipped == "" {
+		patterns.VersionSkipped = DefaultOutputPatterns.VersionSkipped
+	}
+
+	compiled, err := compileOutputPatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	outputPattern = compiled
+	return nil
+}
+
+func currentOutputRegexes() *outputRegexes {
+	outputMu.RLock()
+	defer outputMu.RUnlock()
+	return outputPattern
+}
+
+func compileOutputPatterns(patterns OutputPatterns) (*outputRegexes, error) {
+	hashError, err := compileWithGroups("hash error", patterns.HashError, "file", "hash")
+	if err != nil {
+		return nil, err
+	}
+	processError, err := compileWithGroups("process error", patterns.ProcessError, "file", "message")
+	if err != nil {
+		return nil, err
+	}
+	writtenFile, err := compileWithGroups("written file", patterns.WrittenFile, "qml")
+	if err != nil {
+		return nil, err
+	}
+	versionSkip, err := compileWithGroups("version skipped", patterns.VersionSkipped, "file")
+	if err != nil {
+		return nil, err
+	}
+	return &outputRegexes{hashError: hashError, processError: processError, writtenFile: writtenFile, versionSkip: versionSkip}, nil
+}
+
+func mustCompileOutputPatterns(patterns OutputPatterns) *outputRegexes {
+	compiled, err := compileOutputPatterns(patterns)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// compileWithGroups compiles a pattern and checks it has the named groups
+func compileWithGroups(kind, pattern string, groups ...string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %w", kind, err)
+	}
+	for _, group := range groups {
+		if re.SubexpIndex(group) < 0 {
+			return nil, fmt.Errorf("%s pattern %q has no (?P<%s>...) group", kind, pattern, group)
+		}
+	}
+	return re, nil
+}
+
+// namedMatch returns the first non-empty submatch for a named group, which
+// may appear in several alternatives of the pattern
+func namedMatch(re *regexp.Regexp, matches []string, name string) string {
+	for i, groupName := range re.SubexpNames() {
+		if groupName == name && i < len(matches) && matches[i] != "" {
+			return matches[i]
+		}
+	}
+	return ""
+}
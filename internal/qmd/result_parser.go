@@ -1,6 +1,7 @@
 package qmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"regexp"
@@ -17,6 +18,7 @@ const (
 	StatusValidated     FileStatus = "validated"      // File was successfully validated
 	StatusFailed        FileStatus = "failed"          // File had errors during validation
 	StatusNotAttempted  FileStatus = "not_attempted"   // File was not validated due to prior failure
+	StatusNotUploaded   FileStatus = "not_uploaded"    // File is LOADed but was not among the uploaded files
 )
 
 // ValidationResult contains the results for a single QMD file
@@ -29,6 +31,8 @@ type ValidationResult struct {
 	QMLFilesModified []string    `json:"qml_files_modified,omitempty"`
 	Position         int         `json:"position"` // Position in LOAD order
 	BlockedBy        string      `json:"blocked_by,omitempty"` // File that caused validation to stop
+	Warnings         []string    `json:"warnings,omitempty"`   // Authoring issues that don't fail validation
+	VersionSkipped   int         `json:"version_skipped,omitempty"` // Diffs qmldiff skipped as targeting another version
 }
 
 // HashError represents a hash lookup error
@@ -37,6 +41,48 @@ type HashError struct {
 	Error  string `json:"error"`
 }
 
+// MarshalJSON serializes HashID as a decimal string, since hash IDs can exceed
+// the range JavaScript numbers represent exactly (2^53)
+func (he HashError) MarshalJSON() ([]byte, error) {
+	type Alias HashError
+
+	return json.Marshal(&struct {
+		*Alias
+		HashID string `json:"hash_id"`
+	}{
+		Alias:  (*Alias)(&he),
+		HashID: strconv.FormatUint(he.HashID, 10),
+	})
+}
+
+// UnmarshalJSON accepts HashID as either a decimal string or a JSON number
+func (he *HashError) UnmarshalJSON(data []byte) error {
+	type Alias HashError
+
+	aux := &struct {
+		*Alias
+		HashID json.Number `json:"hash_id"`
+	}{
+		Alias: (*Alias)(he),
+	}
+
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	if aux.HashID == "" {
+		he.HashID = 0
+		return nil
+	}
+
+	hashID, err := strconv.ParseUint(aux.HashID.String(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid hash_id %q: %w", aux.HashID, err)
+	}
+	he.HashID = hashID
+	return nil
+}
+
 // CheckCompatibilityResult contains results from qmldiff check-compatibility command
 type CheckCompatibilityResult struct {
 	HasErrors   bool
@@ -54,6 +100,10 @@ type ParsedOutput struct {
 	HadPanic         bool                    // Whether qmldiff panicked
 	PanicMessage     string                  // The panic message if it panicked
 	PanicFile        string                  // The file being processed when panic occurred
+	VersionSkipped   map[string]int          // QMD file -> diffs skipped for targeting another version
+
+	lastReadFile       string   // Last file announced by "Reading diff"
+	unattributedErrors []string // Lexer errors seen before any "Reading diff"
 }
 
 var (
@@ -88,7 +138,7 @@ func ParseCheckCompatibilityOutput(output string) *CheckCompatibilityResult {
 					result.HashErrors[file] = append(result.HashErrors[file], hashID)
 				}
 			}
-			logging.Debug(logging.ComponentQMD, "Parsed check-compatibility hash error: hash %d required by %v", hashID, files)
+			logging.DebugSampled(logging.ComponentQMD, "qmd.compat_hash_error", "Parsed check-compatibility hash error: hash %d required by %v", hashID, files)
 		}
 
 		if matches := totalErrorsRegex.FindStringSubmatch(line); len(matches) == 2 {
@@ -109,12 +159,11 @@ func ParseApplyDiffsOutput(output string) *ParsedOutput {
 		ProcessErrors:  make(map[string][]string),
 		WrittenFiles:   make(map[string][]string),
 		ProcessedFiles: make(map[string]bool),
+		VersionSkipped: make(map[string]int),
 	}
 
-	hashErrorRegex := regexp.MustCompile(`(?:(.+\.qmd) - Cannot resolve hash (\d+)|Cannot resolve hash (\d+) required by (.+\.qmd))`)
+	patterns := currentOutputRegexes()
 	lexerErrorRegex := regexp.MustCompile(`Lexer error at position (\d+) \(line (\d+)\): (.+)`)
-	processErrorRegex := regexp.MustCompile(`\(On behalf of '(.+\.qmd)'\): (.+)`)
-	writtenFileRegex := regexp.MustCompile(`Written file (.+\.qml) - (\d+) diff\(s\) applied`)
 	fileNotFoundRegex := regexp.MustCompile(`Cannot read file (.+\.qmd)`)
 	panicRegex := regexp.MustCompile(`panicked at`)
 
@@ -164,6 +213,7 @@ func ParseApplyDiffsOutput(output string) *ParsedOutput {
 
 		if matches := readingDiffRegex.FindStringSubmatch(line); len(matches) == 2 {
 			currentFile = filepath.Base(matches[1])
+			result.lastReadFile = currentFile
 			logging.Debug(logging.ComponentQMD, "Currently processing file: %s", currentFile)
 		}
 
@@ -174,41 +224,35 @@ func ParseApplyDiffsOutput(output string) *ParsedOutput {
 				result.ProcessedFiles[currentFile] = true
 				logging.Debug(logging.ComponentQMD, "Parsed lexer error from %s: %s", currentFile, errorMsg)
 			} else {
+				result.unattributedErrors = append(result.unattributedErrors, errorMsg)
 				logging.Debug(logging.ComponentQMD, "Parsed lexer error but no current file context: %s", errorMsg)
 			}
 		}
 
-		if matches := hashErrorRegex.FindStringSubmatch(line); len(matches) == 5 {
-			var hashID uint64
-			var qmdFile string
-
-			if matches[1] != "" && matches[2] != "" {
-				qmdFile = matches[1]
-				hashID, _ = strconv.ParseUint(matches[2], 10, 64)
-			} else if matches[3] != "" && matches[4] != "" {
-				hashID, _ = strconv.ParseUint(matches[3], 10, 64)
-				qmdFile = matches[4]
-			} else {
-				continue
+		if matches := patterns.hashError.FindStringSubmatch(line); matches != nil {
+			qmdFile := namedMatch(patterns.hashError, matches, "file")
+			hashID, err := strconv.ParseUint(namedMatch(patterns.hashError, matches, "hash"), 10, 64)
+			if qmdFile != "" && err == nil {
+				result.HashErrors[qmdFile] = append(result.HashErrors[qmdFile], HashError{
+					HashID: hashID,
+					Error:  line,
+				})
+				result.ProcessedFiles[qmdFile] = true
+
+				logging.DebugSampled(logging.ComponentQMD, "qmd.hash_error", "Parsed hash error from %s: hash %d", qmdFile, hashID)
 			}
-
-			result.HashErrors[qmdFile] = append(result.HashErrors[qmdFile], HashError{
-				HashID: hashID,
-				Error:  line,
-			})
-			result.ProcessedFiles[qmdFile] = true
-
-			logging.Debug(logging.ComponentQMD, "Parsed hash error from %s: hash %d", qmdFile, hashID)
 		}
 
-		if matches := processErrorRegex.FindStringSubmatch(line); len(matches) == 3 {
-			qmdFile := matches[1]
-			errorMsg := matches[2]
+		if matches := patterns.processError.FindStringSubmatch(line); matches != nil {
+			qmdFile := namedMatch(patterns.processError, matches, "file")
+			errorMsg := namedMatch(patterns.processError, matches, "message")
 
-			result.ProcessErrors[qmdFile] = append(result.ProcessErrors[qmdFile], errorMsg)
-			result.ProcessedFiles[qmdFile] = true
+			if qmdFile != "" {
+				result.ProcessErrors[qmdFile] = append(result.ProcessErrors[qmdFile], errorMsg)
+				result.ProcessedFiles[qmdFile] = true
 
-			logging.Debug(logging.ComponentQMD, "Parsed process error from %s: %s", qmdFile, errorMsg)
+				logging.Debug(logging.ComponentQMD, "Parsed process error from %s: %s", qmdFile, errorMsg)
+			}
 		}
 
 		if matches := fileNotFoundRegex.FindStringSubmatch(line); len(matches) == 2 {
@@ -220,15 +264,81 @@ func ParseApplyDiffsOutput(output string) *ParsedOutput {
 			logging.Debug(logging.ComponentQMD, "Detected LOAD failure at: %s", qmdFile)
 		}
 
-		if matches := writtenFileRegex.FindStringSubmatch(line); len(matches) == 3 {
-			qmlFile := matches[1]
-			logging.Debug(logging.ComponentQMD, "QML file modified: %s", qmlFile)
+		if matches := patterns.writtenFile.FindStringSubmatch(line); matches != nil {
+			qmlFile := namedMatch(patterns.writtenFile, matches, "qml")
+			logging.DebugSampled(logging.ComponentQMD, "qmd.qml_modified", "QML file modified: %s", qmlFile)
+		}
+
+		if matches := patterns.versionSkip.FindStringSubmatch(line); matches != nil {
+			qmdFile := namedMatch(patterns.versionSkip, matches, "file")
+			count := 1
+			if n, err := strconv.Atoi(namedMatch(patterns.versionSkip, matches, "count")); err == nil {
+				count = n
+			}
+			if qmdFile != "" {
+				result.VersionSkipped[qmdFile] += count
+				logging.Debug(logging.ComponentQMD, "Parsed %d version-skipped diff(s) from %s", count, qmdFile)
+			}
 		}
 	}
 
 	return result
 }
 
+// ParseApplyDiffsStreams parses apply-diffs stdout and stderr captured
+// separately, so lines from one stream can't split the other's, and merges
+// the results. Context one stream lacks is taken from the other: lexer errors
+// and panics with no "Reading diff" before them are attributed to the last
+// file the other stream was reading.
+func ParseApplyDiffsStreams(stdout, stderr string) *ParsedOutput {
+	result := ParseApplyDiffsOutput(stdout)
+	errResult := ParseApplyDiffsOutput(stderr)
+
+	attribute := func(errs []string, file string) {
+		if file == "" {
+			return
+		}
+		for _, errorMsg := range errs {
+			result.ProcessErrors[file] = append(result.ProcessErrors[file], errorMsg)
+			result.ProcessedFiles[file] = true
+		}
+	}
+	attribute(result.unattributedErrors, errResult.lastReadFile)
+	attribute(errResult.unattributedErrors, result.lastReadFile)
+
+	for file, errs := range errResult.HashErrors {
+		result.HashErrors[file] = append(result.HashErrors[file], errs...)
+	}
+	for file, errs := range errResult.ProcessErrors {
+		result.ProcessErrors[file] = append(result.ProcessErrors[file], errs...)
+	}
+	for file, written := range errResult.WrittenFiles {
+		result.WrittenFiles[file] = append(result.WrittenFiles[file], written...)
+	}
+	for file := range errResult.ProcessedFiles {
+		result.ProcessedFiles[file] = true
+	}
+	for file, count := range errResult.VersionSkipped {
+		result.VersionSkipped[file] += count
+	}
+	if result.FailureFile == "" {
+		result.FailureFile = errResult.FailureFile
+	}
+
+	if errResult.HadPanic && !result.HadPanic {
+		result.HadPanic = true
+		result.PanicMessage = errResult.PanicMessage
+		result.PanicFile = errResult.PanicFile
+		if result.PanicFile == "" {
+			result.PanicFile = result.lastReadFile
+		}
+	} else if result.HadPanic && result.PanicFile == "" {
+		result.PanicFile = errResult.lastReadFile
+	}
+
+	return result
+}
+
 // ReconcileResults combines expected dependencies with actual results
 func ReconcileResults(depInfo *DependencyInfo, parsedOutput *ParsedOutput) map[string]*ValidationResult {
 	results := make(map[string]*ValidationResult)
@@ -334,6 +444,8 @@ func ReconcileResults(depInfo *DependencyInfo, parsedOutput *ParsedOutput) map[s
 		logging.Debug(logging.ComponentQMD, "  Root file is the panic file - marking as failed")
 	}
 
+	attachVersionSkipped(rootResult, parsedOutput, depInfo.RootFile, rootBasename)
+
 	logging.Debug(logging.ComponentQMD, "  Final root result: Compatible=%v, Status=%s, HashErrors=%d, ProcessErrors=%d",
 		rootResult.Compatible, rootResult.Status, len(rootResult.HashErrors), len(rootResult.ProcessErrors))
 
@@ -342,6 +454,15 @@ func ReconcileResults(depInfo *DependencyInfo, parsedOutput *ParsedOutput) map[s
 
 	failurePoint := -1
 
+	notUploaded := make(map[string]bool, len(depInfo.NotUploaded))
+	for _, path := range depInfo.NotUploaded {
+		notUploaded[path] = true
+	}
+	outsideRoot := make(map[string]bool, len(depInfo.OutsideRoot))
+	for _, path := range depInfo.OutsideRoot {
+		outsideRoot[path] = true
+	}
+
 	for i, expectedFile := range depInfo.ExpectedLoads {
 		resolvedPath := ResolveLoadPath(depInfo.RootFile, expectedFile)
 
@@ -352,6 +473,24 @@ func ReconcileResults(depInfo *DependencyInfo, parsedOutput *ParsedOutput) map[s
 			Status:     StatusValidated,
 		}
 
+		// A missing file is reported as such even after an earlier failure,
+		// so it is not mistaken for a file that failed or was skipped
+		if notUploaded[expectedFile] || outsideRoot[expectedFile] {
+			if failurePoint == -1 && (parsedOutput.FailureFile == expectedFile || parsedOutput.FailureFile == resolvedPath) {
+				failurePoint = i
+			}
+			result.Status = StatusNotUploaded
+			result.Compatible = false
+			if outsideRoot[expectedFile] {
+				result.ProcessErrors = append(result.ProcessErrors, ErrLoadOutsideRoot.Error())
+			} else {
+				result.ProcessErrors = append(result.ProcessErrors, "LOADed but not uploaded")
+			}
+			results[expectedFile] = result
+			logging.Debug(logging.ComponentQMD, "File not uploaded: %s", expectedFile)
+			continue
+		}
+
 		if failurePoint != -1 && i > failurePoint {
 			result.Status = StatusNotAttempted
 			result.Compatible = false
@@ -370,7 +509,7 @@ func ReconcileResults(depInfo *DependencyInfo, parsedOutput *ParsedOutput) map[s
 		expectedFileBase := filepath.Base(expectedFile)
 		isPanicFile := parsedOutput.PanicFile != "" && parsedOutput.PanicFile == expectedFileBase
 
-		logging.Debug(logging.ComponentQMD, "Dependency check: expectedFile='%s', expectedFileBase='%s', PanicFile='%s', isPanicFile=%v, isFailure=%v, wasProcessed=%v",
+		logging.DebugSampled(logging.ComponentQMD, "qmd.dependency_check", "Dependency check: expectedFile='%s', expectedFileBase='%s', PanicFile='%s', isPanicFile=%v, isFailure=%v, wasProcessed=%v",
 			expectedFile, expectedFileBase, parsedOutput.PanicFile, isPanicFile, isFailure, wasProcessed)
 
 		if isFailure {
@@ -453,6 +592,8 @@ func ReconcileResults(depInfo *DependencyInfo, parsedOutput *ParsedOutput) map[s
 			result.Compatible = true
 		}
 
+		attachVersionSkipped(result, parsedOutput, expectedFile, resolvedPath)
+
 		results[expectedFile] = result
 	}
 
@@ -461,3 +602,42 @@ func ReconcileResults(depInfo *DependencyInfo, parsedOutput *ParsedOutput) map[s
 
 	return results
 }
+
+// BlockedChain follows the BlockedBy links from a not-attempted file back to
+// the failure that stopped validation: the file that blocked it, the file
+// that blocked that one, and so on. Returns nil if path wasn't blocked.
+func BlockedChain(results map[string]*ValidationResult, path string) []string {
+	var chain []string
+	seen := map[string]bool{path: true}
+	for {
+		result, ok := results[path]
+		if !ok || result.Status != StatusNotAttempted || result.BlockedBy == "" || seen[result.BlockedBy] {
+			return chain
+		}
+		path = result.BlockedBy
+		seen[path] = true
+		chain = append(chain, path)
+	}
+}
+
+// attachVersionSkipped records diffs qmldiff skipped in a file for targeting
+// another OS version. A file passes when nothing applied to it, so the skip
+// is called out rather than left to look like a clean pass.
+func attachVersionSkipped(result *ValidationResult, parsedOutput *ParsedOutput, paths ...string) {
+	for errorPath, count := range parsedOutput.VersionSkipped {
+		for _, path := range paths {
+			if errorPath == path || filepath.Base(errorPath) == path || strings.HasSuffix(errorPath, path) {
+				result.VersionSkipped += count
+				break
+			}
+		}
+	}
+	if result.VersionSkipped > 0 {
+		result.Warnings = append(result.Warnings, VersionSkippedMessage(result.VersionSkipped))
+	}
+}
+
+// VersionSkippedMessage describes diffs skipped due to version filtering
+func VersionSkippedMessage(count int) string {
+	return fmt.Sprintf("%d diff(s) skipped due to version mismatch", count)
+}
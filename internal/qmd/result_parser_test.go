@@ -0,0 +1,395 @@
+package qmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestHashErrorJSONPreservesLargeHashes(t *testing.T) {
+	// Larger than 2^53, so a float64-based JSON parser would lose precision
+	const largeHash uint64 = 17607111715072197239
+
+	result := ValidationResult{
+		Path:       "root.qmd",
+		Status:     StatusFailed,
+		Compatible: false,
+		HashErrors: []HashError{
+			{HashID: largeHash, Error: "Cannot resolve hash 17607111715072197239"},
+		},
+		Position: -1,
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+
+	// Decode the way a JavaScript client would: every number becomes a float64
+	var generic map[string]interface{}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&generic); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	hashErrors, ok := generic["hash_errors"].([]interface{})
+	if !ok || len(hashErrors) != 1 {
+		t.Fatalf("hash_errors = %v, want one entry", generic["hash_errors"])
+	}
+
+	hashID, ok := hashErrors[0].(map[string]interface{})["hash_id"].(string)
+	if !ok {
+		t.Fatalf("hash_id = %T, want string", hashErrors[0].(map[string]interface{})["hash_id"])
+	}
+	if hashID != "17607111715072197239" {
+		t.Errorf("hash_id = %s, want 17607111715072197239", hashID)
+	}
+
+	var roundTrip ValidationResult
+	if err := json.Unmarshal(data, &roundTrip); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if len(roundTrip.HashErrors) != 1 || roundTrip.HashErrors[0].HashID != largeHash {
+		t.Errorf("round-tripped HashErrors = %v, want hash %d", roundTrip.HashErrors, largeHash)
+	}
+}
+
+func TestHashErrorUnmarshalAcceptsNumber(t *testing.T) {
+	var he HashError
+	if err := json.Unmarshal([]byte(`{"hash_id": 12345, "error": "x"}`), &he); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if he.HashID != 12345 {
+		t.Errorf("HashID = %d, want 12345", he.HashID)
+	}
+}
+
+func newParsedOutput() *ParsedOutput {
+	return &ParsedOutput{
+		HashErrors:     make(map[string][]HashError),
+		ProcessErrors:  make(map[string][]string),
+		WrittenFiles:   make(map[string][]string),
+		ProcessedFiles: make(map[string]bool),
+		VersionSkipped: make(map[string]int),
+	}
+}
+
+func TestReconcileResults(t *testing.T) {
+	type want struct {
+		status     FileStatus
+		compatible bool
+		blockedBy  string
+		hashErrors int
+	}
+
+	tests := []struct {
+		name        string
+		loads       []string
+		notUploaded []string
+		outsideRoot []string
+		parsed      func() *ParsedOutput
+		want        map[string]want
+	}{
+		{
+			name:   "clean success",
+			loads:  []string{"a.qmd", "b.qmd"},
+			parsed: newParsedOutput,
+			want: map[string]want{
+				"root.qmd": {status: StatusValidated, compatible: true},
+				"a.qmd":    {status: StatusValidated, compatible: true},
+				"b.qmd":    {status: StatusValidated, compatible: true},
+			},
+		},
+		{
+			name:  "root hash error",
+			loads: []string{"a.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.HashErrors["root.qmd"] = []HashError{{HashID: 1}, {HashID: 2}}
+				p.ProcessedFiles["root.qmd"] = true
+				return p
+			},
+			want: map[string]want{
+				"root.qmd": {status: StatusFailed, compatible: false, hashErrors: 2},
+				"a.qmd":    {status: StatusValidated, compatible: true},
+			},
+		},
+		{
+			name:  "mid-chain failure cascades to not attempted",
+			loads: []string{"a.qmd", "b.qmd", "c.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.FailureFile = "b.qmd"
+				return p
+			},
+			want: map[string]want{
+				"root.qmd": {status: StatusValidated, compatible: true},
+				"a.qmd":    {status: StatusValidated, compatible: true},
+				"b.qmd":    {status: StatusFailed, compatible: false},
+				"c.qmd":    {status: StatusNotAttempted, compatible: false, blockedBy: "b.qmd"},
+			},
+		},
+		{
+			name:  "panic with identified file",
+			loads: []string{"a.qmd", "b.qmd", "c.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.HadPanic = true
+				p.PanicMessage = "thread 'main' panicked at src/main.rs"
+				p.PanicFile = "b.qmd"
+				return p
+			},
+			want: map[string]want{
+				"root.qmd": {status: StatusValidated, compatible: true},
+				"a.qmd":    {status: StatusValidated, compatible: true},
+				"b.qmd":    {status: StatusFailed, compatible: false},
+				"c.qmd":    {status: StatusNotAttempted, compatible: false, blockedBy: "b.qmd"},
+			},
+		},
+		{
+			name:  "panic without identified file",
+			loads: []string{"a.qmd", "b.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.HadPanic = true
+				p.PanicMessage = "thread 'main' panicked at src/main.rs"
+				return p
+			},
+			want: map[string]want{
+				"root.qmd": {status: StatusFailed, compatible: false},
+				"a.qmd":    {status: StatusNotAttempted, compatible: false, blockedBy: "root.qmd"},
+				"b.qmd":    {status: StatusNotAttempted, compatible: false, blockedBy: "root.qmd"},
+			},
+		},
+		{
+			name:  "absolute path matching",
+			loads: []string{"lib/a.qmd", "b.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.HashErrors["/upload/root.qmd"] = []HashError{{HashID: 1}}
+				p.HashErrors["/upload/lib/a.qmd"] = []HashError{{HashID: 2}}
+				p.ProcessedFiles["/upload/lib/a.qmd"] = true
+				return p
+			},
+			want: map[string]want{
+				"root.qmd":  {status: StatusFailed, compatible: false, hashErrors: 1},
+				"lib/a.qmd": {status: StatusFailed, compatible: false, hashErrors: 1},
+				"b.qmd":     {status: StatusValidated, compatible: true},
+			},
+		},
+		{
+			name:  "basename matching",
+			loads: []string{"a.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.ProcessErrors["root.qmd"] = []string{"Cannot locate element"}
+				p.HashErrors["a.qmd"] = []HashError{{HashID: 3}}
+				p.ProcessedFiles["a.qmd"] = true
+				return p
+			},
+			want: map[string]want{
+				"root.qmd": {status: StatusFailed, compatible: false},
+				"a.qmd":    {status: StatusFailed, compatible: false, hashErrors: 1},
+			},
+		},
+		{
+			name:        "missing dependency is not uploaded rather than failed",
+			loads:       []string{"a.qmd", "lib/missing.qmd", "c.qmd"},
+			notUploaded: []string{"lib/missing.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.FailureFile = "/upload/lib/missing.qmd"
+				return p
+			},
+			want: map[string]want{
+				"root.qmd":        {status: StatusValidated, compatible: true},
+				"a.qmd":           {status: StatusValidated, compatible: true},
+				"lib/missing.qmd": {status: StatusNotUploaded, compatible: false},
+				"c.qmd":           {status: StatusNotAttempted, compatible: false, blockedBy: "lib/missing.qmd"},
+			},
+		},
+		{
+			name:        "dependency outside the upload is not uploaded",
+			loads:       []string{"../shared/base.qmd", "c.qmd"},
+			outsideRoot: []string{"../shared/base.qmd"},
+			parsed: func() *ParsedOutput {
+				p := newParsedOutput()
+				p.FailureFile = "/shared/base.qmd"
+				return p
+			},
+			want: map[string]want{
+				"root.qmd":           {status: StatusValidated, compatible: true},
+				"../shared/base.qmd": {status: StatusNotUploaded, compatible: false},
+				"c.qmd":              {status: StatusNotAttempted, compatible: false, blockedBy: "../shared/base.qmd"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			depInfo := &DependencyInfo{
+				RootFile:      "/upload/root.qmd",
+				ExpectedLoads: tt.loads,
+				NotUploaded:   tt.notUploaded,
+				OutsideRoot:   tt.outsideRoot,
+			}
+
+			results := ReconcileResults(depInfo, tt.parsed())
+
+			if len(results) != len(tt.want) {
+				t.Errorf("got %d results, want %d", len(results), len(tt.want))
+			}
+
+			for path, w := range tt.want {
+				got, ok := results[path]
+				if !ok {
+					t.Errorf("missing result for %s", path)
+					continue
+				}
+				if got.Status != w.status {
+					t.Errorf("%s: Status = %s, want %s", path, got.Status, w.status)
+				}
+				if got.Compatible != w.compatible {
+					t.Errorf("%s: Compatible = %v, want %v", path, got.Compatible, w.compatible)
+				}
+				if got.BlockedBy != w.blockedBy {
+					t.Errorf("%s: BlockedBy = %q, want %q", path, got.BlockedBy, w.blockedBy)
+				}
+				if len(got.HashErrors) != w.hashErrors {
+					t.Errorf("%s: %d hash errors, want %d", path, len(got.HashErrors), w.hashErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestParseApplyDiffsStreams(t *testing.T) {
+	// Progress goes to stdout and errors to stderr, so neither stream alone
+	// says which file a lexer error belongs to
+	stdout := "Reading diff /tmp/up/main.qmd\nReading diff /tmp/up/lib/broken.qmd\n"
+	stderr := "Lexer error at position 12 (line 3): unexpected token\n" +
+		"/tmp/up/main.qmd - Cannot resolve hash 42\n" +
+		"(On behalf of '/tmp/up/main.qmd'): Cannot locate node\n"
+
+	parsed := ParseApplyDiffsStreams(stdout, stderr)
+
+	if got := parsed.ProcessErrors["broken.qmd"]; len(got) != 1 || got[0] != "Lexer error at position 12 (line 3): unexpected token" {
+		t.Errorf("ProcessErrors[broken.qmd] = %q, want the lexer error", got)
+	}
+	if got := parsed.HashErrors["/tmp/up/main.qmd"]; len(got) != 1 || got[0].HashID != 42 {
+		t.Errorf("HashErrors[/tmp/up/main.qmd] = %+v, want hash 42", got)
+	}
+	if got := parsed.ProcessErrors["/tmp/up/main.qmd"]; len(got) != 1 || got[0] != "Cannot locate node" {
+		t.Errorf("ProcessErrors[/tmp/up/main.qmd] = %q, want the process error", got)
+	}
+	if parsed.HadPanic {
+		t.Error("HadPanic = true, want false")
+	}
+
+	// A panic on stderr is blamed on the file stdout was reading
+	parsed = ParseApplyDiffsStreams(stdout, "thread 'main' panicked at src/parser.rs:10:5:\nindex out of bounds\n")
+	if !parsed.HadPanic || parsed.PanicFile != "broken.qmd" {
+		t.Errorf("HadPanic = %v, PanicFile = %q, want a panic in broken.qmd", parsed.HadPanic, parsed.PanicFile)
+	}
+}
+
+func TestVersionSkippedDiffs(t *testing.T) {
+	output := "Reading diff /tmp/up/main.qmd\n" +
+		"/tmp/up/main.qmd - 3 diff(s) skipped (version mismatch)\n" +
+		"Skipping diff in /tmp/up/lib/a.qmd: version mismatch\n"
+
+	parsed := ParseApplyDiffsOutput(output)
+	if got := parsed.VersionSkipped["/tmp/up/main.qmd"]; got != 3 {
+		t.Errorf("VersionSkipped[main.qmd] = %d, want 3", got)
+	}
+	if got := parsed.VersionSkipped["/tmp/up/lib/a.qmd"]; got != 1 {
+		t.Errorf("VersionSkipped[lib/a.qmd] = %d, want 1", got)
+	}
+
+	depInfo := &DependencyInfo{
+		RootFile:      "/tmp/up/main.qmd",
+		ExpectedLoads: []string{"lib/a.qmd", "b.qmd"},
+	}
+	results := ReconcileResults(depInfo, parsed)
+
+	// Skipped diffs don't fail a file, but are called out
+	root := results["main.qmd"]
+	if root.Status != StatusValidated || root.VersionSkipped != 3 {
+		t.Errorf("main.qmd: Status = %s, VersionSkipped = %d, want validated with 3 skipped", root.Status, root.VersionSkipped)
+	}
+	if len(root.Warnings) != 1 || root.Warnings[0] != "3 diff(s) skipped due to version mismatch" {
+		t.Errorf("main.qmd: Warnings = %q, want the skipped diffs", root.Warnings)
+	}
+	if got := results["lib/a.qmd"].VersionSkipped; got != 1 {
+		t.Errorf("lib/a.qmd: VersionSkipped = %d, want 1", got)
+	}
+	if got := results["b.qmd"]; got.VersionSkipped != 0 || len(got.Warnings) != 0 {
+		t.Errorf("b.qmd: VersionSkipped = %d, Warnings = %q, want none", got.VersionSkipped, got.Warnings)
+	}
+}
+
+func TestBlockedChain(t *testing.T) {
+	// a.qmd failed, and each later file was blocked by the one before it
+	results := map[string]*ValidationResult{
+		"root.qmd": {Path: "root.qmd", Status: StatusValidated, Compatible: true, Position: -1},
+		"a.qmd":    {Path: "a.qmd", Status: StatusFailed, Position: 0},
+		"b.qmd":    {Path: "b.qmd", Status: StatusNotAttempted, BlockedBy: "a.qmd", Position: 1},
+		"c.qmd":    {Path: "c.qmd", Status: StatusNotAttempted, BlockedBy: "b.qmd", Position: 2},
+		"d.qmd":    {Path: "d.qmd", Status: StatusNotAttempted, BlockedBy: "c.qmd", Position: 3},
+		"x.qmd":    {Path: "x.qmd", Status: StatusNotAttempted, BlockedBy: "y.qmd"},
+		"y.qmd":    {Path: "y.qmd", Status: StatusNotAttempted, BlockedBy: "x.qmd"},
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "d.qmd", want: []string{"c.qmd", "b.qmd", "a.qmd"}},
+		{path: "b.qmd", want: []string{"a.qmd"}},
+		{path: "a.qmd", want: nil},
+		{path: "missing.qmd", want: nil},
+		{path: "x.qmd", want: []string{"y.qmd"}}, // A cycle stops instead of looping
+	}
+
+	for _, tt := range tests {
+		if got := BlockedChain(results, tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("BlockedChain(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSetOutputPatterns(t *testing.T) {
+	defer SetOutputPatterns(OutputPatterns{})
+
+	tests := []struct {
+		name     string
+		patterns OutputPatterns
+		wantErr  bool
+	}{
+		{name: "defaults", patterns: OutputPatterns{}},
+		{name: "invalid regex", patterns: OutputPatterns{HashError: `(`}, wantErr: true},
+		{name: "missing group", patterns: OutputPatterns{HashError: `(?P<file>\S+) lacks (\d+)`}, wantErr: true},
+		{name: "missing message group", patterns: OutputPatterns{ProcessError: `(?P<file>\S+): .+`}, wantErr: true},
+		{name: "new wording", patterns: OutputPatterns{HashError: `Unknown hash (?P<hash>\d+) in (?P<file>\S+\.qmd)`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetOutputPatterns(tt.patterns); (err != nil) != tt.wantErr {
+				t.Errorf("SetOutputPatterns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The last valid patterns stay in effect after rejected ones
+	parsed := ParseApplyDiffsOutput("Unknown hash 42 in main.qmd\nmain.qmd - Cannot resolve hash 7\n")
+	if got := parsed.HashErrors["main.qmd"]; len(got) != 1 || got[0].HashID != 42 {
+		t.Errorf("HashErrors[main.qmd] = %+v, want only hash 42 from the custom pattern", got)
+	}
+
+	SetOutputPatterns(OutputPatterns{})
+	parsed = ParseApplyDiffsOutput("main.qmd - Cannot resolve hash 7\nCannot resolve hash 8 required by lib.qmd\n")
+	if len(parsed.HashErrors["main.qmd"]) != 1 || len(parsed.HashErrors["lib.qmd"]) != 1 {
+		t.Errorf("HashErrors = %+v, want both default formats parsed", parsed.HashErrors)
+	}
+}
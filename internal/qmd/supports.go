@@ -0,0 +1,47 @@
+package qmd
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// supportsRegex matches a supported-versions annotation in a QMD comment header
+// Example: ";; @supports: 3.22.*, 3.23.*"
+var supportsRegex = regexp.MustCompile(`(?m)^\s*;+\s*@supports:\s*(.+)$`)
+
+// ParseSupportedVersions extracts the version patterns declared by @supports annotations
+// Returns nil if the QMD does not declare any supported versions
+func ParseSupportedVersions(qmdContent string) []string {
+	var patterns []string
+	for _, match := range supportsRegex.FindAllStringSubmatch(qmdContent, -1) {
+		for _, pattern := range strings.Split(match[1], ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// VersionMatches reports whether an OS version matches a glob-style version pattern
+// such as "3.22.*"; invalid patterns never match
+func VersionMatches(pattern, version string) bool {
+	matched, err := path.Match(pattern, version)
+	return err == nil && matched
+}
+
+// SupportsVersion reports whether an OS version matches any of the given patterns
+// An empty pattern list supports every version
+func SupportsVersion(patterns []string, version string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if VersionMatches(pattern, version) {
+			return true
+		}
+	}
+	return false
+}
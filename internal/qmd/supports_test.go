@@ -0,0 +1,68 @@
+package qmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSupportedVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "single annotation",
+			content: ";; @supports: 3.22.*, 3.23.*\nAFFECT [[123]]\n",
+			want:    []string{"3.22.*", "3.23.*"},
+		},
+		{
+			name:    "multiple annotations",
+			content: ";; @supports: 3.22.*\n;; @supports: 3.24.0.1\n",
+			want:    []string{"3.22.*", "3.24.0.1"},
+		},
+		{
+			name:    "no annotation",
+			content: "; regular comment\nAFFECT [[123]]\n",
+			want:    nil,
+		},
+		{
+			name:    "annotation not in a comment",
+			content: "@supports: 3.22.*\n",
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseSupportedVersions(tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSupportedVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupportsVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		version  string
+		want     bool
+	}{
+		{"no patterns", nil, "3.22.0.65", true},
+		{"wildcard match", []string{"3.22.*"}, "3.22.0.65", true},
+		{"wildcard mismatch", []string{"3.22.*"}, "3.23.0.1", false},
+		{"exact match", []string{"3.20.0.52"}, "3.20.0.52", true},
+		{"second pattern matches", []string{"3.22.*", "3.23.*"}, "3.23.0.1", true},
+		{"invalid pattern", []string{"3.22.["}, "3.22.0.65", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SupportsVersion(tt.patterns, tt.version); got != tt.want {
+				t.Errorf("SupportsVersion(%v, %q) = %v, want %v", tt.patterns, tt.version, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,59 @@
 package qmd
 
+import "fmt"
+
+// Positions beyond these limits (e.g. in minified single-line QMDs) are clamped
+// and flagged as approximate; Offset still locates the hash exactly
+const (
+	MaxReportedLine   = 1000000
+	MaxReportedColumn = 10000
+)
+
 // HashWithPosition represents a hash value with its position in the source file
 type HashWithPosition struct {
-	Hash   uint64
-	Line   int
-	Column int
+	Hash        uint64
+	Line        int
+	Column      int
+	Offset      int  // Byte offset of the hash in the file
+	Approximate bool // Line or Column was clamped to the reporting limits
+}
+
+// clampPosition caps a line/column pair to the reporting limits
+func clampPosition(line, column int) (int, int, bool) {
+	approximate := false
+	if line > MaxReportedLine {
+		line = MaxReportedLine
+		approximate = true
+	}
+	if column > MaxReportedColumn {
+		column = MaxReportedColumn
+		approximate = true
+	}
+	return line, column, approximate
+}
+
+// AffectTarget is the file an AFFECT directive modifies
+// Literal targets have only Path. Hashed targets ([[123]]) have Hash, and
+// Path too once the hash has been resolved against a hashtable.
+type AffectTarget struct {
+	Path string
+	Hash uint64
+}
+
+// Hashed reports whether the target was written as a hash reference
+func (t AffectTarget) Hashed() bool {
+	return t.Hash != 0
+}
+
+// Resolved reports whether the target's path is known
+func (t AffectTarget) Resolved() bool {
+	return t.Path != ""
+}
+
+// String returns the target's path, or its hash reference if unresolved
+func (t AffectTarget) String() string {
+	if t.Path == "" {
+		return fmt.Sprintf("[[%d]]", t.Hash)
+	}
+	return t.Path
 }
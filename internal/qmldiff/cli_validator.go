@@ -1,6 +1,9 @@
 package qmldiff
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -29,6 +32,10 @@ type TreeValidationResult struct {
 	FailedHashes []uint64
 	// DependencyResults contains per-file validation results including LOADed dependencies
 	DependencyResults map[string]*qmd.ValidationResult
+	// VersionSkipped is the number of diffs qmldiff skipped for targeting another OS version
+	VersionSkipped int
+	// ModifiedFiles lists the tree-relative paths of QML files the diffs changed
+	ModifiedFiles []string
 }
 
 // TreeValidationError represents an error encountered during tree validation
@@ -47,17 +54,32 @@ type BatchTreeValidationResult struct {
 
 // ValidateMultipleQMDsWithCLI validates multiple QMD files by calling the qmldiff CLI binary
 // Each QMD file is processed in a separate qmldiff process for isolation
-func ValidateMultipleQMDsWithCLI(qmdPaths []string, hashtabPath string, treePath string, qmldiffBinary string) (*BatchTreeValidationResult, error) {
+// Once ctx ends, running processes are killed and ctx's error is returned
+// with the results of the files already done.
+func ValidateMultipleQMDsWithCLI(ctx context.Context, qmdPaths []string, hashtabPath string, treePath string, qmldiffBinary string) (*BatchTreeValidationResult, error) {
 	result := &BatchTreeValidationResult{
 		Results: make(map[string]*TreeValidationResult),
 		Errors:  make(map[string]error),
 	}
 
 	for _, qmdPath := range qmdPaths {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
 		logging.Info(logging.ComponentQMLDiff, "Validating QMD with dependency tracking: %s", qmdPath)
 
-		depResults, err := ValidateWithDependencies(qmdPath, hashtabPath, treePath, qmldiffBinary)
+		depResults, modified, err := validateWithDependencies(ctx, qmdPath, hashtabPath, treePath, qmldiffBinary)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		// Keep upload temp paths out of results
+		qmdDir := filepath.Dir(qmdPath)
+		depResults = relativizeDependencyResults(depResults, qmdDir)
+		err = scrubError(err, qmdDir)
 		treeResult := flattenDependencyResults(depResults, err)
+		treeResult.FilesModified = len(modified)
+		treeResult.ModifiedFiles = modified
 
 		if err != nil {
 			result.Errors[qmdPath] = err
@@ -74,13 +96,17 @@ func ValidateMultipleQMDsWithCLI(qmdPaths []string, hashtabPath string, treePath
 
 // ValidateMultipleQMDsWithCLIAndCopy creates a temp directory with tree copy and validates QMDs
 // Uses two-phase validation: check-compatibility for hashes, then apply-diffs for structure
-func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, treePath string, qmldiffBinary string) (*BatchTreeValidationResult, error) {
+func ValidateMultipleQMDsWithCLIAndCopy(ctx context.Context, qmdPaths []string, hashtabPath string, treePath string, qmldiffBinary string) (*BatchTreeValidationResult, error) {
 	result := &BatchTreeValidationResult{
 		Results: make(map[string]*TreeValidationResult),
 		Errors:  make(map[string]error),
 	}
 
 	for _, qmdPath := range qmdPaths {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
 		logging.Info(logging.ComponentQMLDiff, "Validating QMD via CLI with tree copy: %s", qmdPath)
 
 		treeResult := &TreeValidationResult{
@@ -88,7 +114,7 @@ func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, t
 		}
 
 		// Phase 1: Check hash compatibility
-		compatResult, err := CheckCompatibility([]string{qmdPath}, hashtabPath, qmldiffBinary)
+		compatResult, err := CheckCompatibility(ctx, []string{qmdPath}, hashtabPath, qmldiffBinary)
 		if err != nil {
 			result.Errors[qmdPath] = fmt.Errorf("check-compatibility failed: %w", err)
 			continue
@@ -103,7 +129,7 @@ func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, t
 				for _, hashID := range hashIDs {
 					treeResult.FailedHashes = append(treeResult.FailedHashes, hashID)
 					treeResult.Errors = append(treeResult.Errors, TreeValidationError{
-						FilePath: filePath,
+						FilePath: displayPath(filepath.Dir(qmdPath), filePath),
 						Error:    fmt.Sprintf("Cannot resolve hash %d", hashID),
 					})
 				}
@@ -126,7 +152,9 @@ func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, t
 			continue
 		}
 
-		cmd := exec.Command(
+		stdout, stderr, err := runQMLDiff(
+			ctx,
+			qmdPath,
 			qmldiffBinary,
 			"apply-diffs",
 			"--hashtab", hashtabPath,
@@ -134,9 +162,14 @@ func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, t
 			treeOutput, // Same path - modify in place
 			qmdPath,
 		)
+		outputStr := joinStreams(stderr, stdout)
 
-		output, err := cmd.CombinedOutput()
-		outputStr := string(output)
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			logging.Warn(logging.ComponentQMLDiff, "%v", err)
+			result.Errors[qmdPath] = err
+			continue
+		}
 
 		if err != nil {
 			exitCode := -1
@@ -146,7 +179,7 @@ func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, t
 
 			if strings.Contains(outputStr, "panicked at") || strings.Contains(outputStr, "SIGABRT") {
 				logging.Warn(logging.ComponentQMLDiff, "qmldiff panicked for %s: %s", qmdPath, outputStr)
-				result.Errors[qmdPath] = fmt.Errorf("qmldiff panicked: %s", extractPanicMessage(outputStr))
+				result.Errors[qmdPath] = fmt.Errorf("qmldiff panicked: %s", scrubPaths(extractPanicMessage(outputStr), filepath.Dir(qmdPath), tempDir))
 				continue
 			} else {
 				logging.Warn(logging.ComponentQMLDiff, "qmldiff failed for %s (exit code %d): %s", qmdPath, exitCode, outputStr)
@@ -155,16 +188,15 @@ func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, t
 			}
 		}
 
-		modifiedCount := 0
-		filepath.WalkDir(treeOutput, func(path string, d fs.DirEntry, err error) error {
-			if err == nil && !d.IsDir() && strings.HasSuffix(path, ".qml") {
-				modifiedCount++
-			}
-			return nil
-		})
+		processed, modified, err := compareTrees(treePath, treeOutput)
+		if err != nil {
+			result.Errors[qmdPath] = fmt.Errorf("failed to compare tree output: %w", err)
+			continue
+		}
 
-		treeResult.FilesProcessed = modifiedCount
-		treeResult.FilesModified = modifiedCount
+		treeResult.FilesProcessed = processed
+		treeResult.FilesModified = len(modified)
+		treeResult.ModifiedFiles = modified
 		treeResult.FilesWithErrors = 0
 
 		result.Results[qmdPath] = treeResult
@@ -176,6 +208,41 @@ func ValidateMultipleQMDsWithCLIAndCopy(qmdPaths []string, hashtabPath string, t
 	return result, nil
 }
 
+// compareTrees counts the QML files in a diffed copy of a tree and lists,
+// relative to the tree, those whose contents differ from the original.
+// Files that only exist in the copy count as changed.
+func compareTrees(original, output string) (int, []string, error) {
+	processed := 0
+	modified := make([]string, 0)
+	err := filepath.WalkDir(output, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".qml") {
+			return nil
+		}
+		processed++
+
+		relPath, err := filepath.Rel(output, path)
+		if err != nil {
+			return err
+		}
+		after, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		before, err := os.ReadFile(filepath.Join(original, relPath))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err != nil || !bytes.Equal(before, after) {
+			modified = append(modified, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	return processed, modified, err
+}
+
 // copyTree recursively copies a directory tree
 func copyTree(src, dst string) error {
 	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
@@ -218,18 +285,18 @@ func copyFile(src, dst string) error {
 
 // CheckCompatibility runs qmldiff check-compatibility to validate hash compatibility
 // This is Phase 1 of two-phase validation - checks that all hashes exist in the hashtab
-func CheckCompatibility(qmdPaths []string, hashtabPath string, qmldiffBinary string) (*qmd.CheckCompatibilityResult, error) {
+func CheckCompatibility(ctx context.Context, qmdPaths []string, hashtabPath string, qmldiffBinary string) (*qmd.CheckCompatibilityResult, error) {
 	args := []string{"check-compatibility", hashtabPath}
 	args = append(args, qmdPaths...)
 
-	cmd := exec.Command(qmldiffBinary, args...)
-	logging.Debug(logging.ComponentQMLDiff, "check-compatibility command: %s", strings.Join(cmd.Args, " "))
+	logging.Debug(logging.ComponentQMLDiff, "check-compatibility command: %s %s", qmldiffBinary, strings.Join(args, " "))
 
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+	stdout, stderr, err := runQMLDiff(ctx, strings.Join(qmdPaths, ", "), qmldiffBinary, args...)
+	outputStr := joinStreams(stdout, stderr)
 
 	logging.Debug(logging.ComponentQMLDiff, "check-compatibility output:\n%s", outputStr)
 
+	// Every line stands alone, so the streams can be parsed one after the other
 	result := qmd.ParseCheckCompatibilityOutput(outputStr)
 
 	if err != nil {
@@ -243,6 +310,8 @@ func CheckCompatibility(qmdPaths []string, hashtabPath string, qmldiffBinary str
 				// Other error
 				return nil, fmt.Errorf("check-compatibility failed (exit %d): %w", exitCode, err)
 			}
+		} else if _, ok := err.(*TimeoutError); ok {
+			return nil, err
 		} else {
 			return nil, fmt.Errorf("check-compatibility failed: %w", err)
 		}
@@ -316,13 +385,20 @@ func reconcileHashErrors(depInfo *qmd.DependencyInfo, compatResult *qmd.CheckCom
 // ValidateWithDependencies validates a single QMD file using two-phase approach:
 // Phase 1: check-compatibility for hash validation
 // Phase 2: apply-diffs for structural validation (only if Phase 1 passes)
-func ValidateWithDependencies(qmdPath string, hashtabPath string, treePath string, qmldiffBinary string) (map[string]*qmd.ValidationResult, error) {
+func ValidateWithDependencies(ctx context.Context, qmdPath string, hashtabPath string, treePath string, qmldiffBinary string) (map[string]*qmd.ValidationResult, error) {
+	results, _, err := validateWithDependencies(ctx, qmdPath, hashtabPath, treePath, qmldiffBinary)
+	return results, err
+}
+
+// validateWithDependencies is ValidateWithDependencies, also returning the
+// tree-relative paths of the QML files apply-diffs changed
+func validateWithDependencies(ctx context.Context, qmdPath string, hashtabPath string, treePath string, qmldiffBinary string) (map[string]*qmd.ValidationResult, []string, error) {
 	logging.Info(logging.ComponentQMLDiff, "Validating QMD with dependency tracking: %s", qmdPath)
 
 	// Build dependency info for UI reporting
 	depInfo, err := qmd.BuildDependencyInfo(qmdPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build dependency info: %w", err)
+		return nil, nil, fmt.Errorf("failed to build dependency info: %w", err)
 	}
 
 	logging.Info(logging.ComponentQMLDiff, "Found %d LOAD statements in %s", len(depInfo.ExpectedLoads), qmdPath)
@@ -333,15 +409,21 @@ func ValidateWithDependencies(qmdPath string, hashtabPath string, treePath strin
 
 	// Phase 1: Check hash compatibility
 	logging.Info(logging.ComponentQMLDiff, "Phase 1: Running check-compatibility")
-	compatResult, err := CheckCompatibility([]string{qmdPath}, hashtabPath, qmldiffBinary)
+	compatResult, err := CheckCompatibility(ctx, []string{qmdPath}, hashtabPath, qmldiffBinary)
 	if err != nil {
-		return nil, fmt.Errorf("check-compatibility failed: %w", err)
+		if timeoutErr, ok := err.(*TimeoutError); ok {
+			results, err := timeoutResults(depInfo, timeoutErr)
+			return results, nil, err
+		}
+		return nil, nil, fmt.Errorf("check-compatibility failed: %w", err)
 	}
 
 	if compatResult.HasErrors {
 		// Hash errors found - return them without running apply-diffs
 		logging.Info(logging.ComponentQMLDiff, "Phase 1 failed: %d hash errors found", compatResult.TotalErrors)
-		return reconcileHashErrors(depInfo, compatResult), nil
+		results := reconcileHashErrors(depInfo, compatResult)
+		qmd.AttachDependencyWarnings(depInfo, results)
+		return results, nil, nil
 	}
 
 	logging.Info(logging.ComponentQMLDiff, "Phase 1 passed: No hash errors")
@@ -351,27 +433,35 @@ func ValidateWithDependencies(qmdPath string, hashtabPath string, treePath strin
 
 	outputDir, err := os.MkdirTemp("", "qmldiff-output-*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create output dir: %w", err)
+		return nil, nil, fmt.Errorf("failed to create output dir: %w", err)
 	}
 	defer os.RemoveAll(outputDir)
 
-	cmd := exec.Command(
-		qmldiffBinary,
+	args := []string{
 		"apply-diffs",
 		"--hashtab", hashtabPath,
 		treePath,
 		outputDir,
 		qmdPath,
-	)
+	}
 
-	logging.Debug(logging.ComponentQMLDiff, "apply-diffs command: %s", strings.Join(cmd.Args, " "))
+	logging.Debug(logging.ComponentQMLDiff, "apply-diffs command: %s %s", qmldiffBinary, strings.Join(args, " "))
 
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+	stdout, stderr, err := runQMLDiff(ctx, qmdPath, qmldiffBinary, args...)
+	outputStr := joinStreams(stderr, stdout)
 
-	logging.Debug(logging.ComponentQMLDiff, "apply-diffs output:\n%s", outputStr)
+	if timeoutErr, ok := err.(*TimeoutError); ok {
+		results, err := timeoutResults(depInfo, timeoutErr)
+		return results, nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
 
-	parsed := qmd.ParseApplyDiffsOutput(outputStr)
+	logging.Debug(logging.ComponentQMLDiff, "apply-diffs stdout:\n%s", stdout)
+	logging.Debug(logging.ComponentQMLDiff, "apply-diffs stderr:\n%s", stderr)
+
+	parsed := qmd.ParseApplyDiffsStreams(stdout, stderr)
 
 	if err != nil {
 		exitCode := -1
@@ -385,7 +475,9 @@ func ValidateWithDependencies(qmdPath string, hashtabPath string, treePath strin
 		if parsed.HadPanic {
 			panicMsg := extractPanicMessage(outputStr)
 			logging.Warn(logging.ComponentQMLDiff, "apply-diffs panicked: %s", panicMsg)
-			return createErrorResults(depInfo, fmt.Sprintf("qmldiff panicked: %s", panicMsg)), fmt.Errorf("qmldiff panicked")
+			results := createErrorResults(depInfo, fmt.Sprintf("qmldiff panicked: %s", panicMsg))
+			qmd.AttachDependencyWarnings(depInfo, results)
+			return results, nil, fmt.Errorf("qmldiff panicked")
 		} else if exitCode > 0 {
 			logging.Warn(logging.ComponentQMLDiff, "apply-diffs failed (exit %d), attempting to use partial results", exitCode)
 		}
@@ -393,11 +485,21 @@ func ValidateWithDependencies(qmdPath string, hashtabPath string, treePath strin
 		logging.Debug(logging.ComponentQMLDiff, "apply-diffs exit code: 0 (success)")
 	}
 
+	// apply-diffs leaves a file in outputDir for each QML file it wrote, so
+	// comparing against the tree tells which ones actually changed
+	_, modified, compareErr := compareTrees(treePath, outputDir)
+	if compareErr != nil {
+		logging.Warn(logging.ComponentQMLDiff, "Failed to compare apply-diffs output with the tree: %v", compareErr)
+	}
+
 	results := qmd.ReconcileResults(depInfo, parsed)
+	qmd.AttachDependencyWarnings(depInfo, results)
+	results = relativizeDependencyResults(results, qmdDir, outputDir)
 
 	validated := 0
 	failed := 0
 	notAttempted := 0
+	notUploaded := 0
 	for _, result := range results {
 		switch result.Status {
 		case qmd.StatusValidated:
@@ -406,13 +508,15 @@ func ValidateWithDependencies(qmdPath string, hashtabPath string, treePath strin
 			failed++
 		case qmd.StatusNotAttempted:
 			notAttempted++
+		case qmd.StatusNotUploaded:
+			notUploaded++
 		}
 	}
 
-	logging.Info(logging.ComponentQMLDiff, "Validation complete: %d validated, %d failed, %d not attempted",
-		validated, failed, notAttempted)
+	logging.Info(logging.ComponentQMLDiff, "Validation complete: %d validated, %d failed, %d not attempted, %d not uploaded",
+		validated, failed, notAttempted, notUploaded)
 
-	return results, nil
+	return results, modified, nil
 }
 
 // flattenDependencyResults converts dependency-aware results into a TreeValidationResult
@@ -427,26 +531,26 @@ func flattenDependencyResults(depResults map[string]*qmd.ValidationResult, valid
 	logging.Info(logging.ComponentQMLDiff, "Created TreeValidationResult with %d dependency entries", len(depResults))
 
 	if validationErr != nil {
+		filePath := "validation"
+		var timeoutErr *TimeoutError
+		if errors.As(validationErr, &timeoutErr) {
+			filePath = filepath.Base(timeoutErr.QMDPath)
+		}
 		result.FilesWithErrors = 1
 		result.Errors = append(result.Errors, TreeValidationError{
-			FilePath: "validation",
+			FilePath: filePath,
 			Error:    validationErr.Error(),
 		})
 		return result
 	}
 
 	filesProcessed := 0
-	filesModified := 0
 
 	for filePath, fileResult := range depResults {
 		if fileResult.Status == qmd.StatusValidated || fileResult.Status == qmd.StatusFailed {
 			filesProcessed++
 		}
 
-		if fileResult.Status != qmd.StatusNotAttempted {
-			filesModified++
-		}
-
 		if fileResult.Position == -1 {
 			for _, hashErr := range fileResult.HashErrors {
 				result.FailedHashes = append(result.FailedHashes, hashErr.HashID)
@@ -467,10 +571,11 @@ func flattenDependencyResults(depResults map[string]*qmd.ValidationResult, valid
 		if !fileResult.Compatible {
 			result.FilesWithErrors++
 		}
+
+		result.VersionSkipped += fileResult.VersionSkipped
 	}
 
 	result.FilesProcessed = filesProcessed
-	result.FilesModified = filesModified
 
 	result.HasHashErrors = len(result.FailedHashes) > 0
 	if !result.HasHashErrors {
@@ -486,6 +591,15 @@ func flattenDependencyResults(depResults map[string]*qmd.ValidationResult, valid
 }
 
 // extractPanicMessage extracts the panic message from qmldiff output
+// joinStreams concatenates captured streams for logging and line-by-line
+// scanning, keeping each stream's lines whole
+func joinStreams(first, second string) string {
+	if first != "" && !strings.HasSuffix(first, "\n") {
+		first += "\n"
+	}
+	return first + second
+}
+
 func extractPanicMessage(output string) string {
 	lines := strings.Split(output, "\n")
 	for _, line := range lines {
@@ -496,6 +610,15 @@ func extractPanicMessage(output string) string {
 	return "unknown panic"
 }
 
+// timeoutResults marks the root file failed with a killed qmldiff run's
+// timeout and its dependencies as not attempted
+func timeoutResults(depInfo *qmd.DependencyInfo, err *TimeoutError) (map[string]*qmd.ValidationResult, error) {
+	logging.Warn(logging.ComponentQMLDiff, "%v", err)
+	results := createErrorResults(depInfo, err.Error())
+	qmd.AttachDependencyWarnings(depInfo, results)
+	return results, err
+}
+
 // createErrorResults creates ValidationResults for all files when qmldiff fails
 // Marks root file as failed with error message, and all dependencies as not attempted
 func createErrorResults(depInfo *qmd.DependencyInfo, errorMsg string) map[string]*qmd.ValidationResult {
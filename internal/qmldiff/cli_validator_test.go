@@ -0,0 +1,101 @@
+package qmldiff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCompareTreesListsChangedFiles(t *testing.T) {
+	original := t.TempDir()
+	files := map[string]string{
+		"main.qml":            "Item {}\n",
+		"components/Page.qml": "Page {}\n",
+		"components/Menu.qml": "Menu {}\n",
+		"qmldir":              "module x\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(original, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	output := filepath.Join(t.TempDir(), "tree")
+	if err := copyTree(original, output); err != nil {
+		t.Fatalf("copyTree() failed: %v", err)
+	}
+
+	processed, modified, err := compareTrees(original, output)
+	if err != nil {
+		t.Fatalf("compareTrees() failed: %v", err)
+	}
+	if processed != 3 || len(modified) != 0 {
+		t.Errorf("unchanged copy: processed = %d, modified = %v, want 3 and none", processed, modified)
+	}
+
+	// Rewriting a file with the same bytes is not a change
+	if err := os.WriteFile(filepath.Join(output, "main.qml"), []byte("Item {}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(output, "components/Page.qml"), []byte("Page { id: page }\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(output, "components/New.qml"), []byte("Item {}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	processed, modified, err = compareTrees(original, output)
+	if err != nil {
+		t.Fatalf("compareTrees() failed: %v", err)
+	}
+	if want := []string{"components/New.qml", "components/Page.qml"}; processed != 4 || !reflect.DeepEqual(modified, want) {
+		t.Errorf("processed = %d, modified = %v, want 4 and %v", processed, modified, want)
+	}
+}
+
+func TestValidateMultipleQMDsWithCLIListsModifiedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake qmldiff is a shell script")
+	}
+
+	dir := t.TempDir()
+	treePath := filepath.Join(dir, "tree")
+	if err := os.MkdirAll(treePath, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	for _, name := range []string{"Main.qml", "Other.qml"} {
+		if err := os.WriteFile(filepath.Join(treePath, name), []byte("Item {}\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	// apply-diffs --hashtab <hashtab> <tree> <out> <qmd> writes the whole
+	// tree, changing only Main.qml
+	binary := filepath.Join(dir, "qmldiff")
+	script := "#!/bin/sh\nif [ \"$1\" = apply-diffs ]; then\n  cp -R \"$4\"/. \"$5\"/\n  echo 'Item { id: root }' > \"$5/Main.qml\"\nfi\nexit 0\n"
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	qmdPath := filepath.Join(dir, "mod.qmd")
+	if err := os.WriteFile(qmdPath, []byte("AFFECT [[1]]\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	result, err := ValidateMultipleQMDsWithCLI(context.Background(), []string{qmdPath}, filepath.Join(dir, "hashtab"), treePath, binary)
+	if err != nil {
+		t.Fatalf("ValidateMultipleQMDsWithCLI() failed: %v", err)
+	}
+	treeResult := result.Results[qmdPath]
+	if treeResult == nil {
+		t.Fatalf("no result for %s: %v", qmdPath, result.Errors)
+	}
+	if want := []string{"Main.qml"}; treeResult.FilesModified != 1 || !reflect.DeepEqual(treeResult.ModifiedFiles, want) {
+		t.Errorf("FilesModified = %d, ModifiedFiles = %v, want 1 and %v", treeResult.FilesModified, treeResult.ModifiedFiles, want)
+	}
+}
@@ -0,0 +1,73 @@
+package qmldiff
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+)
+
+// displayPath returns path relative to root for use in results
+// Absolute paths outside root are reduced to their base name so server
+// directories never reach API or CLI output
+func displayPath(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(rel)
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Base(path)
+	}
+	return path
+}
+
+// scrubPaths removes the given root directories from free-form text such as
+// qmldiff error output, leaving the paths relative to them
+func scrubPaths(text string, roots ...string) string {
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		root = filepath.Clean(root)
+		text = strings.ReplaceAll(text, root+string(filepath.Separator), "")
+		text = strings.ReplaceAll(text, root, ".")
+	}
+	return text
+}
+
+// scrubError returns err with the given root directories removed from its message
+func scrubError(err error, roots ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	scrubbed := scrubPaths(msg, roots...)
+	if scrubbed == msg {
+		return err
+	}
+	return errors.New(scrubbed)
+}
+
+// relativizeDependencyResults rewrites the paths and messages in dependency
+// results so they are relative to the given root directories
+func relativizeDependencyResults(results map[string]*qmd.ValidationResult, roots ...string) map[string]*qmd.ValidationResult {
+	if len(roots) == 0 || results == nil {
+		return results
+	}
+
+	relativized := make(map[string]*qmd.ValidationResult, len(results))
+	for key, result := range results {
+		result.Path = displayPath(roots[0], result.Path)
+		if result.BlockedBy != "" {
+			result.BlockedBy = displayPath(roots[0], result.BlockedBy)
+		}
+		for i, msg := range result.ProcessErrors {
+			result.ProcessErrors[i] = scrubPaths(msg, roots...)
+		}
+		for i, msg := range result.Warnings {
+			result.Warnings[i] = scrubPaths(msg, roots...)
+		}
+		relativized[displayPath(roots[0], key)] = result
+	}
+	return relativized
+}
@@ -0,0 +1,88 @@
+package qmldiff
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+)
+
+func TestDisplayPath(t *testing.T) {
+	tests := []struct {
+		name string
+		root string
+		path string
+		want string
+	}{
+		{"inside root", "/tmp/qmd-upload-1", "/tmp/qmd-upload-1/file.qmd", "file.qmd"},
+		{"nested inside root", "/tmp/qmd-upload-1", "/tmp/qmd-upload-1/lib/shared.qmd", "lib/shared.qmd"},
+		{"outside root", "/tmp/qmd-upload-1", "/tmp/qmldiff-output-2/file.qmd", "file.qmd"},
+		{"already relative", "/tmp/qmd-upload-1", "../shared.qmd", "../shared.qmd"},
+		{"base name", "/tmp/qmd-upload-1", "file.qmd", "file.qmd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := displayPath(tt.root, tt.path); got != tt.want {
+				t.Errorf("displayPath(%q, %q) = %q, want %q", tt.root, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultsContainNoTempPaths(t *testing.T) {
+	uploadDir := "/tmp/qmd-upload-1234"
+	outputDir := "/tmp/qmldiff-output-5678"
+
+	depResults := map[string]*qmd.ValidationResult{
+		uploadDir + "/main.qmd": {
+			Path:          uploadDir + "/main.qmd",
+			Status:        qmd.StatusFailed,
+			ProcessErrors: []string{"Error in " + uploadDir + "/main.qmd: unexpected token"},
+			Position:      -1,
+		},
+		"lib/shared.qmd": {
+			Path:          "lib/shared.qmd",
+			Status:        qmd.StatusFailed,
+			ProcessErrors: []string{"cannot write " + outputDir + "/ui/Main.qml"},
+			BlockedBy:     uploadDir + "/main.qmd",
+			Position:      0,
+		},
+	}
+
+	depResults = relativizeDependencyResults(depResults, uploadDir, outputDir)
+	err := scrubError(errors.New("failed to build dependency info: open "+uploadDir+"/main.qmd: no such file"), uploadDir)
+	result := flattenDependencyResults(depResults, err)
+	result.DependencyResults = depResults
+
+	data, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		t.Fatalf("Marshal() failed: %v", marshalErr)
+	}
+	if strings.Contains(string(data), "/tmp/") {
+		t.Errorf("result contains a temp path: %s", data)
+	}
+
+	if _, ok := depResults["main.qmd"]; !ok {
+		t.Errorf("dependency results keys = %v, want main.qmd", depResults)
+	}
+	if got := depResults["lib/shared.qmd"].BlockedBy; got != "main.qmd" {
+		t.Errorf("BlockedBy = %q, want %q", got, "main.qmd")
+	}
+}
+
+func TestSaveUploadedFileKeepsName(t *testing.T) {
+	path, err := SaveUploadedFile(strings.NewReader("AFFECT [[1]]\n"), "my-mod.qmd")
+	if err != nil {
+		t.Fatalf("SaveUploadedFile() failed: %v", err)
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if got := filepath.Base(path); got != "my-mod.qmd" {
+		t.Errorf("saved file name = %q, want %q", got, "my-mod.qmd")
+	}
+}
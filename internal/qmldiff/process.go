@@ -0,0 +1,76 @@
+package qmldiff
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a single qmldiff invocation unless SetTimeout changes it
+const DefaultTimeout = 30 * time.Second
+
+var (
+	timeoutMu sync.RWMutex
+	timeout   = DefaultTimeout
+)
+
+// SetTimeout sets how long a single qmldiff invocation may run before it and
+// any processes it started are killed; 0 or less disables the limit
+func SetTimeout(d time.Duration) {
+	timeoutMu.Lock()
+	defer timeoutMu.Unlock()
+	timeout = d
+}
+
+func currentTimeout() time.Duration {
+	timeoutMu.RLock()
+	defer timeoutMu.RUnlock()
+	return timeout
+}
+
+// TimeoutError reports a qmldiff invocation that was killed for running too long
+type TimeoutError struct {
+	Command string // qmldiff subcommand, e.g. "apply-diffs"
+	QMDPath string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("qmldiff %s timed out after %s on %s", e.Command, e.Timeout, filepath.Base(e.QMDPath))
+}
+
+// runQMLDiff runs qmldiff with args and returns its stdout and stderr
+// separately, so lines written to one stream are never split by the other.
+// The process group is killed when ctx ends, returning ctx's error, or once
+// the timeout expires, returning a *TimeoutError naming qmdPath.
+func runQMLDiff(ctx context.Context, qmdPath, qmldiffBinary string, args ...string) (string, string, error) {
+	runCtx := ctx
+	limit := currentTimeout()
+	if limit > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(runCtx, qmldiffBinary, args...)
+	killProcessGroupOnCancel(cmd)
+	// Don't wait forever on output pipes held open by a killed process's children
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		// The caller gave up, which is not qmldiff's fault
+		err = ctx.Err()
+	} else if runCtx.Err() == context.DeadlineExceeded {
+		err = &TimeoutError{Command: args[0], QMDPath: qmdPath, Timeout: limit}
+	}
+	return stdout.String(), stderr.String(), err
+}
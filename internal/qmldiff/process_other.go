@@ -0,0 +1,9 @@
+//go:build !unix
+
+package qmldiff
+
+import "os/exec"
+
+// killProcessGroupOnCancel leaves cancellation killing only qmldiff itself
+// where process groups aren't available
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
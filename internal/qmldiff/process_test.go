@@ -0,0 +1,88 @@
+package qmldiff
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
+)
+
+// hangingQMLDiff writes a qmldiff that never finishes, with a child process
+// holding its output open, and a QMD file to run it on
+func hangingQMLDiff(t *testing.T) (dir, binary, qmdPath string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake qmldiff is a shell script")
+	}
+
+	dir = t.TempDir()
+	binary = filepath.Join(dir, "qmldiff")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nsleep 30 &\nsleep 30\n"), 0755); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	qmdPath = filepath.Join(dir, "stuck.qmd")
+	if err := os.WriteFile(qmdPath, []byte("AFFECT [[1]]\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	return dir, binary, qmdPath
+}
+
+func TestValidateWithDependenciesTimesOut(t *testing.T) {
+	dir, binary, qmdPath := hangingQMLDiff(t)
+
+	defer SetTimeout(DefaultTimeout)
+	SetTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	results, err := ValidateWithDependencies(context.Background(), qmdPath, filepath.Join(dir, "hashtab"), dir, binary)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ValidateWithDependencies() took %s, want it killed after the timeout", elapsed)
+	}
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("error = %v, want a *TimeoutError", err)
+	}
+	if timeoutErr.Command != "check-compatibility" || timeoutErr.QMDPath != qmdPath {
+		t.Errorf("TimeoutError = %+v, want check-compatibility on %s", timeoutErr, qmdPath)
+	}
+
+	root := results["stuck.qmd"]
+	if root == nil || root.Status != qmd.StatusFailed {
+		t.Fatalf("root result = %+v, want failed", root)
+	}
+
+	flattened := flattenDependencyResults(results, err)
+	if len(flattened.Errors) != 1 || flattened.Errors[0].FilePath != "stuck.qmd" {
+		t.Errorf("Errors = %+v, want one error for stuck.qmd", flattened.Errors)
+	}
+}
+
+func TestValidateMultipleQMDsWithCLICancelled(t *testing.T) {
+	dir, binary, qmdPath := hangingQMLDiff(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+	result, err := ValidateMultipleQMDsWithCLI(ctx, []string{qmdPath, qmdPath}, filepath.Join(dir, "hashtab"), dir, binary)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ValidateMultipleQMDsWithCLI() took %s, want it stopped on cancel", elapsed)
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		t.Errorf("cancellation reported as a qmldiff timeout: %v", err)
+	}
+	if len(result.Results) != 0 {
+		t.Errorf("got %d results, want none from a cancelled run", len(result.Results))
+	}
+}
@@ -1,16 +1,18 @@
 package qmldiff
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
@@ -19,9 +21,11 @@ import (
 )
 
 type MissingHashInfo struct {
-	Hash   string `json:"hash"`
-	Line   int    `json:"line"`
-	Column int    `json:"column"`
+	Hash        string `json:"hash"`
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
+	Offset      int    `json:"offset"`
+	Approximate bool   `json:"approximate,omitempty"`
 }
 
 type ComparisonResult struct {
@@ -45,7 +49,16 @@ type TreeComparisonResult struct {
 	FilesModified      int                              `json:"files_modified,omitempty"`
 	FilesWithErrors    int                              `json:"files_with_errors,omitempty"`
 	TreeValidationUsed bool                             `json:"tree_validation_used"`
+	TreeName           string                           `json:"tree_name,omitempty"`       // QML tree the diffs were applied to
+	TreeFileCount      int                              `json:"tree_file_count,omitempty"` // .qml files in that tree, to spot a partial tree
 	DependencyResults  map[string]*qmd.ValidationResult `json:"dependency_results,omitempty"`
+	Warning            string                           `json:"warning,omitempty"`
+	VersionMismatch    string                           `json:"version_mismatch,omitempty"` // Hashtab and tree versions disagree
+	VersionSkipped     int                              `json:"version_skipped,omitempty"`  // Diffs qmldiff skipped as targeting another version
+	Status             qmd.FileStatus                   `json:"status,omitempty"`           // Dependency entries: the file's validation status
+	BlockedBy          string                           `json:"blocked_by,omitempty"`       // Dependency entries: file whose failure stopped validation
+	BlockedChain       []string                         `json:"blocked_chain,omitempty"`    // BlockedBy, then what blocked it, back to the failure
+	ExternalLoads      []string                         `json:"external_loads,omitempty"`   // LOAD EXTERNAL targets, which are not validated
 }
 
 func (cr ComparisonResult) MarshalJSON() ([]byte, error) {
@@ -56,9 +69,11 @@ func (cr ComparisonResult) MarshalJSON() ([]byte, error) {
 		missingHashesInfo = make([]MissingHashInfo, len(cr.MissingHashes))
 		for i, hashPos := range cr.MissingHashes {
 			missingHashesInfo[i] = MissingHashInfo{
-				Hash:   strconv.FormatUint(hashPos.Hash, 10),
-				Line:   hashPos.Line,
-				Column: hashPos.Column,
+				Hash:        strconv.FormatUint(hashPos.Hash, 10),
+				Line:        hashPos.Line,
+				Column:      hashPos.Column,
+				Offset:      hashPos.Offset,
+				Approximate: hashPos.Approximate,
 			}
 		}
 	}
@@ -80,9 +95,11 @@ func (tcr TreeComparisonResult) MarshalJSON() ([]byte, error) {
 		missingHashesInfo = make([]MissingHashInfo, len(tcr.MissingHashes))
 		for i, hashPos := range tcr.MissingHashes {
 			missingHashesInfo[i] = MissingHashInfo{
-				Hash:   strconv.FormatUint(hashPos.Hash, 10),
-				Line:   hashPos.Line,
-				Column: hashPos.Column,
+				Hash:        strconv.FormatUint(hashPos.Hash, 10),
+				Line:        hashPos.Line,
+				Column:      hashPos.Column,
+				Offset:      hashPos.Offset,
+				Approximate: hashPos.Approximate,
 			}
 		}
 	}
@@ -96,10 +113,45 @@ func (tcr TreeComparisonResult) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// UnmarshalJSON reads the form MarshalJSON writes, so saved results reload
+// with their missing hashes
+func (tcr *TreeComparisonResult) UnmarshalJSON(data []byte) error {
+	type Alias TreeComparisonResult
+
+	aux := &struct {
+		*Alias
+		MissingHashes []MissingHashInfo `json:"missing_hashes,omitempty"`
+	}{
+		Alias: (*Alias)(tcr),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	tcr.MissingHashes = nil
+	for _, info := range aux.MissingHashes {
+		hash, err := strconv.ParseUint(info.Hash, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid missing hash %q: %w", info.Hash, err)
+		}
+		tcr.MissingHashes = append(tcr.MissingHashes, qmd.HashWithPosition{
+			Hash:        hash,
+			Line:        info.Line,
+			Column:      info.Column,
+			Offset:      info.Offset,
+			Approximate: info.Approximate,
+		})
+	}
+	return nil
+}
+
 type Service struct {
 	hashtabService *hashtab.Service
 	treeService    *qmltree.Service
 	qmldiffBinary  string
+
+	versionMu     sync.Mutex
+	binaryVersion string // Cached by BinaryVersion
 }
 
 func NewService(binaryPath string, hashtabService *hashtab.Service, treeService *qmltree.Service) *Service {
@@ -111,6 +163,12 @@ func NewService(binaryPath string, hashtabService *hashtab.Service, treeService
 }
 
 
+// WithBinary returns a copy of the service that runs another qmldiff binary,
+// sharing its hashtables and trees
+func (s *Service) WithBinary(binaryPath string) *Service {
+	return NewService(binaryPath, s.hashtabService, s.treeService)
+}
+
 func (s *Service) CompareAgainstAll(qmdContent []byte) ([]ComparisonResult, error) {
 	return s.CompareAgainstAllWithProgress(qmdContent, nil, "")
 }
@@ -239,21 +297,26 @@ func (s *Service) ValidateAgainstAllTrees(qmdContents [][]byte, filenames []stri
 			return nil, fmt.Errorf("failed to create temp dir for hashtable %s: %w", hashtable.Name, err)
 		}
 
+		// A file that can't be written fails on its own rather than failing the batch
 		qmdPaths := make([]string, len(qmdContents))
+		writtenPaths := make([]string, 0, len(qmdContents))
+		setupErrors := make(map[string]error)
 		for i, content := range qmdContents {
 			qmdPath := filepath.Join(tempDir, filenames[i])
+			qmdPaths[i] = qmdPath
 			if err := os.WriteFile(qmdPath, content, 0644); err != nil {
-				os.RemoveAll(tempDir)
-				return nil, fmt.Errorf("failed to write QMD file %s: %w", filenames[i], err)
+				logging.Warn(logging.ComponentQMLDiff, "Failed to write QMD file %s: %v", filenames[i], err)
+				setupErrors[qmdPath] = fmt.Errorf("failed to write QMD file %s: %w", filenames[i], scrubError(err, tempDir))
+				continue
 			}
-			qmdPaths[i] = qmdPath
+			writtenPaths = append(writtenPaths, qmdPath)
 		}
 
 		logging.Info(logging.ComponentQMLDiff, "Validating %d files against hashtable %s (tree: %s)",
-			len(qmdPaths), hashtable.Name, tree.Path)
+			len(writtenPaths), hashtable.Name, tree.Path)
 
 		// Validate all QMD files against this hashtable using CLI
-		batchResult, err := ValidateMultipleQMDsWithCLI(qmdPaths, hashtable.Path, tree.Path, s.qmldiffBinary)
+		batchResult, err := ValidateMultipleQMDsWithCLI(context.Background(), writtenPaths, hashtable.Path, tree.Path, s.qmldiffBinary)
 
 		// Clean up temp directory
 		os.RemoveAll(tempDir)
@@ -261,6 +324,9 @@ func (s *Service) ValidateAgainstAllTrees(qmdContents [][]byte, filenames []stri
 		if err != nil {
 			return nil, fmt.Errorf("batch validation failed for hashtable %s: %w", hashtable.Name, err)
 		}
+		for qmdPath, setupErr := range setupErrors {
+			batchResult.Errors[qmdPath] = setupErr
+		}
 
 		for i, filename := range filenames {
 			qmdPath := qmdPaths[i]
@@ -271,6 +337,8 @@ func (s *Service) ValidateAgainstAllTrees(qmdContents [][]byte, filenames []stri
 				Device:             hashtable.Device,
 				ValidationMode:     "tree",
 				TreeValidationUsed: true,
+				TreeName:           tree.Name,
+				TreeFileCount:      tree.FileCount,
 			}
 
 			if fileErr, hasError := batchResult.Errors[qmdPath]; hasError {
@@ -359,15 +427,59 @@ func (s *Service) compareWithHashes(hashes []qmd.HashWithPosition, hashtable *ha
 	return result
 }
 
+// testBinaryTimeout bounds the qmldiff --version run in TestBinary
+const testBinaryTimeout = 5 * time.Second
+
+// TestBinary checks that the qmldiff binary exists and runs, by running
+// qmldiff --version. It returns an error if the binary is missing, not
+// executable, or exits abnormally.
 func (s *Service) TestBinary() error {
-	logging.Info(logging.ComponentQMLDiff, "Using qmldiff CGO library for QMD verification")
-	return nil
+	_, err := s.runVersion()
+	return err
+}
+
+// BinaryVersion returns what qmldiff --version prints, such as
+// "qmldiff 0.1.6", to record which build produced results
+// The first successful answer is cached.
+func (s *Service) BinaryVersion() (string, error) {
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+	if s.binaryVersion != "" {
+		return s.binaryVersion, nil
+	}
+
+	version, err := s.runVersion()
+	if err != nil {
+		return "", err
+	}
+	s.binaryVersion = version
+	return version, nil
+}
+
+// runVersion runs qmldiff --version and returns the first line it prints
+func (s *Service) runVersion() (string, error) {
+	if _, err := exec.LookPath(s.qmldiffBinary); err != nil {
+		return "", fmt.Errorf("qmldiff binary %s is not available: %w", s.qmldiffBinary, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testBinaryTimeout)
+	defer cancel()
+	stdout, stderr, err := runQMLDiff(ctx, "", s.qmldiffBinary, "--version")
+	if err != nil {
+		if output := strings.TrimSpace(stderr + stdout); output != "" {
+			return "", fmt.Errorf("qmldiff binary %s failed to run: %w: %s", s.qmldiffBinary, err, output)
+		}
+		return "", fmt.Errorf("qmldiff binary %s failed to run: %w", s.qmldiffBinary, err)
+	}
+
+	version, _, _ := strings.Cut(strings.TrimSpace(stdout), "\n")
+	return strings.TrimSpace(version), nil
 }
 
 // ValidateAgainstTree validates a QMD file against a full QML tree
 // This is the new validation mode that uses qmldiff to apply diffs
-func (s *Service) ValidateAgainstTree(qmdPath, hashtabPath, treePath string) (*TreeValidationResult, error) {
-	result, err := ValidateMultipleQMDsWithCLI([]string{qmdPath}, hashtabPath, treePath, s.qmldiffBinary)
+func (s *Service) ValidateAgainstTree(ctx context.Context, qmdPath, hashtabPath, treePath string) (*TreeValidationResult, error) {
+	result, err := ValidateMultipleQMDsWithCLI(ctx, []string{qmdPath}, hashtabPath, treePath, s.qmldiffBinary)
 	if err != nil {
 		return nil, err
 	}
@@ -379,8 +491,8 @@ func (s *Service) ValidateAgainstTree(qmdPath, hashtabPath, treePath string) (*T
 
 // ValidateAgainstTreeWithWorkers validates a QMD file against a full QML tree using CLI
 // numWorkers parameter is ignored (kept for API compatibility)
-func (s *Service) ValidateAgainstTreeWithWorkers(qmdPath, hashtabPath, treePath string, numWorkers int) (*TreeValidationResult, error) {
-	result, err := ValidateMultipleQMDsWithCLI([]string{qmdPath}, hashtabPath, treePath, s.qmldiffBinary)
+func (s *Service) ValidateAgainstTreeWithWorkers(ctx context.Context, qmdPath, hashtabPath, treePath string, numWorkers int) (*TreeValidationResult, error) {
+	result, err := ValidateMultipleQMDsWithCLI(ctx, []string{qmdPath}, hashtabPath, treePath, s.qmldiffBinary)
 	if err != nil {
 		return nil, err
 	}
@@ -398,18 +510,19 @@ func (s *Service) ValidateAgainstTreeWithWorkers(qmdPath, hashtabPath, treePath
 
 // ValidateMultipleAgainstTree validates multiple QMD files against a full QML tree
 // numWorkers parameter is ignored (kept for API compatibility)
-func (s *Service) ValidateMultipleAgainstTree(qmdPaths []string, hashtabPath, treePath string, numWorkers int) (*BatchTreeValidationResult, error) {
-	return ValidateMultipleQMDsWithCLI(qmdPaths, hashtabPath, treePath, s.qmldiffBinary)
+func (s *Service) ValidateMultipleAgainstTree(ctx context.Context, qmdPaths []string, hashtabPath, treePath string, numWorkers int) (*BatchTreeValidationResult, error) {
+	return ValidateMultipleQMDsWithCLI(ctx, qmdPaths, hashtabPath, treePath, s.qmldiffBinary)
 }
 
 // ValidateMultipleAgainstTreeSequential validates multiple QMD files against a full QML tree sequentially
 // This version uses the qmldiff CLI binary for isolation (each file gets its own process)
-func (s *Service) ValidateMultipleAgainstTreeSequential(qmdPaths []string, hashtabPath, treePath string) (*BatchTreeValidationResult, error) {
+// Cancelling ctx kills the running qmldiff process and stops before the next file
+func (s *Service) ValidateMultipleAgainstTreeSequential(ctx context.Context, qmdPaths []string, hashtabPath, treePath string) (*BatchTreeValidationResult, error) {
 	if s.qmldiffBinary == "" {
 		// Fallback to default location
 		s.qmldiffBinary = "./qmldiff"
 	}
-	return ValidateMultipleQMDsWithCLI(qmdPaths, hashtabPath, treePath, s.qmldiffBinary)
+	return ValidateMultipleQMDsWithCLI(ctx, qmdPaths, hashtabPath, treePath, s.qmldiffBinary)
 }
 
 func SaveUploadedFile(reader io.Reader, filename string) (string, error) {
@@ -418,8 +531,9 @@ func SaveUploadedFile(reader io.Reader, filename string) (string, error) {
 		return "", fmt.Errorf("failed to create temp dir: %w", err)
 	}
 
-	uniqueFilename := fmt.Sprintf("%s-%s", uuid.New().String(), filename)
-	filePath := filepath.Join(tempDir, uniqueFilename)
+	// The temp dir is already unique, so keep the uploaded name as-is; it is
+	// what results report back to the user
+	filePath := filepath.Join(tempDir, filepath.Base(filename))
 
 	out, err := os.Create(filePath)
 	if err != nil {
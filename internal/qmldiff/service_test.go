@@ -0,0 +1,141 @@
+package qmldiff
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
+	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
+)
+
+func TestValidateAgainstAllTreesContinuesPastUnwritableFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake qmldiff is a shell script")
+	}
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "qmldiff")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	hashtabDir := filepath.Join(dir, "hashtables")
+	treeDir := filepath.Join(dir, "trees", "3.22.4.2-rmpp")
+	if err := os.MkdirAll(hashtabDir, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if err := os.MkdirAll(treeDir, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if err := hashtab.WriteHashtab(map[uint64]string{1: "width"}, filepath.Join(hashtabDir, "3.22.4.2-rmpp")); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(treeDir, "Main.qml"), []byte("Item {}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	hashtabService, err := hashtab.NewService(hashtabDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+	service := NewService(binary, hashtabService, qmltree.NewService(filepath.Dir(treeDir)))
+
+	// The middle file's directory doesn't exist in the temp dir, so it can't be written
+	filenames := []string{"a.qmd", "missing/b.qmd", "c.qmd"}
+	contents := [][]byte{[]byte("AFFECT [[1]]\n"), []byte("AFFECT [[1]]\n"), []byte("AFFECT [[1]]\n")}
+
+	results, err := service.ValidateAgainstAllTrees(contents, filenames, nil, "")
+	if err != nil {
+		t.Fatalf("ValidateAgainstAllTrees() failed: %v", err)
+	}
+	for _, filename := range filenames {
+		if len(results[filename]) != 1 {
+			t.Fatalf("%s: got %d results, want 1", filename, len(results[filename]))
+		}
+	}
+
+	bad := results["missing/b.qmd"][0]
+	if bad.Compatible || !strings.Contains(bad.ErrorDetail, "failed to write QMD file missing/b.qmd") {
+		t.Errorf("missing/b.qmd: Compatible = %v, ErrorDetail = %q, want a write failure", bad.Compatible, bad.ErrorDetail)
+	}
+	if strings.Contains(bad.ErrorDetail, os.TempDir()) {
+		t.Errorf("missing/b.qmd: ErrorDetail %q leaks the temp dir", bad.ErrorDetail)
+	}
+	for _, filename := range []string{"a.qmd", "c.qmd"} {
+		if result := results[filename][0]; strings.Contains(result.ErrorDetail, "failed to write") {
+			t.Errorf("%s: ErrorDetail = %q, want it validated", filename, result.ErrorDetail)
+		}
+	}
+}
+
+func TestTestBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake qmldiff is a shell script")
+	}
+
+	dir := t.TempDir()
+	write := func(name, script string, mode os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(script), mode); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		binary  string
+		wantErr string
+	}{
+		{"working", write("working", "#!/bin/sh\n[ \"$1\" = --version ] && echo qmldiff 1.0.0\n", 0755), ""},
+		{"missing", filepath.Join(dir, "missing"), "not available"},
+		{"not executable", write("plain", "#!/bin/sh\nexit 0\n", 0644), "not available"},
+		{"exits abnormally", write("broken", "#!/bin/sh\necho 'cannot load library' >&2\nexit 127\n", 0755), "cannot load library"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewService(tt.binary, nil, nil).TestBinary()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("TestBinary() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("TestBinary() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBinaryVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake qmldiff is a shell script")
+	}
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "qmldiff")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\necho 'qmldiff 0.1.6'\necho 'extra line'\n"), 0755); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	service := NewService(binary, nil, nil)
+	version, err := service.BinaryVersion()
+	if err != nil || version != "qmldiff 0.1.6" {
+		t.Fatalf("BinaryVersion() = %q, %v, want %q", version, err, "qmldiff 0.1.6")
+	}
+
+	// Cached: a later change to the binary isn't picked up
+	if err := os.Remove(binary); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := service.BinaryVersion(); err != nil || version != "qmldiff 0.1.6" {
+		t.Errorf("cached BinaryVersion() = %q, %v, want %q", version, err, "qmldiff 0.1.6")
+	}
+	if _, err := service.WithBinary(binary).BinaryVersion(); err == nil {
+		t.Error("WithBinary() copy reused the cached version")
+	}
+}
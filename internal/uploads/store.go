@@ -0,0 +1,170 @@
+package uploads
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+)
+
+// Session collects the files of one batch across several upload requests
+// Files are keyed by their relative path, so resending a file after a
+// dropped request replaces it instead of adding a duplicate.
+type Session struct {
+	ID           string
+	Dir          string
+	Files        map[string]string // Relative path -> saved path, for files to validate
+	Excluded     []string          // Relative paths of saved files left out by exclude patterns
+	LastActivity time.Time
+
+	mu     sync.Mutex // Held while files are written to Dir, see Store.Acquire
+	closed bool       // Taken or expired, so no more files may be written
+}
+
+// Filenames returns the relative paths in the session, sorted
+func (s *Session) Filenames() []string {
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Store struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	ttl      time.Duration
+}
+
+// NewStore returns a store whose sessions expire after ttl without activity
+// Expired sessions are removed along with their upload directory.
+func NewStore(ttl time.Duration) *Store {
+	s := &Store{
+		sessions: make(map[string]*Session),
+		ttl:      ttl,
+	}
+	go s.startCleanup()
+	return s
+}
+
+// TTL returns how long a session may stay idle before it expires
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create starts a session with a fresh upload directory
+func (s *Store) Create() (*Session, error) {
+	dir, err := os.MkdirTemp("", "qmd-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		ID:           uuid.New().String(),
+		Dir:          dir,
+		Files:        make(map[string]string),
+		LastActivity: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.sessions[session.ID] = session
+	s.mu.Unlock()
+	return session, nil
+}
+
+// Acquire holds a session so files can be written to its directory, and
+// refreshes its expiry. Until Release, the session can't be taken or expire
+// under the writer. Returns false if the session does not exist, has expired
+// or has been taken.
+func (s *Store) Acquire(id string) (*Session, bool) {
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	if ok {
+		session.LastActivity = time.Now()
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	session.mu.Lock()
+	if session.closed {
+		session.mu.Unlock()
+		return nil, false
+	}
+	return session, true
+}
+
+// Release lets go of a session from Acquire and refreshes its expiry
+func (s *Store) Release(session *Session) {
+	s.mu.Lock()
+	session.LastActivity = time.Now()
+	s.mu.Unlock()
+	session.mu.Unlock()
+}
+
+// AddFiles records files saved to a session's directory, and the excluded
+// ones saved alongside them, and returns how many files to validate the
+// session now holds. The caller holds the session from Acquire.
+func (s *Session) AddFiles(paths, filenames, excluded []string) int {
+	for i, name := range filenames {
+		s.Files[name] = paths[i]
+	}
+	s.Excluded = append(s.Excluded, excluded...)
+	return len(s.Files)
+}
+
+// Take removes a session from the store and hands it to the caller, who
+// becomes responsible for its directory. It waits for files still being
+// written; later writers find the session gone.
+func (s *Store) Take(id string) (*Session, bool) {
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	if ok {
+		delete(s.sessions, id)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	session.mu.Lock()
+	session.closed = true
+	session.mu.Unlock()
+	return session, true
+}
+
+func (s *Store) startCleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.cleanupExpired()
+	}
+}
+
+func (s *Store) cleanupExpired() {
+	s.mu.Lock()
+	var expired []*Session
+	now := time.Now()
+	for id, session := range s.sessions {
+		// A session being written to is in use, however long the write takes
+		if now.Sub(session.LastActivity) > s.ttl && session.mu.TryLock() {
+			session.closed = true
+			session.mu.Unlock()
+			expired = append(expired, session)
+			delete(s.sessions, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, session := range expired {
+		logging.Debug(logging.ComponentHandler, "Upload session %s expired, removing %d files", session.ID, len(session.Files))
+		os.RemoveAll(session.Dir)
+	}
+}
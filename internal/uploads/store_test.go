@@ -0,0 +1,104 @@
+package uploads
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCleanupExpiredRemovesDir(t *testing.T) {
+	s := &Store{sessions: make(map[string]*Session), ttl: time.Minute}
+
+	session, err := s.Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	fresh, err := s.Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	defer os.RemoveAll(fresh.Dir)
+
+	session.LastActivity = time.Now().Add(-2 * time.Minute)
+	s.cleanupExpired()
+
+	if _, ok := s.sessions[session.ID]; ok {
+		t.Error("expired session still in store")
+	}
+	if _, err := os.Stat(session.Dir); !os.IsNotExist(err) {
+		t.Errorf("expired session dir still exists: %v", err)
+	}
+	if _, ok := s.sessions[fresh.ID]; !ok {
+		t.Error("active session was removed")
+	}
+}
+
+func TestAcquireRefreshesActivity(t *testing.T) {
+	s := &Store{sessions: make(map[string]*Session), ttl: time.Minute}
+
+	session, err := s.Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	defer os.RemoveAll(session.Dir)
+
+	session.LastActivity = time.Now().Add(-50 * time.Second)
+	held, ok := s.Acquire(session.ID)
+	if !ok {
+		t.Fatal("Acquire() on active session failed")
+	}
+	if total := held.AddFiles([]string{session.Dir + "/a.qmd"}, []string{"a.qmd"}, nil); total != 1 {
+		t.Errorf("AddFiles() = %d, want 1", total)
+	}
+	s.Release(held)
+	if time.Since(session.LastActivity) > time.Second {
+		t.Errorf("LastActivity not refreshed: %v", session.LastActivity)
+	}
+
+	if _, ok := s.Acquire("missing"); ok {
+		t.Error("Acquire() on missing session succeeded")
+	}
+}
+
+func TestTakeWaitsForWriter(t *testing.T) {
+	s := &Store{sessions: make(map[string]*Session), ttl: time.Minute}
+
+	session, err := s.Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	defer os.RemoveAll(session.Dir)
+
+	held, ok := s.Acquire(session.ID)
+	if !ok {
+		t.Fatal("Acquire() failed")
+	}
+
+	taken := make(chan *Session)
+	go func() {
+		session, _ := s.Take(session.ID)
+		taken <- session
+	}()
+
+	// Neither Take nor expiry may cut off a chunk being written
+	select {
+	case <-taken:
+		t.Fatal("Take() returned while a writer held the session")
+	case <-time.After(50 * time.Millisecond):
+	}
+	held.LastActivity = time.Now().Add(-2 * time.Minute)
+	s.cleanupExpired()
+	if _, err := os.Stat(session.Dir); err != nil {
+		t.Errorf("held session dir was removed: %v", err)
+	}
+
+	held.AddFiles([]string{session.Dir + "/a.qmd"}, []string{"a.qmd"}, nil)
+	s.Release(held)
+	if got := <-taken; got == nil || len(got.Files) != 1 {
+		t.Errorf("Take() = %+v, want the session with the written file", got)
+	}
+
+	if _, ok := s.Acquire(session.ID); ok {
+		t.Error("Acquire() succeeded on a taken session")
+	}
+}
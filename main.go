@@ -9,6 +9,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -19,7 +23,9 @@ import (
 	"github.com/rmitchellscott/rm-qmd-verify/internal/handlers"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/logging"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/qmd"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/qmldiff"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/uploads"
 	"github.com/rmitchellscott/rm-qmd-verify/internal/version"
 	"github.com/rmitchellscott/rm-qmd-verify/pkg/hashtab"
 	"github.com/rmitchellscott/rm-qmd-verify/pkg/qmltree"
@@ -30,12 +36,34 @@ import (
 var embeddedUI embed.FS
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	// CLI commands read the same .env settings as the server
+	envErr := godotenv.Load()
+	if code, handled := runCommand(os.Args[1:]); handled {
+		os.Exit(code)
+	}
+
+	logging.SetFormat(config.Get("LOG_FORMAT", "text"))
+	levelErr := logging.SetLevel(config.Get("LOG_LEVEL", "info"))
+	if envErr != nil {
 		logging.Info(logging.ComponentStartup, "No .env file found, using environment variables")
 	}
+	if levelErr != nil {
+		logging.Warn(logging.ComponentStartup, "%v; using info", levelErr)
+	}
 
 	logging.Info(logging.ComponentStartup, "Starting rm-qmd-verify %s", version.GetFullVersion())
 
+	logging.SetDebugComponents(config.GetList("DEBUG_COMPONENTS", nil))
+	logging.SetDebugSampleEvery(config.GetInt("DEBUG_SAMPLE_EVERY", 1))
+
+	if err := configureVersionHash(); err != nil {
+		logging.Error(logging.ComponentStartup, "%v", err)
+		os.Exit(1)
+	}
+	if hash := hashtab.VersionHash(); hash != hashtab.DefaultVersionHash {
+		logging.Info(logging.ComponentStartup, "Reading hashtab versions from hash %d", hash)
+	}
+
 	hashtabDir := config.Get("HASHTAB_DIR", "./hashtables")
 	logging.Info(logging.ComponentStartup, "Loading hashtables from: %s", hashtabDir)
 
@@ -51,10 +79,34 @@ func main() {
 		logging.Info(logging.ComponentStartup, "  - %s (%d entries)", ht.Name, len(ht.Entries))
 	}
 
-	treeDir := config.Get("QML_TREE_DIR", "./qml-trees")
-	logging.Info(logging.ComponentStartup, "Loading QML trees from: %s", treeDir)
-
-	treeService := qmltree.NewService(treeDir)
+	var treeService *qmltree.Service
+	switch treeSource := config.Get("TREE_SOURCE", "local"); treeSource {
+	case "local":
+		treeDir := config.Get("QML_TREE_DIR", "./qml-trees")
+		logging.Info(logging.ComponentStartup, "Loading QML trees from: %s", treeDir)
+		treeService = qmltree.NewService(treeDir)
+	case "s3":
+		region := config.Get("S3_REGION", "us-east-1")
+		source := &qmltree.S3Source{
+			Endpoint:     config.Get("S3_ENDPOINT", "https://s3."+region+".amazonaws.com"),
+			Bucket:       config.Get("S3_BUCKET", ""),
+			Prefix:       config.Get("S3_PREFIX", ""),
+			Region:       region,
+			AccessKey:    config.Get("AWS_ACCESS_KEY_ID", ""),
+			SecretKey:    config.Get("AWS_SECRET_ACCESS_KEY", ""),
+			SessionToken: config.Get("AWS_SESSION_TOKEN", ""),
+		}
+		if source.Bucket == "" {
+			logging.Error(logging.ComponentStartup, "TREE_SOURCE=s3 requires S3_BUCKET")
+			os.Exit(1)
+		}
+		cacheDir := config.Get("TREE_CACHE_DIR", filepath.Join(os.TempDir(), "qml-tree-cache"))
+		logging.Info(logging.ComponentStartup, "Listing QML trees from s3://%s/%s (cache: %s)", source.Bucket, source.Prefix, cacheDir)
+		treeService = qmltree.NewRemoteService(source, cacheDir)
+	default:
+		logging.Error(logging.ComponentStartup, "Unknown TREE_SOURCE %q, expected local or s3", treeSource)
+		os.Exit(1)
+	}
 	trees := treeService.GetTrees()
 	logging.Info(logging.ComponentStartup, "Loaded %d QML trees", len(trees))
 	for _, tree := range trees {
@@ -64,35 +116,154 @@ func main() {
 	qmldiffBinary := config.Get("QMLDIFF_BINARY", "./qmldiff")
 	qmldiffService := qmldiff.NewService(qmldiffBinary, hashtabService, treeService)
 	logging.Info(logging.ComponentStartup, "Initialized qmldiff service (binary: %s)", qmldiffBinary)
+	// A missing or broken binary is reported once ALLOWED_MODES is known
+	if qmldiffVersion, err := qmldiffService.BinaryVersion(); err == nil {
+		logging.Info(logging.ComponentStartup, "qmldiff version: %s", qmldiffVersion)
+	}
 
-	jobStore := jobs.NewStore()
+	qmldiffTimeout := config.GetDuration("QMLDIFF_TIMEOUT", qmldiff.DefaultTimeout)
+	qmldiff.SetTimeout(qmldiffTimeout)
+	if qmldiffTimeout > 0 {
+		logging.Info(logging.ComponentStartup, "qmldiff invocations time out after %s", qmldiffTimeout)
+	} else {
+		logging.Warn(logging.ComponentStartup, "qmldiff timeout disabled, a hung qmldiff process will hold its worker slot")
+	}
+
+	outputPatterns := qmd.OutputPatterns{
+		HashError:      config.Get("QMLDIFF_HASH_ERROR_PATTERN", ""),
+		ProcessError:   config.Get("QMLDIFF_PROCESS_ERROR_PATTERN", ""),
+		WrittenFile:    config.Get("QMLDIFF_WRITTEN_FILE_PATTERN", ""),
+		VersionSkipped: config.Get("QMLDIFF_VERSION_SKIPPED_PATTERN", ""),
+	}
+	if err := qmd.SetOutputPatterns(outputPatterns); err != nil {
+		logging.Error(logging.ComponentStartup, "Invalid qmldiff output pattern: %v", err)
+		os.Exit(1)
+	}
+	if outputPatterns != (qmd.OutputPatterns{}) {
+		logging.Info(logging.ComponentStartup, "Using custom qmldiff output patterns")
+	}
+
+	excludePatterns := qmd.ParseExcludePatterns(config.Get("EXCLUDE_PATTERNS", strings.Join(qmd.DefaultExcludePatterns, ",")))
+	qmd.SetExcludePatterns(excludePatterns)
+	logging.Info(logging.ComponentStartup, "Excluding files matching: %v", excludePatterns)
+
+	jobTTL := config.GetDuration("JOB_TTL", jobs.DefaultTTL)
+	jobCleanupInterval := config.GetDuration("JOB_CLEANUP_INTERVAL", jobs.DefaultCleanupInterval)
+	logging.Info(logging.ComponentStartup, "Finished jobs kept for %s, checked every %s", jobTTL, jobCleanupInterval)
+
+	var jobStore *jobs.Store
+	if jobsDir := config.Get("JOBS_DIR", ""); jobsDir != "" {
+		var err error
+		jobStore, err = jobs.NewPersistentStore(jobsDir, jobTTL, jobCleanupInterval)
+		if err != nil {
+			logging.Error(logging.ComponentStartup, "Failed to open jobs directory %s: %v", jobsDir, err)
+			os.Exit(1)
+		}
+		logging.Info(logging.ComponentStartup, "Saving jobs to: %s", jobsDir)
+	} else {
+		jobStore = jobs.NewStore(jobTTL, jobCleanupInterval)
+	}
 
 	maxConcurrentValidations := config.GetInt("MAX_CONCURRENT_VALIDATIONS", 15)
 	logging.Info(logging.ComponentStartup, "Max concurrent validations: %d", maxConcurrentValidations)
 
+	settings := handlers.DefaultSettings()
+	settings.DeviceConcurrency = config.GetIntsWithPrefix("CONCURRENCY_")
+	for device, limit := range settings.DeviceConcurrency {
+		logging.Info(logging.ComponentStartup, "Max concurrent validations for %s: %d", device, limit)
+	}
+	settings.ResultFlushInterval = config.GetDuration("RESULT_FLUSH_INTERVAL", settings.ResultFlushInterval)
+	logging.Info(logging.ComponentStartup, "Result flush interval: %s", settings.ResultFlushInterval)
+	settings.MaxFlattenedDependencies = config.GetInt("MAX_FLATTENED_DEPENDENCIES", settings.MaxFlattenedDependencies)
+	logging.Info(logging.ComponentStartup, "Max flattened dependencies: %d", settings.MaxFlattenedDependencies)
+	settings.CallbackAllowedHosts = config.GetList("CALLBACK_ALLOWED_HOSTS", nil)
+	settings.CallbackTimeout = config.GetDuration("CALLBACK_TIMEOUT", settings.CallbackTimeout)
+	settings.CallbackRetries = config.GetInt("CALLBACK_RETRIES", settings.CallbackRetries)
+	if len(settings.CallbackAllowedHosts) > 0 {
+		logging.Info(logging.ComponentStartup, "Callback hosts: %v (timeout %s, %d retries)", settings.CallbackAllowedHosts, settings.CallbackTimeout, settings.CallbackRetries)
+	}
+
+	settings.MaxJobDuration = config.GetDuration("MAX_JOB_DURATION", settings.MaxJobDuration)
+	logging.Info(logging.ComponentStartup, "Max job duration: %s", settings.MaxJobDuration)
+
+	settings.MultipartMemory = int64(config.GetInt("MULTIPART_MEMORY_MB", int(settings.MultipartMemory>>20))) << 20
+	logging.Info(logging.ComponentStartup, "Multipart memory threshold: %d MB", settings.MultipartMemory>>20)
+	settings.MaxZipExtractedMB = config.GetInt("MAX_ZIP_EXTRACTED_MB", settings.MaxZipExtractedMB)
+	settings.MaxZipEntries = config.GetInt("MAX_ZIP_ENTRIES", settings.MaxZipEntries)
+	logging.Info(logging.ComponentStartup, "Zip upload limits: %d MB, %d entries", settings.MaxZipExtractedMB, settings.MaxZipEntries)
+
+	settings.AllowedModes = config.GetList("ALLOWED_MODES", settings.AllowedModes)
+	for _, mode := range settings.AllowedModes {
+		if mode != "tree" && mode != "hash" {
+			logging.Error(logging.ComponentStartup, "Invalid ALLOWED_MODES entry %q: must be tree or hash", mode)
+			os.Exit(1)
+		}
+	}
+	logging.Info(logging.ComponentStartup, "Allowed compare modes: %v", settings.AllowedModes)
+
+	// Only tree mode runs qmldiff, so a hash-only instance can do without it
+	if err := qmldiffService.TestBinary(); err != nil {
+		if slices.Contains(settings.AllowedModes, "tree") {
+			logging.Error(logging.ComponentStartup, "%v. Set QMLDIFF_BINARY to a working qmldiff, or ALLOWED_MODES=hash to run without one", err)
+			os.Exit(1)
+		}
+		logging.Warn(logging.ComponentStartup, "%v; tree mode is disabled, so continuing", err)
+	} else {
+		logging.Info(logging.ComponentStartup, "qmldiff binary %s is working", qmldiffBinary)
+	}
+
+	settings.AdminToken = config.Get("ADMIN_TOKEN", "")
+	// An alternate binary that can't run would only fail once an admin picks it
+	settings.QMLDiffBinaries = config.GetList("QMLDIFF_BINARIES", nil)
+	for _, binary := range settings.QMLDiffBinaries {
+		if err := qmldiffService.WithBinary(binary).TestBinary(); err != nil {
+			logging.Error(logging.ComponentStartup, "QMLDIFF_BINARIES entry %s: %v", binary, err)
+			os.Exit(1)
+		}
+		logging.Info(logging.ComponentStartup, "Alternate qmldiff binary %s is working", binary)
+	}
+
+	allowedOrigins := config.GetList("WS_ALLOWED_ORIGINS", nil)
+	if slices.Contains(allowedOrigins, "*") {
+		logging.Warn(logging.ComponentStartup, "WebSocket upgrades allowed from any origin (WS_ALLOWED_ORIGINS=*)")
+	} else if len(allowedOrigins) > 0 {
+		logging.Info(logging.ComponentStartup, "Allowed WebSocket origins: %v", allowedOrigins)
+	}
+	wsPingInterval := config.GetDuration("WS_PING_INTERVAL", 20*time.Second)
+
+	uploadSessionTTL := config.GetDuration("UPLOAD_SESSION_TTL", 1*time.Hour)
+	uploadStore := uploads.NewStore(uploadSessionTTL)
+	logging.Info(logging.ComponentStartup, "Upload session TTL: %s", uploadSessionTTL)
+
+	settings.ReadOnly = config.GetBool("READ_ONLY", settings.ReadOnly)
+	if settings.ReadOnly {
+		logging.Info(logging.ComponentStartup, "Read-only mode: hashtables and trees will not be reloaded")
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
-
-	apiHandler := handlers.NewAPIHandler(qmldiffService, hashtabService, treeService, jobStore, maxConcurrentValidations)
-	r.Route("/api", func(r chi.Router) {
-		r.Post("/compare", apiHandler.Compare)
-		r.Post("/validate/tree", apiHandler.ValidateTree)
-		r.Get("/hashtables", apiHandler.ListHashtables)
-		r.Get("/trees", apiHandler.ListTrees)
-		r.Get("/validated-versions", apiHandler.ListValidatedVersions)
-		r.Get("/results/{jobId}", apiHandler.GetResults)
-		r.Get("/status/ws/{jobId}", handlers.StatusWSHandler(jobStore))
-		r.Get("/version", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(version.Get())
-		})
-	})
+
+	apiHandler := handlers.NewAPIHandler(qmldiffService, hashtabService, treeService, jobStore, uploadStore, maxConcurrentValidations, settings)
+	hashtabService.OnReload(apiHandler.CatalogReloaded)
+	treeService.OnReload(apiHandler.CatalogReloaded)
+	if config.GetBool("PREWARM", false) {
+		go func() {
+			logging.Info(logging.ComponentStartup, "Prewarming validation pipeline")
+			start := time.Now()
+			passed, failed := apiHandler.Prewarm(context.Background())
+			if failed > 0 {
+				logging.Warn(logging.ComponentStartup, "Prewarm finished in %s: %d passed, %d failed", time.Since(start).Round(time.Millisecond), passed, failed)
+			} else {
+				logging.Info(logging.ComponentStartup, "Prewarm finished in %s: %d passed", time.Since(start).Round(time.Millisecond), passed)
+			}
+		}()
+	}
+
+	r.Route("/api", apiRoutes(apiHandler, handlers.StatusWSHandler(jobStore, allowedOrigins, wsPingInterval), qmldiffService, 60*time.Second))
 
 	uiFS, err := fs.Sub(embeddedUI, "ui/dist")
 	if err != nil {
@@ -133,9 +304,10 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
-	logging.Info(logging.ComponentServer, "Shutting down server...")
+	shutdownTimeout := config.GetDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	logging.Info(logging.ComponentServer, "Shutting down server (timeout %s)...", shutdownTimeout)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
@@ -145,3 +317,67 @@ func main() {
 
 	logging.Info(logging.ComponentServer, "Server shutdown complete")
 }
+
+// apiRoutes registers the /api routes. Each request gets requestTimeout,
+// except the status WebSocket, which stays open as long as its job runs.
+func apiRoutes(apiHandler *handlers.APIHandler, statusWS http.HandlerFunc, qmldiffService *qmldiff.Service, requestTimeout time.Duration) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/status/ws/{jobId}", statusWS)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Timeout(requestTimeout))
+
+			r.Post("/compare", apiHandler.Compare)
+			r.Post("/validate/tree", apiHandler.ValidateTree)
+			r.Post("/check-syntax", apiHandler.CheckSyntax)
+			r.Post("/dependencies", apiHandler.Dependencies)
+			r.Post("/extract-hashes", apiHandler.ExtractHashes)
+			r.Post("/check-version-set", apiHandler.CheckVersionSet)
+			r.Post("/uploads", apiHandler.CreateUpload)
+			r.Put("/uploads/{uploadId}/files", apiHandler.AddUploadFiles)
+			r.Post("/uploads/{uploadId}/validate", apiHandler.ValidateUpload)
+			r.Get("/hashtables", apiHandler.ListHashtables)
+			r.Get("/trees", apiHandler.ListTrees)
+			r.Get("/trees/{name}/files", apiHandler.ListTreeFiles)
+			r.Get("/validated-versions", apiHandler.ListValidatedVersions)
+			r.Get("/jobs", apiHandler.ListJobs)
+			r.Post("/cancel/{jobId}", apiHandler.CancelJob)
+			r.Get("/results/diff", apiHandler.GetResultsDiff)
+			r.Get("/results/{jobId}", apiHandler.GetResults)
+			r.Get("/hashlist/{jobId}", apiHandler.GetHashlist)
+			r.Get("/export/{jobId}", apiHandler.ExportBundle)
+			r.Get("/hash/{hashID}", apiHandler.LookupHash)
+			r.Get("/common-failures/{jobId}", apiHandler.GetCommonFailures)
+			r.Get("/health", apiHandler.Health)
+			r.Get("/ready", apiHandler.Ready)
+			r.Get("/version", func(w http.ResponseWriter, r *http.Request) {
+				response := struct {
+					version.Info
+					QMLDiffVersion string `json:"qmldiff_version,omitempty"`
+				}{Info: version.Get()}
+				if qmldiffVersion, err := qmldiffService.BinaryVersion(); err == nil {
+					response.QMLDiffVersion = qmldiffVersion
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(response)
+			})
+		})
+	}
+}
+
+// configureVersionHash applies VERSION_HASH_ID, the hashtab entry that holds
+// the OS version. Parsed by hand since the default does not fit in an int.
+func configureVersionHash() error {
+	value := config.Get("VERSION_HASH_ID", "")
+	if value == "" {
+		return nil
+	}
+	hash, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid VERSION_HASH_ID %q: %w", value, err)
+	}
+	hashtab.SetVersionHash(hash)
+	return nil
+}
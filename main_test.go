@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+
+	"github.com/rmitchellscott/rm-qmd-verify/internal/handlers"
+	"github.com/rmitchellscott/rm-qmd-verify/internal/jobs"
+)
+
+func TestStatusWSOutlivesRequestTimeout(t *testing.T) {
+	store := jobs.NewStore(jobs.DefaultTTL, jobs.DefaultCleanupInterval)
+	store.Create("job")
+	store.UpdateWithOperation("job", "running", "Validating", nil, "validating")
+
+	timeout := 50 * time.Millisecond
+	apiHandler := handlers.NewAPIHandler(nil, nil, nil, store, nil, 1, handlers.DefaultSettings())
+	r := chi.NewRouter()
+	r.Route("/api", apiRoutes(apiHandler, handlers.StatusWSHandler(store, nil, 10*time.Millisecond), nil, timeout))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/status/ws/job"
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": []string{server.URL}},
+	})
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var job jobs.Job
+	if err := wsjson.Read(ctx, conn, &job); err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+
+	// The job finishes well after the request timeout; its update must still arrive
+	time.AfterFunc(4*timeout, func() {
+		store.Update("job", "success", "Validation complete", nil)
+	})
+	if err := wsjson.Read(ctx, conn, &job); err != nil {
+		t.Fatalf("Read() after the request timeout failed: %v", err)
+	}
+	if job.Status != "success" {
+		t.Errorf("status = %q, want %q", job.Status, "success")
+	}
+}
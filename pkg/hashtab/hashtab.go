@@ -6,25 +6,57 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync/atomic"
 )
 
 const maxStringLength = 10 * 1024 * 1024 // 10MB
 
+// DefaultVersionHash is the entry whose string holds the hashtab's OS version
+const DefaultVersionHash uint64 = 17607111715072197239
+
+var versionHash atomic.Uint64
+
+func init() {
+	versionHash.Store(DefaultVersionHash)
+}
+
+// SetVersionHash changes which entry is read as the hashtab's OS version,
+// for hashtab formats that use a different sentinel. Affects later loads.
+func SetVersionHash(hash uint64) {
+	versionHash.Store(hash)
+}
+
+// VersionHash returns the entry currently read as the hashtab's OS version
+func VersionHash() uint64 {
+	return versionHash.Load()
+}
+
+// DefaultSeed is the initial value of qmldiff's DJB2 variant
+// Classic DJB2 starts from 5381; qmldiff starts from 5481.
+const DefaultSeed uint64 = 5481
+
+// KnownSeeds are the seeds DetectSeed tries, most likely first
+var KnownSeeds = []uint64{DefaultSeed, 5381}
+
 type Hashtab struct {
-	Name      string
-	Path      string
-	OSVersion string
-	Device    string
-	Entries   map[uint64]string
+	Name            string
+	Path            string
+	OSVersion       string
+	Device          string
+	EmbeddedVersion string // Version recorded in the hashtab itself, if any; overrides the filename
+	Entries         map[uint64]string
 }
 
+// ParseVersion extracts the OS version and device from a hashtab filename
+// Device names are normalized to lowercase so they match tree names regardless of case
 func ParseVersion(filename string) (osVersion, device string) {
 	parts := strings.Split(filename, "-")
 
 	if len(parts) >= 2 {
 		osVersion = parts[0]
-		device = parts[1]
+		device = strings.ToLower(parts[1])
 	} else if len(parts) == 1 {
 		osVersion = parts[0]
 		device = "unknown"
@@ -65,11 +97,12 @@ func Load(path string) (*Hashtab, error) {
 	}
 
 	return &Hashtab{
-		Name:      filename,
-		Path:      path,
-		OSVersion: osVersion,
-		Device:    device,
-		Entries:   entries,
+		Name:            filename,
+		Path:            path,
+		OSVersion:       osVersion,
+		Device:          device,
+		EmbeddedVersion: hashtabVersion,
+		Entries:         entries,
 	}, nil
 }
 
@@ -107,7 +140,7 @@ func loadHashtab(file *os.File) (map[uint64]string, string, error) {
 
 		if hash == 0 {
 			continue
-		} else if hash == 17607111715072197239 {
+		} else if hash == versionHash.Load() {
 			hashtabVersion = str
 		}
 
@@ -117,14 +150,86 @@ func loadHashtab(file *os.File) (map[uint64]string, string, error) {
 	return entries, hashtabVersion, nil
 }
 
+// DJB2Hash hashes s the way qmldiff does, using DefaultSeed
 func DJB2Hash(s string) uint64 {
-	hash := uint64(5481)
+	return DJB2HashWithSeed(s, DefaultSeed)
+}
+
+// DJB2HashWithSeed hashes s with DJB2 starting from seed
+func DJB2HashWithSeed(s string, seed uint64) uint64 {
+	hash := seed
 	for i := 0; i < len(s); i++ {
 		hash = ((hash << 5) + hash) + uint64(s[i])
 	}
 	return hash
 }
 
+// CheckSeed counts how many hashtab entries hash to their stored value with seed
+// Entries without a string and the version entry cannot be checked and are skipped.
+func CheckSeed(entries map[uint64]string, seed uint64) (matched, checked int) {
+	for hash, str := range entries {
+		if str == "" || hash == versionHash.Load() {
+			continue
+		}
+		checked++
+		if DJB2HashWithSeed(str, seed) == hash {
+			matched++
+		}
+	}
+	return matched, checked
+}
+
+// DetectSeed returns the first candidate seed that reproduces every checkable
+// entry of a hashtab. Returns false if none does or nothing can be checked.
+func DetectSeed(entries map[uint64]string, candidates []uint64) (uint64, bool) {
+	for _, seed := range candidates {
+		if matched, checked := CheckSeed(entries, seed); checked > 0 && matched == checked {
+			return seed, true
+		}
+	}
+	return 0, false
+}
+
+// HashtabDiff lists the hashes that differ between two hashtabs, each sorted
+type HashtabDiff struct {
+	Removed []uint64 // Only in the first hashtab
+	Added   []uint64 // Only in the second hashtab
+	Changed []uint64 // In both, with different strings
+	Common  int      // How many hashes are in both, changed or not
+}
+
+// Diff compares the hashes of a and b. Strings are only compared when both
+// hashtabs have one for a hash, so hashlists never report changes, and the
+// version entry is skipped since its string always differs between versions.
+func Diff(a, b *Hashtab) HashtabDiff {
+	diff := HashtabDiff{
+		Removed: make([]uint64, 0),
+		Added:   make([]uint64, 0),
+		Changed: make([]uint64, 0),
+	}
+	for hash, str := range a.Entries {
+		other, ok := b.Entries[hash]
+		if !ok {
+			diff.Removed = append(diff.Removed, hash)
+			continue
+		}
+		diff.Common++
+		if str != "" && other != "" && str != other && hash != versionHash.Load() {
+			diff.Changed = append(diff.Changed, hash)
+		}
+	}
+	for hash := range b.Entries {
+		if _, ok := a.Entries[hash]; !ok {
+			diff.Added = append(diff.Added, hash)
+		}
+	}
+
+	for _, hashes := range [][]uint64{diff.Removed, diff.Added, diff.Changed} {
+		sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	}
+	return diff
+}
+
 func WriteHashlist(hashes []uint64, outputPath string) error {
 	file, err := os.Create(outputPath)
 	if err != nil {
@@ -146,3 +251,38 @@ func WriteHashlist(hashes []uint64, outputPath string) error {
 
 	return nil
 }
+
+// WriteHashtab writes a full hashtab (hashes with their strings), sorted by hash
+func WriteHashtab(entries map[uint64]string, outputPath string) error {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+
+	hashes := make([]uint64, 0, len(entries))
+	for hash := range entries {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	for _, hash := range hashes {
+		str := entries[hash]
+
+		err := binary.Write(file, binary.BigEndian, hash)
+		if err != nil {
+			return fmt.Errorf("failed to write hash: %w", err)
+		}
+
+		err = binary.Write(file, binary.BigEndian, uint32(len(str)))
+		if err != nil {
+			return fmt.Errorf("failed to write length: %w", err)
+		}
+
+		if _, err := file.WriteString(str); err != nil {
+			return fmt.Errorf("failed to write string data: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/binary"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestIsHashlist(t *testing.T) {
@@ -222,6 +224,9 @@ func TestHashlistPreservesVersionHash(t *testing.T) {
 	if _, exists := ht.Entries[versionHash]; !exists {
 		t.Error("Version hash was not preserved in conversion")
 	}
+	if ht.EmbeddedVersion != "" {
+		t.Errorf("EmbeddedVersion = %q, want empty for a hashlist", ht.EmbeddedVersion)
+	}
 }
 
 func TestDJB2Hash(t *testing.T) {
@@ -282,6 +287,12 @@ func TestParseVersion(t *testing.T) {
 			wantOSVersion:  "",
 			wantDevice:     "unknown",
 		},
+		{
+			name:           "uppercase device",
+			filename:       "3.22.4.2-RMPP",
+			wantOSVersion:  "3.22.4.2",
+			wantDevice:     "rmpp",
+		},
 		{
 			name:           "multiple dashes",
 			filename:       "3.22.4.2-rmpp-extra",
@@ -302,3 +313,327 @@ func TestParseVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestGetHashtableCaseInsensitive(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	if err := WriteHashlist([]uint64{123}, filepath.Join(tmpDir, "3.22.4.2-RMPP")); err != nil {
+		t.Fatalf("WriteHashlist() failed: %v", err)
+	}
+
+	service, err := NewService(tmpDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+
+	for _, name := range []string{"3.22.4.2-RMPP", "3.22.4.2-rmpp", "3.22.4.2-RmPp"} {
+		ht := service.GetHashtable(name)
+		if ht == nil {
+			t.Errorf("GetHashtable(%q) = nil, want hashtable", name)
+			continue
+		}
+		if ht.Device != "rmpp" {
+			t.Errorf("GetHashtable(%q).Device = %q, want %q", name, ht.Device, "rmpp")
+		}
+	}
+}
+
+func TestCheckAndReloadNotifiesChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "3.22.4.2-rmpp")
+
+	if err := WriteHashlist([]uint64{123}, path); err != nil {
+		t.Fatalf("WriteHashlist() failed: %v", err)
+	}
+
+	service, err := NewService(tmpDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+
+	// Stand-in for a result cache keyed by hashtable name
+	cache := map[string]bool{"3.22.4.2-rmpp": true}
+	var calls int
+	service.OnReload(func(changed []string) {
+		calls++
+		for _, name := range changed {
+			delete(cache, name)
+		}
+	})
+
+	if err := service.CheckAndReload(); err != nil {
+		t.Fatalf("CheckAndReload() failed: %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("OnReload callback called %d times without changes, want 0", calls)
+	}
+
+	if err := WriteHashlist([]uint64{123, 456}, path); err != nil {
+		t.Fatalf("WriteHashlist() failed: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Chtimes() failed: %v", err)
+	}
+
+	if err := service.CheckAndReload(); err != nil {
+		t.Fatalf("CheckAndReload() failed: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("OnReload callback called %d times, want 1", calls)
+	}
+	if cache["3.22.4.2-rmpp"] {
+		t.Error("cached result for 3.22.4.2-rmpp was not evicted")
+	}
+
+	ht := service.GetHashtable("3.22.4.2-rmpp")
+	if ht == nil || len(ht.Entries) != 2 {
+		t.Errorf("reloaded hashtable = %+v, want 2 entries", ht)
+	}
+}
+
+func TestWriteHashtabRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "3.22.4.2-rmpp")
+
+	entries := map[uint64]string{
+		DJB2Hash("width"):  "width",
+		DJB2Hash("Item"):   "Item",
+		DJB2Hash("qsTr"):   "qsTr",
+		DJB2Hash("Hello!"): "Hello!",
+	}
+
+	if err := WriteHashtab(entries, path); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+
+	ht, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if len(ht.Entries) != len(entries) {
+		t.Errorf("Loaded %d entries, want %d", len(ht.Entries), len(entries))
+	}
+	for hash, str := range entries {
+		if got := ht.Entries[hash]; got != str {
+			t.Errorf("Entries[%d] = %q, want %q", hash, got, str)
+		}
+	}
+	if ht.IsHashlist() {
+		t.Error("Hashtab with strings should not be detected as hashlist")
+	}
+}
+
+func TestDetectSeed(t *testing.T) {
+	classic := uint64(5381)
+	tests := []struct {
+		name     string
+		entries  map[uint64]string
+		wantSeed uint64
+		wantOK   bool
+	}{
+		{
+			name: "default seed",
+			entries: map[uint64]string{
+				DJB2Hash("width"): "width",
+				DJB2Hash("Item"):  "Item",
+				DefaultVersionHash: "3.22.4.2",
+			},
+			wantSeed: DefaultSeed,
+			wantOK:   true,
+		},
+		{
+			name: "classic seed",
+			entries: map[uint64]string{
+				DJB2HashWithSeed("width", classic): "width",
+				DJB2HashWithSeed("Item", classic):  "Item",
+			},
+			wantSeed: classic,
+			wantOK:   true,
+		},
+		{
+			name: "unknown seed",
+			entries: map[uint64]string{
+				DJB2HashWithSeed("width", 42): "width",
+			},
+		},
+		{
+			name:    "hashlist",
+			entries: map[uint64]string{DJB2Hash("width"): ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seed, ok := DetectSeed(tt.entries, KnownSeeds)
+			if ok != tt.wantOK || seed != tt.wantSeed {
+				t.Errorf("DetectSeed() = %d, %v, want %d, %v", seed, ok, tt.wantSeed, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckSeedSkipsUncheckable(t *testing.T) {
+	entries := map[uint64]string{
+		DJB2Hash("width"):              "width",
+		DJB2HashWithSeed("Item", 5381): "Item",
+		DJB2Hash("hashlist-only"):      "",
+		DefaultVersionHash:             "3.22.4.2",
+	}
+
+	matched, checked := CheckSeed(entries, DefaultSeed)
+	if matched != 1 || checked != 2 {
+		t.Errorf("CheckSeed() = %d, %d, want 1, 2", matched, checked)
+	}
+}
+
+func TestLoadUsesConfiguredVersionHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "3.22.4.2-rmpp")
+	customHash := uint64(42)
+	entries := map[uint64]string{
+		DJB2Hash("width"):  "width",
+		DefaultVersionHash: "3.20.0.52",
+		customHash:         "3.22.4.2",
+	}
+	if err := WriteHashtab(entries, path); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+
+	SetVersionHash(customHash)
+	defer SetVersionHash(DefaultVersionHash)
+
+	ht, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if ht.EmbeddedVersion != "3.22.4.2" {
+		t.Errorf("EmbeddedVersion = %q, want 3.22.4.2", ht.EmbeddedVersion)
+	}
+}
+
+func TestLookupHash(t *testing.T) {
+	tmpDir := t.TempDir()
+	width := DJB2Hash("width")
+
+	// The same hash maps to different strings in two versions, and a hashlist
+	// only knows it exists
+	tables := map[string]map[uint64]string{
+		"3.20.0.52-rm2": {width: "width"},
+		"3.22.4.2-rmpp": {width: "implicitWidth"},
+	}
+	for name, entries := range tables {
+		if err := WriteHashtab(entries, filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("WriteHashtab() failed: %v", err)
+		}
+	}
+	if err := WriteHashlist([]uint64{width}, filepath.Join(tmpDir, "3.23.0.1-rmpp")); err != nil {
+		t.Fatalf("WriteHashlist() failed: %v", err)
+	}
+
+	service, err := NewService(tmpDir)
+	if err != nil {
+		t.Fatalf("NewService() failed: %v", err)
+	}
+
+	str, hashtable, ok := service.LookupHash(width)
+	if !ok || str != "width" || hashtable != "3.20.0.52-rm2" {
+		t.Errorf("LookupHash() = %q, %q, %v, want width from 3.20.0.52-rm2", str, hashtable, ok)
+	}
+
+	matches := service.LookupHashAll(width)
+	want := []HashMatch{
+		{String: "width", Hashtable: "3.20.0.52-rm2", OSVersion: "3.20.0.52", Device: "rm2"},
+		{String: "implicitWidth", Hashtable: "3.22.4.2-rmpp", OSVersion: "3.22.4.2", Device: "rmpp"},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("LookupHashAll() = %+v, want %+v", matches, want)
+	}
+
+	if _, _, ok := service.LookupHash(42); ok {
+		t.Error("LookupHash(42) found an unknown hash")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &Hashtab{Entries: map[uint64]string{
+		DefaultVersionHash: "3.22.4.2",
+		1:                  "width",
+		2:                  "height",
+		3:                  "radius",
+	}}
+	b := &Hashtab{Entries: map[uint64]string{
+		DefaultVersionHash: "3.23.0.1",
+		1:                  "width",
+		2:                  "implicitHeight",
+		4:                  "opacity",
+		5:                  "scale",
+	}}
+
+	got := Diff(a, b)
+	want := HashtabDiff{Removed: []uint64{3}, Added: []uint64{4, 5}, Changed: []uint64{2}, Common: 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+
+	// A hashlist has no strings to compare
+	hashlist := &Hashtab{Entries: map[uint64]string{1: "", 2: "", 4: ""}}
+	got = Diff(a, hashlist)
+	want = HashtabDiff{Removed: []uint64{3, DefaultVersionHash}, Added: []uint64{4}, Changed: []uint64{}, Common: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() against hashlist = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "3.20.0.52-rm2")
+	entries := map[uint64]string{
+		DJB2Hash("width"):  "width",
+		DefaultVersionHash: "3.20.0.65",
+		0:                  "padding",
+	}
+	if err := WriteHashtab(entries, path); err != nil {
+		t.Fatalf("WriteHashtab() failed: %v", err)
+	}
+
+	ht, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	// The embedded version wins over the filename's; the device comes from the filename
+	if ht.Name != "3.20.0.52-rm2" || ht.Path != path || ht.OSVersion != "3.20.0.65" || ht.Device != "rm2" || ht.EmbeddedVersion != "3.20.0.65" {
+		t.Errorf("Load() = %s %s %s %s %s, want the filename's name and device with the embedded version",
+			ht.Name, ht.Path, ht.OSVersion, ht.Device, ht.EmbeddedVersion)
+	}
+	if _, ok := ht.Entries[0]; ok || len(ht.Entries) != 2 {
+		t.Errorf("Entries = %v, want width and the version without hash 0", ht.Entries)
+	}
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		return path
+	}
+	entry := func(hash uint64, length uint32, str string) []byte {
+		data := binary.BigEndian.AppendUint64(nil, hash)
+		data = binary.BigEndian.AppendUint32(data, length)
+		return append(data, str...)
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+	}{
+		{"missing", filepath.Join(tmpDir, "missing")},
+		{"truncated hash", write("truncated-hash", []byte{1, 2, 3})},
+		{"truncated string", write("truncated-string", entry(1, 5, "wid"))},
+		{"oversized string", write("oversized", entry(1, maxStringLength+1, ""))},
+	} {
+		if _, err := Load(tt.path); err == nil {
+			t.Errorf("%s: Load() succeeded, want an error", tt.name)
+		}
+	}
+}
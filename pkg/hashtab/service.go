@@ -24,6 +24,7 @@ type Service struct {
 	mu         sync.RWMutex
 	modTimes   map[string]time.Time
 	pathByName map[string]string
+	onReload   []func(changed []string)
 }
 
 func NewService(dir string) (*Service, error) {
@@ -73,7 +74,7 @@ func (s *Service) loadHashtables() error {
 
 		filename := filepath.Base(path)
 
-		if existingPath, exists := loadedNames[filename]; exists {
+		if existingPath, exists := loadedNames[strings.ToLower(filename)]; exists {
 			logging.Warn(logging.ComponentHashtab, "Skipping duplicate hashtable file %s (already loaded from %s)", path, existingPath)
 			return nil
 		}
@@ -93,8 +94,8 @@ func (s *Service) loadHashtables() error {
 		logging.Info(logging.ComponentHashtab, "Loaded %s: %s, %d entries, version %s", filename, formatType, len(ht.Entries), ht.OSVersion)
 
 		s.hashtables = append(s.hashtables, ht)
-		loadedNames[filename] = path
-		s.pathByName[filename] = path
+		loadedNames[strings.ToLower(filename)] = path
+		s.pathByName[strings.ToLower(filename)] = path
 
 		fileInfo, err := d.Info()
 		if err == nil {
@@ -111,7 +112,25 @@ func (s *Service) loadHashtables() error {
 	return nil
 }
 
+// OnReload registers fn to be called after CheckAndReload picks up changes,
+// with the names of the hashtables that were added, modified or removed
+// Use it to evict anything derived from the old hashtable contents
+func (s *Service) OnReload(fn func(changed []string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReload = append(s.onReload, fn)
+}
+
 func (s *Service) CheckAndReload() error {
+	var changed []string
+	var callbacks []func(changed []string)
+	// Deferred first so callbacks run after the lock is released
+	defer func() {
+		for _, fn := range callbacks {
+			fn(changed)
+		}
+	}()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -145,6 +164,7 @@ func (s *Service) CheckAndReload() error {
 
 		if lastMod, exists := s.modTimes[path]; !exists || !lastMod.Equal(modTime) {
 			needsReload = true
+			changed = append(changed, filepath.Base(path))
 		}
 
 		return nil
@@ -154,12 +174,10 @@ func (s *Service) CheckAndReload() error {
 		return fmt.Errorf("failed to walk hashtable directory: %w", err)
 	}
 
-	if !needsReload {
-		for path := range s.modTimes {
-			if _, exists := currentFiles[path]; !exists {
-				needsReload = true
-				break
-			}
+	for path := range s.modTimes {
+		if _, exists := currentFiles[path]; !exists {
+			needsReload = true
+			changed = append(changed, filepath.Base(path))
 		}
 	}
 
@@ -194,7 +212,7 @@ func (s *Service) CheckAndReload() error {
 
 		filename := filepath.Base(path)
 
-		if existingPath, exists := loadedNames[filename]; exists {
+		if existingPath, exists := loadedNames[strings.ToLower(filename)]; exists {
 			logging.Warn(logging.ComponentHashtab, "Skipping duplicate hashtable file %s (already loaded from %s)", path, existingPath)
 			return nil
 		}
@@ -214,8 +232,8 @@ func (s *Service) CheckAndReload() error {
 		logging.Info(logging.ComponentHashtab, "Loaded %s: %s, %d entries, version %s", filename, formatType, len(ht.Entries), ht.OSVersion)
 
 		s.hashtables = append(s.hashtables, ht)
-		loadedNames[filename] = path
-		s.pathByName[filename] = path
+		loadedNames[strings.ToLower(filename)] = path
+		s.pathByName[strings.ToLower(filename)] = path
 
 		fileInfo, err := d.Info()
 		if err == nil {
@@ -231,6 +249,7 @@ func (s *Service) CheckAndReload() error {
 
 	logging.Info(logging.ComponentHashtab, "Reload complete: %d hashtables loaded", len(s.hashtables))
 
+	callbacks = s.onReload
 	return nil
 }
 
@@ -240,13 +259,65 @@ func (s *Service) GetHashtables() []*Hashtab {
 	return s.hashtables
 }
 
+// GetHashtable looks up a hashtable by name, ignoring case
 func (s *Service) GetHashtable(name string) *Hashtab {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	for _, ht := range s.hashtables {
-		if ht.Name == name {
+		if strings.EqualFold(ht.Name, name) {
 			return ht
 		}
 	}
 	return nil
 }
+
+// LookupString returns the string for a hash from the first loaded hashtable
+// that carries it; hashlists, which hold no strings, never match
+func (s *Service) LookupString(hash uint64) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, ht := range s.hashtables {
+		if str, ok := ht.Entries[hash]; ok && str != "" {
+			return str, true
+		}
+	}
+	return "", false
+}
+
+// HashMatch is a hashtable that maps a hash to a string
+type HashMatch struct {
+	String    string
+	Hashtable string
+	OSVersion string
+	Device    string
+}
+
+// LookupHash returns the string for a hash and the name of the first loaded
+// hashtable that carries it; hashlists never match
+func (s *Service) LookupHash(hash uint64) (string, string, bool) {
+	matches := s.LookupHashAll(hash)
+	if len(matches) == 0 {
+		return "", "", false
+	}
+	return matches[0].String, matches[0].Hashtable, true
+}
+
+// LookupHashAll returns every loaded hashtable with a string for a hash, in
+// load order. The same hash can map to different strings across firmware
+// versions, so the strings may differ.
+func (s *Service) LookupHashAll(hash uint64) []HashMatch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matches []HashMatch
+	for _, ht := range s.hashtables {
+		if str, ok := ht.Entries[hash]; ok && str != "" {
+			matches = append(matches, HashMatch{
+				String:    str,
+				Hashtable: ht.Name,
+				OSVersion: ht.OSVersion,
+				Device:    ht.Device,
+			})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,140 @@
+package qmltree
+
+import "strings"
+
+// TokenKind identifies the kind of a QML token
+type TokenKind int
+
+const (
+	TokenIdentifier   TokenKind = iota // Names and keywords, e.g. "Item", "width"
+	TokenString                        // String literal contents, without quotes
+	TokenNumber                        // Numeric literal
+	TokenPunctuation                   // Any other single character
+	TokenHash                          // Hash extension ~&123&~, Value holds the digits
+	TokenHashedString                  // Hashed string ~&"text"&~, Value holds the text without quotes
+)
+
+// Token is a single lexical token from a QML file
+type Token struct {
+	Kind   TokenKind
+	Value  string
+	Offset int // Byte offset of the token in the source
+}
+
+// Tokenize splits QML source into identifiers, string literals, numbers and
+// punctuation. Comments and whitespace are skipped. Unterminated strings and
+// comments run to the end of the input rather than failing.
+func Tokenize(content string) []Token {
+	tokens := make([]Token, 0, len(content)/4)
+
+	for i := 0; i < len(content); {
+		ch := content[i]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+
+		case ch == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+
+		case ch == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += 2 + end + 2
+			}
+
+		case ch == '~' && i+1 < len(content) && content[i+1] == '&':
+			if token, next, ok := lexHashExtension(content, i); ok {
+				tokens = append(tokens, token)
+				i = next
+			} else {
+				tokens = append(tokens, Token{Kind: TokenPunctuation, Value: "~", Offset: i})
+				i++
+			}
+
+		case ch == '"' || ch == '\'' || ch == '`':
+			start := i
+			value, next := readString(content, i)
+			tokens = append(tokens, Token{Kind: TokenString, Value: value, Offset: start})
+			i = next
+
+		case isIdentifierStart(ch):
+			start := i
+			for i < len(content) && isIdentifierPart(content[i]) {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: TokenIdentifier, Value: content[start:i], Offset: start})
+
+		case ch >= '0' && ch <= '9':
+			start := i
+			for i < len(content) && (isIdentifierPart(content[i]) || content[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: TokenNumber, Value: content[start:i], Offset: start})
+
+		default:
+			tokens = append(tokens, Token{Kind: TokenPunctuation, Value: content[i : i+1], Offset: i})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// lexHashExtension reads a ~&...&~ hash extension starting at content[start]
+// The body is either decimal hash digits or a quoted string, whose escaped
+// quotes do not end it. ok is false if the extension is malformed, in which
+// case the caller treats "~" as punctuation.
+func lexHashExtension(content string, start int) (Token, int, bool) {
+	i := start + 2
+	if i >= len(content) {
+		return Token{}, 0, false
+	}
+
+	if ch := content[i]; ch == '"' || ch == '\'' {
+		value, next := readString(content, i)
+		if !strings.HasPrefix(content[next:], "&~") {
+			return Token{}, 0, false
+		}
+		return Token{Kind: TokenHashedString, Value: value, Offset: start}, next + 2, true
+	}
+
+	digitsStart := i
+	for i < len(content) && content[i] >= '0' && content[i] <= '9' {
+		i++
+	}
+	if i == digitsStart || !strings.HasPrefix(content[i:], "&~") {
+		return Token{}, 0, false
+	}
+	return Token{Kind: TokenHash, Value: content[digitsStart:i], Offset: start}, i + 2, true
+}
+
+// readString reads a quoted string starting at content[start] and returns its
+// unquoted value (escape sequences are kept verbatim) and the index after it
+func readString(content string, start int) (string, int) {
+	quote := content[start]
+	i := start + 1
+	for i < len(content) {
+		switch content[i] {
+		case '\\':
+			i += 2
+			continue
+		case quote:
+			return content[start+1 : i], i + 1
+		}
+		i++
+	}
+	return content[start+1:], len(content)
+}
+
+func isIdentifierStart(ch byte) bool {
+	return ch == '_' || ch == '$' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch >= 0x80
+}
+
+func isIdentifierPart(ch byte) bool {
+	return isIdentifierStart(ch) || (ch >= '0' && ch <= '9')
+}
@@ -0,0 +1,136 @@
+package qmltree
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	content := `import QtQuick 2.0
+// line comment with ident
+Item {
+    /* block
+       comment */
+    id: root
+    property string label: "Hello \"world\""
+    width: 1.5
+}
+`
+
+	var got []string
+	for _, token := range Tokenize(content) {
+		if token.Kind == TokenIdentifier || token.Kind == TokenString {
+			got = append(got, token.Value)
+		}
+	}
+
+	want := []string{"import", "QtQuick", "Item", "id", "root", "property", "string", "label", `Hello \"world\"`, "width"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() identifiers = %q, want %q", got, want)
+	}
+}
+
+func TestTokenizeUnterminated(t *testing.T) {
+	tests := []string{
+		`Item { text: "unterminated`,
+		`Item { /* unterminated`,
+		`"trailing backslash \`,
+	}
+
+	for _, content := range tests {
+		// Must not panic or loop forever
+		Tokenize(content)
+	}
+}
+
+func TestTokenizeHashExtensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Token
+	}{
+		{
+			name:    "hash digits",
+			content: `id: ~&123&~`,
+			want:    []Token{{Kind: TokenHash, Value: "123", Offset: 4}},
+		},
+		{
+			name:    "hashed string",
+			content: `text: ~&"foo"&~`,
+			want:    []Token{{Kind: TokenHashedString, Value: "foo", Offset: 6}},
+		},
+		{
+			name:    "escaped quote in hashed string",
+			content: `text: ~&"foo\"bar"&~`,
+			want:    []Token{{Kind: TokenHashedString, Value: `foo\"bar`, Offset: 6}},
+		},
+		{
+			name:    "escaped terminator in hashed string",
+			content: `text: ~&"a\"&~b"&~`,
+			want:    []Token{{Kind: TokenHashedString, Value: `a\"&~b`, Offset: 6}},
+		},
+		{
+			name:    "single quoted hashed string",
+			content: `text: ~&'it\'s'&~`,
+			want:    []Token{{Kind: TokenHashedString, Value: `it\'s`, Offset: 6}},
+		},
+		{
+			name:    "unterminated hashed string",
+			content: `text: ~&"foo\"&~`,
+			want:    nil,
+		},
+		{
+			name:    "non-digit hash",
+			content: `x: ~&12a&~`,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Token
+			for _, token := range Tokenize(tt.content) {
+				if token.Kind == TokenHash || token.Kind == TokenHashedString {
+					got = append(got, token)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) hash tokens = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("import QtQuick 2.0\n\nItem {\n    id: root\n")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&sb, "    // Row %d\n    Rectangle {\n        id: rect%d\n        width: parent.width * 0.5\n        property string label: qsTr(\"Label %d\")\n        onClicked: { root.select(%d) }\n    }\n", i, i, i, i)
+	}
+	sb.WriteString("}\n")
+	content := sb.String()
+
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Tokenize(content)
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	f.Add("import QtQuick 2.0\nItem { id: root; text: \"Hello\" /* c */ }")
+	f.Add("text: ~&\"escaped \\\" quote\"&~ + ~&123&~")
+	f.Add("~&")
+	f.Add("\"\\")
+	f.Add("/* unterminated")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		for _, token := range Tokenize(content) {
+			if token.Offset < 0 || token.Offset >= len(content) {
+				t.Fatalf("token %+v has offset outside the input (length %d)", token, len(content))
+			}
+		}
+	})
+}
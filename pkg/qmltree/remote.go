@@ -0,0 +1,161 @@
+package qmltree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteSource lists and downloads trees kept outside the local filesystem
+type RemoteSource interface {
+	// List returns each tree name with the slash-separated paths of its files
+	List(ctx context.Context) (map[string][]string, error)
+	// Fetch copies one file of a tree to w
+	Fetch(ctx context.Context, tree, file string, w io.Writer) error
+}
+
+// completeMarker is written into a cached tree once every file is downloaded
+const completeMarker = ".complete"
+
+// NewRemoteService creates a tree service backed by a remote source
+// Trees are listed once at startup; each is downloaded into cacheDir the
+// first time it is materialized and kept there for later validations.
+func NewRemoteService(source RemoteSource, cacheDir string) *Service {
+	s := &Service{
+		dir:         cacheDir,
+		trees:       make(map[string]*Tree),
+		modTimes:    make(map[string]time.Time),
+		source:      source,
+		remoteFiles: make(map[string][]string),
+		fetchLocks:  make(map[string]*sync.Mutex),
+	}
+
+	if err := s.loadRemote(); err != nil {
+		fmt.Fprintf(os.Stderr, "[qmltree] Failed to list remote trees: %v\n", err)
+	}
+
+	return s
+}
+
+// loadRemote builds the tree list from the remote source
+// Only .qml files count towards a tree's files, matching local trees.
+func (s *Service) loadRemote() error {
+	listing, err := s.source.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	trees := make(map[string]*Tree, len(listing))
+	for name, files := range listing {
+		version, device := parseNameComponents(name)
+		qmlFiles := make([]string, 0, len(files))
+		for _, file := range files {
+			if strings.HasSuffix(strings.ToLower(file), ".qml") {
+				qmlFiles = append(qmlFiles, file)
+			}
+		}
+
+		tree := &Tree{
+			Name:      name,
+			Path:      filepath.Join(s.dir, name),
+			OSVersion: version,
+			Device:    device,
+			FileCount: len(qmlFiles),
+		}
+		tree.filesOnce.Do(func() { tree.files = qmlFiles })
+		trees[strings.ToLower(name)] = tree
+	}
+
+	s.mu.Lock()
+	s.trees = trees
+	s.remoteFiles = listing
+	s.mu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "[qmltree] Listed %d remote trees\n", len(trees))
+	return nil
+}
+
+// Materialize returns a local directory holding the tree's files
+// Trees from a remote source are downloaded on first use; concurrent callers
+// for the same tree wait for a single download. Local trees are returned as is.
+func (s *Service) Materialize(ctx context.Context, tree *Tree) (string, error) {
+	if s.source == nil {
+		return tree.Path, nil
+	}
+
+	lock := s.fetchLock(tree.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := os.Stat(filepath.Join(tree.Path, completeMarker)); err == nil {
+		return tree.Path, nil
+	}
+
+	s.mu.RLock()
+	files := s.remoteFiles[tree.Name]
+	s.mu.RUnlock()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create tree cache: %w", err)
+	}
+	// Download next to the final location so a partial tree is never used
+	tmpDir, err := os.MkdirTemp(s.dir, "."+tree.Name+"-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create tree cache: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fmt.Fprintf(os.Stderr, "[qmltree] Downloading tree %s (%d files)\n", tree.Name, len(files))
+
+	for _, file := range files {
+		if err := s.fetchFile(ctx, tmpDir, tree.Name, file); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, completeMarker), nil, 0644); err != nil {
+		return "", fmt.Errorf("failed to finish tree cache: %w", err)
+	}
+	os.RemoveAll(tree.Path)
+	if err := os.Rename(tmpDir, tree.Path); err != nil {
+		return "", fmt.Errorf("failed to finish tree cache: %w", err)
+	}
+
+	return tree.Path, nil
+}
+
+// fetchFile downloads one file of a tree into dir
+func (s *Service) fetchFile(ctx context.Context, dir, tree, file string) error {
+	path := filepath.Join(dir, filepath.FromSlash(file))
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return fmt.Errorf("invalid file path in tree %s: %s", tree, file)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", file, err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", file, err)
+	}
+	defer f.Close()
+
+	return s.source.Fetch(ctx, tree, file, f)
+}
+
+func (s *Service) fetchLock(name string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, ok := s.fetchLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.fetchLocks[name] = lock
+	}
+	return lock
+}
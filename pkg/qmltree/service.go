@@ -12,9 +12,15 @@ import (
 // Service manages QML tree discovery and lookup
 type Service struct {
 	dir      string
-	trees    map[string]*Tree      // Map of tree name -> Tree
+	trees    map[string]*Tree      // Map of lowercased tree name -> Tree
 	modTimes map[string]time.Time  // Map of tree path -> modification time
+	onReload []func(changed []string)
 	mu       sync.RWMutex
+
+	// Set for trees from a remote source; dir is then the local cache
+	source      RemoteSource
+	remoteFiles map[string][]string    // Map of tree name -> paths of all its files
+	fetchLocks  map[string]*sync.Mutex // Map of tree name -> lock held while downloading
 }
 
 // NewService creates a new QML tree service
@@ -46,24 +52,50 @@ func (s *Service) GetTrees() []*Tree {
 	return trees
 }
 
-// GetTreeByName looks up a tree by name
+// GetTreeByName looks up a tree by name, ignoring case
 func (s *Service) GetTreeByName(name string) (*Tree, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	tree, exists := s.trees[name]
+	tree, exists := s.trees[strings.ToLower(name)]
 	return tree, exists
 }
 
+// OnReload registers fn to be called after CheckAndReload picks up changes,
+// with the names of the trees that were added, modified or removed
+func (s *Service) OnReload(fn func(changed []string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReload = append(s.onReload, fn)
+}
+
 // CheckAndReload checks if trees have changed and reloads if necessary
 func (s *Service) CheckAndReload() error {
+	var changed []string
+	var callbacks []func(changed []string)
+	// Deferred first so callbacks run after the lock is released
+	defer func() {
+		for _, fn := range callbacks {
+			fn(changed)
+		}
+	}()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Remote trees are listed once at startup; the cache dir is not a catalog
+	if s.source != nil {
+		return nil
+	}
+
 	// Check if directory exists
 	if _, err := os.Stat(s.dir); os.IsNotExist(err) {
 		// Directory doesn't exist - clear trees if we had any
 		if len(s.trees) > 0 {
+			for path := range s.modTimes {
+				changed = append(changed, filepath.Base(path))
+			}
+			callbacks = s.onReload
 			s.trees = make(map[string]*Tree)
 			s.modTimes = make(map[string]time.Time)
 			fmt.Fprintf(os.Stderr, "[qmltree] Tree directory removed, clearing trees\n")
@@ -101,16 +133,15 @@ func (s *Service) CheckAndReload() error {
 		// Check if this is a new or modified directory
 		if lastMod, exists := s.modTimes[path]; !exists || !lastMod.Equal(modTime) {
 			needsReload = true
+			changed = append(changed, entry.Name())
 		}
 	}
 
 	// Check for deleted directories
-	if !needsReload {
-		for path := range s.modTimes {
-			if _, exists := currentDirs[path]; !exists {
-				needsReload = true
-				break
-			}
+	for path := range s.modTimes {
+		if _, exists := currentDirs[path]; !exists {
+			needsReload = true
+			changed = append(changed, filepath.Base(path))
 		}
 	}
 
@@ -141,7 +172,7 @@ func (s *Service) CheckAndReload() error {
 			continue
 		}
 
-		s.trees[name] = tree
+		s.trees[strings.ToLower(name)] = tree
 
 		// Store modification time
 		info, err := entry.Info()
@@ -152,6 +183,7 @@ func (s *Service) CheckAndReload() error {
 
 	fmt.Fprintf(os.Stderr, "[qmltree] Reload complete: %d trees loaded\n", len(s.trees))
 
+	callbacks = s.onReload
 	return nil
 }
 
@@ -195,7 +227,7 @@ func (s *Service) load() error {
 			continue
 		}
 
-		newTrees[name] = tree
+		newTrees[strings.ToLower(name)] = tree
 
 		// Store modification time
 		info, err := entry.Info()
@@ -0,0 +1,165 @@
+package qmltree
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGetTreeByNameCaseInsensitive(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	treePath := filepath.Join(tmpDir, "3.22.4.2-RMPP")
+	if err := os.MkdirAll(treePath, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(treePath, "main.qml"), []byte("Item {}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	service := NewService(tmpDir)
+
+	for _, name := range []string{"3.22.4.2-RMPP", "3.22.4.2-rmpp", "3.22.4.2-RmPp"} {
+		tree, ok := service.GetTreeByName(name)
+		if !ok {
+			t.Errorf("GetTreeByName(%q) not found", name)
+			continue
+		}
+		if tree.Device != "rmpp" {
+			t.Errorf("GetTreeByName(%q).Device = %q, want %q", name, tree.Device, "rmpp")
+		}
+		if tree.Path != treePath {
+			t.Errorf("GetTreeByName(%q).Path = %q, want %q", name, tree.Path, treePath)
+		}
+	}
+}
+
+func TestParseNameComponents(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantVersion string
+		wantDevice  string
+	}{
+		{"standard format", "3.22.0.65-rmppm", "3.22.0.65", "rmppm"},
+		{"uppercase device", "3.22.0.65-RMPPM", "3.22.0.65", "rmppm"},
+		{"no device", "3.22.0.65", "3.22.0.65", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotVersion, gotDevice := parseNameComponents(tt.input)
+			if gotVersion != tt.wantVersion || gotDevice != tt.wantDevice {
+				t.Errorf("parseNameComponents(%q) = (%q, %q), want (%q, %q)",
+					tt.input, gotVersion, gotDevice, tt.wantVersion, tt.wantDevice)
+			}
+		})
+	}
+}
+
+func TestTreeContainsFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	qmlPath := filepath.Join(tmpDir, "qml", "Main.qml")
+	if err := os.MkdirAll(filepath.Dir(qmlPath), 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if err := os.WriteFile(qmlPath, []byte("Item {}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	tree, err := NewTree(tmpDir)
+	if err != nil {
+		t.Fatalf("NewTree() failed: %v", err)
+	}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"qml/Main.qml", true},
+		{"/qml/Main.qml", true},
+		{"Main.qml", true},
+		{"/usr/share/remarkable/qml/Main.qml", true},
+		{"qml/Other.qml", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := tree.ContainsFile(tt.target); got != tt.want {
+			t.Errorf("ContainsFile(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestNewTreeListsFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"qml/Main.qml", "qml/components/Button.QML", "qml/notes.txt", "Root.qml"} {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("Item {}\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	tree, err := NewTree(tmpDir)
+	if err != nil {
+		t.Fatalf("NewTree() failed: %v", err)
+	}
+
+	files := append([]string(nil), tree.Files()...)
+	sort.Strings(files)
+	if want := []string{"Root.qml", "qml/Main.qml", "qml/components/Button.QML"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("Files() = %v, want %v", files, want)
+	}
+	if tree.FileCount != len(files) {
+		t.Errorf("FileCount = %d, want %d", tree.FileCount, len(files))
+	}
+
+	// The listing is taken when the tree is loaded, not on first use
+	if err := os.WriteFile(filepath.Join(tmpDir, "Late.qml"), []byte("Item {}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if len(tree.Files()) != tree.FileCount {
+		t.Errorf("Files() has %d entries after a later write, want FileCount %d", len(tree.Files()), tree.FileCount)
+	}
+}
+
+func TestCheckAndReloadNotifiesChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	treePath := filepath.Join(tmpDir, "3.22.4.2-rmpp")
+	if err := os.MkdirAll(treePath, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+
+	service := NewService(tmpDir)
+
+	var changed []string
+	service.OnReload(func(names []string) {
+		changed = append(changed, names...)
+	})
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(treePath, later, later); err != nil {
+		t.Fatalf("Chtimes() failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "3.22.0.64-rm2"), 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+
+	if err := service.CheckAndReload(); err != nil {
+		t.Fatalf("CheckAndReload() failed: %v", err)
+	}
+
+	sort.Strings(changed)
+	want := []string{"3.22.0.64-rm2", "3.22.4.2-rmpp"}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("OnReload changed = %v, want %v", changed, want)
+	}
+}
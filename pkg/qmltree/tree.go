@@ -1,9 +1,12 @@
 package qmltree
 
 import (
+	"fmt"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // Tree represents a QML tree directory
@@ -13,6 +16,9 @@ type Tree struct {
 	OSVersion string // e.g., "3.22.0.65"
 	Device    string // e.g., "rmppm"
 	FileCount int    // Number of .qml files in tree
+
+	filesOnce sync.Once
+	files     []string // Relative slash-separated paths of .qml files, built lazily
 }
 
 // NewTree creates a new Tree from a directory path
@@ -20,27 +26,39 @@ func NewTree(path string) (*Tree, error) {
 	name := filepath.Base(path)
 	version, device := parseNameComponents(name)
 
-	// Count QML files
-	fileCount := 0
-	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
-		if err == nil && !d.IsDir() && strings.HasSuffix(strings.ToLower(p), ".qml") {
-			fileCount++
-		}
-		return nil
-	})
+	// List QML files once, so FileCount always matches Files()
+	files := listQMLFiles(path)
 
-	return &Tree{
+	tree := &Tree{
 		Name:      name,
 		Path:      path,
 		OSVersion: version,
 		Device:    device,
-		FileCount: fileCount,
-	}, nil
+		FileCount: len(files),
+	}
+	tree.filesOnce.Do(func() { tree.files = files })
+	return tree, nil
+}
+
+// listQMLFiles returns the relative slash-separated paths of the .qml files under dir
+func listQMLFiles(dir string) []string {
+	files := make([]string, 0)
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(strings.ToLower(p), ".qml") {
+			return nil
+		}
+		if rel, err := filepath.Rel(dir, p); err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files
 }
 
 // parseNameComponents extracts version and device from tree directory name
 // Expected format: {version}-{device}
 // Example: "3.22.0.65-rmppm" → ("3.22.0.65", "rmppm")
+// Device is normalized to lowercase to match hashtab naming regardless of case
 func parseNameComponents(name string) (version string, device string) {
 	// Find the last hyphen to split version and device
 	lastHyphen := strings.LastIndex(name, "-")
@@ -49,6 +67,58 @@ func parseNameComponents(name string) (version string, device string) {
 	}
 
 	version = name[:lastHyphen]
-	device = name[lastHyphen+1:]
+	device = strings.ToLower(name[lastHyphen+1:])
 	return
 }
+
+// Files returns the relative paths of all .qml files in the tree
+// NewTree and remote listings fill it in; otherwise it is built on first use.
+// The slice is shared, so callers must not modify it.
+func (t *Tree) Files() []string {
+	t.filesOnce.Do(func() {
+		t.files = listQMLFiles(t.Path)
+	})
+	return t.files
+}
+
+// ContainsFile reports whether the tree has a .qml file matching the given path
+// The path may be absolute on the device; it matches any file it is a suffix of
+func (t *Tree) ContainsFile(target string) bool {
+	target = strings.TrimPrefix(filepath.ToSlash(target), "/")
+	if target == "" {
+		return false
+	}
+	for _, file := range t.Files() {
+		if file == target || strings.HasSuffix(file, "/"+target) || strings.HasSuffix(target, "/"+file) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identifiers returns every unique identifier and string literal in the tree's QML files
+// These are the values qmldiff hashes, so their DJB2 hashes form the tree's hashtab
+func (t *Tree) Identifiers() ([]string, error) {
+	seen := make(map[string]bool)
+	identifiers := make([]string, 0)
+
+	for _, file := range t.Files() {
+		content, err := os.ReadFile(filepath.Join(t.Path, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		for _, token := range Tokenize(string(content)) {
+			if token.Kind != TokenIdentifier && token.Kind != TokenString && token.Kind != TokenHashedString {
+				continue
+			}
+			if token.Value == "" || seen[token.Value] {
+				continue
+			}
+			seen[token.Value] = true
+			identifiers = append(identifiers, token.Value)
+		}
+	}
+
+	return identifiers, nil
+}
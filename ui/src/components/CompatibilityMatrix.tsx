@@ -12,10 +12,10 @@ export interface MissingHashInfo {
 
 export interface ValidationResult {
   path: string;
-  status: 'validated' | 'failed' | 'not_attempted';
+  status: 'validated' | 'failed' | 'not_attempted' | 'not_uploaded';
   compatible: boolean;
   hash_errors?: Array<{
-    hash_id: number;
+    hash_id: string;
     error: string;
   }>;
   process_errors?: string[];
@@ -29,6 +29,8 @@ export interface ComparisonResult {
   device: string;
   compatible: boolean;
   error_detail?: string;
+  version_mismatch?: string;
+  external_loads?: string[];
   missing_hashes?: MissingHashInfo[];
   dependency_results?: Record<string, ValidationResult>;
 }
@@ -37,6 +39,7 @@ export interface CompareResponse {
   compatible: ComparisonResult[];
   incompatible: ComparisonResult[];
   total_checked: number;
+  info?: string;
 }
 
 const deviceNames: Record<string, { short: string; full: string }> = {
@@ -47,6 +50,11 @@ const deviceNames: Record<string, { short: string; full: string }> = {
   'rmppure': { short: 'rMPPure', full: 'Paper Pure' },
 };
 
+function externalLoadsNote(externalLoads: string[]): string {
+  const count = externalLoads.length;
+  return `${count} external ${count === 1 ? 'dependency' : 'dependencies'} (not validated)`;
+}
+
 interface VersionInfo {
   full: string;
   majorMinorPatch: string;
@@ -198,7 +206,12 @@ export function CompatibilityMatrix({
           <TooltipTrigger>
             <CheckCircle2 className="h-5 w-5 text-green-600 inline-block" />
           </TooltipTrigger>
-          <TooltipContent>Compatible</TooltipContent>
+          <TooltipContent>
+            {result.version_mismatch ? `Compatible, but ${result.version_mismatch}` : 'Compatible'}
+            {result.external_loads && result.external_loads.length > 0 && (
+              <div>{externalLoadsNote(result.external_loads)}</div>
+            )}
+          </TooltipContent>
         </Tooltip>
       )}
       {result?.compatible === false && (
@@ -218,6 +231,9 @@ export function CompatibilityMatrix({
           </Tooltip>
           <PopoverContent>
             <div className="text-sm">
+              {result.version_mismatch && (
+                <div className="mb-2 text-amber-600">{result.version_mismatch}</div>
+              )}
               {result.missing_hashes && result.missing_hashes.length > 0 && (
                 <div className="mb-2 font-semibold">Missing {result.missing_hashes.length > 1 ? 'Hashes' : 'Hash'}</div>
               )}
@@ -237,6 +253,11 @@ export function CompatibilityMatrix({
               {(!result.missing_hashes || result.missing_hashes.length === 0) && (
                 <div className="font-bold">{result.error_detail || 'Unknown'}</div>
               )}
+              {result.external_loads && result.external_loads.length > 0 && (
+                <div className="mt-2 text-muted-foreground" title={result.external_loads.join('\n')}>
+                  {externalLoadsNote(result.external_loads)}
+                </div>
+              )}
             </div>
           </PopoverContent>
         </Popover>
@@ -1,4 +1,4 @@
-import { CheckCircle2, XCircle, CircleMinus } from 'lucide-react';
+import { CheckCircle2, XCircle, CircleMinus, FileQuestion } from 'lucide-react';
 import { Table, TableBody, TableCell, TableHead, TableHeader, TableRow } from '@/components/ui/table';
 import { Tooltip, TooltipContent, TooltipProvider, TooltipTrigger } from '@/components/ui/tooltip';
 import type { ValidationResult } from './CompatibilityMatrix';
@@ -79,6 +79,14 @@ export function DependencyResults({ dependencyResults, osVersion, device }: Depe
                       </TooltipContent>
                     </Tooltip>
                   )}
+                  {result.status === 'not_uploaded' && (
+                    <Tooltip>
+                      <TooltipTrigger>
+                        <FileQuestion className="h-5 w-5 text-amber-500 inline-block" />
+                      </TooltipTrigger>
+                      <TooltipContent>Referenced by LOAD but not uploaded</TooltipContent>
+                    </Tooltip>
+                  )}
                 </TableCell>
                 <TableCell className="font-mono text-sm">{filePath}</TableCell>
                 <TableCell className="text-center text-muted-foreground">
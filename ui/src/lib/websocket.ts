@@ -28,7 +28,7 @@ export function waitForJobWS(
       try {
         const st = JSON.parse(ev.data);
         onUpdate(st);
-        if (st.status === "success" || st.status === "error") {
+        if (st.status === "success" || st.status === "error" || st.status === "timeout" || st.status === "cancelled") {
           // Add small delay to allow state update to complete and
           // prevent "Close received after close" race condition
           setTimeout(() => {